@@ -1,23 +1,91 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 
 	"github.com/nishantdesai/coding-agent-account-switcher/internal/ags"
 )
 
 var osExit = os.Exit
 
-func run(args []string, stdout io.Writer, stderr io.Writer) int {
-	if err := ags.Run(args, stdout, stderr); err != nil {
-		fmt.Fprintln(stderr, "Error:", err)
-		return 1
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	args, errorJSON := splitErrorJSONFlag(args)
+	if err := ags.Run(args, stdin, stdout, stderr); err != nil {
+		code := exitCode(err)
+		var exitErr *ags.ExitCodeError
+		if errors.As(err, &exitErr) && exitErr.Silent {
+			return code
+		}
+		if errorJSON {
+			writeErrorJSON(stderr, err, code)
+		} else {
+			fmt.Fprintln(stderr, "Error:", err)
+		}
+		return code
 	}
 	return 0
 }
 
+// splitErrorJSONFlag removes the global --error-json flag, accepted anywhere
+// on the command line. AGS_ERROR_JSON=1 turns it on as well.
+func splitErrorJSONFlag(args []string) ([]string, bool) {
+	enabled, _ := strconv.ParseBool(os.Getenv("AGS_ERROR_JSON"))
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--error-json" || arg == "-error-json" {
+			enabled = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, enabled
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// writeErrorJSON reports err on one line as {"error":"...","code":N}.
+func writeErrorJSON(stderr io.Writer, err error, code int) {
+	if encodeErr := json.NewEncoder(stderr).Encode(errorJSON{Error: err.Error(), Code: code}); encodeErr != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+	}
+}
+
+// Exit codes for scripts: 1 is a generic failure, 2 is reserved for
+// `ags check` expired tokens, and 3-6 map the ags error classes.
+const (
+	exitGeneric       = 1
+	exitNotFound      = 3
+	exitInvalidInput  = 4
+	exitIO            = 5
+	exitAlreadyExists = 6
+)
+
+func exitCode(err error) int {
+	var exitErr *ags.ExitCodeError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, ags.ErrProfileNotFound):
+		return exitNotFound
+	case errors.Is(err, ags.ErrInvalidInput):
+		return exitInvalidInput
+	case errors.Is(err, ags.ErrIO):
+		return exitIO
+	case errors.Is(err, ags.ErrAlreadyExists):
+		return exitAlreadyExists
+	default:
+		return exitGeneric
+	}
+}
+
 func main() {
-	osExit(run(os.Args[1:], os.Stdout, os.Stderr))
+	osExit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/nishantdesai/coding-agent-account-switcher/internal/ags"
 )
 
 func TestRunSuccess(t *testing.T) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	code := run([]string{"help"}, &stdout, &stderr)
+	code := run([]string{"help"}, nil, &stdout, &stderr)
 	if code != 0 {
 		t.Fatalf("expected exit code 0, got %d", code)
 	}
@@ -25,9 +32,9 @@ func TestRunSuccess(t *testing.T) {
 func TestRunError(t *testing.T) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	code := run([]string{"unknown"}, &stdout, &stderr)
-	if code != 1 {
-		t.Fatalf("expected exit code 1, got %d", code)
+	code := run([]string{"unknown"}, nil, &stdout, &stderr)
+	if code != exitInvalidInput {
+		t.Fatalf("expected exit code %d, got %d", exitInvalidInput, code)
 	}
 	if !strings.Contains(stderr.String(), "Error:") {
 		t.Fatalf("expected error output, got %q", stderr.String())
@@ -62,3 +69,101 @@ func TestMainCallsExit(t *testing.T) {
 
 	main()
 }
+
+func TestRunMapsExitCodeError(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "expired.json")
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1}`))
+	if err := os.WriteFile(source, []byte(`{"tokens":{"access_token":"e30.`+claims+`.sig"}}`), 0o600); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	if code := run([]string{"save", "codex", "old", "--source", source, "--root", root}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("save: exit %d stderr=%q", code, stderr.String())
+	}
+	if code := run([]string{"check", "--root", root}, nil, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2 for expired token, got %d", code)
+	}
+}
+
+func TestExitCodeClasses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cases := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"profile not found", []string{"use", "codex", "missing", "--root", root}, exitNotFound},
+		{"invalid label", []string{"save", "codex", "bad label"}, exitInvalidInput},
+		{"bad flag", []string{"list", "--bad-flag"}, exitInvalidInput},
+		{"missing source", []string{"save", "codex", "work", "--source", filepath.Join(root, "missing.json"), "--root", root}, exitIO},
+	}
+	for _, tc := range cases {
+		if code := run(tc.args, nil, &stdout, &stderr); code != tc.want {
+			t.Fatalf("%s: expected exit code %d, got %d (stderr=%q)", tc.name, tc.want, code, stderr.String())
+		}
+	}
+
+	if code := exitCode(errors.New("boom")); code != exitGeneric {
+		t.Fatalf("expected generic exit code, got %d", code)
+	}
+	if code := exitCode(fmt.Errorf("wrapped: %w", ags.ErrAlreadyExists)); code != exitAlreadyExists {
+		t.Fatalf("expected already-exists exit code, got %d", code)
+	}
+}
+
+func TestRunErrorJSON(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code := run([]string{"use", "codex", "missing", "--error-json", "--root", root}, nil, &stdout, &stderr)
+	if code != exitNotFound {
+		t.Fatalf("expected exit code %d, got %d", exitNotFound, code)
+	}
+	var payload struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &payload); err != nil {
+		t.Fatalf("expected JSON on stderr, got %q: %v", stderr.String(), err)
+	}
+	if payload.Code != exitNotFound || !strings.Contains(payload.Error, "missing") {
+		t.Fatalf("unexpected error payload: %+v", payload)
+	}
+
+	stderr.Reset()
+	t.Setenv("AGS_ERROR_JSON", "1")
+	if code := run([]string{"unknown"}, nil, &stdout, &stderr); code != exitInvalidInput {
+		t.Fatalf("expected exit code %d, got %d", exitInvalidInput, code)
+	}
+	if !strings.HasPrefix(stderr.String(), `{"error":"unknown command`) || !strings.Contains(stderr.String(), `"code":4}`) {
+		t.Fatalf("expected JSON error from AGS_ERROR_JSON, got %q", stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"help", "--error-json"}, nil, &stdout, &stderr); code != 0 || stderr.Len() != 0 {
+		t.Fatalf("success path changed: code=%d stderr=%q", code, stderr.String())
+	}
+}
+
+func TestRunSilentExitCodeError(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	if code := run([]string{"active", "codex", "--exit-code", "--root", t.TempDir()}, nil, &stdout, &stderr); code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Fatalf("expected no output, got stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
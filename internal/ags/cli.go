@@ -1,42 +1,135 @@
 package ags
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"regexp"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var labelPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+var (
+	// stdinIsTerminal decides whether delete and save may prompt for confirmation.
+	stdinIsTerminal = isTerminal
+	// watchContext is cancelled when ags active --watch should stop.
+	watchContext = func() (context.Context, context.CancelFunc) {
+		return signal.NotifyContext(context.Background(), os.Interrupt)
+	}
+	watchInterval = 500 * time.Millisecond
+)
+
+// shortAccountIDLength is how much of an account id list --id shows.
+const shortAccountIDLength = 12
+
+// cliOptions carries what Run works out once per invocation: the global
+// --timeout and --log-file flags and the label rule of the data root. Each
+// command gets its own copy, so concurrent Runs do not share any of it.
+type cliOptions struct {
+	ioTimeout time.Duration
+	logFile   string
+	labels    labelRule
+}
 
-func Run(args []string, stdout io.Writer, stderr io.Writer) error {
-	_ = stderr
+// Run executes one ags command. stdin is read by commands that take input,
+// such as save --source - and the delete confirmation; nil reads as empty.
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	args, timeout, err := splitTimeoutFlag(args)
+	if err != nil {
+		return err
+	}
+	args, logFile, err := splitLogFileFlag(args)
+	if err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		printRootUsage(stdout)
 		return nil
 	}
+	labels, err := configuredLabelRule(rootFromArgs(args[1:]), timeout)
+	if err != nil {
+		return err
+	}
+	cli := cliOptions{ioTimeout: timeout, logFile: logFile, labels: labels}
 
 	command := args[0]
 	switch command {
 	case "save":
-		return runSave(args[1:], stdout)
+		return runSave(cli, args[1:], stdin, stdout, stderr)
 	case "use":
-		return runUse(args[1:], stdout)
+		return runUse(cli, args[1:], stdout, stderr)
 	case "delete":
-		return runDelete(args[1:], stdout)
+		return runDelete(cli, args[1:], stdin, stdout, stderr)
 	case "list":
-		return runList(args[1:], stdout)
+		return runList(cli, args[1:], stdout)
 	case "active":
-		return runActive(args[1:], stdout)
+		return runActive(cli, args[1:], stdout)
+	case "restore-state":
+		return runRestoreState(cli, args[1:], stdout)
+	case "check":
+		return runCheck(cli, args[1:], stdout)
+	case "alias":
+		return runAlias(cli, args[1:], stdout)
+	case "note":
+		return runNote(cli, args[1:], stdout)
+	case "tag":
+		return runTag(cli, args[1:], stdout)
+	case "diag":
+		return runDiag(cli, args[1:], stdout)
+	case "dedupe":
+		return runDedupe(cli, args[1:], stdout)
+	case "touch":
+		return runTouch(cli, args[1:], stdout)
+	case "snapshot-path":
+		return runSnapshotPath(cli, args[1:], stdout, stderr)
+	case "move":
+		return runMove(cli, args[1:], stdout)
+	case "providers":
+		return runProviders(cli, args[1:], stdout)
+	case "history":
+		return runHistory(cli, args[1:], stdout)
+	case "find":
+		return runFind(cli, args[1:], stdout)
+	case "diff":
+		return runDiff(cli, args[1:], stdout)
+	case "export-env":
+		return runExportEnv(cli, args[1:], stdout)
+	case "link":
+		return runLink(cli, args[1:], stdout, stderr)
+	case "inspect":
+		return runInspect(cli, args[1:], stdout)
+	case "whoami":
+		return runWhoami(cli, args[1:], stdout)
+	case "default":
+		return runDefault(cli, args[1:], stdout)
+	case "config":
+		return runConfig(cli, args[1:], stdout)
+	case "cache":
+		return runCache(cli, args[1:], stdout)
+	case "batch":
+		return runBatch(cli, args[1:], stdin, stdout, stderr)
+	case "gc":
+		return runGC(cli, args[1:], stdout)
+	case "doctor":
+		return runDoctor(cli, args[1:], stdin, stdout)
+	case "completion":
+		return runCompletion(args[1:], stdout)
 	case "version", "--version", "-V":
-		return runVersion(stdout)
+		return runVersion(args[1:], stdout)
 	case "help", "--help", "-h":
 		return runHelp(args[1:], stdout)
 	default:
-		return fmt.Errorf("unknown command %q\n\n%s", command, rootUsageText())
+		return invalidInputf("unknown command %q\n\n%s", command, rootUsageText())
 	}
 }
 
@@ -48,25 +141,29 @@ func runHelp(args []string, stdout io.Writer) error {
 
 	command := strings.ToLower(args[0])
 	switch command {
-	case "save", "use", "delete", "list", "active", "version":
+	case "save", "use", "delete", "list", "active", "check", "restore-state", "alias", "note", "tag", "touch", "snapshot-path", "find", "diff", "export-env", "link", "inspect", "whoami", "default", "config", "cache", "gc", "doctor", "dedupe", "move", "providers", "history", "diag", "batch", "completion", "version":
 		printCommandUsage(stdout, command)
 		return nil
 	default:
-		return fmt.Errorf("unknown help topic %q\n\n%s", command, rootUsageText())
+		return invalidInputf("unknown help topic %q\n\n%s", command, rootUsageText())
 	}
 }
 
-func runSave(args []string, stdout io.Writer) error {
+func runSave(cli cliOptions, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	if wantsHelp(args) {
 		printCommandUsage(stdout, "save")
 		return nil
 	}
 	if len(args) == 0 {
-		return errors.New("usage: ags save <tool> <label> [--source <path>] [--provider <id>] [--root <path>] OR ags save <tool> --label <name> [--source <path>] [--provider <id>] [--root <path>]")
+		return invalidInput("usage: ags save <tool> <label> [--source <path>] [--provider <id>] [--root <path>] OR ags save <tool> --label <name> [--source <path>] [--provider <id>] [--root <path>]")
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
 	}
 	tool, ok := ParseTool(strings.ToLower(args[0]))
 	if !ok {
-		return fmt.Errorf("invalid tool %q. expected one of: codex, pi", args[0])
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
 	}
 
 	positionalLabel, parseArgs := splitPositionalLabel(args)
@@ -76,37 +173,121 @@ func runSave(args []string, stdout io.Writer) error {
 
 	label := fs.String("label", "", "Profile label name, e.g. work")
 	labelShort := fs.String("l", "", "Profile label name, e.g. work")
-	source := fs.String("source", "", "Override source auth path for this save")
-	provider := fs.String("provider", "", "For pi only: save just one provider (codex, anthropic, or provider key)")
+	source := fs.String("source", "", "Override source auth path for this save (- reads stdin)")
+	provider := fs.String("provider", "", "For pi only: save selected providers (codex, anthropic, provider key, comma-separated list, or all)")
 	root := fs.String("root", defaultRootDir(), "AGS data root directory")
 	verbose := fs.Bool("verbose", false, "Print additional detail lines")
+	soon := fs.Duration("soon", defaultExpiringSoon, "Report tokens expiring within this window as expiring_soon")
+	note := fs.String("note", "", "Freeform note shown in list --verbose (empty clears it)")
+	followSymlinks := fs.Bool("follow-symlinks", false, "Allow the source auth path to be a symlink")
+	fromActive := fs.Bool("from-active", false, "Save the tool's live runtime auth file; fail if it is missing")
+	gzipSnapshot := fs.Bool("gzip", false, "Store the snapshot gzip-compressed (default from compress_snapshots in config.json)")
+	fs.Bool("no-identity-cache", false, "Report only the identity in the token; do not read or update the identity cache")
+	var tags tagListFlag
+	fs.Var(&tags, "tag", "Add a tag to the profile; repeatable or comma-separated")
+	strictJSON := fs.Bool("strict-json", false, "Reject a source with invalid UTF-8, duplicate keys, or trailing content")
+	labelFromAccount := fs.Bool("label-from-account", false, "Name the profile after the source's account email instead of taking a label")
+	force := fs.Bool("force", false, "Replace an existing snapshot with different content without asking")
+	expectTool := fs.String("expect-tool", ExpectToolWarn, "When the source looks like another tool's auth file: warn, strict (refuse), or off")
+	verifyExpiry := fs.Bool("verify-expiry-future", false, "Refuse to save a source whose token has already expired (for pi, all providers)")
+	fromURL := fs.String("from-url", "", "Fetch the source auth JSON from an http(s):// or unix:// URL")
+	allowRemote := fs.Bool("allow-remote", false, "With --from-url, allow hosts other than localhost and loopback addresses")
 
 	if err := fs.Parse(parseArgs); err != nil {
-		return err
+		return classify(ErrInvalidInput, err)
+	}
+	if *fromActive && strings.TrimSpace(*source) != "" {
+		return invalidInput("--from-active and --source are mutually exclusive")
+	}
+	if strings.TrimSpace(*fromURL) != "" && (*fromActive || strings.TrimSpace(*source) != "") {
+		return invalidInput("--from-url cannot be combined with --source or --from-active")
+	}
+	if *allowRemote && strings.TrimSpace(*fromURL) == "" {
+		return invalidInput("--allow-remote requires --from-url")
 	}
 
 	resolvedLabel, err := resolveLabel(*label, *labelShort, positionalLabel, fs.Args())
 	if err != nil {
 		return err
 	}
-	if strings.TrimSpace(resolvedLabel) == "" {
-		return errors.New("--label is required")
-	}
-	if !labelPattern.MatchString(resolvedLabel) {
-		return errors.New("--label must match [a-zA-Z0-9._-]+")
+	if *labelFromAccount {
+		if strings.TrimSpace(resolvedLabel) != "" {
+			return invalidInput("--label-from-account cannot be combined with a label")
+		}
+	} else {
+		if strings.TrimSpace(resolvedLabel) == "" {
+			resolvedLabel, err = defaultLabelForRoot(cli, *root, tool)
+			if err != nil {
+				return err
+			}
+		}
+		if !cli.labels.valid(resolvedLabel) {
+			return invalidInputf("--label must match %s", cli.labels)
+		}
 	}
 	if strings.TrimSpace(*provider) != "" && tool != ToolPi {
-		return errors.New("--provider is only supported for tool=pi")
+		return invalidInput("--provider is only supported for tool=pi")
 	}
 
-	manager, err := NewManager(*root)
+	manager, err := newManagerFromFlags(cli, fs, *root, *soon)
 	if err != nil {
 		return err
 	}
-	result, err := manager.SaveWithPIProvider(tool, resolvedLabel, *source, strings.TrimSpace(*provider))
+	opts := SaveOptions{
+		SourceOverride:     *source,
+		PIProvider:         strings.TrimSpace(*provider),
+		Stdin:              stdin,
+		FollowSymlinks:     *followSymlinks,
+		FromActive:         *fromActive,
+		Tags:               tags,
+		StrictJSON:         *strictJSON,
+		LabelFromAccount:   *labelFromAccount,
+		ExpectTool:         strings.ToLower(strings.TrimSpace(*expectTool)),
+		VerifyExpiryFuture: *verifyExpiry,
+		FromURL:            strings.TrimSpace(*fromURL),
+		AllowRemote:        *allowRemote,
+	}
+	if flagWasSet(fs, "note") {
+		opts.Note = note
+	}
+	if flagWasSet(fs, "gzip") {
+		opts.Compress = gzipSnapshot
+	}
+	// Only prompt when stdin is a terminal that is not also the source;
+	// scripted saves keep overwriting as before.
+	if !*force && strings.TrimSpace(*source) != "-" && stdinIsTerminal(stdin) {
+		opts.ConfirmOverwrite = func(label string, previous AuthInsight, next AuthInsight) bool {
+			fmt.Fprintf(stdout, "Replace %s/%s (%s) with different auth (%s)? [y/N] ", tool, label, orDash(shortIdentity(previous)), orDash(shortIdentity(next)))
+			return readConfirmation(stdin)
+		}
+	}
+	result, err := manager.SaveWithOptions(tool, resolvedLabel, opts)
 	if err != nil {
+		logOperation(stderr, manager, "save", tool, resolvedLabel, "", err)
 		return err
 	}
+	if result.Declined {
+		fmt.Fprintln(stdout, "Aborted; nothing saved.")
+		return nil
+	}
+	logOperation(stderr, manager, "save", tool, result.Label, result.Insight.AccountID, nil)
+
+	if len(result.ExpiredProviders) > 0 {
+		fmt.Fprintf(stderr, "Warning: saved with expired pi provider(s): %s\n", strings.Join(result.ExpiredProviders, ", "))
+	}
+	if result.DetectedTool != "" {
+		fmt.Fprintf(stderr, "Warning: source looks like a %s auth file, not %s; if so, run ags move %s %s %s\n", result.DetectedTool, result.Tool, result.Tool, result.Label, result.DetectedTool)
+	}
+	if len(result.DuplicateLabels) > 0 {
+		fmt.Fprintf(stderr, "Warning: identical to existing label(s): %s\n", strings.Join(result.DuplicateLabels, ", "))
+	}
+	if len(result.AccountConflicts) > 0 {
+		accounts := []string{fmt.Sprintf("%s=%s", result.Tool, accountGroupKey(result.Insight))}
+		for _, conflict := range result.AccountConflicts {
+			accounts = append(accounts, fmt.Sprintf("%s=%s", conflict.Tool, conflict.Account))
+		}
+		fmt.Fprintf(stderr, "Warning: label %s now spans multiple accounts: %s\n", result.Label, strings.Join(accounts, ", "))
+	}
 
 	identity := formatIdentity(result.Insight)
 	if identity != "" {
@@ -115,8 +296,13 @@ func runSave(args []string, stdout io.Writer) error {
 		fmt.Fprintf(stdout, "Saved %s for %s\n", result.Tool, result.Label)
 	}
 
+	if *fromActive {
+		fmt.Fprintf(stdout, "- source: %s (active runtime)\n", result.SourcePath)
+	}
 	if *verbose {
-		fmt.Fprintf(stdout, "- source: %s\n", result.SourcePath)
+		if !*fromActive {
+			fmt.Fprintf(stdout, "- source: %s\n", result.SourcePath)
+		}
 		fmt.Fprintf(stdout, "- snapshot: %s\n", result.SnapshotPath)
 		if result.ChangedSinceLastSave {
 			fmt.Fprintln(stdout, "- change: changed since last save (new auth snapshot)")
@@ -128,17 +314,21 @@ func runSave(args []string, stdout io.Writer) error {
 	return nil
 }
 
-func runUse(args []string, stdout io.Writer) error {
+func runUse(cli cliOptions, args []string, stdout io.Writer, stderr io.Writer) error {
 	if wantsHelp(args) {
 		printCommandUsage(stdout, "use")
 		return nil
 	}
 	if len(args) == 0 {
-		return errors.New("usage: ags use <tool> <label> [--target <path>] [--provider <id>] [--root <path>] OR ags use <tool> --label <name> [--target <path>] [--provider <id>] [--root <path>]")
+		return invalidInput("usage: ags use <tool> <label> [--target <path>] [--provider <id>] [--backup] [--root <path>] OR ags use <tool> --label <name> [--target <path>] [--provider <id>] [--backup] [--root <path>]")
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
 	}
 	tool, ok := ParseTool(strings.ToLower(args[0]))
 	if !ok {
-		return fmt.Errorf("invalid tool %q. expected one of: codex, pi", args[0])
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
 	}
 
 	positionalLabel, parseArgs := splitPositionalLabel(args)
@@ -149,36 +339,182 @@ func runUse(args []string, stdout io.Writer) error {
 	label := fs.String("label", "", "Profile label name, e.g. work")
 	labelShort := fs.String("l", "", "Profile label name, e.g. work")
 	target := fs.String("target", "", "Override runtime target path for this use")
-	provider := fs.String("provider", "", "For pi only: apply just one provider (codex, anthropic, or provider key)")
+	provider := fs.String("provider", "", "For pi only: apply selected providers (codex, anthropic, provider key, comma-separated list, or all)")
+	backup := fs.Bool("backup", false, "Copy the current runtime auth file into the backups directory before overwriting it")
+	followSymlinks := fs.Bool("follow-symlinks", false, "Allow the runtime target path to be a symlink and write to the file it points at")
+	noMerge := fs.Bool("no-merge", false, "For pi: replace the runtime auth file instead of merging providers into it")
+	mergeStrategy := fs.String("merge-strategy", PIMergeReplace, "For pi: replace each provider object (replace) or merge into it recursively (deep)")
+	printOnly := fs.Bool("print", false, "Write the snapshot JSON to stdout instead of the runtime auth file")
+	strict := fs.Bool("strict", false, "Refuse to apply a snapshot that was modified since it was saved")
+	revert := fs.Bool("revert", false, "Re-activate the label that was active before the current one")
+	runtimeCheck := fs.Bool("runtime-check", false, "Re-read the written runtime file and warn if the tool would likely reject it")
+	expectEmail := fs.String("as", "", "Refuse to switch unless the snapshot belongs to this account email")
+	strictJSON := fs.Bool("strict-json", false, "Reject a snapshot (or pi runtime file to merge into) with invalid UTF-8, duplicate keys, or trailing content")
+	ifChanged := fs.Bool("if-changed", false, "Skip the write and state update when the runtime auth already matches the snapshot")
+	quietOnUnchanged := fs.Bool("quiet-on-unchanged", false, "Print nothing on success when the snapshot is unchanged since its last use")
+	chmod := fs.String("chmod", "", "Octal permission mode for the runtime auth file (default from runtime_mode in config.json, else 0600)")
+	backupRuntimeTo := fs.String("backup-runtime-to", "", "Save the current runtime auth file under this label before switching")
+	envPassthrough := fs.Bool("env-passthrough", false, "Also write the tool's env_files from config.json for the activated profile")
+	fs.Bool("no-identity-cache", false, "Report only the identity in the token; do not read or update the identity cache")
 	root := fs.String("root", defaultRootDir(), "AGS data root directory")
 	verbose := fs.Bool("verbose", false, "Print additional detail lines")
+	soon := fs.Duration("soon", defaultExpiringSoon, "Report tokens expiring within this window as expiring_soon")
 
 	if err := fs.Parse(parseArgs); err != nil {
-		return err
+		return classify(ErrInvalidInput, err)
 	}
 
 	resolvedLabel, err := resolveLabel(*label, *labelShort, positionalLabel, fs.Args())
 	if err != nil {
 		return err
 	}
+	if *revert && strings.TrimSpace(resolvedLabel) != "" {
+		return invalidInput("--revert cannot be combined with a label")
+	}
+	if *revert {
+		manager, err := newCLIManager(cli, *root)
+		if err != nil {
+			return err
+		}
+		resolvedLabel, err = manager.PreviousLabel(tool)
+		if err != nil {
+			return err
+		}
+	}
 	if strings.TrimSpace(resolvedLabel) == "" {
-		return errors.New("--label is required")
+		resolvedLabel, err = defaultLabelForRoot(cli, *root, tool)
+		if err != nil {
+			return err
+		}
 	}
-	if !labelPattern.MatchString(resolvedLabel) {
-		return errors.New("--label must match [a-zA-Z0-9._-]+")
+	if !cli.labels.valid(resolvedLabel) {
+		return invalidInputf("--label must match %s", cli.labels)
 	}
 	if strings.TrimSpace(*provider) != "" && tool != ToolPi {
-		return errors.New("--provider is only supported for tool=pi")
+		return invalidInput("--provider is only supported for tool=pi")
+	}
+
+	if *printOnly && strings.TrimSpace(*target) != "" {
+		return invalidInput("--print and --target are mutually exclusive")
+	}
+	if *printOnly && *backup {
+		return invalidInput("--print and --backup are mutually exclusive")
+	}
+	// These only affect the runtime write, which --print skips.
+	for _, name := range []string{"if-changed", "runtime-check", "quiet-on-unchanged", "no-merge", "merge-strategy"} {
+		if *printOnly && flagWasSet(fs, name) {
+			return invalidInputf("--print and --%s are mutually exclusive", name)
+		}
+	}
+	*mergeStrategy = strings.ToLower(strings.TrimSpace(*mergeStrategy))
+	if *mergeStrategy != PIMergeReplace && *mergeStrategy != PIMergeDeep {
+		return invalidInput("--merge-strategy must be replace or deep")
+	}
+	if flagWasSet(fs, "merge-strategy") && tool != ToolPi {
+		return invalidInput("--merge-strategy is only supported for tool=pi")
+	}
+	if flagWasSet(fs, "merge-strategy") && *noMerge {
+		return invalidInput("--merge-strategy and --no-merge are mutually exclusive")
+	}
+	if *printOnly && flagWasSet(fs, "as") {
+		return invalidInput("--print and --as are mutually exclusive")
+	}
+	if flagWasSet(fs, "as") && strings.TrimSpace(*expectEmail) == "" {
+		return invalidInput("--as requires an account email")
+	}
+	if *printOnly && *envPassthrough {
+		return invalidInput("--print and --env-passthrough are mutually exclusive")
+	}
+	*backupRuntimeTo = strings.TrimSpace(*backupRuntimeTo)
+	if flagWasSet(fs, "backup-runtime-to") {
+		if *printOnly {
+			return invalidInput("--print and --backup-runtime-to are mutually exclusive")
+		}
+		if !cli.labels.valid(*backupRuntimeTo) {
+			return invalidInputf("--backup-runtime-to must match %s", cli.labels)
+		}
+	}
+	var mode os.FileMode
+	if flagWasSet(fs, "chmod") {
+		if *printOnly {
+			return invalidInput("--print and --chmod are mutually exclusive")
+		}
+		mode, err = parseFileMode(*chmod)
+		if err != nil {
+			return err
+		}
 	}
 
-	manager, err := NewManager(*root)
+	manager, err := newManagerFromFlags(cli, fs, *root, *soon)
 	if err != nil {
 		return err
 	}
-	result, err := manager.UseWithPIProvider(tool, resolvedLabel, *target, strings.TrimSpace(*provider))
+	if *printOnly {
+		result, err := manager.ReadSnapshotWithOptions(tool, resolvedLabel, UseOptions{
+			PIProvider: strings.TrimSpace(*provider),
+			Strict:     *strict,
+			StrictJSON: *strictJSON,
+		})
+		if err != nil {
+			return err
+		}
+		if result.SnapshotModified {
+			printSnapshotModified(stderr, result.Label)
+		}
+		_, err = stdout.Write(result.Raw)
+		return err
+	}
+	result, err := manager.UseWithOptions(tool, resolvedLabel, UseOptions{
+		TargetOverride:  *target,
+		PIProvider:      strings.TrimSpace(*provider),
+		Backup:          *backup,
+		NoMerge:         *noMerge,
+		MergeStrategy:   *mergeStrategy,
+		FollowSymlinks:  *followSymlinks,
+		Strict:          *strict,
+		RuntimeCheck:    *runtimeCheck,
+		IfChanged:       *ifChanged,
+		StrictJSON:      *strictJSON,
+		ExpectEmail:     *expectEmail,
+		Mode:            mode,
+		BackupRuntimeTo: *backupRuntimeTo,
+		EnvFiles:        *envPassthrough,
+		HookOutput:      stderr,
+	})
 	if err != nil {
+		logOperation(stderr, manager, "use", tool, resolvedLabel, "", err)
 		return err
 	}
+	if result.RuntimeBackup != nil {
+		logOperation(stderr, manager, "save", tool, result.RuntimeBackup.Label, result.RuntimeBackup.Insight.AccountID, nil)
+	}
+	logOperation(stderr, manager, "use", tool, resolvedLabel, result.Insight.AccountID, nil)
+
+	if result.SnapshotModified {
+		printSnapshotModified(stderr, result.Label)
+	}
+	if result.AlreadyActive {
+		fmt.Fprintf(stdout, "%s %s is already active; nothing written\n", result.Tool, result.Label)
+		return nil
+	}
+	if result.Warning != "" {
+		fmt.Fprintf(stderr, "Warning: %s\n", result.Warning)
+	}
+	if result.PostUseHookError != "" {
+		fmt.Fprintf(stderr, "Warning: %s\n", result.PostUseHookError)
+	}
+	if result.EnvFileError != "" {
+		fmt.Fprintf(stderr, "Warning: %s\n", result.EnvFileError)
+	}
+	for _, problem := range result.RuntimeProblems {
+		fmt.Fprintf(stderr, "Warning: runtime check: %s (%s)\n", problem, result.TargetPath)
+	}
+	if result.TargetMode&^0o644 != 0 {
+		fmt.Fprintf(stderr, "Warning: %s holds credentials and was written with mode %04o, broader than 0644\n", result.TargetPath, result.TargetMode)
+	}
+	if *quietOnUnchanged && result.ChangeSinceLastUse == changeSignalUnchanged {
+		return nil
+	}
 
 	identity := formatIdentity(result.Insight)
 	if identity != "" {
@@ -186,6 +522,21 @@ func runUse(args []string, stdout io.Writer) error {
 	} else {
 		fmt.Fprintf(stdout, "Using %s for %s\n", result.Tool, result.Label)
 	}
+	if saved := result.RuntimeBackup; saved != nil {
+		previous := formatIdentity(saved.Insight)
+		if previous == "" {
+			previous = "previous runtime auth"
+		}
+		fmt.Fprintf(stdout, "- saved %s as %s\n", previous, saved.Label)
+	}
+	for _, path := range result.EnvFiles {
+		fmt.Fprintf(stdout, "- env file: %s\n", path)
+	}
+	if result.BackupPath != "" {
+		fmt.Fprintf(stdout, "- backup: %s\n", result.BackupPath)
+	} else if *backup {
+		fmt.Fprintln(stdout, "- backup: skipped (no existing runtime auth file)")
+	}
 
 	if *verbose {
 		fmt.Fprintf(stdout, "- target: %s\n", result.TargetPath)
@@ -195,17 +546,21 @@ func runUse(args []string, stdout io.Writer) error {
 	return nil
 }
 
-func runDelete(args []string, stdout io.Writer) error {
+func runDelete(cli cliOptions, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	if wantsHelp(args) {
 		printCommandUsage(stdout, "delete")
 		return nil
 	}
 	if len(args) == 0 {
-		return errors.New("usage: ags delete <tool> <label> [--root <path>] OR ags delete <tool> --label <name> [--root <path>]")
+		return invalidInput("usage: ags delete <tool> <label> [--root <path>] OR ags delete <tool> --label <name> [--root <path>]")
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
 	}
 	tool, ok := ParseTool(strings.ToLower(args[0]))
 	if !ok {
-		return fmt.Errorf("invalid tool %q. expected one of: codex, pi", args[0])
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
 	}
 
 	positionalLabel, parseArgs := splitPositionalLabel(args)
@@ -216,9 +571,11 @@ func runDelete(args []string, stdout io.Writer) error {
 	label := fs.String("label", "", "Profile label name, e.g. work")
 	labelShort := fs.String("l", "", "Profile label name, e.g. work")
 	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	yes := fs.Bool("yes", false, "Delete without asking for confirmation")
+	yesShort := fs.Bool("y", false, "Delete without asking for confirmation")
 
 	if err := fs.Parse(parseArgs); err != nil {
-		return err
+		return classify(ErrInvalidInput, err)
 	}
 
 	resolvedLabel, err := resolveLabel(*label, *labelShort, positionalLabel, fs.Args())
@@ -226,33 +583,106 @@ func runDelete(args []string, stdout io.Writer) error {
 		return err
 	}
 	if strings.TrimSpace(resolvedLabel) == "" {
-		return errors.New("--label is required")
+		return invalidInput("--label is required")
 	}
-	if !labelPattern.MatchString(resolvedLabel) {
-		return errors.New("--label must match [a-zA-Z0-9._-]+")
+	isPattern := isLabelPattern(resolvedLabel)
+	if !isPattern && !cli.labels.valid(resolvedLabel) {
+		return invalidInputf("--label must match %s", cli.labels)
 	}
 
-	manager, err := NewManager(*root)
+	manager, err := newCLIManager(cli, *root)
 	if err != nil {
 		return err
 	}
-	result, err := manager.Delete(tool, resolvedLabel)
-	if err != nil {
-		return err
+
+	confirm := !*yes && !*yesShort
+	if confirm && !stdinIsTerminal(stdin) {
+		return invalidInput("stdin is not a terminal; pass --yes to delete without confirmation")
+	}
+
+	// Resolve the labels up front when prompting so a typo fails before the
+	// user is asked to confirm it.
+	labels := []string{resolvedLabel}
+	if isPattern || confirm {
+		labels, err = manager.MatchLabels(tool, resolvedLabel)
+		if err != nil {
+			return err
+		}
+	}
+	if confirm {
+		if !isPattern {
+			fmt.Fprintf(stdout, "Delete %s/%s? [y/N] ", tool, labels[0])
+		} else {
+			fmt.Fprintf(stdout, "Delete %d %s label(s): %s? [y/N] ", len(labels), tool, strings.Join(labels, ", "))
+		}
+		if !readConfirmation(stdin) {
+			fmt.Fprintln(stdout, "Aborted; nothing deleted.")
+			return nil
+		}
 	}
 
+	for i, target := range labels {
+		result, err := manager.Delete(tool, target)
+		if err != nil {
+			logOperation(stderr, manager, "delete", tool, target, "", err)
+			return err
+		}
+		logOperation(stderr, manager, "delete", tool, target, result.AccountID, nil)
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		printDeleteResult(stdout, result)
+	}
+	return nil
+}
+
+func printDeleteResult(stdout io.Writer, result *DeleteResult) {
 	fmt.Fprintf(stdout, "Deleted %s label=%s\n", result.Tool, result.Label)
 	fmt.Fprintf(stdout, "- snapshot: %s\n", result.SnapshotPath)
-	if result.SnapshotDeleted {
+	if result.SnapshotLinked {
+		fmt.Fprintln(stdout, "- snapshot file: kept (linked)")
+	} else if result.SnapshotDeleted {
 		fmt.Fprintln(stdout, "- snapshot file: removed")
 	} else {
 		fmt.Fprintln(stdout, "- snapshot file: already missing")
 	}
 	fmt.Fprintln(stdout, "- state: removed")
-	return nil
 }
 
-func runList(args []string, stdout io.Writer) error {
+// isLabelPattern reports whether a delete label should be treated as a glob.
+func isLabelPattern(label string) bool {
+	return strings.ContainsAny(label, "*?[")
+}
+
+// isTerminal reports whether r is an interactive character device. The null
+// device is a character device too, and is what cron, systemd, and CI jobs
+// usually get as stdin, so it never counts.
+func isTerminal(r io.Reader) bool {
+	file, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// readConfirmation reads one line and accepts only "y" or "yes".
+func readConfirmation(r io.Reader) bool {
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func runList(cli cliOptions, args []string, stdout io.Writer) error {
 	if wantsHelp(args) {
 		printCommandUsage(stdout, "list")
 		return nil
@@ -264,7 +694,7 @@ func runList(args []string, stdout io.Writer) error {
 	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
 		tool, ok := ParseTool(strings.ToLower(args[0]))
 		if !ok {
-			return fmt.Errorf("invalid tool %q. expected one of: codex, pi", args[0])
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
 		}
 		toolFilter = &tool
 		flagArgs = args[1:]
@@ -275,30 +705,146 @@ func runList(args []string, stdout io.Writer) error {
 	fs := flag.NewFlagSet("list", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 
+	var tools toolSetFlag
+	fs.Var(&tools, "tool", "Only list these tools; repeatable or comma-separated")
+	fs.Var(&tools, "tools", "Alias for --tool")
+
 	root := fs.String("root", defaultRootDir(), "AGS data root directory")
 	verbose := fs.Bool("verbose", false, "Print additional detail lines")
 	plain := fs.Bool("plain", false, "Print plain tab-separated output for scripts")
 	noHeaders := fs.Bool("no-headers", false, "With --plain, suppress header row")
+	account := fs.String("account", "", "Only show profiles for this account email or id")
+	byAccount := fs.Bool("by-account", false, "Group profiles by account instead of by tool")
+	soon := fs.Duration("soon", defaultExpiringSoon, "Report tokens expiring within this window as expiring_soon")
+	usedSince := fs.String("used-since", "", "Only show profiles used within this window (e.g. 7d, 12h)")
+	unusedFor := fs.String("unused-for", "", "Only show profiles not used within this window (e.g. 30d)")
+	jsonl := fs.Bool("jsonl", false, "Print one JSON object per profile, one per line")
+	plan := fs.String("plan", "", "Only show profiles on this account plan (e.g. Plus, Pro, Team)")
+	showID := fs.Bool("id", false, "Append the account email or short account id to each line")
+	sortKey := fs.String("sort", "", "Sort by expiry, saved, used, or label instead of tool then label")
+	reverse := fs.Bool("reverse", false, "With --sort, reverse the order (unknown times stay last)")
+	stale := fs.String("stale", "", "Mark profiles whose identity comes from a cache entry older than this (e.g. 30d)")
+	tag := fs.String("tag", "", "Only show profiles carrying this tag")
+	expiring := fs.Bool("expiring", false, "Only show profiles that need a refresh (expired or expiring soon), soonest first")
+	count := fs.Bool("count", false, "Print only how many profiles match, per tool and in total")
+	schema := fs.Bool("schema", false, "Print the JSON Schema of a --jsonl line instead of profiles")
+	fs.Bool("no-identity-cache", false, "Report only the identity in the token; do not read or update the identity cache")
 	if err := fs.Parse(flagArgs); err != nil {
-		return err
+		return classify(ErrInvalidInput, err)
 	}
 	if fs.NArg() > 0 {
-		return errors.New("usage: ags list [tool] [--verbose] [--root <path>]")
+		return invalidInput("usage: ags list [tool] [--verbose] [--id] [--plain|--jsonl|--count] [--sort <key> [--reverse]] [--expiring] [--stale <age>] [--tag <tag>] [--account <email-or-id>] [--plan <name>] [--by-account] [--used-since <age>] [--unused-for <age>] [--root <path>]")
+	}
+	usedSinceWindow, err := parseAgeFlag("--used-since", *usedSince)
+	if err != nil {
+		return err
+	}
+	unusedForWindow, err := parseAgeFlag("--unused-for", *unusedFor)
+	if err != nil {
+		return err
+	}
+	staleWindow, err := parseAgeFlag("--stale", *stale)
+	if err != nil {
+		return err
 	}
 	if *noHeaders && !*plain {
-		return errors.New("--no-headers requires --plain")
+		return invalidInput("--no-headers requires --plain")
+	}
+	if *jsonl && *plain {
+		return invalidInput("--jsonl and --plain are mutually exclusive")
+	}
+	if *schema {
+		if *plain || *count || *byAccount || *verbose {
+			return invalidInput("--schema only combines with --jsonl")
+		}
+		_, err := io.WriteString(stdout, listItemJSONSchema)
+		return err
+	}
+	if *count && (*jsonl || *plain || *byAccount || *verbose) {
+		return invalidInput("--count cannot be combined with --jsonl, --plain, --by-account, or --verbose")
+	}
+	*sortKey = strings.ToLower(strings.TrimSpace(*sortKey))
+	if *sortKey != "" && !validListSortKey(*sortKey) {
+		return invalidInputf("--sort must be one of: %s", strings.Join(listSortKeys, ", "))
+	}
+	if *reverse && *sortKey == "" {
+		return invalidInput("--reverse requires --sort")
+	}
+	if *sortKey != "" && *byAccount {
+		return invalidInput("--sort and --by-account are mutually exclusive")
+	}
+	if *expiring && *sortKey == "" && !*byAccount {
+		*sortKey = "expiry"
 	}
 
-	manager, err := NewManager(*root)
+	manager, err := newManagerFromFlags(cli, fs, *root, *soon)
 	if err != nil {
 		return err
 	}
 
-	items, err := manager.List(toolFilter)
+	if toolFilter != nil && len(tools) > 0 {
+		return invalidInputf("conflicting tool filters: positional %q and --tool %s; use one or the other", *toolFilter, tools.String())
+	}
+	if toolFilter != nil {
+		tools = toolSetFlag{*toolFilter}
+	}
+
+	filterItems := func(items []ListItem) []ListItem {
+		items = filterItemsByAccount(items, *account)
+		items = filterItemsByPlan(items, *plan)
+		items = filterItemsByTag(items, *tag)
+		if *expiring {
+			items = filterItemsNeedingRefresh(items)
+		}
+		return filterItemsByLastUsed(items, usedSinceWindow, unusedForWindow)
+	}
+	// Without a sort order, --jsonl writes each profile as soon as its
+	// snapshot is read instead of waiting for all of them.
+	if *jsonl && *sortKey == "" && !*byAccount {
+		enc := json.NewEncoder(stdout)
+		return manager.EachListItem(tools, func(item ListItem) error {
+			items := filterItems([]ListItem{item})
+			if len(items) == 0 {
+				return nil
+			}
+			markStaleIdentities(items, staleWindow)
+			if err := enc.Encode(newListItemJSON(items[0])); err != nil {
+				return err
+			}
+			return flushWriter(stdout)
+		})
+	}
+
+	items, err := manager.ListTools(tools)
 	if err != nil {
 		return err
 	}
+	items = filterItems(items)
+	if *byAccount {
+		sortItemsByAccount(items)
+	}
+	if *sortKey != "" {
+		sortListItems(items, *sortKey, *reverse)
+	}
+	if *count {
+		printListCount(stdout, items, tools)
+		return nil
+	}
+	staleCount := markStaleIdentities(items, staleWindow)
+	if *jsonl {
+		enc := json.NewEncoder(stdout)
+		for _, item := range items {
+			if err := enc.Encode(newListItemJSON(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if len(items) == 0 {
+		if *expiring {
+			fmt.Fprintln(stdout, "All profiles valid; nothing needs refreshing.")
+			return nil
+		}
 		fmt.Fprintln(stdout, "No saved profiles found.")
 		return nil
 	}
@@ -324,6 +870,16 @@ func runList(args []string, stdout io.Writer) error {
 		return nil
 	}
 
+	defer printStaleIdentityHint(stdout, staleCount, *stale)
+	if *byAccount {
+		printListByAccount(stdout, items, *verbose)
+		return nil
+	}
+	if *sortKey != "" {
+		printListSorted(stdout, items, *sortKey, *verbose, *showID)
+		return nil
+	}
+
 	fmt.Fprintln(stdout, "Saved profiles:")
 	currentTool := Tool("")
 	for i, item := range items {
@@ -337,424 +893,3769 @@ func runList(args []string, stdout io.Writer) error {
 
 		fmt.Fprintf(
 			stdout,
-			"  %-18s status=%-13s refresh=%-7s expires=%s\n",
+			"  %-18s status=%-13s refresh=%-7s expires=%s",
 			item.Label,
 			orDash(item.AuthInsight.Status),
 			orDash(item.AuthInsight.NeedsRefresh),
 			summarizeExpiry(item.AuthInsight.ExpiresAt),
 		)
+		if *showID {
+			fmt.Fprintf(stdout, " id=%s", orDash(shortIdentity(item.AuthInsight)))
+		}
+		printStaleIdentityMarker(stdout, item)
+		fmt.Fprintln(stdout)
 
 		if *verbose {
-			if identity := formatIdentity(item.AuthInsight); identity != "" {
-				fmt.Fprintf(stdout, "    account: %s\n", identity)
-			}
-			if item.AuthInsight.LastRefresh != "" {
-				fmt.Fprintf(stdout, "    last refresh: %s\n", formatHumanTime(item.AuthInsight.LastRefresh))
-			}
-			fmt.Fprintf(stdout, "    saved: %s\n", formatHumanTime(item.SavedAt))
-			if item.LastUsedAt != "" {
-				fmt.Fprintf(stdout, "    last used: %s\n", formatHumanTime(item.LastUsedAt))
-			}
-			fmt.Fprintf(stdout, "    snapshot: %s\n", item.Snapshot)
-			for _, detail := range item.AuthInsight.Details {
-				fmt.Fprintf(stdout, "    detail: %s\n", detail)
-			}
+			printListItemDetails(stdout, item)
 		}
 	}
 	return nil
 }
 
-func runVersion(stdout io.Writer) error {
-	fmt.Fprintf(stdout, "ags version %s\n", Version)
+// flushWriter flushes w when it buffers output, such as a *bufio.Writer, so
+// a streamed line reaches the reader right away.
+func flushWriter(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
 	return nil
 }
 
-func runActive(args []string, stdout io.Writer) error {
-	if wantsHelp(args) {
-		printCommandUsage(stdout, "active")
-		return nil
+// markStaleIdentities sets StaleIdentity on items whose identity came from
+// a cache entry older than window and returns how many it marked. A zero
+// window marks nothing.
+func markStaleIdentities(items []ListItem, window time.Duration) int {
+	if window <= 0 {
+		return 0
 	}
-
-	var toolFilter *Tool
-	var flagArgs []string
-
-	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
-		tool, ok := ParseTool(strings.ToLower(args[0]))
-		if !ok {
-			return fmt.Errorf("invalid tool %q. expected one of: codex, pi", args[0])
+	count := 0
+	for i := range items {
+		cachedAt, ok := parseISO(items[i].AuthInsight.IdentityCachedAt)
+		if ok && time.Since(cachedAt) > window {
+			items[i].StaleIdentity = true
+			count++
 		}
-		toolFilter = &tool
-		flagArgs = args[1:]
-	} else {
-		flagArgs = args
 	}
+	return count
+}
 
-	fs := flag.NewFlagSet("active", flag.ContinueOnError)
-	fs.SetOutput(io.Discard)
-	root := fs.String("root", defaultRootDir(), "AGS data root directory")
-	verbose := fs.Bool("verbose", false, "Print additional detail lines")
-	if err := fs.Parse(flagArgs); err != nil {
-		return err
-	}
-	if fs.NArg() > 0 {
-		return errors.New("usage: ags active [tool] [--verbose] [--root <path>]")
+func printStaleIdentityMarker(stdout io.Writer, item ListItem) {
+	if item.StaleIdentity {
+		fmt.Fprint(stdout, " identity=stale")
 	}
+}
 
-	manager, err := NewManager(*root)
-	if err != nil {
-		return err
+func printStaleIdentityHint(stdout io.Writer, count int, window string) {
+	if count == 0 {
+		return
 	}
+	fmt.Fprintf(stdout, "\n%d profile(s) show an account identity cached more than %s ago; re-save them to refresh it.\n", count, window)
+}
 
-	items, err := manager.Active(toolFilter)
-	if err != nil {
-		return err
+// toolSetFlag collects tools from repeated or comma-separated flag values.
+type toolSetFlag []Tool
+
+func (f *toolSetFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, tool := range *f {
+		names = append(names, tool.String())
 	}
+	return strings.Join(names, ",")
+}
 
-	fmt.Fprintln(stdout, "tool\tactive label\tstatus\truntime")
-	for _, item := range items {
-		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", item.Tool, orDash(item.ActiveLabel), item.Status, item.RuntimePath)
-		if *verbose {
-			for _, detail := range item.Details {
-				fmt.Fprintf(stdout, "  detail=%s\n", detail)
-			}
+func (f *toolSetFlag) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		tool, ok := ParseTool(part)
+		if !ok {
+			return fmt.Errorf("invalid tool %q. expected one of: codex, pi", part)
+		}
+		if !containsTool(*f, tool) {
+			*f = append(*f, tool)
 		}
 	}
 	return nil
 }
 
-func wantsHelp(args []string) bool {
-	for _, arg := range args {
-		if arg == "-h" || arg == "--help" {
-			return true
+// tagListFlag collects tags from repeated or comma-separated flag values.
+type tagListFlag []string
+
+func (f *tagListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *tagListFlag) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*f = append(*f, part)
 		}
 	}
-	return false
+	return nil
 }
 
-func splitPositionalLabel(args []string) (string, []string) {
-	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
-		return args[1], args[2:]
-	}
-	return "", args[1:]
+// listItemJSON is the machine-readable shape of one saved profile.
+type listItemJSON struct {
+	Tool         string   `json:"tool"`
+	Label        string   `json:"label"`
+	Status       string   `json:"status"`
+	NeedsRefresh string   `json:"needs_refresh"`
+	ExpiresAt    string   `json:"expires_at,omitempty"`
+	LastRefresh  string   `json:"last_refresh,omitempty"`
+	SavedAt      string   `json:"saved_at"`
+	LastUsedAt   string   `json:"last_used_at,omitempty"`
+	AccountEmail string   `json:"account_email,omitempty"`
+	AccountPlan  string   `json:"account_plan,omitempty"`
+	AccountID    string   `json:"account_id,omitempty"`
+	Issuer       string   `json:"issuer,omitempty"`
+	Subject      string   `json:"subject,omitempty"`
+	Audience     string   `json:"audience,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Snapshot     string   `json:"snapshot"`
+	Linked       bool     `json:"linked,omitempty"`
+	Details      []string `json:"details,omitempty"`
+	Tokens       []string `json:"tokens,omitempty"`
+	// Providers is set for pi profiles, worst expiry status first.
+	Providers []providerJSON `json:"providers,omitempty"`
+	// IdentityCachedAt is set when the email/plan came from the identity
+	// cache; StaleIdentity when list --stale found that entry too old.
+	IdentityCachedAt string `json:"identity_cached_at,omitempty"`
+	StaleIdentity    bool   `json:"stale_identity,omitempty"`
 }
 
-func resolveLabel(longLabel string, shortLabel string, positional string, trailingArgs []string) (string, error) {
-	longLabel = strings.TrimSpace(longLabel)
-	shortLabel = strings.TrimSpace(shortLabel)
-	positional = strings.TrimSpace(positional)
+type providerJSON struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expires_at"`
+}
 
-	if positional == "" && len(trailingArgs) == 1 {
-		positional = strings.TrimSpace(trailingArgs[0])
-	}
-	if len(trailingArgs) > 1 {
-		return "", errors.New("too many arguments; provide exactly one label")
+func newListItemJSON(item ListItem) listItemJSON {
+	return listItemJSON{
+		Tool:         item.Tool.String(),
+		Label:        item.Label,
+		Status:       item.AuthInsight.Status,
+		NeedsRefresh: item.AuthInsight.NeedsRefresh,
+		ExpiresAt:    item.AuthInsight.ExpiresAt,
+		LastRefresh:  item.AuthInsight.LastRefresh,
+		SavedAt:      item.SavedAt,
+		LastUsedAt:   item.LastUsedAt,
+		AccountEmail: item.AuthInsight.AccountEmail,
+		AccountPlan:  item.AuthInsight.AccountPlan,
+		AccountID:    item.AuthInsight.AccountID,
+		Issuer:       item.AuthInsight.Issuer,
+		Subject:      item.AuthInsight.Subject,
+		Audience:     item.AuthInsight.Audience,
+		Note:         item.Note,
+		Tags:         item.Tags,
+		Snapshot:     item.Snapshot,
+		Linked:       item.Linked,
+		Details:      item.AuthInsight.Details,
+		Tokens:       item.Tokens,
+		Providers:    newProvidersJSON(item.AuthInsight.Providers),
+
+		IdentityCachedAt: item.AuthInsight.IdentityCachedAt,
+		StaleIdentity:    item.StaleIdentity,
 	}
+}
 
-	labels := make([]string, 0, 3)
-	for _, candidate := range []string{longLabel, shortLabel, positional} {
-		if candidate == "" {
-			continue
-		}
-		labels = append(labels, candidate)
+func newProvidersJSON(providers []ProviderInsight) []providerJSON {
+	if len(providers) == 0 {
+		return nil
 	}
-	if len(labels) == 0 {
-		return "", nil
+	out := make([]providerJSON, 0, len(providers))
+	for _, provider := range providers {
+		out = append(out, providerJSON{Name: provider.Name, Status: provider.Status, ExpiresAt: provider.ExpiresAt})
 	}
+	return out
+}
 
-	label := labels[0]
-	for _, candidate := range labels[1:] {
-		if candidate != label {
-			return "", errors.New("conflicting labels provided via positional and flag values")
+var listSortKeys = []string{"expiry", "saved", "used", "label"}
+
+func validListSortKey(key string) bool {
+	for _, candidate := range listSortKeys {
+		if key == candidate {
+			return true
 		}
 	}
-	return label, nil
+	return false
 }
 
-func defaultRootDir() string {
-	return "~/.config/ags"
+// sortListItems orders items by key: expiry soonest first, saved and used
+// most recent first, label alphabetically. reverse flips the order, but
+// items without a parseable time always sort last. Ties keep tool+label order.
+func sortListItems(items []ListItem, key string, reverse bool) {
+	if key == "label" {
+		sort.SliceStable(items, func(i, j int) bool {
+			if items[i].Label == items[j].Label {
+				return false
+			}
+			return (items[i].Label < items[j].Label) != reverse
+		})
+		return
+	}
+
+	timeOf := func(item ListItem) (time.Time, bool) {
+		switch key {
+		case "expiry":
+			return parseISO(item.AuthInsight.ExpiresAt)
+		case "saved":
+			return parseISO(item.SavedAt)
+		default:
+			return parseISO(item.LastUsedAt)
+		}
+	}
+	newestFirst := key != "expiry"
+	sort.SliceStable(items, func(i, j int) bool {
+		left, leftOK := timeOf(items[i])
+		right, rightOK := timeOf(items[j])
+		if leftOK != rightOK {
+			return leftOK
+		}
+		if !leftOK || left.Equal(right) {
+			return false
+		}
+		return left.After(right) == (newestFirst != reverse)
+	})
+}
+
+func printListSorted(stdout io.Writer, items []ListItem, key string, verbose bool, showID bool) {
+	fmt.Fprintf(stdout, "Saved profiles by %s:\n", key)
+	for _, item := range items {
+		fmt.Fprintf(
+			stdout,
+			"  %-18s tool=%-6s status=%-13s refresh=%-7s expires=%s",
+			item.Label,
+			item.Tool,
+			orDash(item.AuthInsight.Status),
+			orDash(item.AuthInsight.NeedsRefresh),
+			summarizeExpiry(item.AuthInsight.ExpiresAt),
+		)
+		if showID {
+			fmt.Fprintf(stdout, " id=%s", orDash(shortIdentity(item.AuthInsight)))
+		}
+		printStaleIdentityMarker(stdout, item)
+		fmt.Fprintln(stdout)
+
+		if verbose {
+			printListItemDetails(stdout, item)
+		}
+	}
+}
+
+func printListByAccount(stdout io.Writer, items []ListItem, verbose bool) {
+	fmt.Fprintln(stdout, "Saved profiles by account:")
+	currentAccount := ""
+	for i, item := range items {
+		account := accountGroupKey(item.AuthInsight)
+		if i == 0 || account != currentAccount {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			currentAccount = account
+			fmt.Fprintf(stdout, "%s\n", currentAccount)
+		}
+
+		fmt.Fprintf(
+			stdout,
+			"  %-18s tool=%-6s status=%-13s refresh=%-7s expires=%s",
+			item.Label,
+			item.Tool,
+			orDash(item.AuthInsight.Status),
+			orDash(item.AuthInsight.NeedsRefresh),
+			summarizeExpiry(item.AuthInsight.ExpiresAt),
+		)
+		printStaleIdentityMarker(stdout, item)
+		fmt.Fprintln(stdout)
+
+		if verbose {
+			printListItemDetails(stdout, item)
+		}
+	}
+}
+
+func printListItemDetails(stdout io.Writer, item ListItem) {
+	if item.Note != "" {
+		fmt.Fprintf(stdout, "    note: %s\n", item.Note)
+	}
+	if len(item.Tags) > 0 {
+		fmt.Fprintf(stdout, "    tags: %s\n", strings.Join(item.Tags, ", "))
+	}
+	if identity := formatIdentity(item.AuthInsight); identity != "" {
+		fmt.Fprintf(stdout, "    account: %s\n", identity)
+	}
+	if item.AuthInsight.IdentityCachedAt != "" {
+		fmt.Fprintf(stdout, "    identity cached: %s\n", formatHumanTime(item.AuthInsight.IdentityCachedAt))
+	}
+	if item.AuthInsight.Issuer != "" {
+		fmt.Fprintf(stdout, "    issuer: %s\n", item.AuthInsight.Issuer)
+	}
+	if item.AuthInsight.Subject != "" {
+		fmt.Fprintf(stdout, "    subject: %s\n", item.AuthInsight.Subject)
+	}
+	if item.AuthInsight.Audience != "" {
+		fmt.Fprintf(stdout, "    audience: %s\n", item.AuthInsight.Audience)
+	}
+	if item.AuthInsight.LastRefresh != "" {
+		fmt.Fprintf(stdout, "    last refresh: %s\n", formatHumanTime(item.AuthInsight.LastRefresh))
+	}
+	fmt.Fprintf(stdout, "    saved: %s\n", formatHumanTime(item.SavedAt))
+	if item.LastUsedAt != "" {
+		fmt.Fprintf(stdout, "    last used: %s\n", formatHumanTime(item.LastUsedAt))
+	}
+	if item.Linked {
+		fmt.Fprintf(stdout, "    snapshot: %s (linked)\n", item.Snapshot)
+	} else {
+		fmt.Fprintf(stdout, "    snapshot: %s\n", item.Snapshot)
+	}
+	for _, detail := range item.AuthInsight.Details {
+		fmt.Fprintf(stdout, "    detail: %s\n", detail)
+	}
+}
+
+func accountGroupKey(insight AuthInsight) string {
+	if email := strings.TrimSpace(insight.AccountEmail); email != "" {
+		return email
+	}
+	if accountID := strings.TrimSpace(insight.AccountID); accountID != "" {
+		return accountID
+	}
+	return "unknown"
+}
+
+func sortItemsByAccount(items []ListItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		left := strings.ToLower(accountGroupKey(items[i].AuthInsight))
+		right := strings.ToLower(accountGroupKey(items[j].AuthInsight))
+		if left != right {
+			return left < right
+		}
+		if items[i].Tool != items[j].Tool {
+			return items[i].Tool < items[j].Tool
+		}
+		return items[i].Label < items[j].Label
+	})
+}
+
+// newManagerFromFlags builds a Manager, applying --soon only when it was
+// passed explicitly so config.json can still provide the default.
+func newManagerFromFlags(cli cliOptions, fs *flag.FlagSet, root string, soon time.Duration) (*Manager, error) {
+	opts := []ManagerOption{}
+	if flagWasSet(fs, "soon") {
+		if soon < 0 {
+			return nil, invalidInput("--soon must not be negative")
+		}
+		opts = append(opts, WithExpiringSoon(soon))
+	}
+	if f := fs.Lookup("no-identity-cache"); f != nil && f.Value.String() == "true" {
+		opts = append(opts, WithoutIdentityCache())
+	}
+	return newCLIManager(cli, root, opts...)
+}
+
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// filterItemsByLastUsed keeps items used within usedSince and items not used
+// within unusedFor. A zero window disables that side of the filter. Profiles
+// that were never used count as unused.
+func filterItemsByLastUsed(items []ListItem, usedSince time.Duration, unusedFor time.Duration) []ListItem {
+	if usedSince == 0 && unusedFor == 0 {
+		return items
+	}
+
+	now := nowUTC()
+	filtered := make([]ListItem, 0, len(items))
+	for _, item := range items {
+		lastUsed, used := parseISO(item.LastUsedAt)
+		if usedSince > 0 && (!used || now.Sub(lastUsed) > usedSince) {
+			continue
+		}
+		if unusedFor > 0 && used && now.Sub(lastUsed) <= unusedFor {
+			continue
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
+
+func parseAgeFlag(name string, raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := parseAge(raw)
+	if err != nil || d <= 0 {
+		return 0, invalidInputf("%s must be a positive duration like 7d or 12h, got %q", name, raw)
+	}
+	return d, nil
+}
+
+// parseAge parses Go durations plus a whole-day suffix, e.g. "7d" or "1d12h".
+func parseAge(raw string) (time.Duration, error) {
+	var days time.Duration
+	if idx := strings.Index(raw, "d"); idx >= 0 {
+		n, err := strconv.Atoi(raw[:idx])
+		if err != nil {
+			return 0, invalidInputf("invalid day count in %q", raw)
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		raw = raw[idx+1:]
+		if raw == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	return days + d, nil
+}
+
+func filterItemsByAccount(items []ListItem, query string) []ListItem {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return items
+	}
+
+	filtered := make([]ListItem, 0, len(items))
+	for _, item := range items {
+		if matchesAccount(item.AuthInsight, query) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// filterItemsByPlan keeps profiles whose normalized plan equals the
+// normalized query. Profiles with no known plan never match.
+func filterItemsByPlan(items []ListItem, query string) []ListItem {
+	want := normalizePlan(query)
+	if want == "" {
+		return items
+	}
+
+	filtered := make([]ListItem, 0, len(items))
+	for _, item := range items {
+		if item.AuthInsight.AccountPlan != "" && normalizePlan(item.AuthInsight.AccountPlan) == want {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// filterItemsNeedingRefresh keeps profiles whose token is expired or
+// expiring soon.
+func filterItemsNeedingRefresh(items []ListItem) []ListItem {
+	filtered := make([]ListItem, 0, len(items))
+	for _, item := range items {
+		if item.AuthInsight.NeedsRefresh == "yes" {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+func filterItemsByTag(items []ListItem, tag string) []ListItem {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return items
+	}
+
+	filtered := make([]ListItem, 0, len(items))
+	for _, item := range items {
+		for _, candidate := range item.Tags {
+			if candidate == tag {
+				filtered = append(filtered, item)
+				break
+			}
+		}
+	}
+	return filtered
 }
 
-func orDash(s string) string {
-	if strings.TrimSpace(s) == "" {
-		return "-"
-	}
-	return s
-}
+func matchesAccount(insight AuthInsight, query string) bool {
+	accountID := strings.TrimSpace(insight.AccountID)
+	if accountID != "" && accountID == query {
+		return true
+	}
+	email := strings.ToLower(strings.TrimSpace(insight.AccountEmail))
+	return email != "" && strings.Contains(email, strings.ToLower(query))
+}
+
+func runFind(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "find")
+		return nil
+	}
+
+	positional := make([]string, 0, 1)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 1 {
+		return invalidInput("usage: ags find <email-or-account-id> [--root <path>]")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	items, err := manager.Find(positional[0])
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		fmt.Fprintf(stdout, "No saved profiles match %q.\n", positional[0])
+		return nil
+	}
+	for _, item := range items {
+		identity := formatIdentity(item.AuthInsight)
+		if identity == "" {
+			identity = accountGroupKey(item.AuthInsight)
+		}
+		fmt.Fprintf(stdout, "%-6s %-18s %s\n", item.Tool, item.Label, identity)
+	}
+	return nil
+}
+
+func runDiff(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "diff")
+		return nil
+	}
+
+	positional := make([]string, 0, 3)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 3 {
+		return invalidInput("usage: ags diff <tool> <labelA> <labelB> [--root <path>]")
+	}
+
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	for _, label := range positional[1:] {
+		if !cli.labels.valid(label) {
+			return invalidInputf("label must match %s", cli.labels)
+		}
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.Diff(tool, positional[1], positional[2])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Diff %s %s -> %s\n", result.Tool, result.LabelA, result.LabelB)
+	if len(result.Changes) == 0 {
+		fmt.Fprintln(stdout, "  no differences")
+		return nil
+	}
+	for _, change := range result.Changes {
+		fmt.Fprintf(stdout, "  %-8s %s%s\n", change.Kind, change.Field, formatDiffValues(change))
+	}
+	return nil
+}
+
+func formatDiffValues(change DiffChange) string {
+	if change.Redacted {
+		if change.Kind == "changed" {
+			return ": token changed"
+		}
+		return ""
+	}
+	if change.From == "" && change.To == "" {
+		return ""
+	}
+	switch change.Kind {
+	case "added":
+		return ": " + change.To
+	case "removed":
+		return ": " + change.From
+	case "changed":
+		return fmt.Sprintf(": %s -> %s", change.From, change.To)
+	default:
+		return ""
+	}
+}
+
+func runExportEnv(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "export-env")
+		return nil
+	}
+
+	positional := make([]string, 0, 2)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("export-env", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	format := fs.String("format", "sh", "Output syntax: sh or fish")
+	reveal := fs.Bool("reveal", false, "Confirm that secrets may be printed")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 2 {
+		return invalidInput("usage: ags export-env <tool> <label> --reveal [--format sh|fish] [--root <path>]")
+	}
+	if *format != "sh" && *format != "fish" {
+		return invalidInputf("invalid --format %q. expected one of: sh, fish", *format)
+	}
+	if !*reveal {
+		return invalidInput("export-env prints secret tokens; pass --reveal to confirm")
+	}
+
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	vars, err := manager.ExportEnv(tool, label)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "# ags export-env %s %s\n", tool, label)
+	for _, v := range vars {
+		if v.ExpiresAt != "" {
+			fmt.Fprintf(stdout, "# %s expires %s\n", v.Name, formatHumanTime(v.ExpiresAt))
+		}
+		if *format == "fish" {
+			fmt.Fprintf(stdout, "set -x %s %s\n", v.Name, fishQuote(v.Value))
+		} else {
+			fmt.Fprintf(stdout, "export %s=%s\n", v.Name, shellQuote(v.Value))
+		}
+	}
+	return nil
+}
+
+func shellQuote(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+}
+
+func fishQuote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return "'" + strings.ReplaceAll(v, "'", `\'`) + "'"
+}
+
+func runLink(cli cliOptions, args []string, stdout io.Writer, stderr io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "link")
+		return nil
+	}
+	if len(args) == 0 {
+		return invalidInput("usage: ags link <tool> <label> --snapshot <path> [--root <path>]")
+	}
+	tool, ok := ParseTool(strings.ToLower(args[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
+	}
+
+	positionalLabel, parseArgs := splitPositionalLabel(args)
+
+	fs := flag.NewFlagSet("link", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	label := fs.String("label", "", "Profile label name, e.g. work")
+	labelShort := fs.String("l", "", "Profile label name, e.g. work")
+	snapshot := fs.String("snapshot", "", "Existing auth JSON file to reference in place")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(parseArgs); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+
+	resolvedLabel, err := resolveLabel(*label, *labelShort, positionalLabel, fs.Args())
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(resolvedLabel) == "" {
+		return invalidInput("--label is required")
+	}
+	if !cli.labels.valid(resolvedLabel) {
+		return invalidInputf("--label must match %s", cli.labels)
+	}
+	if strings.TrimSpace(*snapshot) == "" {
+		return invalidInput("--snapshot is required")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.Link(tool, resolvedLabel, *snapshot)
+	if err != nil {
+		return err
+	}
+
+	if len(result.DuplicateLabels) > 0 {
+		fmt.Fprintf(stderr, "Warning: identical to existing label(s): %s\n", strings.Join(result.DuplicateLabels, ", "))
+	}
+	if len(result.AccountConflicts) > 0 {
+		accounts := []string{fmt.Sprintf("%s=%s", result.Tool, accountGroupKey(result.Insight))}
+		for _, conflict := range result.AccountConflicts {
+			accounts = append(accounts, fmt.Sprintf("%s=%s", conflict.Tool, conflict.Account))
+		}
+		fmt.Fprintf(stderr, "Warning: label %s now spans multiple accounts: %s\n", result.Label, strings.Join(accounts, ", "))
+	}
+	fmt.Fprintf(stdout, "Linked %s %s\n", result.Tool, result.Label)
+	fmt.Fprintf(stdout, "- snapshot: %s\n", result.SnapshotPath)
+	return nil
+}
+
+// whoamiJSON is one value of ags whoami --json, keyed by tool name. Status
+// is the token status, or missing, empty, invalid, or error when the
+// runtime auth file cannot be inspected; Error then says why.
+type whoamiJSON struct {
+	Email       string `json:"email,omitempty"`
+	Plan        string `json:"plan,omitempty"`
+	AccountID   string `json:"account_id,omitempty"`
+	Status      string `json:"status"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	RuntimePath string `json:"runtime_path"`
+	Error       string `json:"error,omitempty"`
+}
+
+// runtimeErrorStatus names a RuntimeInsight failure for ags whoami.
+func runtimeErrorStatus(err error) string {
+	switch {
+	case errors.Is(err, ErrRuntimeMissing):
+		return "missing"
+	case errors.Is(err, ErrRuntimeEmpty):
+		return "empty"
+	case errors.Is(err, ErrRuntimeInvalid):
+		return "invalid"
+	}
+	return "error"
+}
+
+func runWhoami(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "whoami")
+		return nil
+	}
+
+	tools := []Tool{ToolCodex, ToolPi}
+	flagArgs := args
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		tool, ok := ParseTool(strings.ToLower(args[0]))
+		if !ok {
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
+		}
+		tools = []Tool{tool}
+		flagArgs = args[1:]
+	}
+
+	fs := flag.NewFlagSet("whoami", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "Print one JSON object keyed by tool")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	soon := fs.Duration("soon", defaultExpiringSoon, "Report tokens expiring within this window as expiring_soon")
+	if err := fs.Parse(flagArgs); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags whoami [tool] [--json] [--root <path>]")
+	}
+
+	manager, err := newManagerFromFlags(cli, fs, *root, *soon)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]whoamiJSON, len(tools))
+	for i, tool := range tools {
+		runtimePath := manager.paths[tool].DefaultRuntime
+		insight, err := manager.RuntimeInsight(tool)
+		if *asJSON {
+			entry := whoamiJSON{RuntimePath: runtimePath}
+			if err != nil {
+				entry.Status = runtimeErrorStatus(err)
+				entry.Error = err.Error()
+			} else {
+				entry.Email = insight.AccountEmail
+				entry.Plan = insight.AccountPlan
+				entry.AccountID = insight.AccountID
+				entry.Status = insight.Status
+				entry.ExpiresAt = insight.ExpiresAt
+			}
+			entries[tool.String()] = entry
+			continue
+		}
+
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %s\n- runtime: %s\n- error: %v\n", tool, runtimeErrorStatus(err), runtimePath, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", tool, orDash(formatIdentity(insight)))
+		fmt.Fprintf(stdout, "- runtime: %s\n", runtimePath)
+		printInsight(stdout, insight, false)
+	}
+	if *asJSON {
+		return json.NewEncoder(stdout).Encode(entries)
+	}
+	return nil
+}
+
+func runInspect(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "inspect")
+		return nil
+	}
+	if len(args) == 0 {
+		return invalidInput("usage: ags inspect <tool> <label> [--json] [--root <path>]")
+	}
+	tool, ok := ParseTool(strings.ToLower(args[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
+	}
+
+	positionalLabel, parseArgs := splitPositionalLabel(args)
+
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	label := fs.String("label", "", "Profile label name, e.g. work")
+	labelShort := fs.String("l", "", "Profile label name, e.g. work")
+	asJSON := fs.Bool("json", false, "Print the profile as a JSON object")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	soon := fs.Duration("soon", defaultExpiringSoon, "Report tokens expiring within this window as expiring_soon")
+	if err := fs.Parse(parseArgs); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+
+	resolvedLabel, err := resolveLabel(*label, *labelShort, positionalLabel, fs.Args())
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(resolvedLabel) == "" {
+		return invalidInput("--label is required")
+	}
+	if !cli.labels.valid(resolvedLabel) {
+		return invalidInputf("--label must match %s", cli.labels)
+	}
+
+	manager, err := newManagerFromFlags(cli, fs, *root, *soon)
+	if err != nil {
+		return err
+	}
+	item, err := manager.Inspect(tool, resolvedLabel)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(stdout).Encode(newListItemJSON(*item))
+	}
+
+	fmt.Fprintf(stdout, "%s %s\n", item.Tool, item.Label)
+	if identity := formatIdentity(item.AuthInsight); identity != "" {
+		fmt.Fprintf(stdout, "- account: %s\n", identity)
+	}
+	printInsight(stdout, item.AuthInsight, true)
+	if item.AuthInsight.Issuer != "" {
+		fmt.Fprintf(stdout, "- issuer: %s\n", item.AuthInsight.Issuer)
+	}
+	if item.AuthInsight.Subject != "" {
+		fmt.Fprintf(stdout, "- subject: %s\n", item.AuthInsight.Subject)
+	}
+	if item.AuthInsight.Audience != "" {
+		fmt.Fprintf(stdout, "- audience: %s\n", item.AuthInsight.Audience)
+	}
+	for _, token := range item.Tokens {
+		fmt.Fprintf(stdout, "- token: %s\n", token)
+	}
+	fmt.Fprintf(stdout, "- saved: %s\n", formatHumanTime(item.SavedAt))
+	if item.LastUsedAt != "" {
+		fmt.Fprintf(stdout, "- last used: %s\n", formatHumanTime(item.LastUsedAt))
+	}
+	if item.Linked {
+		fmt.Fprintf(stdout, "- snapshot: %s (linked)\n", item.Snapshot)
+	} else {
+		fmt.Fprintf(stdout, "- snapshot: %s\n", item.Snapshot)
+	}
+	if item.Note != "" {
+		fmt.Fprintf(stdout, "- note: %s\n", item.Note)
+	}
+	if len(item.Tags) > 0 {
+		fmt.Fprintf(stdout, "- tags: %s\n", strings.Join(item.Tags, ", "))
+	}
+	return nil
+}
+
+func runCompletion(args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "completion")
+		return nil
+	}
+
+	positionals := []string{}
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positionals = append(positionals, args[0])
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("completion", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	install := fs.Bool("install", false, "Write the script to the shell's per-user completion directory")
+	force := fs.Bool("force", false, "With --install, replace an existing completion file")
+	if err := fs.Parse(args); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positionals = append(positionals, fs.Args()...)
+	if len(positionals) != 1 {
+		return invalidInput("usage: ags completion bash|zsh|fish [--install [--force]]")
+	}
+	if *force && !*install {
+		return invalidInput("--force requires --install")
+	}
+	shell := strings.ToLower(positionals[0])
+
+	if !*install {
+		script, err := completionScript(shell)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(stdout, script)
+		return nil
+	}
+	path, err := installCompletion(shell, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Installed %s completion to %s\n", shell, path)
+	fmt.Fprint(stdout, completionInstallHint(shell, path))
+	return nil
+}
+
+func runVersion(args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "version")
+		return nil
+	}
+
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "Print version, commit, and build date as JSON")
+	if err := fs.Parse(args); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags version [--json]")
+	}
+
+	if *asJSON {
+		return json.NewEncoder(stdout).Encode(versionJSON{
+			Version: Version,
+			Commit:  Commit,
+			Built:   BuildDate,
+		})
+	}
+	fmt.Fprintf(stdout, "ags version %s\n", Version)
+	return nil
+}
+
+type versionJSON struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Built   string `json:"built"`
+}
+
+func runActive(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "active")
+		return nil
+	}
+
+	var toolFilter *Tool
+	var flagArgs []string
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		tool, ok := ParseTool(strings.ToLower(args[0]))
+		if !ok {
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
+		}
+		toolFilter = &tool
+		flagArgs = args[1:]
+	} else {
+		flagArgs = args
+	}
+
+	fs := flag.NewFlagSet("active", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	verbose := fs.Bool("verbose", false, "Print additional detail lines")
+	watch := fs.Bool("watch", false, "Re-print whenever a runtime auth file changes, until interrupted")
+	asJSON := fs.Bool("json", false, "Print the result as one JSON line")
+	compact := fs.Bool("compact", false, "With --json, print one object keyed by tool instead of an array")
+	exitCode := fs.Bool("exit-code", false, "Report the match as the exit status and print nothing unless --verbose")
+	onlyMismatch := fs.Bool("only-mismatch", false, "Only print tools whose runtime auth is not matched to a saved label")
+	if err := fs.Parse(flagArgs); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags active [tool] [--verbose] [--json [--compact]] [--watch] [--exit-code] [--only-mismatch] [--root <path>]")
+	}
+	if *compact && !*asJSON {
+		return invalidInput("--compact requires --json")
+	}
+	if *exitCode && (*watch || *asJSON) {
+		return invalidInput("--exit-code cannot be combined with --watch or --json")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+
+	render := func(items []ActiveItem) error {
+		if *onlyMismatch {
+			items = filterActiveMismatches(items)
+			if len(items) == 0 && !*asJSON {
+				fmt.Fprintln(stdout, "All runtime auth files match a saved profile.")
+				return nil
+			}
+		}
+		if *compact {
+			return printActiveMap(stdout, items)
+		}
+		return printActiveItems(stdout, items, *verbose, *asJSON)
+	}
+	if *watch {
+		ctx, cancel := watchContext()
+		defer cancel()
+		first := true
+		return manager.WatchActive(ctx, toolFilter, watchInterval, func(items []ActiveItem) error {
+			if !first && !*asJSON {
+				fmt.Fprintln(stdout)
+			}
+			first = false
+			return render(items)
+		})
+	}
+
+	items, err := manager.Active(toolFilter)
+	if err != nil {
+		return err
+	}
+	if *exitCode {
+		if *verbose {
+			if err := render(items); err != nil {
+				return err
+			}
+		}
+		if code := activeExitCode(items); code != 0 {
+			return &ExitCodeError{Code: code, Err: fmt.Errorf("active exit code %d", code), Silent: true}
+		}
+		return nil
+	}
+	return render(items)
+}
+
+// activeMatched reports whether status is one of the match statuses: plain,
+// by account, or by last-activated.
+func activeMatched(status string) bool {
+	return strings.HasPrefix(status, "match")
+}
+
+// filterActiveMismatches keeps the items not in a matched state.
+func filterActiveMismatches(items []ActiveItem) []ActiveItem {
+	kept := make([]ActiveItem, 0, len(items))
+	for _, item := range items {
+		if !activeMatched(item.Status) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// Exit codes for ags active --exit-code, from best to worst.
+const (
+	activeExitMatch     = 0
+	activeExitAmbiguous = 2
+	activeExitNoMatch   = 3
+	activeExitNoRuntime = 4
+)
+
+// activeExitCode maps active statuses to --exit-code values and returns the
+// worst across items.
+func activeExitCode(items []ActiveItem) int {
+	worst := activeExitMatch
+	for _, item := range items {
+		code := activeExitNoMatch
+		switch {
+		case activeMatched(item.Status):
+			code = activeExitMatch
+		case item.Status == "ambiguous":
+			code = activeExitAmbiguous
+		case item.Status == "runtime auth file missing", item.Status == "runtime auth file empty",
+			item.Status == "runtime auth directory missing", item.Status == "no runtime auth files":
+			code = activeExitNoRuntime
+		}
+		worst = max(worst, code)
+	}
+	return worst
+}
+
+// activeItemJSON is the machine-readable shape of one ags active row.
+type activeItemJSON struct {
+	Tool        string   `json:"tool"`
+	ActiveLabel string   `json:"active_label,omitempty"`
+	Status      string   `json:"status"`
+	RuntimePath string   `json:"runtime_path"`
+	RuntimeFile string   `json:"runtime_file,omitempty"`
+	Details     []string `json:"details,omitempty"`
+	TokenExpiry string   `json:"token_expiry,omitempty"`
+	Expired     bool     `json:"expired"`
+}
+
+// activeMapEntryJSON is one value of ags active --json --compact, keyed by
+// tool name, or by tool/file for a tool with a runtime directory.
+type activeMapEntryJSON struct {
+	Label  string `json:"label,omitempty"`
+	Status string `json:"status"`
+}
+
+func printActiveMap(stdout io.Writer, items []ActiveItem) error {
+	entries := make(map[string]activeMapEntryJSON, len(items))
+	for _, item := range items {
+		key := item.Tool.String()
+		if item.RuntimeFile != "" {
+			key += "/" + item.RuntimeFile
+		}
+		entries[key] = activeMapEntryJSON{Label: item.ActiveLabel, Status: item.Status}
+	}
+	return json.NewEncoder(stdout).Encode(entries)
+}
+
+func printActiveItems(stdout io.Writer, items []ActiveItem, verbose bool, asJSON bool) error {
+	if asJSON {
+		rows := make([]activeItemJSON, 0, len(items))
+		for _, item := range items {
+			rows = append(rows, activeItemJSON{
+				Tool:        item.Tool.String(),
+				ActiveLabel: item.ActiveLabel,
+				Status:      item.Status,
+				RuntimePath: item.RuntimePath,
+				RuntimeFile: item.RuntimeFile,
+				Details:     item.Details,
+				TokenExpiry: item.TokenExpiry,
+				Expired:     item.Expired,
+			})
+		}
+		return json.NewEncoder(stdout).Encode(rows)
+	}
+
+	fmt.Fprintln(stdout, "tool\tactive label\tstatus\truntime")
+	for _, item := range items {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", item.Tool, orDash(item.ActiveLabel), item.Status, item.RuntimePath)
+		if verbose {
+			if item.TokenExpiry != "" {
+				fmt.Fprintf(stdout, "  expires=%s\n", summarizeExpiry(item.TokenExpiry))
+			}
+			for _, detail := range item.Details {
+				fmt.Fprintf(stdout, "  detail=%s\n", detail)
+			}
+		}
+	}
+	return nil
+}
+
+func runCheck(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "check")
+		return nil
+	}
+
+	var toolFilter *Tool
+	var flagArgs []string
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		tool, ok := ParseTool(strings.ToLower(args[0]))
+		if !ok {
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
+		}
+		toolFilter = &tool
+		flagArgs = args[1:]
+	} else {
+		flagArgs = args
+	}
+
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	warnBefore := fs.Duration("warn-before", 15*time.Minute, "Warn when a token expires within this window")
+	criticalBefore := fs.Duration("critical-before", 0, "Grade tokens expiring within this window as high severity")
+	activeOnly := fs.Bool("active-only", false, "Check runtime auth files instead of saved profiles")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	verbose := fs.Bool("verbose", false, "Print healthy and unknown profiles too")
+	if err := fs.Parse(flagArgs); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags check [tool] [--warn-before <duration>] [--critical-before <duration>] [--active-only] [--root <path>]")
+	}
+	if *warnBefore < 0 {
+		return invalidInput("--warn-before must not be negative")
+	}
+	if *criticalBefore < 0 {
+		return invalidInput("--critical-before must not be negative")
+	}
+	if *criticalBefore > *warnBefore {
+		return invalidInput("--critical-before must not exceed --warn-before")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	items, err := manager.Check(toolFilter, *warnBefore, *activeOnly)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, item := range items {
+		severity := checkSeverity(item, *criticalBefore)
+		counts[severity]++
+		if severity == checkSeverityNone && !*verbose {
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t%s\n", item.Tool, item.Label, item.Status, severity, summarizeExpiry(item.ExpiresAt))
+	}
+
+	switch {
+	case counts[checkSeverityCritical] > 0 || counts[checkSeverityHigh] > 0:
+		summary := fmt.Sprintf("%d expired", counts[checkSeverityCritical])
+		if *criticalBefore > 0 {
+			summary += fmt.Sprintf(", %d expiring within %s", counts[checkSeverityHigh], *criticalBefore)
+		}
+		summary += fmt.Sprintf(", %d expiring within %s", counts[checkSeverityMedium], *warnBefore)
+		return &ExitCodeError{Code: 2, Err: errors.New(summary)}
+	case counts[checkSeverityMedium] > 0:
+		return &ExitCodeError{Code: 1, Err: fmt.Errorf("%d expiring within %s", counts[checkSeverityMedium], *warnBefore)}
+	}
+	fmt.Fprintf(stdout, "OK: %d checked, none expiring within %s\n", len(items), *warnBefore)
+	return nil
+}
+
+// Severities assigned by ags check. An expired token is critical, one
+// within --critical-before is high, and one within --warn-before is medium.
+const (
+	checkSeverityNone     = "none"
+	checkSeverityMedium   = "medium"
+	checkSeverityHigh     = "high"
+	checkSeverityCritical = "critical"
+)
+
+func checkSeverity(item CheckItem, criticalBefore time.Duration) string {
+	switch item.Status {
+	case "expired":
+		return checkSeverityCritical
+	case "expiring":
+		if item.Remaining <= criticalBefore {
+			return checkSeverityHigh
+		}
+		return checkSeverityMedium
+	default:
+		return checkSeverityNone
+	}
+}
+
+// printListCount prints the number of items per tool, including tools with
+// none, then the total: "codex: 4, pi: 2, total: 6".
+func printListCount(stdout io.Writer, items []ListItem, tools toolSetFlag) {
+	if len(tools) == 0 {
+		tools = toolSetFlag{ToolCodex, ToolPi}
+	}
+	perTool := map[Tool]int{}
+	for _, item := range items {
+		perTool[item.Tool]++
+	}
+	parts := make([]string, 0, len(tools)+1)
+	for _, tool := range tools {
+		parts = append(parts, fmt.Sprintf("%s: %d", tool, perTool[tool]))
+	}
+	parts = append(parts, fmt.Sprintf("total: %d", len(items)))
+	fmt.Fprintln(stdout, strings.Join(parts, ", "))
+}
+
+func runRestoreState(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "restore-state")
+		return nil
+	}
+
+	fs := flag.NewFlagSet("restore-state", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	from := fs.Int("from", 1, "Backup slot to restore (1 is newest)")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(args); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags restore-state [--from <n>] [--root <path>]")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.RestoreState(*from)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Restored state from %s\n", result.BackupPath)
+	fmt.Fprintf(stdout, "- state: %s\n", result.StatePath)
+	fmt.Fprintf(stdout, "- entries: %d\n", result.Entries)
+	return nil
+}
+
+func runGC(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "gc")
+		return nil
+	}
+
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dryRun := fs.Bool("dry-run", false, "List orphaned snapshot files without removing them")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(args); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags gc [--dry-run] [--root <path>]")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.GC(*dryRun)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(result.OrphanedSnapshots) == 0:
+		fmt.Fprintln(stdout, "No orphaned snapshot files found.")
+	case result.DryRun:
+		fmt.Fprintf(stdout, "Would remove %d orphaned snapshot file(s):\n", len(result.OrphanedSnapshots))
+	default:
+		fmt.Fprintf(stdout, "Removed %d orphaned snapshot file(s):\n", len(result.OrphanedSnapshots))
+	}
+	for _, path := range result.OrphanedSnapshots {
+		fmt.Fprintf(stdout, "- %s\n", path)
+	}
+	if len(result.MissingSnapshots) > 0 {
+		fmt.Fprintf(stdout, "State entries with a missing snapshot file (%d):\n", len(result.MissingSnapshots))
+		for _, missing := range result.MissingSnapshots {
+			fmt.Fprintf(stdout, "- %s %s: %s\n", missing.Tool, missing.Label, missing.SnapshotPath)
+		}
+	}
+	return nil
+}
+
+// Repairs accepted by ags doctor --fix.
+const (
+	doctorFixSHA     = "sha"
+	doctorFixOrphans = "orphans"
+	doctorFixMissing = "missing"
+)
+
+// parseDoctorFixes parses the comma-separated --fix value.
+func parseDoctorFixes(value string) (DoctorOptions, error) {
+	var opts DoctorOptions
+	for _, part := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case doctorFixSHA:
+			opts.FixSHA = true
+		case doctorFixOrphans:
+			opts.FixOrphans = true
+		case doctorFixMissing:
+			opts.FixMissing = true
+		default:
+			return DoctorOptions{}, invalidInputf("--fix: unknown repair %q. expected a comma-separated list of: %s, %s, %s", part, doctorFixSHA, doctorFixOrphans, doctorFixMissing)
+		}
+	}
+	return opts, nil
+}
+
+func runDoctor(cli cliOptions, args []string, stdin io.Reader, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "doctor")
+		return nil
+	}
+
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fix := fs.String("fix", "", "Comma-separated repairs to apply: sha, orphans, missing")
+	yes := fs.Bool("yes", false, "Remove entries with a missing snapshot without asking")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(args); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags doctor [--fix sha,orphans,missing] [--yes] [--root <path>]")
+	}
+	var opts DoctorOptions
+	if flagWasSet(fs, "fix") {
+		parsed, err := parseDoctorFixes(*fix)
+		if err != nil {
+			return err
+		}
+		opts = parsed
+	}
+	if *yes && !opts.FixMissing {
+		return invalidInput("--yes only applies to --fix missing")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+
+	// Dropping state entries loses their labels, aliases, and history, so
+	// it is confirmed unless --yes is given.
+	if opts.FixMissing && !*yes {
+		if !stdinIsTerminal(stdin) {
+			return invalidInput("stdin is not a terminal; pass --yes to remove entries with a missing snapshot")
+		}
+		preview, err := manager.Doctor(DoctorOptions{})
+		if err != nil {
+			return err
+		}
+		if len(preview.MissingSnapshots) > 0 {
+			names := make([]string, 0, len(preview.MissingSnapshots))
+			for _, missing := range preview.MissingSnapshots {
+				names = append(names, missing.Tool.String()+"/"+missing.Label)
+			}
+			fmt.Fprintf(stdout, "Remove state entries with a missing snapshot (%d): %s? [y/N] ", len(names), strings.Join(names, ", "))
+			if !readConfirmation(stdin) {
+				fmt.Fprintln(stdout, "Keeping entries with a missing snapshot.")
+				opts.FixMissing = false
+			}
+		}
+	}
+
+	result, err := manager.Doctor(opts)
+	if err != nil {
+		return err
+	}
+	return printDoctorResult(stdout, result)
+}
+
+// printDoctorResult reports each kind of problem and whether it was fixed.
+// Problems left unfixed make ags doctor exit 1.
+func printDoctorResult(stdout io.Writer, result *DoctorResult) error {
+	unfixed := 0
+	section := func(count int, what string, fixed bool, fixName string) {
+		if count == 0 {
+			return
+		}
+		if fixed {
+			fmt.Fprintf(stdout, "%s (%d), fixed:\n", what, count)
+			return
+		}
+		unfixed += count
+		fmt.Fprintf(stdout, "%s (%d); repair with --fix %s:\n", what, count, fixName)
+	}
+
+	section(len(result.SHAMismatches), "SHA-256 mismatches", result.Options.FixSHA, doctorFixSHA)
+	for _, mismatch := range result.SHAMismatches {
+		fmt.Fprintf(stdout, "- %s %s: recorded %s, on disk %s\n", mismatch.Tool, mismatch.Label, shortHash(mismatch.Recorded), shortHash(mismatch.Actual))
+	}
+	section(len(result.OrphanedSnapshots), "Orphaned snapshot files", result.Options.FixOrphans, doctorFixOrphans)
+	for _, path := range result.OrphanedSnapshots {
+		fmt.Fprintf(stdout, "- %s\n", path)
+	}
+	section(len(result.MissingSnapshots), "State entries with a missing snapshot file", result.Options.FixMissing, doctorFixMissing)
+	for _, missing := range result.MissingSnapshots {
+		fmt.Fprintf(stdout, "- %s %s: %s\n", missing.Tool, missing.Label, missing.SnapshotPath)
+	}
+
+	if len(result.SHAMismatches)+len(result.OrphanedSnapshots)+len(result.MissingSnapshots) == 0 {
+		fmt.Fprintln(stdout, "No problems found.")
+	}
+	if unfixed > 0 {
+		return &ExitCodeError{Code: 1, Err: fmt.Errorf("%d problem(s) left unfixed", unfixed), Silent: true}
+	}
+	return nil
+}
+
+func runDedupe(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "dedupe")
+		return nil
+	}
+
+	positional := make([]string, 0, 1)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dryRun := fs.Bool("dry-run", false, "List the labels that would be removed without removing them")
+	keep := fs.String("keep", DedupeKeepOldest, "Which label of each identical group to keep: oldest or newest")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 1 {
+		return invalidInput("usage: ags dedupe <tool> [--dry-run] [--keep oldest|newest] [--root <path>]")
+	}
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	*keep = strings.ToLower(strings.TrimSpace(*keep))
+	if *keep != DedupeKeepOldest && *keep != DedupeKeepNewest {
+		return invalidInput("--keep must be oldest or newest")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.Dedupe(tool, *keep, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, group := range result.Groups {
+		removed += len(group.Removed)
+	}
+	switch {
+	case removed == 0:
+		fmt.Fprintf(stdout, "No duplicate %s snapshots found.\n", tool)
+		return nil
+	case result.DryRun:
+		fmt.Fprintf(stdout, "Would remove %d duplicate %s label(s):\n", removed, tool)
+	default:
+		fmt.Fprintf(stdout, "Removed %d duplicate %s label(s):\n", removed, tool)
+	}
+	for _, group := range result.Groups {
+		fmt.Fprintf(stdout, "- kept %s; removed %s (sha256 %s)\n", group.Kept, strings.Join(group.Removed, ", "), shortHash(group.SHA256))
+	}
+	return nil
+}
+
+// batchSaveRecord is one line of ags batch save input.
+type batchSaveRecord struct {
+	Tool   string `json:"tool"`
+	Label  string `json:"label"`
+	Source string `json:"source"`
+}
+
+func runBatch(cli cliOptions, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if wantsHelp(args) || len(args) == 0 {
+		printCommandUsage(stdout, "batch")
+		return nil
+	}
+	if args[0] != "save" {
+		return invalidInputf("unknown batch subcommand %q. expected: save", args[0])
+	}
+
+	fs := flag.NewFlagSet("batch save", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(args[1:]); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() != 0 {
+		return invalidInput("usage: ags batch save [--root <path>] < records.jsonl")
+	}
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+
+	saved, failed := 0, 0
+	scanner := bufio.NewScanner(stdin)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		result, err := batchSave(manager, text)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "line %d: error: %v\n", line, err)
+			continue
+		}
+		saved++
+		logOperation(stderr, manager, "save", result.Tool, result.Label, result.Insight.AccountID, nil)
+		identity := formatIdentity(result.Insight)
+		if identity == "" {
+			identity = "no identity"
+		}
+		fmt.Fprintf(stdout, "line %d: saved %s %s (%s)\n", line, result.Tool, result.Label, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return ioErrorf("reading batch input: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Saved %d of %d record(s); %d failed\n", saved, saved+failed, failed)
+	if failed > 0 {
+		return &ExitCodeError{Code: 1, Err: fmt.Errorf("%d of %d batch record(s) failed", failed, saved+failed)}
+	}
+	return nil
+}
+
+// batchSave validates one batch record and saves it. Records name their
+// source explicitly; stdin is the batch itself, so "-" is refused.
+func batchSave(manager *Manager, text string) (*SaveResult, error) {
+	var record batchSaveRecord
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, invalidInputf("invalid record: %v", err)
+	}
+	tool, ok := ParseTool(strings.ToLower(strings.TrimSpace(record.Tool)))
+	if !ok {
+		return nil, invalidInputf("invalid tool %q. expected one of: codex, pi", record.Tool)
+	}
+	label := strings.TrimSpace(record.Label)
+	if !manager.labels.valid(label) {
+		return nil, invalidInputf("label %q must match %s", record.Label, manager.labels)
+	}
+	source := strings.TrimSpace(record.Source)
+	if source == "" || source == "-" {
+		return nil, invalidInput("source must be a file path")
+	}
+	return manager.SaveWithOptions(tool, label, SaveOptions{SourceOverride: source})
+}
+
+func runCache(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) || len(args) == 0 {
+		printCommandUsage(stdout, "cache")
+		return nil
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("cache "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	account := fs.String("account", "", "Only clear the cached identity of this account id")
+	if err := fs.Parse(args[1:]); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+
+	switch sub {
+	case "ls":
+		if fs.NArg() != 0 || flagWasSet(fs, "account") {
+			return invalidInput("usage: ags cache ls [--root <path>]")
+		}
+		manager, err := newCLIManager(cli, *root)
+		if err != nil {
+			return err
+		}
+		entries, err := manager.IdentityCache()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Fprintln(stdout, "Identity cache is empty.")
+			return nil
+		}
+		for _, entry := range entries {
+			fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", entry.AccountID, orDash(entry.Email), orDash(entry.Plan), orDash(entry.UpdatedAt))
+		}
+		return nil
+	case "clear":
+		if fs.NArg() != 0 {
+			return invalidInput("usage: ags cache clear [--account <id>] [--root <path>]")
+		}
+		if flagWasSet(fs, "account") && strings.TrimSpace(*account) == "" {
+			return invalidInput("--account must not be empty")
+		}
+		manager, err := newCLIManager(cli, *root)
+		if err != nil {
+			return err
+		}
+		removed, err := manager.ClearIdentityCache(*account)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(*account) != "" {
+			fmt.Fprintf(stdout, "Removed cached identity for %s\n", strings.TrimSpace(*account))
+		} else {
+			fmt.Fprintf(stdout, "Cleared the identity cache: %d account(s) removed\n", removed)
+		}
+		return nil
+	default:
+		return invalidInputf("unknown cache subcommand %q. expected one of: ls, clear", sub)
+	}
+}
+
+func runAlias(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) || len(args) == 0 {
+		printCommandUsage(stdout, "alias")
+		return nil
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("alias "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+
+	positional := make([]string, 0, 3)
+	rest := args[1:]
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+
+	switch sub {
+	case "add":
+		if len(positional) != 3 {
+			return invalidInput("usage: ags alias add <name> <tool> <label> [--root <path>]")
+		}
+		tool, ok := ParseTool(strings.ToLower(positional[1]))
+		if !ok {
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[1])
+		}
+		manager, err := newCLIManager(cli, *root)
+		if err != nil {
+			return err
+		}
+		item, err := manager.AddAlias(positional[0], tool, positional[2])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Added alias %s -> %s %s\n", item.Name, item.Tool, item.Label)
+		return nil
+	case "rm":
+		if len(positional) != 1 {
+			return invalidInput("usage: ags alias rm <name> [--root <path>]")
+		}
+		manager, err := newCLIManager(cli, *root)
+		if err != nil {
+			return err
+		}
+		item, err := manager.RemoveAlias(positional[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Removed alias %s (was %s %s)\n", item.Name, item.Tool, item.Label)
+		return nil
+	case "ls":
+		if len(positional) != 0 {
+			return invalidInput("usage: ags alias ls [--root <path>]")
+		}
+		manager, err := newCLIManager(cli, *root)
+		if err != nil {
+			return err
+		}
+		items, err := manager.Aliases()
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			fmt.Fprintln(stdout, "No aliases defined.")
+			return nil
+		}
+		for _, item := range items {
+			fmt.Fprintf(stdout, "%s -> %s %s\n", item.Name, item.Tool, item.Label)
+		}
+		return nil
+	default:
+		return invalidInputf("unknown alias subcommand %q. expected one of: add, rm, ls", sub)
+	}
+}
+
+func runNote(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "note")
+		return nil
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
+	}
+
+	positional := make([]string, 0, 3)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("note", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 3 {
+		return invalidInput("usage: ags note <tool> <label> <text> [--root <path>]")
+	}
+
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	if err := manager.SetNote(tool, label, positional[2]); err != nil {
+		return err
+	}
+	if strings.TrimSpace(positional[2]) == "" {
+		fmt.Fprintf(stdout, "Cleared note for %s %s\n", tool, label)
+	} else {
+		fmt.Fprintf(stdout, "Updated note for %s %s\n", tool, label)
+	}
+	return nil
+}
+
+func runTag(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) || len(args) == 0 {
+		printCommandUsage(stdout, "tag")
+		return nil
+	}
+
+	sub := args[0]
+	if sub != "add" && sub != "rm" {
+		return invalidInputf("unknown tag subcommand %q. expected one of: add, rm", sub)
+	}
+	args, err := expandAliasArgs(cli, args[1:])
+	if err != nil {
+		return err
+	}
+
+	positional := make([]string, 0, 3)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("tag "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 3 {
+		return invalidInputf("usage: ags tag %s <tool> <label> <tag> [--root <path>]", sub)
+	}
+
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+	tag := positional[2]
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	if sub == "add" {
+		if err := manager.AddTag(tool, label, tag); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Tagged %s %s with %s\n", tool, label, tag)
+		return nil
+	}
+	if err := manager.RemoveTag(tool, label, tag); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Removed tag %s from %s %s\n", tag, tool, label)
+	return nil
+}
+
+func runSnapshotPath(cli cliOptions, args []string, stdout io.Writer, stderr io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "snapshot-path")
+		return nil
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
+	}
+
+	positional := make([]string, 0, 2)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("snapshot-path", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	evenIfMissing := fs.Bool("even-if-missing", false, "Print the path a save would use when the label is not saved")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 2 {
+		return invalidInput("usage: ags snapshot-path <tool> <label> [--even-if-missing] [--root <path>]")
+	}
+
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.SnapshotPath(tool, label, *evenIfMissing)
+	if err != nil {
+		return err
+	}
+	if result.RecordedPath == "" {
+		fmt.Fprintln(stdout, result.LayoutPath)
+		return nil
+	}
+	fmt.Fprintln(stdout, result.RecordedPath)
+	if result.RecordedPath != result.LayoutPath {
+		fmt.Fprintf(stderr, "Note: ags save %s %s would write %s\n", tool, label, result.LayoutPath)
+	}
+	return nil
+}
+
+func runTouch(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "touch")
+		return nil
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
+	}
+
+	positional := make([]string, 0, 2)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("touch", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 2 {
+		return invalidInput("usage: ags touch <tool> <label> [--root <path>]")
+	}
+
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	usedAt, err := manager.Touch(tool, label)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Marked %s %s as used at %s\n", tool, label, formatHumanTime(usedAt))
+	return nil
+}
+
+func runMove(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "move")
+		return nil
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
+	}
+
+	positional := make([]string, 0, 3)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("move", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 3 {
+		return invalidInput("usage: ags move <tool> <label> <new-tool> [--root <path>]")
+	}
+
+	from, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+	to, ok := ParseTool(strings.ToLower(positional[2]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[2])
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	result, err := manager.Move(from, label, to)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Moved %s %s to %s %s\n", result.From, result.Label, result.To, result.Label)
+	fmt.Fprintf(stdout, "- snapshot: %s\n", result.SnapshotPath)
+	printInsight(stdout, result.Insight, false)
+	return nil
+}
+
+// diagJSON is the document ags diag prints: everything about the profile
+// layout needed in a bug report, with no secret values.
+type diagJSON struct {
+	Version     string            `json:"version"`
+	GeneratedAt string            `json:"generated_at"`
+	Root        string            `json:"root"`
+	ReadOnly    bool              `json:"read_only,omitempty"`
+	Runtimes    []diagRuntimeJSON `json:"runtimes"`
+	Profiles    []diagProfileJSON `json:"profiles"`
+}
+
+type diagRuntimeJSON struct {
+	Tool        string `json:"tool"`
+	Path        string `json:"path"`
+	Status      string `json:"status"`
+	ActiveLabel string `json:"active_label,omitempty"`
+}
+
+type diagProfileJSON struct {
+	listItemJSON
+	// Redacted is the snapshot with every secret replaced by a hash prefix.
+	Redacted any `json:"redacted_snapshot,omitempty"`
+}
+
+func runDiag(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "diag")
+		return nil
+	}
+
+	var toolFilter *Tool
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		tool, ok := ParseTool(strings.ToLower(args[0]))
+		if !ok {
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", args[0])
+		}
+		toolFilter = &tool
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet("diag", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(args); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	if fs.NArg() > 0 {
+		return invalidInput("usage: ags diag [tool] [--root <path>]")
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	doc := diagJSON{
+		Version:     Version,
+		GeneratedAt: nowISO(),
+		Root:        manager.rootDir,
+		ReadOnly:    manager.ReadOnly(),
+		Runtimes:    []diagRuntimeJSON{},
+		Profiles:    []diagProfileJSON{},
+	}
+
+	active, err := manager.Active(toolFilter)
+	if err != nil {
+		return err
+	}
+	for _, item := range active {
+		doc.Runtimes = append(doc.Runtimes, diagRuntimeJSON{
+			Tool:        item.Tool.String(),
+			Path:        item.RuntimePath,
+			Status:      item.Status,
+			ActiveLabel: item.ActiveLabel,
+		})
+	}
+
+	items, err := manager.List(toolFilter)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		profile := diagProfileJSON{listItemJSON: newListItemJSON(item)}
+		// A missing or corrupt snapshot is already reported in the item's
+		// details, so the redacted copy is simply left out.
+		if redacted, err := manager.RedactedSnapshot(item.Tool, item.Label); err == nil {
+			profile.Redacted = redacted
+		}
+		doc.Profiles = append(doc.Profiles, profile)
+	}
+	return json.NewEncoder(stdout).Encode(doc)
+}
+
+// historyEventJSON is the machine-readable shape of one ags history event.
+type historyEventJSON struct {
+	Action string `json:"action"`
+	At     string `json:"at"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func runHistory(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "history")
+		return nil
+	}
+	args, err := expandAliasArgs(cli, args)
+	if err != nil {
+		return err
+	}
+
+	positional := make([]string, 0, 2)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "Print a JSON array of events")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 2 {
+		return invalidInput("usage: ags history <tool> <label> [--json] [--root <path>]")
+	}
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	label := positional[1]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	events, err := manager.History(tool, label)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		out := make([]historyEventJSON, 0, len(events))
+		for _, event := range events {
+			out = append(out, historyEventJSON(event))
+		}
+		return json.NewEncoder(stdout).Encode(out)
+	}
+
+	fmt.Fprintf(stdout, "%s %s\n", tool, label)
+	for _, event := range events {
+		fmt.Fprintf(stdout, "- %-8s %s sha256=%s\n", event.Action, formatHumanTime(event.At), orDash(shortHash(event.SHA256)))
+	}
+	return nil
+}
+
+// shortHash abbreviates a SHA-256 hex digest for display.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// piProviderJSON is the machine-readable shape of one ags providers row.
+type piProviderJSON struct {
+	Key       string   `json:"key"`
+	Status    string   `json:"status"`
+	ExpiresAt string   `json:"expires_at,omitempty"`
+	Selectors []string `json:"selectors"`
+}
+
+func runProviders(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) {
+		printCommandUsage(stdout, "providers")
+		return nil
+	}
+
+	positional := make([]string, 0, 1)
+	rest := args
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	fs := flag.NewFlagSet("providers", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "Print a JSON array of providers")
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+	soon := fs.Duration("soon", defaultExpiringSoon, "Report tokens expiring within this window as expiring_soon")
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+	if len(positional) != 1 {
+		return invalidInput("usage: ags providers <label> [--json] [--root <path>]")
+	}
+	label := positional[0]
+	if !cli.labels.valid(label) {
+		return invalidInputf("label must match %s", cli.labels)
+	}
+
+	manager, err := newManagerFromFlags(cli, fs, *root, *soon)
+	if err != nil {
+		return err
+	}
+	items, err := manager.PIProviders(label)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		out := make([]piProviderJSON, 0, len(items))
+		for _, item := range items {
+			selectors := item.Selectors
+			if selectors == nil {
+				selectors = []string{}
+			}
+			out = append(out, piProviderJSON{Key: item.Key, Status: item.Status, ExpiresAt: item.ExpiresAt, Selectors: selectors})
+		}
+		return json.NewEncoder(stdout).Encode(out)
+	}
+
+	if len(items) == 0 {
+		fmt.Fprintf(stdout, "No providers in %s %s.\n", ToolPi, label)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Providers in %s %s:\n", ToolPi, label)
+	for _, item := range items {
+		selectors := "-"
+		if len(item.Selectors) > 0 {
+			selectors = strings.Join(item.Selectors, ",")
+		}
+		fmt.Fprintf(stdout, "- %s status=%s expires=%s selectors=%s\n", item.Key, orDash(item.Status), summarizeExpiry(item.ExpiresAt), selectors)
+	}
+	return nil
+}
+
+func runConfig(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) || len(args) == 0 {
+		printCommandUsage(stdout, "config")
+		return nil
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("config "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+
+	positional := make([]string, 0, 3)
+	rest := args[1:]
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+
+	switch {
+	case sub == "set-path" && len(positional) == 3, sub == "unset-path" && len(positional) == 2:
+	case sub == "set-path":
+		return invalidInput("usage: ags config set-path <tool> runtime|source <path> [--root <path>]")
+	case sub == "unset-path":
+		return invalidInput("usage: ags config unset-path <tool> runtime|source [--root <path>]")
+	default:
+		return invalidInputf("unknown config subcommand %q. expected one of: set-path, unset-path", sub)
+	}
+	tool, ok := ParseTool(strings.ToLower(positional[0]))
+	if !ok {
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+	}
+	kind := strings.ToLower(positional[1])
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	if sub == "set-path" {
+		stored, err := manager.SetPathOverride(tool, kind, positional[2])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s %s path is now %s\n", tool, kind, stored)
+		return nil
+	}
+	if err := manager.UnsetPathOverride(tool, kind); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Cleared %s %s path override\n", tool, kind)
+	return nil
+}
+
+func runDefault(cli cliOptions, args []string, stdout io.Writer) error {
+	if wantsHelp(args) || len(args) == 0 {
+		printCommandUsage(stdout, "default")
+		return nil
+	}
+
+	sub := args[0]
+	fs := flag.NewFlagSet("default "+sub, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	root := fs.String("root", defaultRootDir(), "AGS data root directory")
+
+	positional := make([]string, 0, 2)
+	rest := args[1:]
+	for len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+	if err := fs.Parse(rest); err != nil {
+		return classify(ErrInvalidInput, err)
+	}
+	positional = append(positional, fs.Args()...)
+
+	var tool Tool
+	switch {
+	case sub == "set" && len(positional) == 2, sub == "clear" && len(positional) == 1, sub == "show" && len(positional) == 1:
+		parsed, ok := ParseTool(strings.ToLower(positional[0]))
+		if !ok {
+			return invalidInputf("invalid tool %q. expected one of: codex, pi", positional[0])
+		}
+		tool = parsed
+	case sub == "show" && len(positional) == 0:
+	case sub == "set":
+		return invalidInput("usage: ags default set <tool> <label> [--root <path>]")
+	case sub == "clear":
+		return invalidInput("usage: ags default clear <tool> [--root <path>]")
+	case sub == "show":
+		return invalidInput("usage: ags default show [tool] [--root <path>]")
+	default:
+		return invalidInputf("unknown default subcommand %q. expected one of: set, clear, show", sub)
+	}
+
+	manager, err := newCLIManager(cli, *root)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "set":
+		if err := manager.SetDefault(tool, positional[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Default for %s is now %s\n", tool, positional[1])
+	case "clear":
+		if err := manager.ClearDefault(tool); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Cleared default for %s\n", tool)
+	case "show":
+		defaults, err := manager.Defaults()
+		if err != nil {
+			return err
+		}
+		tools := []Tool{ToolCodex, ToolPi}
+		if tool != "" {
+			tools = []Tool{tool}
+		}
+		for _, t := range tools {
+			fmt.Fprintf(stdout, "%s\t%s\n", t, orDash(defaults[t]))
+		}
+	}
+	return nil
+}
+
+// defaultLabelForRoot returns the default label recorded for tool, for
+// commands invoked without a label.
+func defaultLabelForRoot(cli cliOptions, root string, tool Tool) (string, error) {
+	manager, err := newCLIManager(cli, root)
+	if err != nil {
+		return "", err
+	}
+	defaults, err := manager.Defaults()
+	if err != nil {
+		return "", err
+	}
+	label, ok := defaults[tool]
+	if !ok {
+		return "", invalidInputf("--label is required (no default label set for %s; see ags default set)", tool)
+	}
+	return label, nil
+}
+
+// expandAliasArgs rewrites a leading alias name into its tool and label so
+// save, use, and delete can parse the arguments as usual. Arguments that
+// already start with a tool, a flag, or an unknown name are returned as-is.
+func expandAliasArgs(cli cliOptions, args []string) ([]string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return args, nil
+	}
+	if _, ok := ParseTool(strings.ToLower(args[0])); ok {
+		return args, nil
+	}
+
+	manager, err := newCLIManager(cli, rootFromArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	tool, label, ok, err := manager.ResolveAlias(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return args, nil
+	}
+
+	expanded := make([]string, 0, len(args)+1)
+	expanded = append(expanded, tool.String(), label)
+	return append(expanded, args[1:]...), nil
+}
+
+// splitTimeoutFlag removes the global --timeout <duration> flag, accepted
+// anywhere on the command line, and returns the remaining args.
+func splitTimeoutFlag(args []string) ([]string, time.Duration, error) {
+	rest := make([]string, 0, len(args))
+	var timeout time.Duration
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--timeout" && name != "-timeout" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, 0, invalidInput("--timeout requires a duration like 5s")
+			}
+			i++
+			value = args[i]
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || parsed < 0 {
+			return nil, 0, invalidInputf("--timeout must be a non-negative duration like 5s, got %q", value)
+		}
+		timeout = parsed
+	}
+	return rest, timeout, nil
+}
+
+// splitLogFileFlag removes the global --log-file <path> flag, accepted
+// anywhere on the command line, and returns the remaining args.
+func splitLogFileFlag(args []string) ([]string, string, error) {
+	rest := make([]string, 0, len(args))
+	logFile := ""
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--log-file" && name != "-log-file" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, "", invalidInput("--log-file requires a path")
+			}
+			i++
+			value = args[i]
+		}
+		expanded, err := expandPath(strings.TrimSpace(value))
+		if err != nil {
+			return nil, "", invalidInputf("--log-file: %v", err)
+		}
+		logFile = expanded
+	}
+	return rest, logFile, nil
+}
+
+// printSnapshotModified warns that label's snapshot no longer matches the
+// hash recorded at save time.
+func printSnapshotModified(stderr io.Writer, label string) {
+	fmt.Fprintf(stderr, "Warning: snapshot for %s was modified outside ags since it was saved (sha256 mismatch); pass --strict to refuse\n", label)
+}
+
+// newCLIManager builds a Manager with the global --timeout and --log-file
+// applied.
+func newCLIManager(cli cliOptions, root string, opts ...ManagerOption) (*Manager, error) {
+	if cli.ioTimeout > 0 {
+		opts = append(opts, WithIOTimeout(cli.ioTimeout))
+	}
+	if cli.logFile != "" {
+		opts = append(opts, WithLogFile(cli.logFile))
+	}
+	return NewManager(root, opts...)
+}
+
+// rootFromArgs finds a --root value ahead of flag parsing, falling back to
+// the default data root.
+func rootFromArgs(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "root" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return defaultRootDir()
+}
+
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		if arg == "-h" || arg == "--help" {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPositionalLabel(args []string) (string, []string) {
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		return args[1], args[2:]
+	}
+	return "", args[1:]
+}
+
+func resolveLabel(longLabel string, shortLabel string, positional string, trailingArgs []string) (string, error) {
+	longLabel = strings.TrimSpace(longLabel)
+	shortLabel = strings.TrimSpace(shortLabel)
+	positional = strings.TrimSpace(positional)
+
+	if positional == "" && len(trailingArgs) == 1 {
+		positional = strings.TrimSpace(trailingArgs[0])
+	}
+	if len(trailingArgs) > 1 {
+		return "", invalidInput("too many arguments; provide exactly one label")
+	}
+
+	labels := make([]string, 0, 3)
+	for _, candidate := range []string{longLabel, shortLabel, positional} {
+		if candidate == "" {
+			continue
+		}
+		labels = append(labels, candidate)
+	}
+	if len(labels) == 0 {
+		return "", nil
+	}
+
+	label := labels[0]
+	for _, candidate := range labels[1:] {
+		if candidate != label {
+			return "", invalidInput("conflicting labels provided via positional and flag values")
+		}
+	}
+	return label, nil
+}
+
+func defaultRootDir() string {
+	return "~/.config/ags"
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
+
+func printInsight(out io.Writer, insight AuthInsight, verbose bool) {
+	fmt.Fprintf(out, "- status: %s\n", orDash(insight.Status))
+	fmt.Fprintf(out, "- needs refresh: %s\n", orDash(insight.NeedsRefresh))
+	if insight.ExpiresAt != "" {
+		fmt.Fprintf(out, "- expires: %s\n", formatHumanTime(insight.ExpiresAt))
+	}
+	if insight.LastRefresh != "" {
+		fmt.Fprintf(out, "- last refresh: %s\n", formatHumanTime(insight.LastRefresh))
+	}
+	if !verbose {
+		return
+	}
+	if insight.AccountID != "" {
+		fmt.Fprintf(out, "- account id: %s\n", insight.AccountID)
+	}
+	for _, detail := range insight.Details {
+		fmt.Fprintf(out, "- detail: %s\n", detail)
+	}
+}
+
+func formatIdentity(insight AuthInsight) string {
+	email := strings.TrimSpace(insight.AccountEmail)
+	plan := strings.TrimSpace(insight.AccountPlan)
+	if email == "" {
+		return ""
+	}
+	if plan == "" {
+		return email
+	}
+	return fmt.Sprintf("%s (%s)", email, plan)
+}
+
+// shortIdentity returns the account email, or a shortened account id when
+// no email is known, for the concise list line.
+func shortIdentity(insight AuthInsight) string {
+	if email := strings.TrimSpace(insight.AccountEmail); email != "" {
+		return email
+	}
+	accountID := []rune(strings.TrimSpace(insight.AccountID))
+	if len(accountID) > shortAccountIDLength {
+		return string(accountID[:shortAccountIDLength]) + "…"
+	}
+	return string(accountID)
+}
+
+func formatHumanTime(raw string) string {
+	t, ok := parseISO(raw)
+	if !ok {
+		return raw
+	}
+	return fmt.Sprintf("%s (%s)", formatRelative(t), t.UTC().Format("Mon, Jan 2, 2006, 3:04 PM MST"))
+}
+
+// summarizeExpiry renders an expiry for a single output line: "expired 2
+// hours ago" once past, "in 10 minutes (soon)" within the default
+// expiring-soon window, and "in 3 days" otherwise.
+func summarizeExpiry(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "-"
+	}
+	t, ok := parseISO(raw)
+	if !ok {
+		return raw
+	}
+	delta := time.Until(t)
+	switch {
+	case delta <= 0:
+		return "expired " + humanizeDuration(delta)
+	case delta <= defaultExpiringSoon:
+		return humanizeDuration(delta) + " (soon)"
+	default:
+		return humanizeDuration(delta)
+	}
+}
+
+func sanitizePlainField(v string) string {
+	v = strings.ReplaceAll(v, "\t", " ")
+	v = strings.ReplaceAll(v, "\n", " ")
+	return strings.ReplaceAll(v, "\r", " ")
+}
+
+func parseISO(raw string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
+
+func formatRelative(t time.Time) string {
+	return humanizeDuration(time.Until(t))
+}
+
+func humanizeDuration(delta time.Duration) string {
+	if delta == 0 {
+		return "now"
+	}
+
+	future := delta > 0
+	if delta < 0 {
+		delta = -delta
+	}
+
+	days := int(delta / (24 * time.Hour))
+	delta %= 24 * time.Hour
+	hours := int(delta / time.Hour)
+	delta %= time.Hour
+	minutes := int(delta / time.Minute)
+
+	parts := make([]string, 0, 2)
+	if days > 0 {
+		parts = append(parts, plural(days, "day"))
+	}
+	if hours > 0 && len(parts) < 2 {
+		parts = append(parts, plural(hours, "hour"))
+	}
+	if days == 0 && minutes > 0 && len(parts) < 2 {
+		parts = append(parts, plural(minutes, "minute"))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "less than a minute")
+	}
+
+	text := strings.Join(parts, " ")
+	if future {
+		return "in " + text
+	}
+	return text + " ago"
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func printRootUsage(out io.Writer) {
+	fmt.Fprint(out, rootUsageText())
+}
+
+func printCommandUsage(out io.Writer, command string) {
+	fmt.Fprint(out, commandUsageText(command))
+}
+
+func rootUsageText() string {
+	return `ags - Coding Agent Account Switcher
+
+USAGE:
+  ags <command> [arguments] [flags]
+
+COMMANDS:
+  save      Save current tool auth JSON as a labeled snapshot.
+  use       Activate a saved labeled snapshot for a tool.
+  delete    Remove a saved labeled snapshot and its metadata.
+  list      List saved snapshots with status and refresh signals.
+  active    Show which saved profile is currently active.
+  whoami    Show the account and token status of each runtime auth file.
+  check     Exit non-zero when tokens expire within a window.
+  restore-state
+            Restore state.json from one of its rolling backups.
+  gc        Remove snapshot files that no state entry points at.
+  doctor    Check state.json against snapshot files; --fix repairs what it finds.
+  dedupe    Collapse labels of one tool whose snapshots are byte-identical.
+  alias     Manage short names that point at a tool and label.
+  note      Set or clear the freeform note on a saved profile.
+  tag       Add or remove tags used by list --tag.
+  touch     Mark a saved profile as used now without rewriting the runtime file.
+  snapshot-path
+            Print the snapshot file path of a saved profile.
+  move      Reclassify a saved profile under a different tool.
+  providers List the providers in a saved pi snapshot and their selectors.
+  find      Find saved profiles by email or account id across all tools.
+  diff      Compare two saved snapshots of the same tool.
+  default   Set, clear, or show the label used when save/use get no label.
+  config    Persist per-tool runtime/source path overrides.
+  cache     List or clear the cached account identities (email, plan).
+  batch     Save many profiles from JSON lines on stdin.
+  inspect   Show the full decoded insight for one saved profile.
+  history   Show when one saved profile was saved, changed, and used.
+  diag      Print a redacted JSON report of all profiles for bug reports.
+  link      Register an existing auth JSON file as a snapshot without copying it.
+  export-env
+            Print shell exports for a snapshot's tokens (requires --reveal).
+  completion
+            Print or install a bash, zsh, or fish completion script.
+  version   Show CLI version.
+  help      Show detailed help. Use "ags help <command>".
+
+TOOLS:
+  codex, pi
+
+GLOBAL FLAGS:
+  --timeout <duration>
+            Fail with an I/O error instead of hanging when a state read, source
+            lookup, or file write takes longer (e.g. on a stuck NFS mount).
+            Accepted anywhere on the command line; default is no limit.
+  --log-file <path>
+            Append one JSON line per save, use, and delete (time, tool, label,
+            account id, outcome; never token values). Defaults to log_file in
+            config.json.
+  --error-json
+            Report a failure on stderr as one JSON line, {"error":"...","code":N},
+            instead of "Error: ...". AGS_ERROR_JSON=1 does the same.
+
+GLOBAL NOTES:
+  - Labels must match [a-zA-Z0-9._-]+, or label_pattern in config.json.
+  - Auth files must be strict JSON objects.
+  - Default AGS data root: ~/.config/ags
+  - Optional settings live in <root>/config.json, e.g. {"expiring_soon": "1h"}.
+
+QUICK START:
+  ags save codex work
+  ags use codex work
+  ags active codex
+  ags list --verbose
+
+DETAIL:
+  ags help save
+  ags help use
+  ags help delete
+  ags help list
+  ags help active
+  ags help whoami
+  ags help check
+  ags help restore-state
+  ags help gc
+  ags help doctor
+  ags help dedupe
+  ags help alias
+  ags help note
+  ags help tag
+  ags help touch
+  ags help snapshot-path
+  ags help move
+  ags help providers
+  ags help find
+  ags help diff
+  ags help export-env
+  ags help link
+  ags help inspect
+  ags help history
+  ags help diag
+  ags help default
+  ags help config
+  ags help cache
+  ags help batch
+  ags help completion
+  ags version
+`
+}
+
+func commandUsageText(command string) string {
+	switch command {
+	case "save":
+		return `ags save - store a labeled auth snapshot
+
+USAGE:
+  ags save <tool> <label> [--source <path>] [--root <path>]
+  ags save <tool> --label <name> [--source <path>] [--root <path>]
+  ags save <tool> --label-from-account [--source <path>] [--root <path>]
+
+FLAGS:
+  --label, -l <name> Required profile label (example: work, personal)
+  --label-from-account
+                    Name the profile after the account email's local part
+                    (jane.doe@x.com -> jane-doe); adds -2, -3, ... when that
+                    label is already saved for a different account
+  --source <path>   Optional override source auth file path (- reads JSON from stdin)
+  --from-active     Save the tool's live runtime auth file (~/.codex/auth.json or
+                    ~/.pi/agent/auth.json) and fail if it is missing
+  --from-url <url>  Fetch the auth JSON from a local helper: http(s)://127.0.0.1:PORT/...,
+                    http://localhost/..., or unix:///path/to.sock (requests /, or
+                    ?path=/v1/auth); 1 MiB cap, 10s timeout unless --timeout is set
+  --allow-remote    With --from-url, allow hosts other than localhost and
+                    loopback addresses
+  --provider <ids>  For pi only: save selected providers (codex, anthropic, key,
+                    a comma-separated list of those, or all)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+  --verbose         Show additional detail lines
+  --soon <duration> Expiring-soon window for status output (default: 15m)
+  --note <text>     Freeform note (max 500 characters); kept on re-save unless given
+  --tag <tag>       Add a tag (repeatable or comma-separated); existing tags are kept
+  --strict-json     Reject a source with invalid UTF-8, duplicate keys, or content
+                    after the JSON object (default: lenient)
+  --follow-symlinks Allow the source auth path to be a symlink (refused by default)
+  --force           Replace an existing snapshot with different content without
+                    asking (only asked when stdin is a terminal)
+  --expect-tool <m> When the source is shaped like the other tool's auth file
+                    (e.g. pi providers saved under codex): warn (default),
+                    strict to refuse the save, or off
+  --verify-expiry-future
+                    Refuse to save a token that has already expired; for pi,
+                    refuse when every provider has expired and warn when some have
+  --no-identity-cache
+                    Show only the identity in the token itself; do not fill it
+                    from, or write it to, the identity cache
+  --gzip            Store the snapshot as <label>.json.gz (default from config.json
+                    compress_snapshots; --gzip=false stores plain JSON)
+
+EXAMPLES:
+  ags save codex work
+  ags save codex client-x --note "client X sandbox account"
+  ags save codex prod-eu --tag prod --tag eu
+  ags save codex fresh-login --from-active
+  ags save codex --label-from-account
+  ags save pi personal
+  ags save pi codex-work --provider codex
+  ags save pi work --provider codex,anthropic
+  ags save pi --label work --source ~/.pi/agent/auth.json
+  some-auth-producer | ags save codex work --source -
+  ags save codex work --from-url http://127.0.0.1:8765/auth
+  ags save pi work --from-url 'unix:///run/user/1000/auth-helper.sock?path=/pi'
+`
+	case "use":
+		return `ags use - activate a labeled auth snapshot
+
+USAGE:
+  ags use <tool> <label> [--target <path>] [--root <path>]
+  ags use <tool> --label <name> [--target <path>] [--root <path>]
+  ags use <tool> --revert [--target <path>] [--root <path>]
+
+FLAGS:
+  --label, -l <name> Required profile label to activate
+  --target <path>   Optional override runtime auth destination
+  --provider <ids>  For pi only: apply selected providers (codex, anthropic, key,
+                    a comma-separated list of those, or all)
+  --backup          Copy the current runtime auth file to <root>/backups/<tool>/ first
+  --follow-symlinks Allow the runtime target to be a symlink and write to the file
+                    it points at (refused by default)
+  --no-merge        For pi: write the snapshot as the whole runtime file, discarding
+                    runtime-only providers (codex always overwrites)
+  --merge-strategy <replace|deep>
+                    For pi: replace each snapshot provider object in the runtime
+                    file (default), or merge it recursively so runtime-only fields
+                    such as a device id survive; the snapshot wins at every leaf
+  --print           Write the snapshot JSON to stdout instead of the runtime file
+                    (mutually exclusive with --target and --backup)
+  --strict          Abort when the snapshot no longer matches the SHA-256 recorded
+                    at save time (default: apply it and warn)
+  --revert          Re-activate the label that was active before the current one
+                    (cannot be combined with a label)
+  --no-identity-cache
+                    Show only the identity in the token itself; do not fill it
+                    from, or write it to, the identity cache
+  --runtime-check   Re-read the written runtime file and warn if it is not a JSON
+                    object, has no parseable codex access_token, has a non-object
+                    pi provider, or is not readable by its owner
+  --as <email>      Refuse to switch (exit 4) unless the snapshot's account email,
+                    including one from the identity cache, matches (case-insensitive)
+  --strict-json     Reject a snapshot, or a pi runtime file being merged into, with
+                    invalid UTF-8, duplicate keys, or content after the JSON object
+  --if-changed      Do nothing when the runtime file already holds what use would
+                    write (for pi, the merged result): no write, hooks, or last-used update
+  --chmod <mode>    Octal permission mode for the runtime auth file, e.g. 0640 for
+                    a shared service account (default: runtime_mode in
+                    config.json, else 0600; warns above 0644). Snapshots stay 0600
+  --quiet-on-unchanged
+                    Print nothing when the snapshot is the one applied last time;
+                    first use and refreshed snapshots still print (warnings always do)
+  --backup-runtime-to <label>
+                    Save the current runtime auth file as <label> first, as ags
+                    save would; if that fails, nothing is switched
+  --env-passthrough Also write the tool's env_files from config.json, rendered
+                    from the activated snapshot (see README)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+  --verbose         Show additional detail lines
+  --soon <duration> Expiring-soon window for status output (default: 15m)
+
+BEHAVIOR:
+  - Writes the saved snapshot into the tool runtime auth path.
+  - Remembers the previously active label per tool; --revert switches back to
+    it, so running it twice returns to where you started.
+  - With --backup, keeps a persistent copy of the replaced runtime auth file.
+  - With --print, only reads: no runtime file is written and last-used is not updated.
+  - With --if-changed, prints "already active" and leaves the runtime file and
+    state untouched when nothing would change (useful in shell startup hooks).
+  - For pi, merges only providers present in the saved snapshot into the existing runtime auth JSON.
+    With --no-merge, providers present only in the runtime file are removed.
+    With --merge-strategy deep, fields present only in a runtime provider object
+    are kept too; arrays and other non-object values come from the snapshot.
+  - Prints refresh signal: first use / unchanged / changed since last use.
+  - With {"companion_files": true} in config.json, also restores companion files
+    saved with the snapshot (codex account.json) next to the runtime auth file.
+  - Runs the pre_use/post_use hooks from config.json, if set (see README).
+    A failing pre_use aborts the switch; a failing post_use only warns.
+  - With --env-passthrough, a template error aborts the switch; failing to
+    write an env file afterwards only warns.
+
+EXAMPLES:
+  ags use codex work
+  ags use pi personal
+  ags use pi codex-work --provider codex
+  ags use pi work --provider all
+  ags use codex work --backup
+  ags use codex work --print | some-tool --auth-stdin
+  ags use codex --revert
+  ags use codex work --if-changed
+  ags use codex personal --backup-runtime-to work
+  ags use codex ci --as ci-bot@company.com
+`
+	case "delete":
+		return `ags delete - remove a labeled auth snapshot
+
+USAGE:
+  ags delete <tool> <label> [--yes] [--root <path>]
+  ags delete <tool> --label <name> [--yes] [--root <path>]
+  ags delete <tool> '<pattern>' [--yes] [--root <path>]
+
+FLAGS:
+  --label, -l <name> Required profile label to delete
+  --yes, -y         Delete without asking for confirmation (required when
+                    stdin is not a terminal)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Deletes snapshot file from ~/.config/ags/snapshots/<tool>/<label>.json
+  - Removes matching entry from ~/.config/ags/state.json
+  - Does NOT modify current runtime auth file used by the tool
+  - Asks "Delete <tool>/<label>? [y/N]" first; anything but y or yes aborts.
+  - A label containing *, ?, or [ is a glob matched against the tool's saved
+    labels; the matches are listed and confirmed before anything is deleted.
+
+EXAMPLES:
+  ags delete codex work
+  ags delete pi personal --yes
+  ags delete codex 'test-*' --yes
+`
+	case "list":
+		return `ags list - inspect saved profiles
+
+USAGE:
+  ags list [tool | --tool <name>...] [--verbose] [--id] [--plain|--jsonl|--count] [--sort <key> [--reverse]] [--expiring] [--stale <age>] [--tag <tag>] [--account <email-or-id>] [--by-account] [--root <path>]
+
+FLAGS:
+  --tool <names>    Only list these tools; repeat or comma-separate (alias: --tools).
+                    Cannot be combined with a positional tool.
+  --verbose         Show account, timestamps, snapshot path, and details
+  --id              Append the account email (or short account id) to each concise line
+  --plain           Print tab-separated rows for scripts
+  --no-headers      With --plain, suppress the header row
+  --jsonl           Print one JSON object per profile per line (no output when
+                    empty); each line is written as soon as its snapshot is read,
+                    except with --sort, --by-account, or --expiring, which need
+                    every profile first
+  --tag <tag>       Only show profiles carrying this tag
+  --expiring        Only show profiles that need a refresh (expired or expiring
+                    within --soon), sorted by expiry unless --sort is given
+  --schema          Print the JSON Schema that each --jsonl line follows instead
+                    of any profiles
+  --count           Print only the number of matching profiles, per tool and in
+                    total (e.g. "codex: 4, pi: 2, total: 6"); filters still apply
+  --account <query> Only show profiles whose email contains <query> or whose account id equals it
+  --by-account      Group labels by account (email, then account id) instead of by tool
+  --sort <key>      Sort by expiry (soonest first), saved or used (most recent first),
+                    or label; profiles without that time sort last
+  --reverse         With --sort, reverse the order (missing times still sort last)
+  --plan <name>     Only show profiles on this plan (Free, Plus, Pro, Team, Business,
+                    Enterprise, Edu); profiles with an unknown plan are excluded
+  --soon <duration> Expiring-soon window for status output (default: 15m)
+  --used-since <age> Only profiles used within <age> (e.g. 7d, 12h)
+  --unused-for <age> Only profiles not used within <age>, including never-used ones
+  --no-identity-cache
+                    Show only the identity in the token itself; do not fill it
+                    from, or write it to, the identity cache
+  --stale <age>     Mark profiles whose email/plan comes from an identity cache entry
+                    older than <age> with identity=stale (re-save to refresh)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+OUTPUT:
+  Grouped by tool with one concise line per label.
+  Use --by-account to group by account with the tool shown on each line.
+  Use --verbose for additional metadata.
+
+EXAMPLES:
+  ags list
+  ags list codex
+  ags list pi --verbose
+  ags list codex --id
+  ags list --tool codex --tool pi
+  ags list --account person@company.com
+  ags list --by-account
+  ags list --sort expiry
+  ags list --sort used --reverse
+  ags list --plan team
+  ags list --tag prod
+  ags list --expiring
+  ags list --expiring --count
+  ags list --unused-for 30d
+  ags list --stale 30d
+  ags list --jsonl | jq -c 'select(.status == "expired")'
+  ags list --schema > ags-list.schema.json
+`
+	case "active":
+		return `ags active - show active saved profile
+
+USAGE:
+  ags active [tool] [--verbose] [--json [--compact]] [--watch] [--exit-code] [--only-mismatch] [--root <path>]
+
+FLAGS:
+  --verbose         Show additional detail lines, including runtime token expiry
+  --json            Print the rows as a single JSON array on one line; each row
+                    carries token_expiry and expired for the runtime auth file
+  --compact         With --json, print one object keyed by tool instead:
+                    {"codex":{"label":"work","status":"match"},...}
+  --watch           Keep running and re-print whenever a runtime auth file
+                    changes (including atomic replacement); stop with Ctrl-C
+  --exit-code       Print nothing (unless --verbose) and exit 0 on a match, 2 if
+                    ambiguous, 3 if nothing matches, 4 if the runtime auth file
+                    is missing or empty; across tools the worst code wins
+  --only-mismatch   Only print tools that are not matched to a saved label
+                    (ambiguous, no match, runtime file missing or invalid)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+OUTPUT COLUMNS:
+  tool, active label, status, runtime
+
+STATUS:
+  match                       exactly one saved label matches the runtime auth
+  match (by last-activated)   several labels match; the one last applied by use wins
+  match (by account)          codex only: no exact match, but one label has the
+                              runtime's account id (the token was refreshed)
+  ambiguous                   several labels match and none was last applied
+
+RUNTIME DIRECTORIES:
+  With {"paths": {"<tool>": {"runtime_dir": "<dir>"}}} in config.json, active
+  checks every *.json file in <dir> and prints one row per file; --compact
+  keys those rows by <tool>/<file>. An absent directory or one without
+  *.json files counts as a missing runtime for --exit-code.
+
+EXAMPLES:
+  ags active
+  ags active codex
+  ags active pi --verbose
+  ags active --watch --json
+  ags active --json --compact | jq -r .codex.label
+  ags active codex --exit-code && echo "codex profile active"
+`
+	case "check":
+		return `ags check - report tokens that are expired or expiring soon
+
+USAGE:
+  ags check [tool] [--warn-before <duration>] [--critical-before <duration>] [--active-only] [--root <path>]
+
+FLAGS:
+  --warn-before <d> Window to warn within, as a Go duration (default: 15m)
+  --critical-before <d>
+                    Narrower window graded high instead of medium (default: off)
+  --active-only     Check the runtime auth files instead of saved profiles
+  --verbose         Also print healthy and unknown rows
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+OUTPUT:
+  One row per flagged profile: tool, label, status, severity, time to expiry.
+  Severity is critical (expired), high (within --critical-before), or
+  medium (within --warn-before).
+
+EXIT CODES:
+  0  all checked tokens are healthy (or expiry is unknown)
+  1  the highest severity found is medium
+  2  the highest severity found is high or critical
+
+EXAMPLES:
+  ags check --warn-before 2h
+  ags check --warn-before 1h --critical-before 5m
+  ags check codex --active-only
+`
+	case "restore-state":
+		return `ags restore-state - restore state.json from a backup
+
+USAGE:
+  ags restore-state [--from <n>] [--root <path>]
+
+FLAGS:
+  --from <n>        Backup slot to restore, 1 (newest) to 3 (default: 1)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Every state write first copies the current state.json to state.json.1,
+    shifting older copies up to state.json.3.
+  - The chosen backup must parse as valid state before it replaces state.json.
+  - Snapshot files are not touched.
+
+EXAMPLES:
+  ags restore-state
+  ags restore-state --from 2
+`
+	case "gc":
+		return `ags gc - remove orphaned snapshot files
+
+USAGE:
+  ags gc [--dry-run] [--root <path>]
+
+FLAGS:
+  --dry-run         List orphaned snapshot files without removing them
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Removes snapshots/<tool>/*.json and flat snapshots/<tool>-*.json files that
+    no state.json entry points at.
+  - Reports state entries whose snapshot file is missing; they are kept,
+    so use ags delete to drop them.
+  - Linked snapshots outside the data root are never touched.
+
+EXAMPLES:
+  ags gc --dry-run
+  ags gc
+`
+	case "doctor":
+		return `ags doctor - check and repair state.json against snapshot files
+
+USAGE:
+  ags doctor [--fix sha,orphans,missing] [--yes] [--root <path>]
+
+FLAGS:
+  --fix <list>      Comma-separated repairs to apply; problems of other kinds
+                    are only reported:
+                      sha      record the on-disk SHA-256 for snapshots that
+                               changed since they were saved
+                      orphans  remove snapshot files no state entry points at
+                               (the same files ags gc removes)
+                      missing  remove state entries whose snapshot file is gone
+  --yes             With --fix missing, remove the entries without asking
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Without --fix nothing changes; doctor lists what it finds.
+  - --fix missing asks before removing entries and needs --yes when stdin is
+    not a terminal. Declining still applies the other repairs.
+  - Linked snapshots are expected to change in place and are not checked
+    against their SHA-256.
+  - Exits 1 while any reported problem is left unfixed.
+
+EXAMPLES:
+  ags doctor
+  ags doctor --fix sha
+  ags doctor --fix sha,orphans,missing --yes
+`
+	case "alias":
+		return `ags alias - manage profile aliases
+
+USAGE:
+  ags alias add <name> <tool> <label> [--root <path>]
+  ags alias rm <name> [--root <path>]
+  ags alias ls [--root <path>]
+
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Aliases are stored in state.json.
+  - save, use, and delete accept an alias in place of <tool> <label>.
+  - Alias names must match [a-zA-Z0-9._-]+ and cannot be a tool name.
+
+EXAMPLES:
+  ags alias add w codex work
+  ags use w
+  ags alias ls
+  ags alias rm w
+`
+	case "cache":
+		return `ags cache - inspect or reset the identity cache
+
+USAGE:
+  ags cache ls [--root <path>]
+  ags cache clear [--account <id>] [--root <path>]
+
+FLAGS:
+  --account <id>    With clear, remove only this account id's entry
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - The identity cache maps account ids to the email and plan last seen in a
+    token, so profiles whose token lacks them can still show them.
+  - ls prints account id, email, plan, and when the entry was last updated.
+  - clear empties the cache (or one entry); the next save of each account
+    fills it again.
+
+EXAMPLES:
+  ags cache ls
+  ags cache clear --account acct_123
+  ags cache clear
+`
+	case "note":
+		return `ags note - set the note on a saved profile
+
+USAGE:
+  ags note <tool> <label> <text> [--root <path>]
+
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Notes are shown under the label in ags list --verbose.
+  - Notes are limited to 500 characters; an empty <text> clears the note.
+
+EXAMPLES:
+  ags note codex work "client X sandbox account"
+  ags note codex work ""
+`
+	case "tag":
+		return `ags tag - add or remove tags on a saved profile
+
+USAGE:
+  ags tag add <tool> <label> <tag> [--root <path>]
+  ags tag rm <tool> <label> <tag> [--root <path>]
+
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Tags must match [a-zA-Z0-9._-]+; a profile keeps each tag once.
+  - Tags are kept on re-save and shown in ags list --verbose, ags inspect,
+    and list --jsonl.
+  - ags list --tag <tag> only shows profiles carrying that tag.
+
+EXAMPLES:
+  ags tag add codex work prod
+  ags tag rm codex work prod
+  ags list --tag prod
+`
+	case "dedupe":
+		return `ags dedupe - collapse byte-identical labels
+
+USAGE:
+  ags dedupe <tool> [--dry-run] [--keep oldest|newest] [--root <path>]
+
+FLAGS:
+  --dry-run         Only list what would be removed
+  --keep <policy>   Keep the oldest (default) or newest saved label of each group
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Groups the tool's profiles by the SHA-256 recorded at save time.
+  - In each group with more than one label, keeps one and deletes the rest.
+  - Aliases, defaults, and the active/previous label that pointed at a removed
+    label now point at the kept one. Linked snapshot files are left in place.
+
+EXAMPLES:
+  ags dedupe codex --dry-run
+  ags dedupe codex --keep newest
+`
+	case "touch":
+		return `ags touch - mark a saved profile as used now
+
+USAGE:
+  ags touch <tool> <label> [--root <path>]
+
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Updates only the last-used time and hash in state.json; the snapshot and the
+    runtime auth file are not read or written.
+  - Use it when the profile is already active so list --used-since/--unused-for
+    and list --sort used stay accurate.
+  - Records a "touched" event in ags history.
+
+EXAMPLES:
+  ags touch codex work
+`
+	case "snapshot-path":
+		return `ags snapshot-path - print where a profile's snapshot file is
+
+USAGE:
+  ags snapshot-path <tool> <label> [--even-if-missing] [--root <path>]
+
+FLAGS:
+  --even-if-missing Print the path a save would use even when the label is
+                    not saved (default: exit 3)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Prints the path recorded in state.json; the file itself is not read.
+  - When that differs from where ags save would write the snapshot now (a
+    linked snapshot, a compressed one, or one from another snapshot layout),
+    also notes the save path on stderr.
+
+EXAMPLES:
+  ags snapshot-path codex work
+  cp "$(ags snapshot-path codex work)" /tmp/work.json
+`
+	case "move":
+		return `ags move - reclassify a saved profile under another tool
+
+USAGE:
+  ags move <tool> <label> <new-tool> [--root <path>]
+
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+BEHAVIOR:
+  - Moves snapshots/<tool>/<label>.json to snapshots/<new-tool>/<label>.json
+    and re-inspects it as <new-tool>. Linked snapshots stay in place.
+  - Refuses when the snapshot does not look like a <new-tool> auth file or
+    <new-tool> already has <label>.
+  - Aliases follow the profile; a default or last-activated marker for the
+    old tool is cleared.
+
+EXAMPLES:
+  ags move codex work pi
+`
+	case "diag":
+		return `ags diag - print a redacted diagnostics report
+
+USAGE:
+  ags diag [tool] [--root <path>]
 
-func printInsight(out io.Writer, insight AuthInsight, verbose bool) {
-	fmt.Fprintf(out, "- status: %s\n", orDash(insight.Status))
-	fmt.Fprintf(out, "- needs refresh: %s\n", orDash(insight.NeedsRefresh))
-	if insight.ExpiresAt != "" {
-		fmt.Fprintf(out, "- expires: %s\n", formatHumanTime(insight.ExpiresAt))
-	}
-	if insight.LastRefresh != "" {
-		fmt.Fprintf(out, "- last refresh: %s\n", formatHumanTime(insight.LastRefresh))
-	}
-	if !verbose {
-		return
-	}
-	if insight.AccountID != "" {
-		fmt.Fprintf(out, "- account id: %s\n", insight.AccountID)
-	}
-	for _, detail := range insight.Details {
-		fmt.Fprintf(out, "- detail: %s\n", detail)
-	}
-}
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
 
-func formatIdentity(insight AuthInsight) string {
-	email := strings.TrimSpace(insight.AccountEmail)
-	plan := strings.TrimSpace(insight.AccountPlan)
-	if email == "" {
-		return ""
-	}
-	if plan == "" {
-		return email
-	}
-	return fmt.Sprintf("%s (%s)", email, plan)
-}
+OUTPUT:
+  One JSON object with the ags version, data root, each runtime auth path and
+  its active status, and every saved profile with its identity, status, expiry,
+  paths, and a redacted copy of its snapshot.
 
-func formatHumanTime(raw string) string {
-	t, ok := parseISO(raw)
-	if !ok {
-		return raw
-	}
-	return fmt.Sprintf("%s (%s)", formatRelative(t), t.UTC().Format("Mon, Jan 2, 2006, 3:04 PM MST"))
-}
+BEHAVIOR:
+  - Token, secret, password, and key values, plus any long opaque string, are
+    replaced by "sha256:<first 12 hex chars>"; equal values keep equal hashes.
+  - Account emails and ids are included; review the output before sharing it.
 
-func summarizeExpiry(raw string) string {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return "-"
-	}
-	t, ok := parseISO(raw)
-	if !ok {
-		return raw
-	}
-	return formatRelative(t)
-}
+EXAMPLES:
+  ags diag > ags-diag.json
+  ags diag codex
+`
+	case "history":
+		return `ags history - show the timeline of one saved profile
 
-func sanitizePlainField(v string) string {
-	v = strings.ReplaceAll(v, "\t", " ")
-	v = strings.ReplaceAll(v, "\n", " ")
-	return strings.ReplaceAll(v, "\r", " ")
-}
+USAGE:
+  ags history <tool> <label> [--json] [--root <path>]
 
-func parseISO(raw string) (time.Time, bool) {
-	t, err := time.Parse(time.RFC3339Nano, raw)
-	if err != nil {
-		return time.Time{}, false
-	}
-	return t.UTC(), true
-}
+FLAGS:
+  --json            Print a JSON array of events
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
 
-func formatRelative(t time.Time) string {
-	return humanizeDuration(time.Until(t))
-}
+BEHAVIOR:
+  - Lists events newest first: created, changed (re-save with new content),
+    resaved (identical content), linked, used, and touched (ags touch).
+  - Keeps the last 50 events per profile.
+  - Profiles saved before history was recorded show their last save and use.
 
-func humanizeDuration(delta time.Duration) string {
-	if delta == 0 {
-		return "now"
-	}
+EXAMPLES:
+  ags history codex work
+  ags history pi personal --json
+`
+	case "providers":
+		return `ags providers - list the providers in a saved pi snapshot
 
-	future := delta > 0
-	if delta < 0 {
-		delta = -delta
-	}
+USAGE:
+  ags providers <label> [--json] [--root <path>]
 
-	days := int(delta / (24 * time.Hour))
-	delta %= 24 * time.Hour
-	hours := int(delta / time.Hour)
-	delta %= time.Hour
-	minutes := int(delta / time.Minute)
+FLAGS:
+  --json            Print a JSON array of providers
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+  --soon <duration> Expiring-soon window for status output (default: 15m)
 
-	parts := make([]string, 0, 2)
-	if days > 0 {
-		parts = append(parts, plural(days, "day"))
-	}
-	if hours > 0 && len(parts) < 2 {
-		parts = append(parts, plural(hours, "hour"))
-	}
-	if days == 0 && minutes > 0 && len(parts) < 2 {
-		parts = append(parts, plural(minutes, "minute"))
-	}
-	if len(parts) == 0 {
-		parts = append(parts, "less than a minute")
-	}
+BEHAVIOR:
+  - Lists each provider key in pi/<label> with its own status and expiry.
+  - Shows which --provider selector aliases (codex, anthropic) match the key.
+    The exact key always works as a selector too, as does all.
 
-	text := strings.Join(parts, " ")
-	if future {
-		return "in " + text
-	}
-	return text + " ago"
-}
+EXAMPLES:
+  ags providers work
+  ags providers work --json
+`
+	case "find":
+		return `ags find - find saved profiles by account
 
-func plural(n int, unit string) string {
-	if n == 1 {
-		return fmt.Sprintf("%d %s", n, unit)
-	}
-	return fmt.Sprintf("%d %ss", n, unit)
-}
+USAGE:
+  ags find <email-or-account-id> [--root <path>]
 
-func printRootUsage(out io.Writer) {
-	fmt.Fprint(out, rootUsageText())
-}
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
 
-func printCommandUsage(out io.Writer, command string) {
-	fmt.Fprint(out, commandUsageText(command))
-}
+BEHAVIOR:
+  - Searches saved profiles of every tool.
+  - Matches when the email or account id contains the query (case-insensitive).
+  - Prints one line per match: tool, label, account.
 
-func rootUsageText() string {
-	return `ags - Coding Agent Account Switcher
+EXAMPLES:
+  ags find person@company.com
+  ags find company.com
+`
+	case "diff":
+		return `ags diff - compare two saved snapshots
 
 USAGE:
-  ags <command> [arguments] [flags]
+  ags diff <tool> <labelA> <labelB> [--root <path>]
 
-COMMANDS:
-  save      Save current tool auth JSON as a labeled snapshot.
-  use       Activate a saved labeled snapshot for a tool.
-  delete    Remove a saved labeled snapshot and its metadata.
-  list      List saved snapshots with status and refresh signals.
-  active    Show which saved profile is currently active.
-  version   Show CLI version.
-  help      Show detailed help. Use "ags help <command>".
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
 
-TOOLS:
-  codex, pi
+OUTPUT:
+  One line per difference: added, removed, or changed, then the field.
+  codex compares account id, email, plan, expiry, last refresh, and tokens.
+  pi compares the provider set and each provider's expiry and tokens.
+  Token values are never printed; a differing token shows as "token changed".
 
-GLOBAL NOTES:
-  - Labels must match [a-zA-Z0-9._-]+.
-  - Auth files must be strict JSON objects.
-  - Default AGS data root: ~/.config/ags
+EXAMPLES:
+  ags diff codex work work-clone
+  ags diff pi personal work
+`
+	case "export-env":
+		return `ags export-env - print a snapshot's tokens as environment variables
 
-QUICK START:
-  ags save codex work
-  ags use codex work
-  ags active codex
-  ags list --verbose
+USAGE:
+  ags export-env <tool> <label> --reveal [--format sh|fish] [--root <path>]
 
-DETAIL:
-  ags help save
-  ags help use
-  ags help delete
-  ags help list
-  ags help active
-  ags version
-`
-}
+FLAGS:
+  --reveal          Required: confirms that secret tokens may be printed
+  --format <name>   sh (export NAME=...) or fish (set -x NAME ...) (default: sh)
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
 
-func commandUsageText(command string) string {
-	switch command {
-	case "save":
-		return `ags save - store a labeled auth snapshot
+VARIABLES:
+  codex  CODEX_ACCESS_TOKEN, CODEX_ACCOUNT_ID, OPENAI_API_KEY (when present)
+  pi     <PROVIDER>_ACCESS_TOKEN and <PROVIDER>_API_KEY per provider,
+         e.g. ANTHROPIC_ACCESS_TOKEN, OPENAI_CODEX_ACCESS_TOKEN
+
+BEHAVIOR:
+  - A comment before each token notes its expiry when known.
+  - Output contains secrets; avoid logging it.
+
+EXAMPLES:
+  eval "$(ags export-env codex work --reveal)"
+  ags export-env pi personal --reveal --format fish | source
+`
+	case "default":
+		return `ags default - manage the default label per tool
 
 USAGE:
-  ags save <tool> <label> [--source <path>] [--root <path>]
-  ags save <tool> --label <name> [--source <path>] [--root <path>]
+  ags default set <tool> <label> [--root <path>]
+  ags default clear <tool> [--root <path>]
+  ags default show [tool] [--root <path>]
 
 FLAGS:
-  --label, -l <name> Required profile label (example: work, personal)
-  --source <path>   Optional override source auth file path
-  --provider <id>   For pi only: save just one provider (codex, anthropic, or key)
   --root <path>     Optional AGS data root (default: ~/.config/ags)
-  --verbose         Show additional detail lines
+
+BEHAVIOR:
+  - Defaults are stored in state.json.
+  - ags save <tool> and ags use <tool> without a label use the default.
+  - Without a default, a label is still required.
 
 EXAMPLES:
-  ags save codex work
-  ags save pi personal
-  ags save pi codex-work --provider codex
-  ags save pi --label work --source ~/.pi/agent/auth.json
+  ags default set codex work
+  ags use codex
+  ags default show
+  ags default clear codex
 `
-	case "use":
-		return `ags use - activate a labeled auth snapshot
+	case "config":
+		return `ags config - persist per-tool path overrides
 
 USAGE:
-  ags use <tool> <label> [--target <path>] [--root <path>]
-  ags use <tool> --label <name> [--target <path>] [--root <path>]
+  ags config set-path <tool> runtime|source <path> [--root <path>]
+  ags config unset-path <tool> runtime|source [--root <path>]
 
 FLAGS:
-  --label, -l <name> Required profile label to activate
-  --target <path>   Optional override runtime auth destination
-  --provider <id>   For pi only: apply just one provider (codex, anthropic, or key)
   --root <path>     Optional AGS data root (default: ~/.config/ags)
-  --verbose         Show additional detail lines
 
 BEHAVIOR:
-  - Writes the saved snapshot into the tool runtime auth path.
-  - For pi, merges only providers present in the saved snapshot into the existing runtime auth JSON.
-  - Prints refresh signal: first use / unchanged / changed since last use.
+  - Overrides are stored under "paths" in <root>/config.json.
+  - runtime replaces the auth file use writes and active reads; it is also
+    where save looks unless a source override is set.
+  - source replaces the file save reads.
+  - --source and --target still win for a single command.
 
 EXAMPLES:
-  ags use codex work
-  ags use pi personal
-  ags use pi codex-work --provider codex
+  ags config set-path codex runtime ~/project/.codex/auth.json
+  ags config set-path pi source /mnt/shared/pi-auth.json
+  ags config unset-path codex runtime
 `
-	case "delete":
-		return `ags delete - remove a labeled auth snapshot
+	case "whoami":
+		return `ags whoami - show the identity in each runtime auth file
 
 USAGE:
-  ags delete <tool> <label> [--root <path>]
-  ags delete <tool> --label <name> [--root <path>]
+  ags whoami [tool] [--json] [--soon <duration>] [--root <path>]
 
 FLAGS:
-  --label, -l <name> Required profile label to delete
+  --json            Print one object keyed by tool:
+                    {"codex":{"email":"...","plan":"...","status":"valid",
+                    "expires_at":"...","runtime_path":"..."},"pi":{...}}
+  --soon <duration> Report tokens expiring within this window as expiring_soon
   --root <path>     Optional AGS data root (default: ~/.config/ags)
 
 BEHAVIOR:
-  - Deletes snapshot file from ~/.config/ags/snapshots/<tool>/<label>.json
-  - Removes matching entry from ~/.config/ags/state.json
-  - Does NOT modify current runtime auth file used by the tool
+  - Reads the runtime auth files directly; unlike ags active it does not
+    compare them with saved profiles.
+  - A runtime file that is missing, empty, or not a JSON object is reported
+    with status missing, empty, or invalid and an error message; whoami
+    still exits 0.
 
 EXAMPLES:
-  ags delete codex work
-  ags delete pi personal
+  ags whoami
+  ags whoami codex
+  ags whoami --json | jq -r .codex.email
 `
-	case "list":
-		return `ags list - inspect saved profiles
+	case "inspect":
+		return `ags inspect - show one saved profile in full
 
 USAGE:
-  ags list [tool] [--verbose] [--root <path>]
+  ags inspect <tool> <label> [--json] [--root <path>]
 
 FLAGS:
-  --verbose         Show account, timestamps, snapshot path, and details
-  --plain           Print tab-separated rows for scripts
-  --no-headers      With --plain, suppress the header row
+  --label, -l <name> Profile label (alternative to the positional label)
+  --json            Print the profile as a JSON object
+  --soon <duration> Expiring-soon window for status output (default: 15m)
   --root <path>     Optional AGS data root (default: ~/.config/ags)
 
 OUTPUT:
-  Grouped by tool with one concise line per label.
-  Use --verbose for additional metadata.
+  Account, status, expiry, details, issuer/subject/audience, and one line per
+  JWT listing its claim keys. Token values are never printed.
 
 EXAMPLES:
-  ags list
-  ags list codex
-  ags list pi --verbose
+  ags inspect codex work
+  ags inspect pi personal --json
 `
-	case "active":
-		return `ags active - show active saved profile
+	case "link":
+		return `ags link - reference an existing snapshot file in place
 
 USAGE:
-  ags active [tool] [--verbose] [--root <path>]
+  ags link <tool> <label> --snapshot <path> [--root <path>]
 
 FLAGS:
-  --verbose         Show additional detail lines
+  --label, -l <name> Profile label (alternative to the positional label)
+  --snapshot <path> Existing auth JSON file (must be a JSON object)
   --root <path>     Optional AGS data root (default: ~/.config/ags)
 
-OUTPUT COLUMNS:
-  tool, active label, status, runtime
+BEHAVIOR:
+  - Records the file as the label's snapshot; nothing is copied.
+  - use, list, and active read the linked file directly.
+  - delete removes only the metadata; the linked file is kept.
+  - A later save of the same label writes a regular snapshot under the data root.
 
 EXAMPLES:
-  ags active
-  ags active codex
-  ags active pi --verbose
+  ags link codex legacy --snapshot ~/old-tool/codex-work.json
+`
+	case "batch":
+		return `ags batch - run saves from JSON lines on stdin
+
+USAGE:
+  ags batch save [--root <path>] < records.jsonl
+
+INPUT:
+  One JSON object per line; blank lines are skipped:
+    {"tool":"codex","label":"acct1","source":"/path/to/auth.json"}
+  tool, label, and source are required; other keys are rejected.
+
+BEHAVIOR:
+  - Each record is saved like ags save <tool> <label> --source <path>.
+  - A bad record is reported with its line number and skipped; the rest are
+    still saved.
+  - Ends with a summary. Exit code 1 if any record failed.
+
+FLAGS:
+  --root <path>     Optional AGS data root (default: ~/.config/ags)
+
+EXAMPLES:
+  ags batch save < accounts.jsonl
+`
+	case "completion":
+		return `ags completion - print or install a shell completion script
+
+USAGE:
+  ags completion bash|zsh|fish [--install [--force]]
+
+FLAGS:
+  --install         Write the script into place instead of printing it:
+                      bash  $XDG_DATA_HOME/bash-completion/completions/ags
+                            (default ~/.local/share/...)
+                      zsh   ~/.zsh/completions/_ags (add the directory to fpath)
+                      fish  $XDG_CONFIG_HOME/fish/completions/ags.fish
+                            (default ~/.config/...)
+  --force           With --install, replace an existing completion file
+
+BEHAVIOR:
+  - Completes commands, tools, and saved labels (read from ags list --plain).
+  - --install creates missing directories and refuses to overwrite an existing
+    file (exit code 6) unless --force is given.
+
+EXAMPLES:
+  ags completion bash --install
+  ags completion zsh > "${fpath[1]}/_ags"
+  ags completion fish | source
 `
 	case "version":
 		return `ags version - show CLI version
 
 USAGE:
-  ags version
+  ags version [--json]
+
+FLAGS:
+  --json            Print {"version","commit","built"} for release tooling
 
 EXAMPLES:
   ags version
+  ags version --json
 `
 	default:
 		return rootUsageText()
@@ -2,34 +2,39 @@ package ags
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestRunNoArgsAndUnknownCommand(t *testing.T) {
 	var out bytes.Buffer
-	if err := Run(nil, &out, &out); err != nil {
+	if err := Run(nil, nil, &out, &out); err != nil {
 		t.Fatalf("Run no args: %v", err)
 	}
 	if !strings.Contains(out.String(), "USAGE:") {
 		t.Fatalf("expected root help output, got %q", out.String())
 	}
 
-	err := Run([]string{"unknown"}, &out, &out)
+	err := Run([]string{"unknown"}, nil, &out, &out)
 	if err == nil || !strings.Contains(err.Error(), "unknown command") {
 		t.Fatalf("expected unknown command error, got %v", err)
 	}
 }
 
 func TestRunHelpTopics(t *testing.T) {
-	topics := []string{"save", "use", "delete", "list"}
+	topics := []string{"save", "use", "delete", "list", "check", "restore-state"}
 	for _, topic := range topics {
 		var out bytes.Buffer
-		if err := Run([]string{"help", topic}, &out, &out); err != nil {
+		if err := Run([]string{"help", topic}, nil, &out, &out); err != nil {
 			t.Fatalf("help %s: %v", topic, err)
 		}
 		if !strings.Contains(out.String(), "USAGE:") {
@@ -38,7 +43,7 @@ func TestRunHelpTopics(t *testing.T) {
 	}
 
 	var out bytes.Buffer
-	if err := Run([]string{"help", "wat"}, &out, &out); err == nil {
+	if err := Run([]string{"help", "wat"}, nil, &out, &out); err == nil {
 		t.Fatalf("expected unknown help topic error")
 	}
 }
@@ -53,7 +58,7 @@ func TestSubcommandHelpFlags(t *testing.T) {
 	}
 	for _, args := range cases {
 		var out bytes.Buffer
-		if err := Run(args, &out, &out); err != nil {
+		if err := Run(args, nil, &out, &out); err != nil {
 			t.Fatalf("Run %v: %v", args, err)
 		}
 		if !strings.Contains(out.String(), "USAGE:") {
@@ -71,7 +76,7 @@ func TestCLIEndToEndSaveUseListDelete(t *testing.T) {
 	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
 
 	var out bytes.Buffer
-	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save: %v", err)
 	}
 	if !strings.Contains(out.String(), "Saved codex for work") {
@@ -79,7 +84,7 @@ func TestCLIEndToEndSaveUseListDelete(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("use: %v", err)
 	}
 	if !strings.Contains(out.String(), "Using codex for work") {
@@ -87,7 +92,7 @@ func TestCLIEndToEndSaveUseListDelete(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"list", "codex", "--verbose", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"list", "codex", "--verbose", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("list verbose: %v", err)
 	}
 	if !strings.Contains(out.String(), "Saved profiles:") || !strings.Contains(out.String(), "snapshot:") {
@@ -95,7 +100,7 @@ func TestCLIEndToEndSaveUseListDelete(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"delete", "codex", "work", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"delete", "codex", "work", "--yes", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("delete: %v", err)
 	}
 	if !strings.Contains(out.String(), "state: removed") {
@@ -103,7 +108,7 @@ func TestCLIEndToEndSaveUseListDelete(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"list", "codex", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"list", "codex", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("list empty: %v", err)
 	}
 	if !strings.Contains(out.String(), "No saved profiles found.") {
@@ -129,7 +134,7 @@ func TestCLISavePiShowsIdentityWhenAvailable(t *testing.T) {
 	writeFile(t, source, []byte(raw))
 
 	var out bytes.Buffer
-	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save pi with identity: %v", err)
 	}
 	if !strings.Contains(out.String(), "Saved pi.person@company.com (Plus) for work") {
@@ -146,12 +151,12 @@ func TestCLIListPiNormalizesProviderNames(t *testing.T) {
 	writeFile(t, source, []byte(`{"openai-codex":{"access":"token-a","expires":`+expMillis+`},"anthropic":{"access":"token-b","expires":`+expMillis+`}}`))
 
 	var out bytes.Buffer
-	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save pi: %v", err)
 	}
 
 	out.Reset()
-	if err := Run([]string{"list", "pi", "--verbose", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"list", "pi", "--verbose", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("list pi --verbose: %v", err)
 	}
 	if strings.Contains(out.String(), "openai-codex=") {
@@ -171,7 +176,7 @@ func TestCLIPiProviderScopedSaveAndUse(t *testing.T) {
 	writeFile(t, target, []byte(`{"openai-codex":{"access":"codex-old"},"anthropic":{"access":"anthro-old"}}`))
 
 	var out bytes.Buffer
-	if err := Run([]string{"save", "pi", "work", "--provider", "codex", "--source", source, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "pi", "work", "--provider", "codex", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save pi codex-only: %v", err)
 	}
 	if !strings.Contains(out.String(), "Saved pi for work") {
@@ -179,7 +184,7 @@ func TestCLIPiProviderScopedSaveAndUse(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"use", "pi", "work", "--provider", "codex", "--target", target, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"use", "pi", "work", "--provider", "codex", "--target", target, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("use pi codex-only: %v", err)
 	}
 
@@ -225,7 +230,7 @@ func TestCLIValidationAndParseErrors(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			var out bytes.Buffer
-			err := Run(tc.args, &out, &out)
+			err := Run(tc.args, nil, &out, &out)
 			if err == nil || !strings.Contains(err.Error(), tc.sub) {
 				t.Fatalf("expected error containing %q, got %v", tc.sub, err)
 			}
@@ -320,6 +325,25 @@ func TestCLITimeFormattingHelpers(t *testing.T) {
 	if got := formatRelative(time.Now().Add(-time.Minute)); !strings.Contains(got, "ago") {
 		t.Fatalf("expected past relative text, got %q", got)
 	}
+
+	if got := summarizeExpiry(""); got != "-" {
+		t.Fatalf("expected dash for empty expiry, got %q", got)
+	}
+	if got := summarizeExpiry("not-a-time"); got != "not-a-time" {
+		t.Fatalf("expected invalid expiry passed through, got %q", got)
+	}
+	expired := time.Now().Add(-2*time.Hour - time.Minute).UTC().Format(time.RFC3339)
+	if got := summarizeExpiry(expired); got != "expired 2 hours 1 minute ago" {
+		t.Fatalf("unexpected expired summary: %q", got)
+	}
+	soon := time.Now().Add(10*time.Minute + 30*time.Second).UTC().Format(time.RFC3339)
+	if got := summarizeExpiry(soon); got != "in 10 minutes (soon)" {
+		t.Fatalf("unexpected soon summary: %q", got)
+	}
+	later := time.Now().Add(3*24*time.Hour + time.Hour + 30*time.Second).UTC().Format(time.RFC3339)
+	if got := summarizeExpiry(later); got != "in 3 days 1 hour" {
+		t.Fatalf("unexpected far-future summary: %q", got)
+	}
 }
 
 func TestCLIPrintFunctionsAndUsageText(t *testing.T) {
@@ -354,7 +378,7 @@ func TestCLIPrintFunctionsAndUsageText(t *testing.T) {
 
 func TestRunHelpNoTopic(t *testing.T) {
 	var out bytes.Buffer
-	if err := Run([]string{"help"}, &out, &out); err != nil {
+	if err := Run([]string{"help"}, nil, &out, &out); err != nil {
 		t.Fatalf("help with no topic should succeed: %v", err)
 	}
 	if !strings.Contains(out.String(), "USAGE:") {
@@ -370,65 +394,65 @@ func TestRunSaveRunUseRunDeleteErrorBranches(t *testing.T) {
 
 	var out bytes.Buffer
 
-	if err := runSave([]string{}, &out); err == nil {
+	if err := runSave(cliOptions{}, []string{}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runSave len args usage error")
 	}
-	if err := runUse([]string{}, &out); err == nil {
+	if err := runUse(cliOptions{}, []string{}, &out, &out); err == nil {
 		t.Fatalf("expected runUse len args usage error")
 	}
-	if err := runDelete([]string{}, &out); err == nil {
+	if err := runDelete(cliOptions{}, []string{}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runDelete len args usage error")
 	}
 
-	if err := runSave([]string{"codex", "work", "--bad"}, &out); err == nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--bad"}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runSave parse error")
 	}
-	if err := runUse([]string{"codex", "work", "--bad"}, &out); err == nil {
+	if err := runUse(cliOptions{}, []string{"codex", "work", "--bad"}, &out, &out); err == nil {
 		t.Fatalf("expected runUse parse error")
 	}
-	if err := runDelete([]string{"codex", "work", "--bad"}, &out); err == nil {
+	if err := runDelete(cliOptions{}, []string{"codex", "work", "--bad"}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runDelete parse error")
 	}
 
-	if err := runUse([]string{"codex", "--root", root}, &out); err == nil || !strings.Contains(err.Error(), "--label is required") {
+	if err := runUse(cliOptions{}, []string{"codex", "--root", root}, &out, &out); err == nil || !strings.Contains(err.Error(), "--label is required") {
 		t.Fatalf("expected runUse required label error, got %v", err)
 	}
-	if err := runUse([]string{"codex", "bad label", "--root", root}, &out); err == nil || !strings.Contains(err.Error(), "--label must match") {
+	if err := runUse(cliOptions{}, []string{"codex", "bad label", "--root", root}, &out, &out); err == nil || !strings.Contains(err.Error(), "--label must match") {
 		t.Fatalf("expected runUse label pattern error, got %v", err)
 	}
-	if err := runDelete([]string{"codex", "--root", root}, &out); err == nil || !strings.Contains(err.Error(), "--label is required") {
+	if err := runDelete(cliOptions{}, []string{"codex", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "--label is required") {
 		t.Fatalf("expected runDelete required label error, got %v", err)
 	}
-	if err := runDelete([]string{"codex", "bad label", "--root", root}, &out); err == nil || !strings.Contains(err.Error(), "--label must match") {
+	if err := runDelete(cliOptions{}, []string{"codex", "bad label", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "--label must match") {
 		t.Fatalf("expected runDelete label pattern error, got %v", err)
 	}
 
-	if err := runSave([]string{"codex", "work", "--source", source, "--root", " "}, &out); err == nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--source", source, "--root", " "}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runSave NewManager error with empty root")
 	}
-	if err := runUse([]string{"codex", "work", "--root", " "}, &out); err == nil {
+	if err := runUse(cliOptions{}, []string{"codex", "work", "--root", " "}, &out, &out); err == nil {
 		t.Fatalf("expected runUse NewManager error with empty root")
 	}
-	if err := runDelete([]string{"codex", "work", "--root", " "}, &out); err == nil {
+	if err := runDelete(cliOptions{}, []string{"codex", "work", "--root", " "}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runDelete NewManager error with empty root")
 	}
 
-	if err := runSave([]string{"codex", "work", "--root", root}, &out); err == nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--root", root}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runSave manager.Save error when source cannot be resolved")
 	}
-	if err := runUse([]string{"codex", "work", "--root", root}, &out); err == nil {
+	if err := runUse(cliOptions{}, []string{"codex", "work", "--root", root}, &out, &out); err == nil {
 		t.Fatalf("expected runUse manager.Use error for missing saved profile")
 	}
-	if err := runDelete([]string{"codex", "work", "--root", root}, &out); err == nil {
+	if err := runDelete(cliOptions{}, []string{"codex", "work", "--yes", "--root", root}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runDelete manager.Delete error for missing profile")
 	}
 
 	out.Reset()
-	if err := runSave([]string{"codex", "work", "--source", source, "--root", root}, &out); err != nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("runSave setup: %v", err)
 	}
 	out.Reset()
-	if err := runSave([]string{"codex", "work", "--source", source, "--root", root}, &out); err != nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("runSave second save: %v", err)
 	}
 	if !strings.Contains(out.String(), "Saved codex for work") {
@@ -441,7 +465,7 @@ func TestRunListErrorAndVerboseBranches(t *testing.T) {
 	root := t.TempDir()
 	var out bytes.Buffer
 
-	if err := runList([]string{"--root", " "}, &out); err == nil {
+	if err := runList(cliOptions{}, []string{"--root", " "}, &out); err == nil {
 		t.Fatalf("expected runList NewManager error with empty root")
 	}
 
@@ -449,17 +473,17 @@ func TestRunListErrorAndVerboseBranches(t *testing.T) {
 	if err := os.MkdirAll(filepath.Join(brokenRoot, "state.json"), 0o700); err != nil {
 		t.Fatalf("mkdir state dir: %v", err)
 	}
-	if err := runList([]string{"--root", brokenRoot}, &out); err == nil {
+	if err := runList(cliOptions{}, []string{"--root", brokenRoot}, &out); err == nil {
 		t.Fatalf("expected runList manager.List/loadState error")
 	}
 
 	source := filepath.Join(root, "source.json")
 	writeFile(t, source, []byte(`{"last_refresh":"2026-01-01T00:00:00Z","tokens":{"access_token":"bad"}}`))
-	if err := runSave([]string{"codex", "work", "--source", source, "--root", root}, &out); err != nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save for list verbose branches: %v", err)
 	}
 	out.Reset()
-	if err := runList([]string{"codex", "--verbose", "--root", root}, &out); err != nil {
+	if err := runList(cliOptions{}, []string{"codex", "--verbose", "--root", root}, &out); err != nil {
 		t.Fatalf("list verbose: %v", err)
 	}
 	if !strings.Contains(out.String(), "last refresh:") || !strings.Contains(out.String(), "detail:") {
@@ -467,7 +491,7 @@ func TestRunListErrorAndVerboseBranches(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := runList([]string{"codex", "--plain", "--root", root}, &out); err != nil {
+	if err := runList(cliOptions{}, []string{"codex", "--plain", "--root", root}, &out); err != nil {
 		t.Fatalf("list plain: %v", err)
 	}
 	if !strings.Contains(out.String(), "tool\tlabel\tstatus\tneeds_refresh") {
@@ -475,7 +499,7 @@ func TestRunListErrorAndVerboseBranches(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := runList([]string{"codex", "--plain", "--no-headers", "--root", root}, &out); err != nil {
+	if err := runList(cliOptions{}, []string{"codex", "--plain", "--no-headers", "--root", root}, &out); err != nil {
 		t.Fatalf("list plain no-headers: %v", err)
 	}
 	if strings.Contains(out.String(), "tool\tlabel\tstatus\tneeds_refresh") {
@@ -490,17 +514,17 @@ func TestRunUseAndDeleteRemainingBranches(t *testing.T) {
 	writeFile(t, source, []byte(`{"x":1}`))
 	var out bytes.Buffer
 
-	if err := runSave([]string{"codex", "work", "--source", source, "--root", root}, &out); err != nil {
+	if err := runSave(cliOptions{}, []string{"codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("setup save: %v", err)
 	}
 
 	// resolveLabel conflict branch in runUse
-	if err := runUse([]string{"codex", "work", "--label", "personal", "--root", root}, &out); err == nil {
+	if err := runUse(cliOptions{}, []string{"codex", "work", "--label", "personal", "--root", root}, &out, &out); err == nil {
 		t.Fatalf("expected runUse resolveLabel conflict error")
 	}
 
 	// resolveLabel conflict branch in runDelete
-	if err := runDelete([]string{"codex", "work", "--label", "personal", "--root", root}, &out); err == nil {
+	if err := runDelete(cliOptions{}, []string{"codex", "work", "--label", "personal", "--root", root}, nil, &out, &out); err == nil {
 		t.Fatalf("expected runDelete resolveLabel conflict error")
 	}
 
@@ -513,7 +537,7 @@ func TestRunUseAndDeleteRemainingBranches(t *testing.T) {
 		t.Fatalf("remove snapshot: %v", err)
 	}
 	out.Reset()
-	if err := runDelete([]string{"codex", "work", "--root", root}, &out); err != nil {
+	if err := runDelete(cliOptions{}, []string{"codex", "work", "--yes", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("runDelete with missing snapshot: %v", err)
 	}
 	if !strings.Contains(out.String(), "snapshot file: already missing") {
@@ -527,7 +551,7 @@ func TestRunVersionAndHelpTopic(t *testing.T) {
 	defer func() { Version = oldVersion }()
 
 	var out bytes.Buffer
-	if err := Run([]string{"version"}, &out, &out); err != nil {
+	if err := Run([]string{"version"}, nil, &out, &out); err != nil {
 		t.Fatalf("version command: %v", err)
 	}
 	if !strings.Contains(out.String(), "ags version 0.1.0-test") {
@@ -535,7 +559,7 @@ func TestRunVersionAndHelpTopic(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"--version"}, &out, &out); err != nil {
+	if err := Run([]string{"--version"}, nil, &out, &out); err != nil {
 		t.Fatalf("--version command: %v", err)
 	}
 	if !strings.Contains(out.String(), "ags version 0.1.0-test") {
@@ -543,7 +567,7 @@ func TestRunVersionAndHelpTopic(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"help", "active"}, &out, &out); err != nil {
+	if err := Run([]string{"help", "active"}, nil, &out, &out); err != nil {
 		t.Fatalf("help active: %v", err)
 	}
 	if !strings.Contains(out.String(), "ags active") {
@@ -551,7 +575,7 @@ func TestRunVersionAndHelpTopic(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"help", "version"}, &out, &out); err != nil {
+	if err := Run([]string{"help", "version"}, nil, &out, &out); err != nil {
 		t.Fatalf("help version: %v", err)
 	}
 	if !strings.Contains(out.String(), "ags version") {
@@ -566,7 +590,7 @@ func TestRunActive(t *testing.T) {
 	writeFile(t, piSrc, []byte(`{"openai-codex":{"access":"codex-work"}}`))
 
 	var out bytes.Buffer
-	if err := Run([]string{"active", "--help"}, &out, &out); err != nil {
+	if err := Run([]string{"active", "--help"}, nil, &out, &out); err != nil {
 		t.Fatalf("active --help: %v", err)
 	}
 	if !strings.Contains(out.String(), "ags active") {
@@ -574,12 +598,12 @@ func TestRunActive(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"save", "pi", "work", "--source", piSrc, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "pi", "work", "--source", piSrc, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save pi for active: %v", err)
 	}
 
 	out.Reset()
-	if err := Run([]string{"active", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"active", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("active all: %v", err)
 	}
 	if !strings.Contains(out.String(), "tool\tactive label\tstatus\truntime") {
@@ -587,23 +611,23 @@ func TestRunActive(t *testing.T) {
 	}
 
 	out.Reset()
-	if err := Run([]string{"active", "pi", "--verbose", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"active", "pi", "--verbose", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("active filtered: %v", err)
 	}
 	if !strings.Contains(out.String(), "pi") {
 		t.Fatalf("expected pi row in active output: %q", out.String())
 	}
 
-	if err := Run([]string{"active", "bad"}, &out, &out); err == nil {
+	if err := Run([]string{"active", "bad"}, nil, &out, &out); err == nil {
 		t.Fatalf("expected invalid tool error")
 	}
-	if err := Run([]string{"active", "pi", "extra", "--root", root}, &out, &out); err == nil {
+	if err := Run([]string{"active", "pi", "extra", "--root", root}, nil, &out, &out); err == nil {
 		t.Fatalf("expected active usage error for extra arg")
 	}
-	if err := Run([]string{"active", "pi", "--bad-flag", "--root", root}, &out, &out); err == nil {
+	if err := Run([]string{"active", "pi", "--bad-flag", "--root", root}, nil, &out, &out); err == nil {
 		t.Fatalf("expected active parse error")
 	}
-	if err := Run([]string{"active", "pi", "--root", " "}, &out, &out); err == nil {
+	if err := Run([]string{"active", "pi", "--root", " "}, nil, &out, &out); err == nil {
 		t.Fatalf("expected active NewManager error")
 	}
 
@@ -611,29 +635,3775 @@ func TestRunActive(t *testing.T) {
 	if err := os.MkdirAll(filepath.Join(brokenRoot, "state.json"), 0o700); err != nil {
 		t.Fatalf("mkdir broken state path: %v", err)
 	}
-	if err := Run([]string{"active", "--root", brokenRoot}, &out, &out); err == nil {
+	if err := Run([]string{"active", "--root", brokenRoot}, nil, &out, &out); err == nil {
 		t.Fatalf("expected active manager error")
 	}
 
 	codexSrc := filepath.Join(t.TempDir(), "codex.json")
 	writeFile(t, codexSrc, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
 	out.Reset()
-	if err := Run([]string{"save", "codex", "work", "--source", codexSrc, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "codex", "work", "--source", codexSrc, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save codex work: %v", err)
 	}
 	out.Reset()
-	if err := Run([]string{"save", "codex", "work-clone", "--source", codexSrc, "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"save", "codex", "work-clone", "--source", codexSrc, "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("save codex work-clone: %v", err)
 	}
 	out.Reset()
-	if err := Run([]string{"use", "codex", "work", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"use", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("use codex work: %v", err)
 	}
 	out.Reset()
-	if err := Run([]string{"active", "codex", "--verbose", "--root", root}, &out, &out); err != nil {
+	if err := Run([]string{"active", "codex", "--verbose", "--root", root}, nil, &out, &out); err != nil {
 		t.Fatalf("active codex verbose: %v", err)
 	}
 	if !strings.Contains(out.String(), "detail=multiple saved labels match current runtime auth") {
 		t.Fatalf("expected active verbose detail, got %q", out.String())
 	}
 }
+
+func TestRunListAccountFilter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	workSrc := filepath.Join(root, "work.json")
+	personalSrc := filepath.Join(root, "personal.json")
+	writeFile(t, workSrc, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_work", "Work.Person@Company.com", "team"))
+	writeFile(t, personalSrc, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_personal", "me@home.net", "plus"))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", workSrc, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save work: %v", err)
+	}
+	if err := Run([]string{"save", "codex", "personal", "--source", personalSrc, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save personal: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--account", "company.COM", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --account email: %v", err)
+	}
+	if !strings.Contains(out.String(), "work") || strings.Contains(out.String(), "personal") {
+		t.Fatalf("expected only work profile, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "codex", "--account", "acct_personal", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --account id: %v", err)
+	}
+	if !strings.Contains(out.String(), "personal") || strings.Contains(out.String(), "  work") {
+		t.Fatalf("expected only personal profile, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--account", "acct_per", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --account partial id: %v", err)
+	}
+	if !strings.Contains(out.String(), "No saved profiles found.") {
+		t.Fatalf("expected empty message for partial id, got %q", out.String())
+	}
+}
+
+func TestMatchesAccount(t *testing.T) {
+	insight := AuthInsight{AccountEmail: "Person@Company.com", AccountID: "acct_1"}
+	if !matchesAccount(insight, "person@") || !matchesAccount(insight, "acct_1") {
+		t.Fatalf("expected email substring and exact id to match")
+	}
+	if matchesAccount(insight, "acct") || matchesAccount(AuthInsight{}, "x") {
+		t.Fatalf("did not expect partial id or empty identity to match")
+	}
+	items := []ListItem{{Label: "a", AuthInsight: insight}, {Label: "b"}}
+	if got := filterItemsByAccount(items, " "); len(got) != 2 {
+		t.Fatalf("expected blank query to keep all items, got %+v", got)
+	}
+}
+
+func TestRunListByAccount(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	codexSrc := filepath.Join(root, "codex.json")
+	piSrc := filepath.Join(root, "pi.json")
+	otherSrc := filepath.Join(root, "other.json")
+	writeFile(t, codexSrc, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_b", "b@company.com", "team"))
+	writeFile(t, otherSrc, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	accessToken := makeJWT(t, map[string]any{
+		"exp":                            time.Now().UTC().Add(2 * time.Hour).Unix(),
+		"https://api.openai.com/profile": map[string]any{"email": "b@company.com"},
+	})
+	writeFile(t, piSrc, []byte(`{"openai-codex":{"access":"`+accessToken+`"}}`))
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"save", "codex", "work", "--source", codexSrc, "--root", root},
+		{"save", "pi", "work", "--source", piSrc, "--root", root},
+		{"save", "codex", "anon", "--source", otherSrc, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--by-account", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --by-account: %v", err)
+	}
+	got := out.String()
+	if !strings.Contains(got, "Saved profiles by account:") {
+		t.Fatalf("expected by-account header, got %q", got)
+	}
+	accountIdx := strings.Index(got, "\nb@company.com\n")
+	unknownIdx := strings.Index(got, "\nunknown\n")
+	if accountIdx < 0 || unknownIdx < 0 || accountIdx > unknownIdx {
+		t.Fatalf("expected account group before unknown group, got %q", got)
+	}
+	codexIdx := strings.Index(got, "tool=codex")
+	piIdx := strings.Index(got, "tool=pi")
+	if codexIdx < 0 || piIdx < 0 || codexIdx > piIdx {
+		t.Fatalf("expected codex before pi within account group, got %q", got)
+	}
+
+	if key := accountGroupKey(AuthInsight{AccountID: "acct_x"}); key != "acct_x" {
+		t.Fatalf("expected account id fallback, got %q", key)
+	}
+}
+
+func TestRunUseBackupOutput(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	target := filepath.Join(root, "target.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"use", "codex", "work", "--backup", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use --backup without target: %v", err)
+	}
+	if !strings.Contains(out.String(), "- backup: skipped") {
+		t.Fatalf("expected skipped backup output, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"use", "codex", "work", "--backup", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use --backup: %v", err)
+	}
+	if !strings.Contains(out.String(), "- backup: "+filepath.Join(root, "backups", "codex")) {
+		t.Fatalf("expected backup path output, got %q", out.String())
+	}
+}
+
+func TestRunRestoreState(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"restore-state", "--help"}, nil, &out, &out); err != nil || !strings.Contains(out.String(), "ags restore-state") {
+		t.Fatalf("restore-state --help: %v %q", err, out.String())
+	}
+	if err := Run([]string{"restore-state", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "no state backup found") {
+		t.Fatalf("expected missing backup error, got %v", err)
+	}
+
+	for _, label := range []string{"work", "personal"} {
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, &out, &out); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"restore-state", "--from", "1", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("restore-state: %v", err)
+	}
+	if !strings.Contains(out.String(), "Restored state from") || !strings.Contains(out.String(), "- entries: 1") {
+		t.Fatalf("unexpected restore output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "codex", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list after restore: %v", err)
+	}
+	if strings.Contains(out.String(), "personal") {
+		t.Fatalf("expected restored state to predate personal save, got %q", out.String())
+	}
+
+	if err := Run([]string{"restore-state", "extra"}, nil, &out, &out); err == nil {
+		t.Fatalf("expected usage error for extra arg")
+	}
+	if err := Run([]string{"restore-state", "--bad"}, nil, &out, &out); err == nil {
+		t.Fatalf("expected parse error")
+	}
+	if err := Run([]string{"restore-state", "--root", " "}, nil, &out, &out); err == nil {
+		t.Fatalf("expected NewManager error")
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	soon := filepath.Join(root, "soon.json")
+	gone := filepath.Join(root, "gone.json")
+	writeFile(t, soon, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	writeFile(t, gone, makeCodexAuthJSON(t, time.Now().Add(-time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"check", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("check with no profiles: %v", err)
+	}
+	if !strings.Contains(out.String(), "OK: 0 checked") {
+		t.Fatalf("unexpected empty check output: %q", out.String())
+	}
+
+	if err := Run([]string{"save", "codex", "soon", "--source", soon, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save soon: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"check", "codex", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("check default window: %v", err)
+	}
+	if !strings.Contains(out.String(), "codex\tsoon\tok") {
+		t.Fatalf("expected verbose healthy row, got %q", out.String())
+	}
+
+	out.Reset()
+	err := Run([]string{"check", "--warn-before", "2h", "--root", root}, nil, &out, &out)
+	var exitErr *ExitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected exit code 1 error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "codex\tsoon\texpiring") {
+		t.Fatalf("expected expiring row, got %q", out.String())
+	}
+
+	if err := Run([]string{"save", "codex", "gone", "--source", gone, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save gone: %v", err)
+	}
+	err = Run([]string{"check", "--warn-before", "2h", "--root", root}, nil, &out, &out)
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 || !strings.Contains(err.Error(), "1 expired") {
+		t.Fatalf("expected exit code 2 error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "codex\tgone\texpired\tcritical\t") || !strings.Contains(out.String(), "codex\tsoon\texpiring\tmedium\t") {
+		t.Fatalf("expected graded rows, got %q", out.String())
+	}
+
+	if err := Run([]string{"delete", "codex", "gone", "--yes", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("delete gone: %v", err)
+	}
+	out.Reset()
+	err = Run([]string{"check", "--warn-before", "3h", "--critical-before", "2h", "--root", root}, nil, &out, &out)
+	if !errors.As(err, &exitErr) || exitErr.Code != 2 || !strings.Contains(err.Error(), "0 expired, 1 expiring within 2h0m0s") {
+		t.Fatalf("expected high severity to exit 2, got %v", err)
+	}
+	if !strings.Contains(out.String(), "codex\tsoon\texpiring\thigh\t") {
+		t.Fatalf("expected high row, got %q", out.String())
+	}
+	err = Run([]string{"check", "--warn-before", "3h", "--critical-before", "30m", "--root", root}, nil, &out, &out)
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected medium severity to exit 1, got %v", err)
+	}
+
+	cases := [][]string{
+		{"check", "--critical-before", "-1m"},
+		{"check", "--warn-before", "1h", "--critical-before", "2h"},
+		{"check", "bad"},
+		{"check", "codex", "extra"},
+		{"check", "--bad"},
+		{"check", "--warn-before", "-1h"},
+		{"check", "--root", " "},
+		{"check", "--warn-before", "soon"},
+	}
+	for _, args := range cases {
+		if err := Run(args, nil, &out, &out); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+
+	brokenRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(brokenRoot, "state.json"), 0o700); err != nil {
+		t.Fatalf("mkdir broken state: %v", err)
+	}
+	if err := Run([]string{"check", "--root", brokenRoot}, nil, &out, &out); err == nil {
+		t.Fatalf("expected manager error for broken state")
+	}
+}
+
+func TestRunListCount(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	fresh := filepath.Join(root, "fresh.json")
+	gone := filepath.Join(root, "gone.json")
+	writeFile(t, fresh, makeCodexAuthJSON(t, time.Now().Add(5*time.Hour)))
+	writeFile(t, gone, makeCodexAuthJSON(t, time.Now().Add(-time.Hour)))
+	for label, source := range map[string]string{"a": fresh, "b": fresh, "old": gone} {
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+	if err := Run([]string{"tag", "add", "codex", "a", "prod", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+
+	cases := map[string][]string{
+		"codex: 3, pi: 0, total: 3\n": {"list", "--count"},
+		"codex: 1, pi: 0, total: 1\n": {"list", "--expiring", "--count"},
+		"codex: 1, total: 1\n":        {"list", "codex", "--tag", "prod", "--count"},
+		"pi: 0, total: 0\n":           {"list", "pi", "--count"},
+	}
+	for want, args := range cases {
+		var out bytes.Buffer
+		if err := Run(append(args, "--root", root), nil, &out, &out); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if out.String() != want {
+			t.Fatalf("%v: expected %q, got %q", args, want, out.String())
+		}
+	}
+
+	if err := Run([]string{"list", "--count", "--jsonl", "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --count with --jsonl to be rejected, got %v", err)
+	}
+}
+
+func TestRunSnapshotPath(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := Run([]string{"link", "codex", "shared", "--snapshot", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"snapshot-path", "codex", "work", "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("snapshot-path: %v", err)
+	}
+	if want := filepath.Join(root, "snapshots", "codex", "work.json") + "\n"; stdout.String() != want || stderr.Len() != 0 {
+		t.Fatalf("expected %q with no note, got stdout=%q stderr=%q", want, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	if err := Run([]string{"snapshot-path", "codex", "shared", "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("snapshot-path linked: %v", err)
+	}
+	if stdout.String() != source+"\n" || !strings.Contains(stderr.String(), "Note: ags save codex shared would write "+filepath.Join(root, "snapshots", "codex", "shared.json")) {
+		t.Fatalf("expected linked path and note, got stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+
+	if err := Run([]string{"snapshot-path", "pi", "missing", "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected missing label to be not found, got %v", err)
+	}
+	stdout.Reset()
+	if err := Run([]string{"snapshot-path", "pi", "missing", "--even-if-missing", "--root", root}, nil, &stdout, io.Discard); err != nil {
+		t.Fatalf("snapshot-path --even-if-missing: %v", err)
+	}
+	if want := filepath.Join(root, "snapshots", "pi", "missing.json") + "\n"; stdout.String() != want {
+		t.Fatalf("expected %q, got %q", want, stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "pi", "missing.json")); !os.IsNotExist(err) {
+		t.Fatalf("snapshot-path must not create files, got %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"snapshot-path", "codex"},
+		{"snapshot-path", "bad", "work"},
+		{"snapshot-path", "codex", "bad label"},
+	} {
+		if err := Run(append(args, "--root", root), nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected invalid input for %v, got %v", args, err)
+		}
+	}
+}
+
+func TestRunListSoonFlag(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--soon", "2h", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save --soon: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list default: %v", err)
+	}
+	if !strings.Contains(out.String(), "\tvalid\t") {
+		t.Fatalf("expected valid with default window, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--plain", "--no-headers", "--soon", "2h", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --soon: %v", err)
+	}
+	if !strings.Contains(out.String(), "\texpiring_soon\t") {
+		t.Fatalf("expected expiring_soon with --soon 2h, got %q", out.String())
+	}
+
+	if err := Run([]string{"list", "--soon", "-1m", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "--soon must not be negative") {
+		t.Fatalf("expected negative --soon error, got %v", err)
+	}
+}
+
+func TestRunSaveFromStdin(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+
+	stdin := bytes.NewReader(makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", "-", "--verbose", "--root", root}, stdin, &out, &out); err != nil {
+		t.Fatalf("save from stdin: %v", err)
+	}
+	if !strings.Contains(out.String(), "- source: <stdin>") {
+		t.Fatalf("expected stdin source in output, got %q", out.String())
+	}
+}
+
+func TestRunUsePrint(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	codexSrc := filepath.Join(root, "codex.json")
+	piSrc := filepath.Join(root, "pi.json")
+	codexRaw := makeCodexAuthJSON(t, time.Now().Add(2*time.Hour))
+	writeFile(t, codexSrc, codexRaw)
+	writeFile(t, piSrc, []byte(`{"openai-codex":{"access":"codex-work"},"anthropic":{"access":"anthro-work"}}`))
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"save", "codex", "work", "--source", codexSrc, "--root", root},
+		{"save", "pi", "work", "--source", piSrc, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"use", "codex", "work", "--print", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use --print: %v", err)
+	}
+	if out.String() != string(codexRaw) {
+		t.Fatalf("expected raw snapshot on stdout, got %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(home, ".codex", "auth.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected runtime file untouched, got err=%v", err)
+	}
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if state.Entries[stateKey(ToolCodex, "work")].LastUsedAt != "" {
+		t.Fatalf("expected --print not to update last used, got %+v", state.Entries)
+	}
+
+	out.Reset()
+	if err := Run([]string{"use", "pi", "work", "--print", "--provider", "anthropic", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use pi --print --provider: %v", err)
+	}
+	if !strings.Contains(out.String(), "anthro-work") || strings.Contains(out.String(), "codex-work") {
+		t.Fatalf("expected filtered pi snapshot, got %q", out.String())
+	}
+
+	if err := Run([]string{"use", "codex", "work", "--print", "--target", "/tmp/x", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("expected --print/--target conflict, got %v", err)
+	}
+	if err := Run([]string{"use", "codex", "work", "--print", "--backup", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("expected --print/--backup conflict, got %v", err)
+	}
+	for _, flags := range [][]string{{"--if-changed"}, {"--runtime-check"}, {"--quiet-on-unchanged"}, {"--no-merge"}, {"--merge-strategy", "deep"}} {
+		args := append([]string{"use", "pi", "work", "--print", "--root", root}, flags...)
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "--print and "+flags[0]+" are mutually exclusive") {
+			t.Fatalf("expected --print/%s conflict, got %v", flags[0], err)
+		}
+	}
+	if err := Run([]string{"use", "codex", "missing", "--print", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "no saved profile") {
+		t.Fatalf("expected missing profile error, got %v", err)
+	}
+}
+
+func TestFilterItemsByLastUsedAndParseAge(t *testing.T) {
+	now := time.Now().UTC()
+	items := []ListItem{
+		{Label: "recent", LastUsedAt: now.Add(-time.Hour).Format(time.RFC3339)},
+		{Label: "stale", LastUsedAt: now.Add(-40 * 24 * time.Hour).Format(time.RFC3339)},
+		{Label: "never"},
+	}
+	labels := func(items []ListItem) string {
+		out := []string{}
+		for _, item := range items {
+			out = append(out, item.Label)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := labels(filterItemsByLastUsed(items, 7*24*time.Hour, 0)); got != "recent" {
+		t.Fatalf("unexpected used-since result %q", got)
+	}
+	if got := labels(filterItemsByLastUsed(items, 0, 30*24*time.Hour)); got != "stale,never" {
+		t.Fatalf("unexpected unused-for result %q", got)
+	}
+	if got := labels(filterItemsByLastUsed(items, 0, 0)); got != "recent,stale,never" {
+		t.Fatalf("expected no filtering, got %q", got)
+	}
+
+	cases := map[string]time.Duration{
+		"7d":    7 * 24 * time.Hour,
+		"1d12h": 36 * time.Hour,
+		"90m":   90 * time.Minute,
+	}
+	for raw, want := range cases {
+		got, err := parseAge(raw)
+		if err != nil || got != want {
+			t.Fatalf("parseAge(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"xd", "1dx", "soon"} {
+		if _, err := parseAge(raw); err == nil {
+			t.Fatalf("expected parseAge error for %q", raw)
+		}
+	}
+	if _, err := parseAgeFlag("--used-since", "0d"); err == nil {
+		t.Fatalf("expected non-positive age error")
+	}
+}
+
+func TestRunListUsedSinceAndUnusedFor(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	target := filepath.Join(root, "target.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"save", "codex", "used", "--source", source, "--root", root},
+		{"save", "codex", "idle", "--source", source, "--root", root},
+		{"use", "codex", "used", "--target", target, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--used-since", "7d", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --used-since: %v", err)
+	}
+	if !strings.Contains(out.String(), "\tused\t") || strings.Contains(out.String(), "\tidle\t") {
+		t.Fatalf("unexpected --used-since output %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--unused-for", "30d", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --unused-for: %v", err)
+	}
+	if strings.Contains(out.String(), "\tused\t") || !strings.Contains(out.String(), "\tidle\t") {
+		t.Fatalf("unexpected --unused-for output %q", out.String())
+	}
+
+	if err := Run([]string{"list", "--used-since", "bad", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "--used-since must be") {
+		t.Fatalf("expected --used-since parse error, got %v", err)
+	}
+	if err := Run([]string{"list", "--unused-for", "-1h", "--root", root}, nil, &out, &out); err == nil || !strings.Contains(err.Error(), "--unused-for must be") {
+		t.Fatalf("expected --unused-for parse error, got %v", err)
+	}
+}
+
+func TestRunAlias(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"alias", "add", "w", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("alias add: %v", err)
+	}
+	if !strings.Contains(out.String(), "Added alias w -> codex work") {
+		t.Fatalf("unexpected alias add output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"save", "w", "--source", src, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save via alias: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"use", "w", "--root=" + root}, nil, &out, &out); err != nil {
+		t.Fatalf("use via alias: %v", err)
+	}
+	if !strings.Contains(out.String(), "for work") {
+		t.Fatalf("unexpected use output: %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(home, ".codex", "auth.json")); err != nil {
+		t.Fatalf("expected runtime auth written via alias: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"alias", "ls", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("alias ls: %v", err)
+	}
+	if out.String() != "w -> codex work\n" {
+		t.Fatalf("unexpected alias ls output: %q", out.String())
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want error
+	}{
+		{[]string{"alias", "add", "w", "pi", "work", "--root", root}, ErrAlreadyExists},
+		{[]string{"alias", "add", "Codex", "codex", "work", "--root", root}, ErrInvalidInput},
+		{[]string{"alias", "add", "bad/name", "codex", "work", "--root", root}, ErrInvalidInput},
+		{[]string{"alias", "add", "x", "nope", "work", "--root", root}, ErrInvalidInput},
+		{[]string{"alias", "add", "x", "codex", "--root", root}, ErrInvalidInput},
+		{[]string{"alias", "rm", "missing", "--root", root}, ErrProfileNotFound},
+		{[]string{"alias", "ls", "extra", "--root", root}, ErrInvalidInput},
+		{[]string{"alias", "bogus", "--root", root}, ErrInvalidInput},
+		{[]string{"alias", "ls", "--nope"}, ErrInvalidInput},
+		{[]string{"use", "unknown", "--root", root}, ErrInvalidInput},
+	} {
+		err := Run(tc.args, nil, &out, &out)
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("Run %v: expected %v, got %v", tc.args, tc.want, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"delete", "w", "-y", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("delete via alias: %v", err)
+	}
+	if !strings.Contains(out.String(), "Deleted codex label=work") {
+		t.Fatalf("unexpected delete output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"alias", "rm", "w", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("alias rm: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"alias", "ls", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("alias ls: %v", err)
+	}
+	if !strings.Contains(out.String(), "No aliases defined.") {
+		t.Fatalf("unexpected empty alias ls output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"alias"}, nil, &out, &out); err != nil {
+		t.Fatalf("alias help: %v", err)
+	}
+	if !strings.Contains(out.String(), "ags alias add <name> <tool> <label>") {
+		t.Fatalf("expected alias usage, got %q", out.String())
+	}
+}
+
+func TestRootFromArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"w"}, defaultRootDir()},
+		{[]string{"w", "--root", "/a"}, "/a"},
+		{[]string{"w", "-root=/b"}, "/b"},
+		{[]string{"w", "--root"}, defaultRootDir()},
+		{[]string{"root", "x"}, defaultRootDir()},
+	} {
+		if got := rootFromArgs(tc.args); got != tc.want {
+			t.Fatalf("rootFromArgs(%v) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestRunNote(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--note", "sandbox", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save --note: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"list", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), "    note: sandbox\n") {
+		t.Fatalf("expected note in verbose list, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"note", "codex", "work", "client X", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("note: %v", err)
+	}
+	if !strings.Contains(out.String(), "Updated note for codex work") {
+		t.Fatalf("unexpected note output: %q", out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"list", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), "    note: client X\n") {
+		t.Fatalf("expected updated note, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"note", "codex", "work", "", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("note clear: %v", err)
+	}
+	if !strings.Contains(out.String(), "Cleared note for codex work") {
+		t.Fatalf("unexpected clear output: %q", out.String())
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want error
+	}{
+		{[]string{"note", "codex", "work", "--root", root}, ErrInvalidInput},
+		{[]string{"note", "bad", "work", "x", "--root", root}, ErrInvalidInput},
+		{[]string{"note", "codex", "bad/label", "x", "--root", root}, ErrInvalidInput},
+		{[]string{"note", "codex", "missing", "x", "--root", root}, ErrProfileNotFound},
+		{[]string{"note", "codex", "work", "x", "--nope"}, ErrInvalidInput},
+	} {
+		if err := Run(tc.args, nil, &out, &out); !errors.Is(err, tc.want) {
+			t.Fatalf("Run %v: expected %v, got %v", tc.args, tc.want, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"help", "note"}, nil, &out, &out); err != nil || !strings.Contains(out.String(), "ags note <tool> <label> <text>") {
+		t.Fatalf("help note: err=%v out=%q", err, out.String())
+	}
+}
+
+func TestRunSaveWarnsOnDuplicateToStderr(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"save", "codex", "personal", "--source", src, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save personal: %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no warning on first save, got %q", stderr.String())
+	}
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save work: %v", err)
+	}
+	if stderr.String() != "Warning: identical to existing label(s): personal\n" {
+		t.Fatalf("unexpected stderr: %q", stderr.String())
+	}
+	if strings.Contains(stdout.String(), "Warning") {
+		t.Fatalf("warning should not go to stdout: %q", stdout.String())
+	}
+}
+
+func TestRunSaveWarnsOnCrossToolAccountMismatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+	first := filepath.Join(root, "first.json")
+	writeFile(t, first, makeCodexAuthJSONWithIdentity(t, exp, "acct_a", "person@a.com", ""))
+	second := filepath.Join(root, "second.json")
+	writeFile(t, second, makeCodexAuthJSONWithIdentity(t, exp, "acct_b", "person@b.com", ""))
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", first, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save codex: %v", err)
+	}
+	// The fixture is codex-shaped; skip the shape check to isolate the account warning.
+	if err := Run([]string{"save", "pi", "work", "--source", second, "--expect-tool", "off", "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save pi: %v", err)
+	}
+	if stderr.String() != "Warning: label work now spans multiple accounts: pi=acct_b, codex=person@a.com\n" {
+		t.Fatalf("unexpected stderr: %q", stderr.String())
+	}
+}
+
+func TestRunFind(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+	codexSrc := filepath.Join(root, "codex.json")
+	writeFile(t, codexSrc, makeCodexAuthJSONWithIdentity(t, exp, "acct_1", "person@company.com", "pro"))
+	idOnlySrc := filepath.Join(root, "id-only.json")
+	writeFile(t, idOnlySrc, makeCodexAuthJSONWithIdentity(t, exp, "acct_company", "", ""))
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"save", "codex", "work", "--source", codexSrc, "--root", root},
+		{"save", "codex", "ci", "--source", idOnlySrc, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"find", "company", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	want := "codex  ci                 acct_company\ncodex  work               person@company.com (Pro)\n"
+	if out.String() != want {
+		t.Fatalf("unexpected find output:\n%q\nwant\n%q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := Run([]string{"find", "--root", root, "nobody"}, nil, &out, &out); err != nil {
+		t.Fatalf("find nobody: %v", err)
+	}
+	if !strings.Contains(out.String(), `No saved profiles match "nobody".`) {
+		t.Fatalf("unexpected no-match output: %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"find", "--root", root},
+		{"find", "a", "b", "--root", root},
+		{"find", "a", "--nope"},
+	} {
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Run %v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunListVerboseShowsTokenClaims(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	access := makeJWT(t, map[string]any{"exp": time.Now().Add(2 * time.Hour).Unix(), "iss": "https://auth.example.com", "sub": "user-1", "aud": "api"})
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, []byte(`{"tokens":{"access_token":"`+access+`"}}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"list", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	for _, want := range []string{"    issuer: https://auth.example.com\n", "    subject: user-1\n", "    audience: api\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in verbose list, got %q", want, out.String())
+		}
+	}
+}
+
+func TestRunListJSONL(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+	codexSrc := filepath.Join(root, "codex.json")
+	writeFile(t, codexSrc, makeCodexAuthJSONWithIdentity(t, exp, "acct_1", "person@company.com", "pro"))
+	piSrc := filepath.Join(root, "pi.json")
+	writeFile(t, piSrc, []byte(`{"anthropic":{"access":"a"}}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"list", "--jsonl", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --jsonl empty: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no output for empty jsonl list, got %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"save", "codex", "work", "--source", codexSrc, "--note", "main", "--root", root},
+		{"save", "pi", "home", "--source", piSrc, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--jsonl", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --jsonl: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", out.String())
+	}
+	var first map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("decode line: %v", err)
+	}
+	if first["tool"] != "codex" || first["label"] != "work" || first["account_email"] != "person@company.com" || first["note"] != "main" || first["status"] != "valid" {
+		t.Fatalf("unexpected first object: %v", first)
+	}
+	var second map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("decode line: %v", err)
+	}
+	if second["tool"] != "pi" || second["label"] != "home" {
+		t.Fatalf("unexpected second object: %v", second)
+	}
+	if _, ok := second["issuer"]; ok {
+		t.Fatalf("expected empty fields omitted, got %v", second)
+	}
+
+	flushed := &flushRecorder{}
+	if err := Run([]string{"list", "--jsonl", "--root", root}, nil, flushed, io.Discard); err != nil {
+		t.Fatalf("list --jsonl to a flushing writer: %v", err)
+	}
+	if len(flushed.atFlush) != 2 || strings.Count(flushed.atFlush[0], "\n") != 1 {
+		t.Fatalf("expected a flush after each line, got %q", flushed.atFlush)
+	}
+
+	if err := Run([]string{"list", "--jsonl", "--plain", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --jsonl/--plain conflict, got %v", err)
+	}
+}
+
+// flushRecorder is a buffering writer that records what had been written
+// at each Flush.
+type flushRecorder struct {
+	bytes.Buffer
+	atFlush []string
+}
+
+func (f *flushRecorder) Flush() error {
+	f.atFlush = append(f.atFlush, f.String())
+	return nil
+}
+
+func TestRunUseNoMerge(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(root, "pi.json")
+	writeFile(t, src, []byte(`{"anthropic":{"access":"anthro-work"}}`))
+	target := filepath.Join(root, "runtime.json")
+	writeFile(t, target, []byte(`{"runtime-only":{"access":"x"}}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "pi", "work", "--source", src, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := Run([]string{"use", "pi", "work", "--target", target, "--no-merge", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use --no-merge: %v", err)
+	}
+	raw, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("read target: %v", err)
+	}
+	if strings.Contains(string(raw), "runtime-only") {
+		t.Fatalf("expected runtime-only provider dropped, got %s", raw)
+	}
+}
+
+func TestRunUseReadOnlyRootWarnsOnStderr(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restore := restoreFileSeams()
+	defer restore()
+	probeDir = func(dir string) error {
+		return &os.PathError{Op: "open", Path: dir, Err: os.ErrPermission}
+	}
+	stdout.Reset()
+	if err := Run([]string{"use", "codex", "work", "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: data root") || !strings.Contains(stdout.String(), "Using") {
+		t.Fatalf("unexpected output stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunLink(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	external := filepath.Join(t.TempDir(), "legacy.json")
+	writeFile(t, external, []byte(`{"anthropic":{"access":"a"}}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"link", "pi", "legacy", "--snapshot", external, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if !strings.Contains(out.String(), "Linked pi legacy") {
+		t.Fatalf("unexpected link output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), external+" (linked)") {
+		t.Fatalf("expected linked marker in list, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"delete", "pi", "legacy", "--yes", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if !strings.Contains(out.String(), "- snapshot file: kept (linked)") {
+		t.Fatalf("unexpected delete output: %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"link"},
+		{"link", "bad", "x", "--snapshot", external},
+		{"link", "pi", "--snapshot", external, "--root", root},
+		{"link", "pi", "bad/label", "--snapshot", external, "--root", root},
+		{"link", "pi", "x", "--root", root},
+		{"link", "pi", "x", "--nope"},
+	} {
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Run %v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunListPlanFilter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+
+	var out bytes.Buffer
+	for label, plan := range map[string]string{"team": "chatgpt_team", "edu": "edu", "unknown": ""} {
+		src := filepath.Join(root, label+".json")
+		writeFile(t, src, makeCodexAuthJSONWithIdentity(t, exp, "acct_"+label, label+"@example.com", plan))
+		if err := Run([]string{"save", "codex", label, "--source", src, "--root", root}, nil, &out, &out); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--plan", "Team", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --plan: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "codex\tteam\t") {
+		t.Fatalf("expected only the team profile, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--plan", "chatgpt_edu", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --plan edu: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "codex\tedu\t") || strings.Count(out.String(), "\n") != 1 {
+		t.Fatalf("expected only the edu profile, got %q", out.String())
+	}
+
+	items := []ListItem{{Label: "a"}, {Label: "b", AuthInsight: AuthInsight{AccountPlan: "Pro"}}}
+	if got := filterItemsByPlan(items, ""); len(got) != 2 {
+		t.Fatalf("expected empty plan query to keep all items, got %v", got)
+	}
+	if got := filterItemsByPlan(items, "pro"); len(got) != 1 || got[0].Label != "b" {
+		t.Fatalf("expected unknown plan excluded, got %v", got)
+	}
+}
+
+func TestRunInspect(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_1", "a@example.com", "pro"))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--note", "n", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"inspect", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	for _, want := range []string{"codex work\n", "- account: a@example.com (Pro)\n", "- account id: acct_1\n", "- token: access_token format=jwt", "- note: n\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in inspect output, got %q", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"inspect", "codex", "--label", "work", "--json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("inspect --json: %v", err)
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(out.Bytes(), &obj); err != nil {
+		t.Fatalf("decode inspect json: %v", err)
+	}
+	if obj["label"] != "work" || obj["account_id"] != "acct_1" || obj["tokens"] == nil {
+		t.Fatalf("unexpected inspect json: %v", obj)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want error
+	}{
+		{[]string{"inspect"}, ErrInvalidInput},
+		{[]string{"inspect", "bad", "work"}, ErrInvalidInput},
+		{[]string{"inspect", "codex", "--root", root}, ErrInvalidInput},
+		{[]string{"inspect", "codex", "bad/label", "--root", root}, ErrInvalidInput},
+		{[]string{"inspect", "codex", "work", "--nope"}, ErrInvalidInput},
+		{[]string{"inspect", "codex", "missing", "--root", root}, ErrProfileNotFound},
+	} {
+		if err := Run(tc.args, nil, &out, &out); !errors.Is(err, tc.want) {
+			t.Fatalf("Run %v: expected %v, got %v", tc.args, tc.want, err)
+		}
+	}
+}
+
+func TestRunListToolSet(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	codexSrc := filepath.Join(root, "codex.json")
+	writeFile(t, codexSrc, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	piSrc := filepath.Join(root, "pi.json")
+	writeFile(t, piSrc, []byte(`{"anthropic":{"access":"a"}}`))
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"save", "codex", "work", "--source", codexSrc, "--root", root},
+		{"save", "pi", "home", "--source", piSrc, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	countRows := func(args ...string) int {
+		t.Helper()
+		out.Reset()
+		args = append([]string{"list", "--plain", "--no-headers", "--root", root}, args...)
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+		return strings.Count(out.String(), "\n")
+	}
+	if got := countRows("--tool", "pi"); got != 1 || !strings.HasPrefix(out.String(), "pi\thome") {
+		t.Fatalf("expected only pi row, got %q", out.String())
+	}
+	if got := countRows("--tool", "codex", "--tool", "pi"); got != 2 {
+		t.Fatalf("expected both rows for repeated --tool, got %q", out.String())
+	}
+	if got := countRows("--tools", "codex,pi,codex"); got != 2 {
+		t.Fatalf("expected both rows for --tools list, got %q", out.String())
+	}
+
+	err := Run([]string{"list", "codex", "--tool", "pi", "--root", root}, nil, &out, &out)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "conflicting tool filters") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if err := Run([]string{"list", "--tool", "claude", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool error, got %v", err)
+	}
+}
+
+func TestRunDefault(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	err := Run([]string{"use", "codex", "--root", root}, nil, &out, &out)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "--label is required") {
+		t.Fatalf("expected label required without default, got %v", err)
+	}
+
+	if err := Run([]string{"default", "set", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("default set: %v", err)
+	}
+	if !strings.Contains(out.String(), "Default for codex is now work") {
+		t.Fatalf("unexpected default set output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"save", "codex", "--source", src, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save with default: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"use", "codex", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use with default: %v", err)
+	}
+	if !strings.Contains(out.String(), "for work") {
+		t.Fatalf("unexpected use output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"default", "show", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("default show: %v", err)
+	}
+	if out.String() != "codex\twork\npi\t-\n" {
+		t.Fatalf("unexpected default show output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"default", "clear", "codex", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("default clear: %v", err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want error
+	}{
+		{[]string{"default", "clear", "codex", "--root", root}, ErrProfileNotFound},
+		{[]string{"default", "set", "codex", "--root", root}, ErrInvalidInput},
+		{[]string{"default", "set", "codex", "bad/label", "--root", root}, ErrInvalidInput},
+		{[]string{"default", "set", "nope", "work", "--root", root}, ErrInvalidInput},
+		{[]string{"default", "bogus", "--root", root}, ErrInvalidInput},
+		{[]string{"default", "show", "--nope"}, ErrInvalidInput},
+		{[]string{"use", "codex", "--root", root}, ErrInvalidInput},
+	} {
+		err := Run(tc.args, nil, &out, &out)
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("Run %v: expected %v, got %v", tc.args, tc.want, err)
+		}
+	}
+}
+
+func TestRunListID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+
+	var out bytes.Buffer
+	for label, raw := range map[string][]byte{
+		"mail":   makeCodexAuthJSONWithIdentity(t, exp, "acct_mail", "person@example.com", "plus"),
+		"idonly": makeCodexAuthJSONWithIdentity(t, exp, "acct_0123456789abcdef", "", ""),
+		"none":   makeCodexAuthJSON(t, exp),
+	} {
+		src := filepath.Join(root, label+".json")
+		writeFile(t, src, raw)
+		if err := Run([]string{"save", "codex", label, "--source", src, "--root", root}, nil, &out, &out); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "codex", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if strings.Contains(out.String(), "id=") {
+		t.Fatalf("expected no id without --id, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "codex", "--id", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --id: %v", err)
+	}
+	for _, want := range []string{"id=person@example.com\n", "id=acct_0123456…\n", "id=-\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in list --id output, got %q", want, out.String())
+		}
+	}
+}
+
+func TestRunGC(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"gc", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if out.String() != "No orphaned snapshot files found.\n" {
+		t.Fatalf("unexpected clean gc output: %q", out.String())
+	}
+
+	orphan := filepath.Join(root, "snapshots", "codex", "old.json")
+	writeFile(t, orphan, []byte(`{}`))
+	out.Reset()
+	if err := Run([]string{"gc", "--dry-run", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("gc --dry-run: %v", err)
+	}
+	if !strings.Contains(out.String(), "Would remove 1 orphaned snapshot file(s):\n- "+orphan) {
+		t.Fatalf("unexpected dry run output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"gc", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if !strings.Contains(out.String(), "Removed 1 orphaned snapshot file(s):") {
+		t.Fatalf("unexpected gc output: %q", out.String())
+	}
+
+	if err := os.Remove(filepath.Join(root, "snapshots", "codex", "work.json")); err != nil {
+		t.Fatalf("remove snapshot: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"gc", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if !strings.Contains(out.String(), "State entries with a missing snapshot file (1):\n- codex work: ") {
+		t.Fatalf("expected missing snapshot report, got %q", out.String())
+	}
+
+	if err := Run([]string{"gc", "extra"}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+	if err := Run([]string{"gc", "--bad"}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+}
+
+func TestCompileLabelPattern(t *testing.T) {
+	re, err := compileLabelPattern("[a-zA-Z0-9@._-]+")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if !re.MatchString("me@work") || re.MatchString("x me@work") {
+		t.Fatalf("expected the pattern to be anchored to whole labels")
+	}
+	for _, expr := range []string{
+		"[a-zA-Z0-9@./_-]+",
+		`[a-z\\]+`,
+		".*",
+		"[a-z]+(/[a-z]+)?",
+		"[a-z",
+		" ",
+	} {
+		if _, err := compileLabelPattern(expr); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%q: expected invalid input, got %v", expr, err)
+		}
+	}
+}
+
+func TestRunCustomLabelPattern(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	if err := Run([]string{"save", "codex", "me@work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected the default pattern to reject @, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"label_pattern": "[a-z0-9@._-]+"}`))
+	if err := Run([]string{"save", "codex", "me@work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save with custom pattern: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "codex", "me@work.json")); err != nil {
+		t.Fatalf("expected snapshot: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Run([]string{"use", "codex", "me@work", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("use with custom pattern: %v", err)
+	}
+	err := Run([]string{"save", "codex", "Work", "--source", source, "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "must match [a-z0-9@._-]+") {
+		t.Fatalf("expected the custom pattern in the error, got %v", err)
+	}
+	if err := Run([]string{"delete", "codex", "..", "--yes", "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected .. to be refused, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"label_pattern": "[a-z0-9@./_-]+"}`))
+	err = Run([]string{"list", "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "path separators") {
+		t.Fatalf("expected a traversal-capable pattern to be rejected, got %v", err)
+	}
+
+	// The pattern belongs to its data root only.
+	if err := Run([]string{"save", "codex", "me@work", "--source", source, "--root", t.TempDir()}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected another root to keep the default pattern, got %v", err)
+	}
+}
+
+func TestRunConcurrentInvocations(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	custom := t.TempDir()
+	writeFile(t, filepath.Join(custom, "config.json"), []byte(`{"label_pattern": "[a-z0-9@._-]+"}`))
+	plain := t.TempDir()
+	source := filepath.Join(t.TempDir(), "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	logPath := filepath.Join(t.TempDir(), "ops.log")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := Run([]string{"--timeout", "5s", "--log-file", logPath, "save", "codex", "me@work", "--source", source, "--root", custom}, nil, io.Discard, io.Discard); err != nil {
+				errs[0] = err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := Run([]string{"save", "codex", "me@work", "--source", source, "--root", plain}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+				errs[1] = fmt.Errorf("expected the default pattern, got %v", err)
+			}
+		}()
+		wg.Wait()
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries := readOperationLog(t, logPath); len(entries) != 20 {
+		t.Fatalf("expected one log line per logged save, got %d", len(entries))
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	for _, label := range []string{"work", "home"} {
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"doctor", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("doctor: %v", err)
+	}
+	if out.String() != "No problems found.\n" {
+		t.Fatalf("unexpected clean doctor output: %q", out.String())
+	}
+
+	writeFile(t, filepath.Join(root, "snapshots", "codex", "work.json"), makeCodexAuthJSON(t, time.Now().Add(3*time.Hour)))
+	orphan := filepath.Join(root, "snapshots", "codex", "old.json")
+	writeFile(t, orphan, []byte(`{}`))
+	if err := os.Remove(filepath.Join(root, "snapshots", "codex", "home.json")); err != nil {
+		t.Fatalf("remove snapshot: %v", err)
+	}
+
+	out.Reset()
+	err := Run([]string{"doctor", "--root", root}, nil, &out, &out)
+	var exitErr *ExitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected exit code 1 with unfixed problems, got %v", err)
+	}
+	for _, want := range []string{
+		"SHA-256 mismatches (1); repair with --fix sha:\n- codex work: recorded ",
+		"Orphaned snapshot files (1); repair with --fix orphans:\n- " + orphan,
+		"State entries with a missing snapshot file (1); repair with --fix missing:\n- codex home: ",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in %q", want, out.String())
+		}
+	}
+
+	if err := Run([]string{"doctor", "--fix", "missing", "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --fix missing without a terminal to need --yes, got %v", err)
+	}
+
+	out.Reset()
+	err = Run([]string{"doctor", "--fix", "sha", "--root", root}, nil, &out, &out)
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected exit code 1 while orphans and missing remain, got %v", err)
+	}
+	if !strings.Contains(out.String(), "SHA-256 mismatches (1), fixed:") {
+		t.Fatalf("expected sha fix report, got %q", out.String())
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("--fix sha should leave the orphan alone: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"doctor", "--fix", "orphans,missing", "--yes", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("doctor --fix orphans,missing: %v", err)
+	}
+	if !strings.Contains(out.String(), "Orphaned snapshot files (1), fixed:") || !strings.Contains(out.String(), "State entries with a missing snapshot file (1), fixed:") {
+		t.Fatalf("unexpected fix output: %q", out.String())
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("expected orphan removed, got %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"doctor", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("doctor after fixes: %v", err)
+	}
+	if out.String() != "No problems found.\n" {
+		t.Fatalf("expected a clean report after fixes, got %q", out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"list", "--plain", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if strings.Contains(out.String(), "home") || !strings.Contains(out.String(), "work") {
+		t.Fatalf("expected only work to remain, got %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"doctor", "--fix", "bogus"},
+		{"doctor", "--fix", "sha", "--yes"},
+		{"doctor", "extra"},
+	} {
+		if err := Run(append(args, "--root", root), nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunDeletePattern(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"save", "codex", "test-a", "--source", source, "--root", root},
+		{"save", "codex", "test-b", "--source", source, "--root", root},
+		{"save", "codex", "work", "--source", source, "--root", root},
+		{"save", "pi", "test-c", "--source", source, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("save %v: %v", args, err)
+		}
+	}
+
+	origIsTerminal := stdinIsTerminal
+	t.Cleanup(func() { stdinIsTerminal = origIsTerminal })
+	stdinIsTerminal = func(io.Reader) bool { return true }
+
+	out.Reset()
+	if err := Run([]string{"delete", "codex", "test-*", "--root", root}, strings.NewReader("n\n"), &out, &out); err != nil {
+		t.Fatalf("delete declined: %v", err)
+	}
+	if !strings.Contains(out.String(), "Delete 2 codex label(s): test-a, test-b? [y/N] Aborted; nothing deleted.") {
+		t.Fatalf("unexpected declined output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"delete", "codex", "test-*", "--root", root}, strings.NewReader("yes\n"), &out, &out); err != nil {
+		t.Fatalf("delete confirmed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Deleted codex label=test-a") || !strings.Contains(out.String(), "Deleted codex label=test-b") {
+		t.Fatalf("unexpected delete output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), "codex\twork\t") || !strings.Contains(out.String(), "pi\ttest-c\t") || strings.Contains(out.String(), "codex\ttest-") {
+		t.Fatalf("expected only codex test labels removed, got %q", out.String())
+	}
+
+	if err := Run([]string{"delete", "pi", "test-?", "--yes", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("delete --yes: %v", err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want error
+	}{
+		{[]string{"delete", "codex", "nomatch-*", "--yes", "--root", root}, ErrProfileNotFound},
+		{[]string{"delete", "codex", "../*", "--yes", "--root", root}, ErrInvalidInput},
+		{[]string{"delete", "codex", "[", "--yes", "--root", root}, ErrInvalidInput},
+	} {
+		if err := Run(tc.args, nil, &out, &out); !errors.Is(err, tc.want) {
+			t.Fatalf("Run %v: expected %v, got %v", tc.args, tc.want, err)
+		}
+	}
+}
+
+func TestRunVersionJSON(t *testing.T) {
+	oldVersion, oldCommit, oldDate := Version, Commit, BuildDate
+	Version, Commit, BuildDate = "1.2.3", "abc1234", "2026-01-02T03:04:05Z"
+	defer func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldDate }()
+
+	var out bytes.Buffer
+	if err := Run([]string{"version", "--json"}, nil, &out, &out); err != nil {
+		t.Fatalf("version --json: %v", err)
+	}
+	if out.String() != `{"version":"1.2.3","commit":"abc1234","built":"2026-01-02T03:04:05Z"}`+"\n" {
+		t.Fatalf("unexpected version --json output: %q", out.String())
+	}
+
+	for _, args := range [][]string{{"version", "extra"}, {"version", "--bad"}} {
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Run %v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestListItemJSONProviders(t *testing.T) {
+	item := ListItem{Tool: ToolPi, Label: "home", AuthInsight: AuthInsight{
+		Providers: []ProviderInsight{{Name: "codex", Status: "expired", ExpiresAt: "2026-01-01T00:00:00Z"}},
+	}}
+	raw, err := json.Marshal(newListItemJSON(item))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(raw), `"providers":[{"name":"codex","status":"expired","expires_at":"2026-01-01T00:00:00Z"}]`) {
+		t.Fatalf("unexpected providers json: %s", raw)
+	}
+
+	raw, err = json.Marshal(newListItemJSON(ListItem{Tool: ToolCodex, Label: "work"}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if strings.Contains(string(raw), "providers") {
+		t.Fatalf("expected providers omitted for codex, got %s", raw)
+	}
+}
+
+func TestRunDeleteConfirmation(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	origIsTerminal := stdinIsTerminal
+	t.Cleanup(func() { stdinIsTerminal = origIsTerminal })
+
+	stdinIsTerminal = func(io.Reader) bool { return false }
+	err := Run([]string{"delete", "codex", "work", "--root", root}, strings.NewReader("y\n"), &out, &out)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "pass --yes") {
+		t.Fatalf("expected non-terminal stdin to require --yes, got %v", err)
+	}
+
+	// /dev/null, the usual stdin of cron and CI jobs, is not a terminal.
+	stdinIsTerminal = isTerminal
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	if isTerminal(devNull) {
+		t.Fatalf("expected %s not to count as a terminal", os.DevNull)
+	}
+	out.Reset()
+	err = Run([]string{"delete", "codex", "work", "--root", root}, devNull, &out, &out)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "pass --yes") || strings.Contains(out.String(), "[y/N]") {
+		t.Fatalf("expected %s stdin to require --yes without prompting, got %v (%q)", os.DevNull, err, out.String())
+	}
+
+	stdinIsTerminal = func(io.Reader) bool { return true }
+	if err := Run([]string{"delete", "codex", "typo", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected missing label to fail before prompting, got %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"delete", "codex", "work", "--root", root}, strings.NewReader("\n"), &out, &out); err != nil {
+		t.Fatalf("delete declined: %v", err)
+	}
+	if out.String() != "Delete codex/work? [y/N] Aborted; nothing deleted.\n" {
+		t.Fatalf("unexpected declined output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"delete", "codex", "work", "--root", root}, strings.NewReader("Y\n"), &out, &out); err != nil {
+		t.Fatalf("delete confirmed: %v", err)
+	}
+	if !strings.Contains(out.String(), "Deleted codex label=work") {
+		t.Fatalf("unexpected confirmed output: %q", out.String())
+	}
+
+	if isTerminal(strings.NewReader("")) {
+		t.Fatalf("expected non-file reader to not be a terminal")
+	}
+}
+
+func TestRunSaveFromActive(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+
+	var out bytes.Buffer
+	err := Run([]string{"save", "codex", "fresh", "--from-active", "--root", root}, nil, &out, &out)
+	if !errors.Is(err, ErrIO) || !strings.Contains(err.Error(), "no active codex runtime auth file") {
+		t.Fatalf("expected missing runtime error, got %v", err)
+	}
+
+	runtime := filepath.Join(home, ".codex", "auth.json")
+	writeFile(t, runtime, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	out.Reset()
+	if err := Run([]string{"save", "codex", "fresh", "--from-active", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save --from-active: %v", err)
+	}
+	if !strings.Contains(out.String(), "- source: "+runtime+" (active runtime)") {
+		t.Fatalf("expected runtime path in output, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"save", "codex", "fresh", "--from-active", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save --from-active --verbose: %v", err)
+	}
+	if strings.Count(out.String(), "- source: ") != 1 {
+		t.Fatalf("expected one source line, got %q", out.String())
+	}
+
+	err = Run([]string{"save", "codex", "fresh", "--from-active", "--source", runtime, "--root", root}, nil, &out, &out)
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --from-active/--source conflict, got %v", err)
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.SaveWithOptions(ToolCodex, "x", SaveOptions{FromActive: true, SourceOverride: runtime}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected manager to reject FromActive with a source override, got %v", err)
+	}
+}
+
+func TestRunWhoami(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	writeFile(t, filepath.Join(home, ".codex", "auth.json"), makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-1", "one@example.com", "plus"))
+	writeFile(t, filepath.Join(home, ".pi", "agent", "auth.json"), []byte(`[]`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"whoami", "--json", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("whoami --json: %v", err)
+	}
+	var entries map[string]whoamiJSON
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("decode %q: %v", out.String(), err)
+	}
+	codex := entries["codex"]
+	if codex.Email != "one@example.com" || codex.Plan != "Plus" || codex.Status != "valid" || codex.ExpiresAt == "" || codex.Error != "" {
+		t.Fatalf("unexpected codex entry: %+v", codex)
+	}
+	if pi := entries["pi"]; pi.Status != "invalid" || pi.Error == "" || pi.Email != "" {
+		t.Fatalf("unexpected pi entry: %+v", pi)
+	}
+
+	if err := os.Remove(filepath.Join(home, ".pi", "agent", "auth.json")); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Run([]string{"whoami", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("whoami: %v", err)
+	}
+	if !strings.Contains(out.String(), "codex: one@example.com (Plus)\n- runtime: ") || !strings.Contains(out.String(), "pi: missing\n") {
+		t.Fatalf("unexpected whoami output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"whoami", "pi", "--json", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("whoami pi --json: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), `{"pi":{"status":"missing",`) {
+		t.Fatalf("expected only the pi entry, got %q", out.String())
+	}
+
+	if err := Run([]string{"whoami", "bogus"}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool error, got %v", err)
+	}
+}
+
+func TestRunActiveRuntimeDir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "profiles")
+	work := makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-work", "work@example.com", "plus")
+	home := makeCodexAuthJSONWithIdentity(t, time.Now().Add(-time.Hour), "acct-home", "home@example.com", "plus")
+	other := makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-other", "other@example.com", "plus")
+	writeFile(t, filepath.Join(dir, "a.json"), work)
+	writeFile(t, filepath.Join(dir, "b.json"), home)
+	writeFile(t, filepath.Join(dir, "c.json"), other)
+	writeFile(t, filepath.Join(dir, "notes.txt"), []byte("ignored"))
+	for label, raw := range map[string][]byte{"work": work, "home": home} {
+		source := filepath.Join(t.TempDir(), "auth.json")
+		writeFile(t, source, raw)
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"active", "codex", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("active without runtime_dir: %v", err)
+	}
+	if !strings.Contains(out.String(), "codex	-	runtime auth file missing") {
+		t.Fatalf("expected single-file behaviour without runtime_dir, got %q", out.String())
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"paths":{"codex":{"runtime_dir":"`+dir+`"}}}`))
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("active: %v", err)
+	}
+	for _, want := range []string{
+		"codex\twork\tmatch\t" + filepath.Join(dir, "a.json"),
+		"codex\thome\tmatch\t" + filepath.Join(dir, "b.json"),
+		"codex\t-\tno matching saved profile\t" + filepath.Join(dir, "c.json"),
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in %q", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "notes.txt") {
+		t.Fatalf("non-JSON files should be skipped, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--json", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("active --json: %v", err)
+	}
+	var rows []activeItemJSON
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(rows) != 3 || rows[1].RuntimeFile != "b.json" || rows[1].ActiveLabel != "home" || !rows[1].Expired {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--json", "--compact", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("active --compact: %v", err)
+	}
+	var compact map[string]activeMapEntryJSON
+	if err := json.Unmarshal(out.Bytes(), &compact); err != nil {
+		t.Fatalf("decode compact: %v", err)
+	}
+	if compact["codex/a.json"].Label != "work" || compact["codex/c.json"].Status != "no matching saved profile" {
+		t.Fatalf("unexpected compact map: %+v", compact)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	err := Run([]string{"active", "codex", "--exit-code", "--root", root}, nil, io.Discard, io.Discard)
+	var exitErr *ExitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != activeExitNoRuntime {
+		t.Fatalf("expected no-runtime exit code for a missing directory, got %v", err)
+	}
+}
+
+func TestRunActiveOnlyMismatch(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	writeFile(t, filepath.Join(home, ".codex", "auth.json"), makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"active", "--only-mismatch", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --only-mismatch: %v", err)
+	}
+	if strings.Contains(out.String(), "codex\t") || !strings.Contains(out.String(), "pi\t-\tno saved profiles") {
+		t.Fatalf("expected only the pi row, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--only-mismatch", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active codex --only-mismatch: %v", err)
+	}
+	if out.String() != "All runtime auth files match a saved profile.\n" {
+		t.Fatalf("expected all-match message, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--only-mismatch", "--json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --only-mismatch --json: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Fatalf("expected empty JSON array, got %q", out.String())
+	}
+
+	writeFile(t, filepath.Join(home, ".codex", "auth.json"), []byte(`[]`))
+	out.Reset()
+	err := Run([]string{"active", "codex", "--only-mismatch", "--exit-code", "--verbose", "--root", root}, nil, &out, &out)
+	var exitErr *ExitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != activeExitNoMatch {
+		t.Fatalf("expected no-match exit code, got %v", err)
+	}
+	if !strings.Contains(out.String(), "codex\t-\truntime auth JSON invalid") {
+		t.Fatalf("expected invalid runtime row, got %q", out.String())
+	}
+}
+
+func TestRunActiveJSONExpiry(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	runtime := filepath.Join(home, ".codex", "auth.json")
+	exp := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	writeFile(t, runtime, makeCodexAuthJSON(t, exp))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "--json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --json: %v", err)
+	}
+	var rows []activeItemJSON
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatalf("decode %q: %v", out.String(), err)
+	}
+	byTool := map[string]activeItemJSON{}
+	for _, row := range rows {
+		byTool[row.Tool] = row
+	}
+	if got := byTool["codex"]; got.ActiveLabel != "work" || !got.Expired || got.TokenExpiry != exp.Format(time.RFC3339) {
+		t.Fatalf("unexpected codex row: %+v", got)
+	}
+	if got := byTool["pi"]; got.Expired || got.TokenExpiry != "" {
+		t.Fatalf("expected no expiry without a pi runtime file, got %+v", got)
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active: %v", err)
+	}
+	if strings.Contains(out.String(), "expires=") {
+		t.Fatalf("expiry should only show with --verbose, got %q", out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"active", "codex", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --verbose: %v", err)
+	}
+	if !strings.Contains(out.String(), "  expires=expired") {
+		t.Fatalf("expected expiry line with --verbose, got %q", out.String())
+	}
+}
+
+func TestRunActiveJSONCompact(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	runtime := filepath.Join(home, ".codex", "auth.json")
+	writeFile(t, runtime, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"active", "--json", "--compact", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --json --compact: %v", err)
+	}
+	var got map[string]map[string]string
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("decode %q: %v", out.String(), err)
+	}
+	if got["codex"]["label"] != "work" || got["codex"]["status"] != "match" {
+		t.Fatalf("unexpected codex entry: %v", got)
+	}
+	if _, ok := got["pi"]; !ok {
+		t.Fatalf("expected pi entry, got %v", got)
+	}
+	if _, ok := got["pi"]["label"]; ok {
+		t.Fatalf("expected empty label omitted, got %v", got["pi"])
+	}
+
+	if err := Run([]string{"active", "--compact", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --compact without --json to fail, got %v", err)
+	}
+}
+
+func TestRunUseWarnsOnModifiedSnapshot(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	writeFile(t, filepath.Join(root, "snapshots", "codex", "work.json"), makeCodexAuthJSON(t, time.Now().Add(3*time.Hour)))
+
+	if err := Run([]string{"use", "codex", "work", "--strict", "--root", root}, nil, &stdout, &stderr); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected --strict failure, got %v", err)
+	}
+	stdout.Reset()
+	if err := Run([]string{"use", "codex", "work", "--print", "--strict", "--root", root}, nil, &stdout, &stderr); !errors.Is(err, ErrIO) || !strings.Contains(err.Error(), "modified outside ags") {
+		t.Fatalf("expected --print --strict failure, got %v", err)
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected nothing printed under --strict, got %q", stdout.String())
+	}
+	if err := Run([]string{"use", "codex", "work", "--print", "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use --print: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: snapshot for work was modified outside ags") {
+		t.Fatalf("expected --print to warn about the modification, got %q", stderr.String())
+	}
+	stderr.Reset()
+	if err := Run([]string{"use", "codex", "work", "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: snapshot for work was modified outside ags") {
+		t.Fatalf("expected modification warning, got %q", stderr.String())
+	}
+}
+
+func TestSortListItems(t *testing.T) {
+	items := func() []ListItem {
+		return []ListItem{
+			{Tool: ToolCodex, Label: "b", SavedAt: "2026-01-02T00:00:00Z", AuthInsight: AuthInsight{ExpiresAt: "2026-03-01T00:00:00Z"}},
+			{Tool: ToolCodex, Label: "c", SavedAt: "2026-01-03T00:00:00Z", LastUsedAt: "2026-02-01T00:00:00Z"},
+			{Tool: ToolPi, Label: "a", SavedAt: "2026-01-01T00:00:00Z", LastUsedAt: "2026-02-02T00:00:00Z", AuthInsight: AuthInsight{ExpiresAt: "2026-02-01T00:00:00Z"}},
+		}
+	}
+	labels := func(items []ListItem) string {
+		out := make([]string, 0, len(items))
+		for _, item := range items {
+			out = append(out, item.Label)
+		}
+		return strings.Join(out, ",")
+	}
+
+	for _, tc := range []struct {
+		key     string
+		reverse bool
+		want    string
+	}{
+		{"expiry", false, "a,b,c"},
+		{"expiry", true, "b,a,c"},
+		{"saved", false, "c,b,a"},
+		{"saved", true, "a,b,c"},
+		{"used", false, "a,c,b"},
+		{"used", true, "c,a,b"},
+		{"label", false, "a,b,c"},
+		{"label", true, "c,b,a"},
+	} {
+		got := items()
+		sortListItems(got, tc.key, tc.reverse)
+		if labels(got) != tc.want {
+			t.Fatalf("sort %s reverse=%v: expected %s, got %s", tc.key, tc.reverse, tc.want, labels(got))
+		}
+	}
+}
+
+func TestRunListSort(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+
+	var out bytes.Buffer
+	for label, hours := range map[string]int{"late": 5, "soon": 1} {
+		src := filepath.Join(root, label+".json")
+		writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(time.Duration(hours)*time.Hour)))
+		if err := Run([]string{"save", "codex", label, "--source", src, "--root", root}, nil, &out, &out); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--sort", "expiry", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --sort: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Saved profiles by expiry:\n  soon ") || !strings.Contains(out.String(), "tool=codex") {
+		t.Fatalf("unexpected sorted output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--sort", "EXPIRY", "--reverse", "--plain", "--no-headers", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --sort --reverse --plain: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "codex\tlate\t") {
+		t.Fatalf("expected latest expiry first, got %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"list", "--sort", "size", "--root", root},
+		{"list", "--reverse", "--root", root},
+		{"list", "--sort", "used", "--by-account", "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Run %v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestSplitTimeoutFlag(t *testing.T) {
+	rest, timeout, err := splitTimeoutFlag([]string{"--timeout", "5s", "list", "--timeout=2s", "--root", "x"})
+	if err != nil {
+		t.Fatalf("splitTimeoutFlag: %v", err)
+	}
+	if timeout != 2*time.Second || strings.Join(rest, " ") != "list --root x" {
+		t.Fatalf("unexpected split: %v %v", rest, timeout)
+	}
+
+	for _, args := range [][]string{{"list", "--timeout"}, {"list", "--timeout", "soon"}, {"list", "--timeout=-1s"}} {
+		if _, _, err := splitTimeoutFlag(args); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("splitTimeoutFlag %v: expected invalid input, got %v", args, err)
+		}
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	var out bytes.Buffer
+	if err := Run([]string{"--timeout", "5s", "list", "--root", t.TempDir()}, nil, &out, &out); err != nil {
+		t.Fatalf("list with --timeout: %v", err)
+	}
+	m, err := newCLIManager(cliOptions{ioTimeout: 5 * time.Second}, t.TempDir())
+	if err != nil {
+		t.Fatalf("newCLIManager: %v", err)
+	}
+	if m.ioTimeout != 5*time.Second {
+		t.Fatalf("expected --timeout on the manager, got %v", m.ioTimeout)
+	}
+}
+
+func TestRunMove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"move", "pi", "work", "codex", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Moved pi work to codex work\n- snapshot: "+filepath.Join(root, "snapshots", "codex", "work.json")) {
+		t.Fatalf("unexpected move output: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "- status: valid") {
+		t.Fatalf("expected codex insight after move: %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"move", "codex", "work"},
+		{"move", "codex", "work", "nope"},
+		{"move", "codex", "bad/label", "pi"},
+	} {
+		if err := Run(append(args, "--root", root), nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunUseRevert(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	workRaw := makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_work", "work@example.com", "plus")
+	personalRaw := makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_personal", "me@example.com", "plus")
+	for label, raw := range map[string][]byte{"work": workRaw, "personal": personalRaw} {
+		source := filepath.Join(t.TempDir(), label+".json")
+		writeFile(t, source, raw)
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"use", "codex", "--revert", "--target", target, "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found without a previous label, got %v", err)
+	}
+	for _, label := range []string{"work", "work", "personal"} {
+		if err := Run([]string{"use", "codex", label, "--target", target, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("use %s: %v", label, err)
+		}
+	}
+	if err := Run([]string{"use", "codex", "work", "--revert", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for --revert with a label, got %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"use", "codex", "--revert", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("revert: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Using work@example.com (Plus) for work\n") {
+		t.Fatalf("unexpected revert output: %q", out.String())
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, workRaw) {
+		t.Fatalf("expected work snapshot applied by revert")
+	}
+
+	// A second revert toggles back.
+	out.Reset()
+	if err := Run([]string{"use", "codex", "--revert", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("second revert: %v", err)
+	}
+	if !strings.Contains(out.String(), "for personal\n") {
+		t.Fatalf("expected second revert to return to personal: %q", out.String())
+	}
+
+	if err := Run([]string{"delete", "codex", "work", "--yes", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := Run([]string{"use", "codex", "--revert", "--target", target, "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected deleted previous label to be forgotten, got %v", err)
+	}
+}
+
+func TestRunProviders(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "pi.json")
+	future := strconv.FormatInt(time.Now().Add(3*time.Hour).UnixMilli(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10)
+	writeFile(t, source, []byte(`{"openai-codex":{"type":"oauth","access":"a","expires":`+future+`},"anthropic":{"type":"oauth","access":"b","expires":`+past+`},"custom":{"type":"api_key","key":"k"}}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"providers", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("providers: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "Providers in pi work:" {
+		t.Fatalf("unexpected providers output: %q", out.String())
+	}
+	if !strings.HasPrefix(lines[1], "- anthropic status=expired ") || !strings.HasSuffix(lines[1], " selectors=anthropic") {
+		t.Fatalf("unexpected anthropic line: %q", lines[1])
+	}
+	if lines[2] != "- custom status=unknown expires=- selectors=-" {
+		t.Fatalf("unexpected custom line: %q", lines[2])
+	}
+	if !strings.HasPrefix(lines[3], "- openai-codex status=valid ") || !strings.HasSuffix(lines[3], " selectors=codex") {
+		t.Fatalf("unexpected codex line: %q", lines[3])
+	}
+
+	out.Reset()
+	if err := Run([]string{"providers", "work", "--json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("providers --json: %v", err)
+	}
+	var rows []piProviderJSON
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatalf("parse json: %v (%q)", err, out.String())
+	}
+	if len(rows) != 3 || rows[1].Key != "custom" || rows[1].Selectors == nil || len(rows[1].Selectors) != 0 || rows[2].Selectors[0] != "codex" {
+		t.Fatalf("unexpected json rows: %+v", rows)
+	}
+
+	if err := Run([]string{"providers", "missing", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := Run([]string{"providers", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
+
+func TestRunConfigPaths(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"expiring_soon": "1h"}`))
+	runtime := filepath.Join(t.TempDir(), "project", "auth.json")
+	writeFile(t, runtime, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	var out bytes.Buffer
+	if err := Run([]string{"config", "set-path", "codex", "runtime", runtime, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("set-path: %v", err)
+	}
+	if out.String() != "codex runtime path is now "+runtime+"\n" {
+		t.Fatalf("unexpected set-path output: %q", out.String())
+	}
+	cfgRaw, err := os.ReadFile(filepath.Join(root, "config.json"))
+	if err != nil || !strings.Contains(string(cfgRaw), `"expiring_soon": "1h"`) {
+		t.Fatalf("expected other config keys kept, got %s (err=%v)", cfgRaw, err)
+	}
+
+	// save and use now default to the relocated runtime file.
+	if err := Run([]string{"save", "codex", "work", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save from override: %v", err)
+	}
+	if err := os.Remove(runtime); err != nil {
+		t.Fatalf("remove runtime: %v", err)
+	}
+	if err := Run([]string{"use", "codex", "work", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("use to override: %v", err)
+	}
+	if _, err := os.Stat(runtime); err != nil {
+		t.Fatalf("expected use to write the overridden runtime path: %v", err)
+	}
+
+	source := filepath.Join(t.TempDir(), "pi-source.json")
+	writeFile(t, source, []byte(`{"anthropic":{"type":"oauth","access":"a","expires":9999999999999}}`))
+	if err := Run([]string{"config", "set-path", "pi", "source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("set-path source: %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"save", "pi", "work", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save from source override: %v", err)
+	}
+	if !strings.Contains(out.String(), "- source: "+source+"\n") {
+		t.Fatalf("expected source override used: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"config", "unset-path", "pi", "source", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("unset-path: %v", err)
+	}
+	if out.String() != "Cleared pi source path override\n" {
+		t.Fatalf("unexpected unset-path output: %q", out.String())
+	}
+	if err := Run([]string{"config", "unset-path", "pi", "source", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found for unset override, got %v", err)
+	}
+	if err := Run([]string{"config", "unset-path", "codex", "runtime", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("unset-path runtime: %v", err)
+	}
+	cfgRaw, _ = os.ReadFile(filepath.Join(root, "config.json"))
+	if strings.Contains(string(cfgRaw), "paths") {
+		t.Fatalf("expected empty paths removed from config: %s", cfgRaw)
+	}
+
+	for _, args := range [][]string{
+		{"config", "set-path", "codex", "elsewhere", runtime},
+		{"config", "set-path", "nope", "runtime", runtime},
+		{"config", "set-path", "codex", "runtime"},
+		{"config", "bogus"},
+	} {
+		if err := Run(append(args, "--root", root), nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"paths": {"vim": {"runtime": "/x"}}}`))
+	if err := Run([]string{"list", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected unknown tool in config paths rejected, got %v", err)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	target := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	steps := [][]string{
+		{"save", "codex", "work", "--source", source},
+		{"save", "codex", "work", "--source", source},
+		{"use", "codex", "work", "--target", target},
+	}
+	for _, args := range steps {
+		if err := Run(append(args, "--root", root), nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(3*time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("changed save: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"history", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || lines[0] != "codex work" {
+		t.Fatalf("unexpected history output: %q", out.String())
+	}
+	for i, action := range []string{"changed", "used", "resaved", "created"} {
+		if !strings.HasPrefix(lines[i+1], fmt.Sprintf("- %-8s ", action)) {
+			t.Fatalf("line %d: expected %s, got %q", i+1, action, lines[i+1])
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"history", "codex", "work", "--json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("history --json: %v", err)
+	}
+	var events []historyEventJSON
+	if err := json.Unmarshal(out.Bytes(), &events); err != nil {
+		t.Fatalf("parse history json: %v", err)
+	}
+	if len(events) != 4 || events[0].Action != "changed" || len(events[0].SHA256) != 64 {
+		t.Fatalf("unexpected history json: %+v", events)
+	}
+
+	if err := Run([]string{"history", "codex", "missing", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := Run([]string{"history", "codex", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
+
+func TestRunUseRuntimeCheck(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	good := filepath.Join(root, "good.json")
+	writeFile(t, good, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	opaque := filepath.Join(root, "opaque.json")
+	writeFile(t, opaque, []byte(`{"tokens":{"access_token":"opaque"}}`))
+	for label, source := range map[string]string{"good": good, "opaque": opaque} {
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"use", "codex", "good", "--runtime-check", "--target", target, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use good: %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no runtime warnings, got %q", stderr.String())
+	}
+
+	if err := Run([]string{"use", "codex", "opaque", "--runtime-check", "--target", target, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use opaque: %v", err)
+	}
+	if stderr.String() != "Warning: runtime check: access_token is missing or not a parseable JWT ("+target+")\n" {
+		t.Fatalf("unexpected runtime check warning: %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if err := Run([]string{"use", "codex", "opaque", "--target", target, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use without check: %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no check without --runtime-check, got %q", stderr.String())
+	}
+}
+
+func TestRunListStale(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	full := filepath.Join(root, "full.json")
+	writeFile(t, full, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_s", "stale@example.com", "plus"))
+	idOnly := filepath.Join(root, "id-only.json")
+	writeFile(t, idOnly, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_s", "", ""))
+	for label, source := range map[string]string{"fresh": full, "cached": idOnly} {
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	old := time.Now().Add(-40 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	item := state.IdentityCache["acct_s"]
+	item.UpdatedAt = old
+	state.IdentityCache["acct_s"] = item
+	if err := m.saveState(state); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"list", "--stale", "30d", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --stale: %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	var cachedLine, freshLine string
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "  cached "):
+			cachedLine = line
+		case strings.HasPrefix(line, "  fresh "):
+			freshLine = line
+		}
+	}
+	if !strings.HasSuffix(cachedLine, " identity=stale") || strings.Contains(freshLine, "identity=stale") {
+		t.Fatalf("expected only the cache-backed profile marked stale: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "\n1 profile(s) show an account identity cached more than 30d ago; re-save them to refresh it.\n") {
+		t.Fatalf("expected stale hint: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--stale", "60d", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --stale 60d: %v", err)
+	}
+	if strings.Contains(out.String(), "stale") {
+		t.Fatalf("expected nothing stale within 60d: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--stale", "30d", "--jsonl", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --stale --jsonl: %v", err)
+	}
+	if !strings.Contains(out.String(), `"identity_cached_at":"`+old+`","stale_identity":true`) {
+		t.Fatalf("expected stale fields in jsonl: %q", out.String())
+	}
+
+	if err := Run([]string{"list", "--stale", "soon", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid --stale, got %v", err)
+	}
+}
+
+func TestRunNoIdentityCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	full := filepath.Join(root, "full.json")
+	writeFile(t, full, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_n", "cached@example.com", "plus"))
+	idOnly := filepath.Join(root, "id-only.json")
+	writeFile(t, idOnly, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_n", "", ""))
+	other := filepath.Join(root, "other.json")
+	writeFile(t, other, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_n", "polluted@example.com", "pro"))
+
+	if err := Run([]string{"save", "codex", "full", "--source", full, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save full: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "bare", "--source", idOnly, "--no-identity-cache", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("save bare: %v", err)
+	}
+	if out.String() != "Saved codex for bare\n" {
+		t.Fatalf("expected no cached identity on save, got %q", out.String())
+	}
+	if err := Run([]string{"save", "codex", "other", "--source", other, "--no-identity-cache", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save other: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--jsonl", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if !strings.Contains(out.String(), `"label":"bare"`) || !strings.Contains(out.String(), `"account_email":"cached@example.com"`) || strings.Count(out.String(), "cached@example.com") != 2 {
+		t.Fatalf("expected the cache untouched by --no-identity-cache saves: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--jsonl", "--no-identity-cache", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --no-identity-cache: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.Contains(line, `"label":"bare"`) && strings.Contains(line, "account_email") {
+			t.Fatalf("expected no cached email with --no-identity-cache: %q", line)
+		}
+	}
+
+	out.Reset()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	if err := Run([]string{"use", "codex", "bare", "--target", target, "--no-identity-cache", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if out.String() != "Using codex for bare\n" {
+		t.Fatalf("expected no cached identity on use, got %q", out.String())
+	}
+}
+
+func TestRunCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	for _, id := range []string{"acct_b", "acct_a"} {
+		source := filepath.Join(root, id+".json")
+		writeFile(t, source, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), id, id+"@example.com", "plus"))
+		if err := Run([]string{"save", "codex", id, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", id, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"cache", "ls", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("cache ls: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "acct_a\tacct_a@example.com\tPlus\t") || !strings.HasPrefix(lines[1], "acct_b\t") {
+		t.Fatalf("unexpected cache ls output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"cache", "clear", "--account", "acct_a", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("cache clear --account: %v", err)
+	}
+	if out.String() != "Removed cached identity for acct_a\n" {
+		t.Fatalf("unexpected clear output: %q", out.String())
+	}
+	if err := Run([]string{"cache", "clear", "--account", "acct_a", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found for missing account, got %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"cache", "clear", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("cache clear: %v", err)
+	}
+	if out.String() != "Cleared the identity cache: 1 account(s) removed\n" {
+		t.Fatalf("unexpected clear-all output: %q", out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"cache", "ls", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("cache ls after clear: %v", err)
+	}
+	if out.String() != "Identity cache is empty.\n" {
+		t.Fatalf("unexpected empty cache output: %q", out.String())
+	}
+
+	for _, args := range [][]string{{"cache", "bogus"}, {"cache", "ls", "extra"}, {"cache", "clear", "--account", " "}} {
+		if err := Run(append(args, "--root", root), nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunTags(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--tag", "prod", "--tag", "eu,prod", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save --tag: %v", err)
+	}
+	if err := Run([]string{"save", "codex", "home", "--source", src, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save home: %v", err)
+	}
+	// Re-saving without --tag keeps the existing tags.
+	if err := Run([]string{"save", "codex", "work", "--source", src, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("re-save: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"list", "--tag", "prod", "--verbose", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --tag: %v", err)
+	}
+	if !strings.Contains(out.String(), "  work ") || strings.Contains(out.String(), "  home ") || !strings.Contains(out.String(), "    tags: eu, prod\n") {
+		t.Fatalf("unexpected list --tag output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"tag", "add", "codex", "home", "prod", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("tag add: %v", err)
+	}
+	if out.String() != "Tagged codex home with prod\n" {
+		t.Fatalf("unexpected tag add output: %q", out.String())
+	}
+	out.Reset()
+	if err := Run([]string{"tag", "rm", "codex", "work", "prod", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("tag rm: %v", err)
+	}
+	if out.String() != "Removed tag prod from codex work\n" {
+		t.Fatalf("unexpected tag rm output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--tag", "prod", "--jsonl", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --tag --jsonl: %v", err)
+	}
+	var item listItemJSON
+	if err := json.Unmarshal(out.Bytes(), &item); err != nil {
+		t.Fatalf("decode jsonl: %v (%q)", err, out.String())
+	}
+	if item.Label != "home" || strings.Join(item.Tags, ",") != "prod" {
+		t.Fatalf("unexpected jsonl item: %+v", item)
+	}
+
+	if err := Run([]string{"tag", "rm", "codex", "work", "prod", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found removing a missing tag, got %v", err)
+	}
+	for _, args := range [][]string{
+		{"tag", "add", "codex", "work", "bad tag"},
+		{"tag", "bogus", "codex", "work", "x"},
+		{"save", "codex", "work", "--source", src, "--tag", "no/slash"},
+	} {
+		if err := Run(append(args, "--root", root), nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunUseIfChanged(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	source := filepath.Join(root, "codex.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"use", "codex", "work", "--if-changed", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if strings.Contains(out.String(), "already active") {
+		t.Fatalf("first use should write the missing runtime file, got %q", out.String())
+	}
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	usedAt := state.Entries[stateKey(ToolCodex, "work")].LastUsedAt
+	events := len(state.Entries[stateKey(ToolCodex, "work")].History)
+
+	out.Reset()
+	if err := Run([]string{"use", "codex", "work", "--if-changed", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("second use: %v", err)
+	}
+	if out.String() != "codex work is already active; nothing written\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	state, err = m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	entry := state.Entries[stateKey(ToolCodex, "work")]
+	if entry.LastUsedAt != usedAt || len(entry.History) != events {
+		t.Fatalf("expected no state update, got %+v", entry)
+	}
+
+	// pi compares the merged result, so runtime-only providers do not count
+	// as a change.
+	piTarget := filepath.Join(t.TempDir(), "pi-auth.json")
+	piSource := filepath.Join(root, "pi.json")
+	writeFile(t, piSource, []byte(`{"openai-codex":{"access":"codex-work"}}`))
+	if err := Run([]string{"save", "pi", "work", "--source", piSource, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save pi: %v", err)
+	}
+	writeFile(t, piTarget, []byte(`{"anthropic":{"access":"anthro"},"openai-codex":{"access":"codex-old"}}`))
+	out.Reset()
+	if err := Run([]string{"use", "pi", "work", "--if-changed", "--target", piTarget, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use pi: %v", err)
+	}
+	if strings.Contains(out.String(), "already active") {
+		t.Fatalf("expected pi write, got %q", out.String())
+	}
+	writeFile(t, piTarget, []byte(`{"openai-codex":{"access":"codex-work"},"anthropic":{"access":"anthro"}}`))
+	out.Reset()
+	if err := Run([]string{"use", "pi", "work", "--if-changed", "--target", piTarget, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use pi again: %v", err)
+	}
+	if out.String() != "pi work is already active; nothing written\n" {
+		t.Fatalf("unexpected pi output: %q", out.String())
+	}
+	if raw, _ := os.ReadFile(piTarget); string(raw) != `{"openai-codex":{"access":"codex-work"},"anthropic":{"access":"anthro"}}` {
+		t.Fatalf("runtime file should be untouched, got %s", raw)
+	}
+	out.Reset()
+	if err := Run([]string{"use", "pi", "work", "--if-changed", "--no-merge", "--target", piTarget, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use pi --no-merge: %v", err)
+	}
+	if strings.Contains(out.String(), "already active") {
+		t.Fatalf("--no-merge would drop anthropic, so it is a change; got %q", out.String())
+	}
+}
+
+func TestRunTouch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "codex.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"list", "--unused-for", "1d", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list before touch: %v", err)
+	}
+	if !strings.Contains(out.String(), "work") {
+		t.Fatalf("expected never-used profile listed, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"touch", "codex", "work", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Marked codex work as used at ") {
+		t.Fatalf("unexpected touch output: %q", out.String())
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	entry := state.Entries[stateKey(ToolCodex, "work")]
+	if entry.LastUsedAt == "" || entry.LastUsedSHA != entry.SHA256 || entry.History[len(entry.History)-1].Action != "touched" {
+		t.Fatalf("unexpected entry after touch: %+v", entry)
+	}
+	if len(state.LastActivatedLabel) != 0 {
+		t.Fatalf("touch should not change the active label, got %v", state.LastActivatedLabel)
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--unused-for", "1d", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list after touch: %v", err)
+	}
+	if out.String() != "No saved profiles found.\n" {
+		t.Fatalf("expected touched profile filtered out, got %q", out.String())
+	}
+
+	if err := Run([]string{"touch", "codex", "missing", "--root", root}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := Run([]string{"touch", "codex", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input, got %v", err)
+	}
+}
+
+func TestRunDedupe(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	same := filepath.Join(root, "same.json")
+	writeFile(t, same, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	other := filepath.Join(root, "other.json")
+	writeFile(t, other, makeCodexAuthJSON(t, time.Now().Add(3*time.Hour)))
+	for _, save := range []struct{ label, source string }{{"work", same}, {"work-old", same}, {"work2", same}, {"home", other}} {
+		if err := Run([]string{"save", "codex", save.label, "--source", save.source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", save.label, err)
+		}
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	for i, label := range []string{"work", "work-old", "work2"} {
+		entry := state.Entries[stateKey(ToolCodex, label)]
+		entry.SavedAt = time.Date(2026, 1, 1+i, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		state.Entries[stateKey(ToolCodex, label)] = entry
+	}
+	state.Aliases["w2"] = AliasTarget{Tool: "codex", Label: "work2"}
+	state.LastActivatedLabel["codex"] = "work-old"
+	if err := m.saveState(state); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	work2Snapshot := state.Entries[stateKey(ToolCodex, "work2")].SnapshotPath
+
+	var out bytes.Buffer
+	if err := Run([]string{"dedupe", "codex", "--dry-run", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("dedupe --dry-run: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Would remove 2 duplicate codex label(s):\n- kept work; removed work-old, work2 (sha256 ") {
+		t.Fatalf("unexpected dry-run output: %q", out.String())
+	}
+	if _, err := os.Stat(work2Snapshot); err != nil {
+		t.Fatalf("dry run removed a snapshot: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"dedupe", "codex", "--keep", "newest", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("dedupe: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Removed 2 duplicate codex label(s):\n- kept work2; removed work, work-old (sha256 ") {
+		t.Fatalf("unexpected dedupe output: %q", out.String())
+	}
+	state, err = m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if len(state.Entries) != 2 || state.LastActivatedLabel["codex"] != "work2" || state.Aliases["w2"].Label != "work2" {
+		t.Fatalf("unexpected state after dedupe: %+v", state)
+	}
+	if _, err := os.Stat(state.Entries[stateKey(ToolCodex, "work2")].SnapshotPath); err != nil {
+		t.Fatalf("kept snapshot missing: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"dedupe", "codex", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("dedupe again: %v", err)
+	}
+	if out.String() != "No duplicate codex snapshots found.\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+	for _, args := range [][]string{{"dedupe"}, {"dedupe", "codex", "--keep", "middle"}, {"dedupe", "bogus"}} {
+		if err := Run(append(args, "--root", root), nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunDiag(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	codexRaw := makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_d", "diag@example.com", "plus")
+	codexSource := filepath.Join(root, "codex.json")
+	writeFile(t, codexSource, codexRaw)
+	piSource := filepath.Join(root, "pi.json")
+	writeFile(t, piSource, []byte(`{"anthropic":{"type":"oauth","access":"pi-secret-access","refresh":"pi-secret-refresh"}}`))
+	if err := Run([]string{"save", "codex", "work", "--source", codexSource, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save codex: %v", err)
+	}
+	if err := Run([]string{"save", "pi", "home", "--source", piSource, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save pi: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"diag", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("diag: %v", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(codexRaw, &payload); err != nil {
+		t.Fatalf("decode codex fixture: %v", err)
+	}
+	tokens, _, _ := findCodexTokens(payload)
+	for _, secret := range []string{extractStringClaim(tokens, "access_token"), "pi-secret-access", "pi-secret-refresh"} {
+		if secret == "" || strings.Contains(out.String(), secret) {
+			t.Fatalf("diag output leaks a secret %q: %s", secret, out.String())
+		}
+	}
+
+	var doc diagJSON
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("decode diag: %v", err)
+	}
+	if len(doc.Runtimes) != 2 || len(doc.Profiles) != 2 {
+		t.Fatalf("unexpected diag shape: %+v", doc)
+	}
+	codex := doc.Profiles[0]
+	if codex.Tool != "codex" || codex.AccountEmail != "diag@example.com" || codex.Status != "valid" || codex.Redacted == nil {
+		t.Fatalf("unexpected codex profile: %+v", codex)
+	}
+	if !strings.Contains(out.String(), `"access":"sha256:`) {
+		t.Fatalf("expected hashed pi access token, got %s", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"diag", "pi", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("diag pi: %v", err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil || len(doc.Profiles) != 1 || len(doc.Runtimes) != 1 {
+		t.Fatalf("unexpected diag pi output: %s (%v)", out.String(), err)
+	}
+	if err := Run([]string{"diag", "bogus", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input, got %v", err)
+	}
+}
+
+func TestRunStrictJSON(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	source := filepath.Join(root, "dup.json")
+	writeFile(t, source, []byte(`{"openai-codex":{"access":"old"},"openai-codex":{"access":"new"}}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--strict-json", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected strict save to reject duplicate keys, got %v", err)
+	}
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("lenient save: %v", err)
+	}
+	if err := Run([]string{"use", "pi", "work", "--strict-json", "--target", target, "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected strict use to reject the snapshot, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("strict use should not write the target, got %v", err)
+	}
+	out.Reset()
+	if err := Run([]string{"use", "pi", "work", "--print", "--strict-json", "--root", root}, nil, &out, io.Discard); !errors.Is(err, ErrInvalidInput) || out.Len() != 0 {
+		t.Fatalf("expected strict --print to reject the snapshot without printing it, got %v (%q)", err, out.String())
+	}
+
+	clean := filepath.Join(root, "clean.json")
+	writeFile(t, clean, []byte(`{"openai-codex":{"access":"new"}}`))
+	if err := Run([]string{"save", "pi", "clean", "--source", clean, "--strict-json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("strict save of a clean file: %v", err)
+	}
+	writeFile(t, target, []byte("{\"anthropic\":{\"access\":\"\xff\"}}"))
+	if err := Run([]string{"use", "pi", "clean", "--strict-json", "--target", target, "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected strict use to reject the runtime file it merges into, got %v", err)
+	}
+	if err := Run([]string{"use", "pi", "clean", "--strict-json", "--no-merge", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("strict use with --no-merge ignores the runtime file: %v", err)
+	}
+}
+
+func TestRunUseAs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	full := filepath.Join(root, "full.json")
+	writeFile(t, full, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_as", "CI-Bot@Company.com", "team"))
+	idOnly := filepath.Join(root, "id-only.json")
+	writeFile(t, idOnly, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_as", "", ""))
+	for label, source := range map[string]string{"ci": full, "cached": idOnly} {
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	var out bytes.Buffer
+	err := Run([]string{"use", "codex", "ci", "--as", "someone@company.com", "--target", target, "--root", root}, nil, &out, &out)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "belongs to CI-Bot@Company.com, not someone@company.com") {
+		t.Fatalf("expected identity mismatch, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("mismatched --as should not write the target, got %v", err)
+	}
+
+	if err := Run([]string{"use", "codex", "ci", "--as", "ci-bot@company.com", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use --as matching email: %v", err)
+	}
+	// The email of "cached" only comes from the identity cache.
+	if err := Run([]string{"use", "codex", "cached", "--as", "ci-bot@company.com", "--target", target, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("use --as with cached identity: %v", err)
+	}
+	if err := Run([]string{"use", "codex", "ci", "--as", "", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected empty --as to be rejected, got %v", err)
+	}
+	if err := Run([]string{"use", "codex", "ci", "--as", "ci-bot@company.com", "--print", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --as with --print to be rejected, got %v", err)
+	}
+}
+
+func TestRunListExpiring(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	for label, exp := range map[string]time.Duration{"fresh": 2 * time.Hour, "soon": 5 * time.Minute, "gone": -time.Hour} {
+		source := filepath.Join(root, label+".json")
+		writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(exp)))
+		if err := Run([]string{"save", "codex", label, "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"list", "--expiring", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --expiring: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[0] != "Saved profiles by expiry:" || !strings.HasPrefix(lines[1], "  gone ") || !strings.HasPrefix(lines[2], "  soon ") {
+		t.Fatalf("unexpected list --expiring output: %q", out.String())
+	}
+
+	for _, label := range []string{"soon", "gone"} {
+		if err := Run([]string{"delete", "codex", label, "--yes", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("delete %s: %v", label, err)
+		}
+	}
+	out.Reset()
+	if err := Run([]string{"list", "--expiring", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("list --expiring empty: %v", err)
+	}
+	if out.String() != "All profiles valid; nothing needs refreshing.\n" {
+		t.Fatalf("unexpected empty output: %q", out.String())
+	}
+}
+
+func TestRunUseMergeStrategy(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	source := filepath.Join(root, "pi.json")
+	writeFile(t, source, []byte(`{"openai-codex":{"access":"codex-new"}}`))
+	if err := Run([]string{"save", "pi", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	writeFile(t, target, []byte(`{"openai-codex":{"access":"codex-old","device_id":"dev-1"}}`))
+
+	if err := Run([]string{"use", "pi", "work", "--merge-strategy", "deep", "--target", target, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("use --merge-strategy deep: %v", err)
+	}
+	raw, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("read target: %v", err)
+	}
+	if !strings.Contains(string(raw), `"device_id": "dev-1"`) || !strings.Contains(string(raw), `"access": "codex-new"`) {
+		t.Fatalf("expected deep merge to keep device_id, got %s", raw)
+	}
+
+	if err := Run([]string{"use", "pi", "work", "--target", target, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if raw, _ := os.ReadFile(target); strings.Contains(string(raw), "device_id") {
+		t.Fatalf("expected default replace merge to drop device_id, got %s", raw)
+	}
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"use", "pi", "work", "--merge-strategy", "shallow"},
+		{"use", "pi", "work", "--merge-strategy", "deep", "--no-merge"},
+		{"use", "codex", "work", "--merge-strategy", "deep"},
+	} {
+		if err := Run(append(args, "--target", target, "--root", root), nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunSaveLabelFromAccount(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+	source := filepath.Join(root, "codex.json")
+
+	save := func(raw []byte) (string, error) {
+		t.Helper()
+		writeFile(t, source, raw)
+		var out bytes.Buffer
+		err := Run([]string{"save", "codex", "--label-from-account", "--source", source, "--root", root}, nil, &out, &out)
+		return out.String(), err
+	}
+
+	out, err := save(makeCodexAuthJSONWithIdentity(t, exp, "acct_jane", "Jane.Doe+ci@Example.com", "plus"))
+	if err != nil {
+		t.Fatalf("save --label-from-account: %v", err)
+	}
+	if !strings.HasSuffix(out, " for jane-doe-ci\n") {
+		t.Fatalf("expected derived label jane-doe-ci, got %q", out)
+	}
+	// The same account re-saves into its own label.
+	if out, err = save(makeCodexAuthJSONWithIdentity(t, exp.Add(time.Hour), "acct_jane", "jane.doe+ci@example.com", "plus")); err != nil || !strings.HasSuffix(out, " for jane-doe-ci\n") {
+		t.Fatalf("expected re-save into jane-doe-ci, got %q, %v", out, err)
+	}
+	// A different account with the same local part gets a suffix.
+	if out, err = save(makeCodexAuthJSONWithIdentity(t, exp, "acct_other", "jane.doe+ci@other.com", "team")); err != nil || !strings.HasSuffix(out, " for jane-doe-ci-2\n") {
+		t.Fatalf("expected suffixed label jane-doe-ci-2, got %q, %v", out, err)
+	}
+
+	if _, err := save(makeCodexAuthJSON(t, exp)); !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "no account email") {
+		t.Fatalf("expected missing email to be rejected, got %v", err)
+	}
+	if err := Run([]string{"save", "codex", "work", "--label-from-account", "--source", source, "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected label with --label-from-account to be rejected, got %v", err)
+	}
+
+	// A derived label is held to label_pattern like any other.
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"label_pattern": "[a-z]+"}`))
+	if _, err := save(makeCodexAuthJSONWithIdentity(t, exp, "acct_jane", "jane.doe@example.com", "plus")); !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), `"jane-doe"`) || !strings.Contains(err.Error(), "must match [a-z]+") {
+		t.Fatalf("expected the derived label to be checked against label_pattern, got %v", err)
+	}
+}
+
+func TestRunSaveConfirmOverwrite(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour)
+	source := filepath.Join(root, "codex.json")
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, exp, "acct_work", "work@example.com", "plus"))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	snapshot, err := os.ReadFile(filepath.Join(root, "snapshots", "codex", "work.json"))
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+
+	origIsTerminal := stdinIsTerminal
+	t.Cleanup(func() { stdinIsTerminal = origIsTerminal })
+	stdinIsTerminal = func(io.Reader) bool { return true }
+
+	// Identical content has nothing to confirm.
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, strings.NewReader(""), &out, &out); err != nil {
+		t.Fatalf("identical re-save: %v", err)
+	}
+	if strings.Contains(out.String(), "[y/N]") {
+		t.Fatalf("identical re-save should not prompt: %q", out.String())
+	}
+
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, exp, "acct_home", "home@example.com", "plus"))
+	out.Reset()
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, strings.NewReader("n\n"), &out, &out); err != nil {
+		t.Fatalf("declined re-save: %v", err)
+	}
+	if out.String() != "Replace codex/work (work@example.com) with different auth (home@example.com)? [y/N] Aborted; nothing saved.\n" {
+		t.Fatalf("unexpected declined output: %q", out.String())
+	}
+	if raw, _ := os.ReadFile(filepath.Join(root, "snapshots", "codex", "work.json")); !bytes.Equal(raw, snapshot) {
+		t.Fatal("declined re-save changed the snapshot")
+	}
+
+	out.Reset()
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, strings.NewReader("y\n"), &out, &out); err != nil {
+		t.Fatalf("confirmed re-save: %v", err)
+	}
+	if !strings.Contains(out.String(), "Saved home@example.com (Plus) for work") {
+		t.Fatalf("unexpected confirmed output: %q", out.String())
+	}
+
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, exp, "acct_work", "work@example.com", "plus"))
+	out.Reset()
+	if err := Run([]string{"save", "codex", "work", "--force", "--source", source, "--root", root}, strings.NewReader(""), &out, &out); err != nil {
+		t.Fatalf("forced re-save: %v", err)
+	}
+	if strings.Contains(out.String(), "[y/N]") || !strings.Contains(out.String(), "Saved work@example.com (Plus) for work") {
+		t.Fatalf("unexpected forced output: %q", out.String())
+	}
+}
+
+func TestRunUseQuietOnUnchanged(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	source := filepath.Join(root, "codex.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	use := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := Run([]string{"use", "codex", "work", "--quiet-on-unchanged", "--target", target, "--root", root}, nil, &out, io.Discard); err != nil {
+			t.Fatalf("use --quiet-on-unchanged: %v", err)
+		}
+		return out.String()
+	}
+
+	if out := use(); !strings.Contains(out, "Using codex for work") {
+		t.Fatalf("first use should print the summary, got %q", out)
+	}
+	if out := use(); out != "" {
+		t.Fatalf("unchanged use should be quiet, got %q", out)
+	}
+
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(3*time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("re-save: %v", err)
+	}
+	if out := use(); !strings.Contains(out, "Using codex for work") {
+		t.Fatalf("changed use should print the summary, got %q", out)
+	}
+}
+
+func TestRunActiveExitCode(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	source := filepath.Join(root, "codex.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	activeCode := func(args ...string) (int, string) {
+		t.Helper()
+		var out bytes.Buffer
+		err := Run(append([]string{"active"}, append(args, "--exit-code", "--root", root)...), nil, &out, &out)
+		if err == nil {
+			return 0, out.String()
+		}
+		var exitErr *ExitCodeError
+		if !errors.As(err, &exitErr) || !exitErr.Silent {
+			t.Fatalf("expected silent exit code error, got %v", err)
+		}
+		return exitErr.Code, out.String()
+	}
+
+	if code, out := activeCode("codex"); code != 4 || out != "" {
+		t.Fatalf("expected silent exit 4 for missing runtime, got %d %q", code, out)
+	}
+	runtimePath := filepath.Join(home, ".codex", "auth.json")
+	writeFile(t, runtimePath, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if code, _ := activeCode("codex"); code != 3 {
+		t.Fatalf("expected exit 3 for no match, got %d", code)
+	}
+	if err := Run([]string{"use", "codex", "work", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if code, out := activeCode("codex"); code != 0 || out != "" {
+		t.Fatalf("expected silent exit 0 for a match, got %d %q", code, out)
+	}
+	if code, out := activeCode("codex", "--verbose"); code != 0 || !strings.Contains(out, "codex\twork\tmatch") {
+		t.Fatalf("expected rows with --verbose, got %d %q", code, out)
+	}
+	if err := Run([]string{"save", "codex", "work-clone", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save clone: %v", err)
+	}
+	if err := Run([]string{"delete", "codex", "work", "--yes", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := Run([]string{"save", "codex", "work-clone-2", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save clone 2: %v", err)
+	}
+	if code, _ := activeCode("codex"); code != 2 {
+		t.Fatalf("expected exit 2 for ambiguous, got %d", code)
+	}
+	// Across tools the worst wins: pi has no saved profiles.
+	if code, _ := activeCode(); code != 3 {
+		t.Fatalf("expected worst code 3 across tools, got %d", code)
+	}
+
+	for _, args := range [][]string{{"--json"}, {"--watch"}} {
+		if err := Run(append([]string{"active", "--exit-code", "--root", root}, args...), nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunSaveExpectTool(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	piSource := filepath.Join(root, "pi.json")
+	writeFile(t, piSource, []byte(`{"anthropic":{"type":"oauth","access":"a","expires":9999999999999}}`))
+
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", piSource, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save with mismatched shape: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: source looks like a pi auth file, not codex; if so, run ags move codex work pi") {
+		t.Fatalf("expected shape warning, got %q", stderr.String())
+	}
+
+	stderr.Reset()
+	err := Run([]string{"save", "codex", "strict", "--expect-tool", "strict", "--source", piSource, "--root", root}, nil, &stdout, &stderr)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "looks like a pi auth file") {
+		t.Fatalf("expected strict mismatch to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "codex", "strict.json")); !os.IsNotExist(err) {
+		t.Fatalf("refused save should not write a snapshot, got %v", err)
+	}
+
+	if err := Run([]string{"save", "codex", "quiet", "--expect-tool", "off", "--source", piSource, "--root", root}, nil, &stdout, &stderr); err != nil || strings.Contains(stderr.String(), "looks like") {
+		t.Fatalf("expected --expect-tool off to skip the check, err=%v stderr=%q", err, stderr.String())
+	}
+	if err := Run([]string{"save", "pi", "ok", "--expect-tool", "strict", "--source", piSource, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("matching shape with strict: %v", err)
+	}
+	if err := Run([]string{"save", "pi", "ok", "--expect-tool", "maybe", "--source", piSource, "--root", root}, nil, &stdout, &stderr); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid --expect-tool value to be rejected, got %v", err)
+	}
+}
+
+func TestRunBatchSave(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	codexSource := filepath.Join(root, "codex.json")
+	writeFile(t, codexSource, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct-1", "one@example.com", "plus"))
+	piSource := filepath.Join(root, "pi.json")
+	writeFile(t, piSource, []byte(`{"anthropic":{"type":"oauth","access":"a","expires":9999999999999}}`))
+
+	input := strings.Join([]string{
+		`{"tool":"codex","label":"acct1","source":"` + codexSource + `"}`,
+		``,
+		`{"tool":"claude","label":"x","source":"` + codexSource + `"}`,
+		`{"tool":"pi","label":"bad label","source":"` + piSource + `"}`,
+		`{"tool":"pi","label":"p1","source":"-"}`,
+		`{"tool":"pi","label":"p1","source":"` + piSource + `","extra":1}`,
+		`{"tool":"pi","label":"p1","source":"` + filepath.Join(root, "missing.json") + `"}`,
+		`{"tool":"pi","label":"p2","source":"` + piSource + `"}`,
+	}, "\n")
+
+	var stdout, stderr bytes.Buffer
+	err := Run([]string{"batch", "save", "--root", root}, strings.NewReader(input), &stdout, &stderr)
+	var exitErr *ExitCodeError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected exit code 1 for failed records, got %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"line 1: saved codex acct1 (one@example.com",
+		"line 3: error: invalid tool",
+		"line 4: error: label",
+		"line 5: error: source must be a file path",
+		"line 6: error: invalid record",
+		"line 7: error:",
+		"line 8: saved pi p2",
+		"Saved 2 of 7 record(s); 5 failed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "line 2:") {
+		t.Fatalf("blank line should be skipped:\n%s", out)
+	}
+	for _, path := range []string{
+		filepath.Join(root, "snapshots", "codex", "acct1.json"),
+		filepath.Join(root, "snapshots", "pi", "p2.json"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected snapshot %s: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "pi", "p1.json")); !os.IsNotExist(err) {
+		t.Fatalf("failed records should not write snapshots, got %v", err)
+	}
+
+	stdout.Reset()
+	if err := Run([]string{"batch", "save", "--root", root}, strings.NewReader(input[:strings.Index(input, "\n")]), &stdout, &stderr); err != nil {
+		t.Fatalf("batch with only good records: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Saved 1 of 1 record(s); 0 failed") {
+		t.Fatalf("unexpected summary: %q", stdout.String())
+	}
+	if err := Run([]string{"batch", "load", "--root", root}, strings.NewReader(""), &stdout, &stderr); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected unknown subcommand to be rejected, got %v", err)
+	}
+}
+
+func TestRunSaveVerifyExpiryFuture(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	gone := filepath.Join(root, "gone.json")
+	writeFile(t, gone, makeCodexAuthJSON(t, time.Now().Add(-time.Hour)))
+	fresh := filepath.Join(root, "fresh.json")
+	writeFile(t, fresh, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	past := time.Now().Add(-time.Hour).UnixMilli()
+	future := time.Now().Add(time.Hour).UnixMilli()
+	piPartial := filepath.Join(root, "pi-partial.json")
+	writeFile(t, piPartial, []byte(fmt.Sprintf(`{"anthropic":{"type":"oauth","access":"a","expires":%d},"openai-codex":{"type":"oauth","access":"c","expires":%d}}`, past, future)))
+	piDead := filepath.Join(root, "pi-dead.json")
+	writeFile(t, piDead, []byte(fmt.Sprintf(`{"anthropic":{"type":"oauth","access":"a","expires":%d}}`, past)))
+
+	err := Run([]string{"save", "codex", "gone", "--verify-expiry-future", "--source", gone, "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "refusing to save a dead credential") {
+		t.Fatalf("expected expired codex source to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "codex", "gone.json")); !os.IsNotExist(err) {
+		t.Fatalf("refused save should not write a snapshot, got %v", err)
+	}
+	if err := Run([]string{"save", "codex", "gone", "--source", gone, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("expired source without the flag should still save: %v", err)
+	}
+	if err := Run([]string{"save", "codex", "fresh", "--verify-expiry-future", "--source", fresh, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("fresh source: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	if err := Run([]string{"save", "pi", "partial", "--verify-expiry-future", "--source", piPartial, "--root", root}, nil, io.Discard, &stderr); err != nil {
+		t.Fatalf("partly expired pi source: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: saved with expired pi provider(s): anthropic") {
+		t.Fatalf("expected expired provider warning, got %q", stderr.String())
+	}
+	err = Run([]string{"save", "pi", "dead", "--verify-expiry-future", "--source", piDead, "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "every provider in the source has expired (anthropic)") {
+		t.Fatalf("expected fully expired pi source to be refused, got %v", err)
+	}
+}
+
+// TestRunNeverPrintsTokens runs every read and write command against
+// snapshots with recognizable tokens and checks that no token value reaches
+// stdout or stderr. use --print and export-env --reveal print tokens on
+// purpose and are left out.
+func TestRunNeverPrintsTokens(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	exp := time.Now().Add(2 * time.Hour).Unix()
+
+	codexAccess := makeJWT(t, map[string]any{"exp": exp, "jti": "codex-access-secret"})
+	codexID := makeJWT(t, map[string]any{"email": "leak@example.com", "account_id": "acct_leak", "jti": "codex-id-secret"})
+	codexRefresh := "codex-refresh-secret-0123456789"
+	codexRaw := []byte(`{"tokens":{"access_token":"` + codexAccess + `","id_token":"` + codexID + `","refresh_token":"` + codexRefresh + `","account_id":"acct_leak"}}`)
+	piAccess := makeJWT(t, map[string]any{"exp": exp, "jti": "pi-access-secret"})
+	piRefresh := "pi-refresh-secret-0123456789"
+	piRaw := []byte(`{"anthropic":{"type":"oauth","access":"` + piAccess + `","refresh":"` + piRefresh + `","expires":` + strconv.FormatInt(exp*1000, 10) + `}}`)
+
+	secrets := []string{codexRefresh, piRefresh}
+	for _, token := range []string{codexAccess, codexID, piAccess} {
+		// The header and signature are shared by every test JWT; the claims
+		// segment is what identifies the token.
+		secrets = append(secrets, token, strings.Split(token, ".")[1])
+	}
+
+	codexSource := filepath.Join(t.TempDir(), "codex.json")
+	writeFile(t, codexSource, codexRaw)
+	piSource := filepath.Join(t.TempDir(), "pi.json")
+	writeFile(t, piSource, piRaw)
+	target := filepath.Join(t.TempDir(), "auth.json")
+
+	commands := [][]string{
+		{"save", "codex", "work", "--source", codexSource, "--verbose"},
+		{"save", "codex", "work-copy", "--source", codexSource, "--verbose"},
+		{"save", "pi", "home", "--source", piSource, "--verbose"},
+		{"use", "codex", "work", "--verbose"},
+		{"use", "pi", "home", "--target", target, "--verbose", "--runtime-check"},
+		{"list"},
+		{"list", "--verbose", "--id"},
+		{"list", "--plain"},
+		{"list", "--jsonl"},
+		{"list", "--by-account", "--verbose"},
+		{"active", "--verbose"},
+		{"active", "--json"},
+		{"check", "--verbose", "--warn-before", "1m"},
+		{"check", "--active-only", "--verbose"},
+		{"inspect", "codex", "work"},
+		{"inspect", "codex", "work", "--json"},
+		{"inspect", "pi", "home", "--json"},
+		{"history", "codex", "work", "--json"},
+		{"diff", "codex", "work", "work-copy"},
+		{"find", "leak@example.com"},
+		{"providers", "home", "--json"},
+		{"cache", "ls"},
+		{"diag"},
+		{"dedupe", "codex", "--dry-run"},
+		{"touch", "codex", "work"},
+		{"note", "codex", "work", "leak check"},
+		{"tag", "add", "codex", "work", "audit"},
+		{"export-env", "codex", "work"},
+		{"delete", "codex", "work-copy", "--yes"},
+	}
+	for _, args := range commands {
+		var out bytes.Buffer
+		err := Run(append(args, "--root", root), nil, &out, &out)
+		// export-env refuses to print tokens without --reveal; every other
+		// command must succeed, or the check below proves nothing.
+		if args[0] == "export-env" {
+			if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "--reveal") {
+				t.Fatalf("%v: expected a --reveal refusal, got %v", args, err)
+			}
+		} else if err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, out.String())
+		}
+		if err != nil {
+			out.WriteString(err.Error())
+		}
+		for _, secret := range secrets {
+			if strings.Contains(out.String(), secret) {
+				t.Fatalf("%v printed a token value %q:\n%s", args, secret, out.String())
+			}
+		}
+	}
+}
+
+func TestRunUseBackupRuntimeTo(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	runtime := filepath.Join(home, ".codex", "auth.json")
+	personal := filepath.Join(root, "personal.json")
+	writeFile(t, personal, makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-p", "personal@example.com", "plus"))
+	if err := Run([]string{"save", "codex", "personal", "--source", personal, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save personal: %v", err)
+	}
+
+	// Without a runtime file the backup save fails and nothing is switched.
+	err := Run([]string{"use", "codex", "personal", "--backup-runtime-to", "work", "--root", root}, nil, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "codex/personal was not activated") {
+		t.Fatalf("expected failed backup to abort the switch, got %v", err)
+	}
+	if _, err := os.Stat(runtime); !os.IsNotExist(err) {
+		t.Fatalf("runtime file should not be written after a failed backup, got %v", err)
+	}
+
+	workRaw := makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-w", "work@example.com", "team")
+	writeFile(t, runtime, workRaw)
+	var stdout bytes.Buffer
+	if err := Run([]string{"use", "codex", "personal", "--backup-runtime-to", "work", "--root", root}, nil, &stdout, io.Discard); err != nil {
+		t.Fatalf("use --backup-runtime-to: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "- saved work@example.com") || !strings.Contains(stdout.String(), " as work") {
+		t.Fatalf("expected backup line, got %q", stdout.String())
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	saved, err := m.ReadSnapshot(ToolCodex, "work", "")
+	if err != nil || !bytes.Equal(saved, workRaw) {
+		t.Fatalf("expected work snapshot to hold the previous runtime auth, err=%v", err)
+	}
+	items, err := m.Active(nil)
+	if err != nil {
+		t.Fatalf("Active: %v", err)
+	}
+	if items[0].ActiveLabel != "personal" {
+		t.Fatalf("expected personal to be active, got %+v", items[0])
+	}
+
+	// A failing pre_use hook leaves neither a backup entry nor its snapshot.
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"pre_use": "exit 1"}`))
+	err = Run([]string{"use", "codex", "work", "--backup-runtime-to", "spare", "--root", root}, nil, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "codex/work was not activated") {
+		t.Fatalf("expected pre_use failure to abort, got %v", err)
+	}
+	if _, err := m.ReadSnapshot(ToolCodex, "spare", ""); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected no spare entry after an aborted use, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "codex", "spare.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected no spare snapshot after an aborted use, got %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"use", "codex", "personal", "--backup-runtime-to", "personal", "--root", root},
+		{"use", "codex", "personal", "--backup-runtime-to", "bad label", "--root", root},
+		{"use", "codex", "personal", "--backup-runtime-to", "work", "--print", "--root", root},
+	} {
+		if err := Run(args, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected invalid input for %v, got %v", args, err)
+		}
+	}
+}
+
+func TestRunUseEnvPassthrough(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-1", "one@example.com", "plus"))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	target := filepath.Join(home, "target.json")
+
+	if err := Run([]string{"use", "codex", "work", "--env-passthrough", "--target", target, "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "no env_files configured for codex") {
+		t.Fatalf("expected missing env_files to be rejected, got %v", err)
+	}
+
+	envPath := filepath.Join(home, "project", ".env")
+	writeEnvConfig := func(template string) {
+		t.Helper()
+		cfg, err := json.Marshal(map[string]any{"env_files": map[string]any{"codex": []map[string]string{{"path": "~/project/.env", "template": template}}}})
+		if err != nil {
+			t.Fatalf("marshal config: %v", err)
+		}
+		writeFile(t, filepath.Join(root, "config.json"), cfg)
+	}
+
+	writeEnvConfig("TOKEN={{.Env.MISSING}}\n")
+	if err := Run([]string{"use", "codex", "work", "--env-passthrough", "--target", target, "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected unknown variable to abort, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("template error should abort before the switch, got %v", err)
+	}
+
+	writeEnvConfig("TOKEN={{.Env.CODEX_ACCESS_TOKEN}}\n# {{.Label}} {{.Insight.AccountEmail}}\n")
+	var stdout bytes.Buffer
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, &stdout, io.Discard); err != nil {
+		t.Fatalf("use without --env-passthrough: %v", err)
+	}
+	if _, err := os.Stat(envPath); !os.IsNotExist(err) {
+		t.Fatalf("env file should only be written with --env-passthrough, got %v", err)
+	}
+	stdout.Reset()
+	if err := Run([]string{"use", "codex", "work", "--env-passthrough", "--target", target, "--root", root}, nil, &stdout, io.Discard); err != nil {
+		t.Fatalf("use --env-passthrough: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "- env file: "+envPath) {
+		t.Fatalf("expected env file line, got %q", stdout.String())
+	}
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	vars, err := m.ExportEnv(ToolCodex, "work")
+	if err != nil {
+		t.Fatalf("ExportEnv: %v", err)
+	}
+	got, err := os.ReadFile(envPath)
+	if err != nil {
+		t.Fatalf("read env file: %v", err)
+	}
+	if want := "TOKEN=" + vars[0].Value + "\n# work one@example.com\n"; string(got) != want {
+		t.Fatalf("unexpected env file:\n%s", got)
+	}
+	if info, err := os.Stat(envPath); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected env file mode 0600, got %v err=%v", info.Mode().Perm(), err)
+	}
+
+	if err := Run([]string{"use", "codex", "work", "--env-passthrough", "--print", "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected --print with --env-passthrough to be rejected, got %v", err)
+	}
+
+	for _, cfg := range []string{
+		`{"env_files": {"claude": [{"path": "/tmp/x.env", "template": "x"}]}}`,
+		`{"env_files": {"codex": [{"path": "relative.env", "template": "x"}]}}`,
+		`{"env_files": {"codex": [{"path": "/tmp/x.env", "template": ""}]}}`,
+		`{"env_files": {"codex": [{"path": "/tmp/x.env", "template": "{{.Env"}]}}`,
+	} {
+		writeFile(t, filepath.Join(root, "config.json"), []byte(cfg))
+		if _, err := NewManager(root); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %s to be rejected, got %v", cfg, err)
+		}
+	}
+}
+
+func TestRunUseChmod(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "auth.json")
+	source := filepath.Join(root, "codex.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	modeOf := func(path string) os.FileMode {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		return info.Mode().Perm()
+	}
+
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if mode := modeOf(target); mode != 0o600 {
+		t.Fatalf("expected default mode 0600, got %04o", mode)
+	}
+
+	var stderr bytes.Buffer
+	if err := Run([]string{"use", "codex", "work", "--chmod", "0640", "--target", target, "--root", root}, nil, io.Discard, &stderr); err != nil {
+		t.Fatalf("use --chmod 0640: %v", err)
+	}
+	if mode := modeOf(target); mode != 0o640 {
+		t.Fatalf("expected mode 0640, got %04o", mode)
+	}
+	if strings.Contains(stderr.String(), "broader than 0644") {
+		t.Fatalf("0640 should not warn: %q", stderr.String())
+	}
+	if mode := modeOf(filepath.Join(root, "snapshots", "codex", "work.json")); mode != 0o600 {
+		t.Fatalf("snapshot mode should stay 0600, got %04o", mode)
+	}
+
+	stderr.Reset()
+	if err := Run([]string{"use", "codex", "work", "--chmod", "666", "--target", target, "--root", root}, nil, io.Discard, &stderr); err != nil {
+		t.Fatalf("use --chmod 666: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "written with mode 0666, broader than 0644") {
+		t.Fatalf("expected broad mode warning, got %q", stderr.String())
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"runtime_mode": "0640"}`))
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("use with runtime_mode: %v", err)
+	}
+	if mode := modeOf(target); mode != 0o640 {
+		t.Fatalf("expected config mode 0640, got %04o", mode)
+	}
+
+	for _, args := range [][]string{
+		{"--chmod", "0400"},
+		{"--chmod", "0999"},
+		{"--chmod", "rw-r-----"},
+		{"--chmod", "01600"},
+		{"--chmod", "0600", "--print"},
+	} {
+		if err := Run(append([]string{"use", "codex", "work", "--target", target, "--root", root}, args...), nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"runtime_mode": "0200"}`))
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "runtime_mode") {
+		t.Fatalf("expected invalid runtime_mode to be rejected, got %v", err)
+	}
+}
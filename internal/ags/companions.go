@@ -0,0 +1,188 @@
+package ags
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// codexAccountFile is the identity file newer codex installs keep next to
+// auth.json.
+const codexAccountFile = "account.json"
+
+// companionFiles lists, per tool, the files read from the auth file's
+// directory alongside it when companion_files is enabled in config.json.
+var companionFiles = map[Tool][]string{
+	ToolCodex: {codexAccountFile},
+}
+
+// companionSnapshotPath is where a saved companion file lives. Companions
+// are kept beside the snapshot rather than wrapped into it, so the snapshot
+// stays byte-identical to the auth file for hashing and active matching.
+func (m *Manager) companionSnapshotPath(tool Tool, label string, name string) string {
+	return filepath.Join(m.rootDir, "snapshots", tool.String(), "companions", label, name)
+}
+
+// readCompanions reads the tool's companion files from the directory of
+// authPath. Missing companions are skipped; present ones must be JSON objects.
+func (m *Manager) readCompanions(tool Tool, authPath string) (map[string][]byte, error) {
+	names := m.paths[tool].Companions
+	if len(names) == 0 || authPath == stdinSourcePath || isURLSource(authPath) {
+		return nil, nil
+	}
+	companions := map[string][]byte{}
+	for _, name := range names {
+		path := filepath.Join(filepath.Dir(authPath), name)
+		raw, ok, err := m.readOptionalFile(path)
+		if err != nil {
+			return nil, ioErrorf("reading companion file: %w", err)
+		}
+		if !ok {
+			continue
+		}
+		if err := validateJSONObject(raw); err != nil {
+			return nil, invalidInputf("companion file %s is not a valid JSON object: %w", path, err)
+		}
+		companions[name] = raw
+	}
+	return companions, nil
+}
+
+// removeCompanions deletes the companion snapshots in paths that keep does
+// not also reference.
+func removeCompanions(paths map[string]string, keep map[string]string) error {
+	for name, path := range paths {
+		if keep[name] == path {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return ioErrorf("removing companion snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// moveCompanions copies the companion snapshots in paths that tool to also
+// keeps companions of under to's companions directory for label, and returns
+// their new paths. The rest are dropped, e.g. codex's account.json when a
+// profile moves to pi. The old files are left for the caller to remove once
+// state is saved.
+func (m *Manager) moveCompanions(paths map[string]string, to Tool, label string) (map[string]string, error) {
+	var moved map[string]string
+	for name, path := range paths {
+		if !slices.Contains(companionFiles[to], name) {
+			continue
+		}
+		raw, err := m.readFile(path)
+		if err != nil {
+			_ = removeCompanions(moved, nil)
+			return nil, ioErrorf("reading companion snapshot: %w", err)
+		}
+		dest := m.companionSnapshotPath(to, label, name)
+		if err := m.writeFile(dest, raw, 0o600); err != nil {
+			_ = removeCompanions(moved, nil)
+			return nil, ioErrorf("writing companion snapshot: %w", err)
+		}
+		if moved == nil {
+			moved = map[string]string{}
+		}
+		moved[name] = dest
+	}
+	return moved, nil
+}
+
+// readEntryCompanions reads the companion snapshots recorded on entry.
+// Unreadable ones are skipped.
+func (m *Manager) readEntryCompanions(entry StateEntry) map[string][]byte {
+	if len(entry.Companions) == 0 {
+		return nil
+	}
+	companions := make(map[string][]byte, len(entry.Companions))
+	for name, path := range entry.Companions {
+		if raw, err := m.readFile(path); err == nil {
+			companions[name] = raw
+		}
+	}
+	return companions
+}
+
+// companionTarget pairs a companion with the runtime path use writes it to.
+type companionTarget struct {
+	Path        string
+	Raw         []byte
+	Previous    []byte
+	HadPrevious bool
+}
+
+// companionTargets resolves where use writes each companion: next to the
+// runtime auth file target. Names are sorted for a stable write order.
+func (m *Manager) companionTargets(companions map[string][]byte, target string) ([]companionTarget, error) {
+	names := make([]string, 0, len(companions))
+	for name := range companions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	targets := make([]companionTarget, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(filepath.Dir(target), name)
+		previous, had, err := m.readOptionalFile(path)
+		if err != nil {
+			return nil, ioErrorf("reading existing companion file: %w", err)
+		}
+		targets = append(targets, companionTarget{Path: path, Raw: companions[name], Previous: previous, HadPrevious: had})
+	}
+	return targets, nil
+}
+
+// applyCompanionIdentity fills missing identity fields of a codex insight
+// from its account.json companion.
+func applyCompanionIdentity(tool Tool, insight *AuthInsight, companions map[string][]byte) {
+	if tool != ToolCodex || insight == nil {
+		return
+	}
+	raw, ok := companions[codexAccountFile]
+	if !ok {
+		return
+	}
+	email, plan, accountID := identityFromAccountFile(raw)
+	if insight.AccountEmail == "" {
+		insight.AccountEmail = email
+	}
+	if insight.AccountPlan == "" {
+		insight.AccountPlan = plan
+	}
+	if insight.AccountID == "" {
+		insight.AccountID = accountID
+	}
+}
+
+// identityFromAccountFile reads email, plan, and account id from a codex
+// account.json. The fields may sit at the top level or under "account".
+func identityFromAccountFile(raw []byte) (email string, plan string, accountID string) {
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return "", "", ""
+	}
+	objects := []map[string]any{payload}
+	if nested, ok := payload["account"].(map[string]any); ok {
+		objects = append(objects, nested)
+	}
+	first := func(keys ...string) string {
+		for _, object := range objects {
+			for _, key := range keys {
+				if value, ok := object[key].(string); ok && strings.TrimSpace(value) != "" {
+					return strings.TrimSpace(value)
+				}
+			}
+		}
+		return ""
+	}
+	email = first("email")
+	plan = normalizePlan(first("plan_type", "chatgpt_plan_type", "plan"))
+	accountID = first("account_id", "chatgpt_account_id", "id")
+	return email, plan, accountID
+}
@@ -0,0 +1,185 @@
+package ags
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIdentityFromAccountFile(t *testing.T) {
+	email, plan, accountID := identityFromAccountFile([]byte(`{"email":"top@example.com","account":{"plan_type":"pro","account_id":"acct_n"}}`))
+	if email != "top@example.com" || plan != "Pro" || accountID != "acct_n" {
+		t.Fatalf("unexpected identity: %q %q %q", email, plan, accountID)
+	}
+	if email, plan, accountID := identityFromAccountFile([]byte(`not-json`)); email != "" || plan != "" || accountID != "" {
+		t.Fatalf("expected empty identity for invalid JSON")
+	}
+
+	insight := AuthInsight{AccountEmail: "token@example.com"}
+	applyCompanionIdentity(ToolCodex, &insight, map[string][]byte{codexAccountFile: []byte(`{"email":"file@example.com","plan":"team"}`)})
+	if insight.AccountEmail != "token@example.com" || insight.AccountPlan != "Team" {
+		t.Fatalf("companion should only fill missing fields, got %+v", insight)
+	}
+	piInsight := AuthInsight{}
+	applyCompanionIdentity(ToolPi, &piInsight, map[string][]byte{codexAccountFile: []byte(`{"email":"file@example.com"}`)})
+	if piInsight.AccountEmail != "" {
+		t.Fatalf("pi insight should not use codex companions, got %+v", piInsight)
+	}
+}
+
+func TestManagerCompanionFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"companion_files": true}`))
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	sourceDir := t.TempDir()
+	source := filepath.Join(sourceDir, "auth.json")
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "", "", ""))
+	account := []byte(`{"email":"companion@example.com","plan_type":"plus","account_id":"acct_c"}`)
+	writeFile(t, filepath.Join(sourceDir, codexAccountFile), account)
+
+	saved, err := m.Save(ToolCodex, "work", source)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if saved.Insight.AccountEmail != "companion@example.com" || saved.Insight.AccountPlan != "Plus" || saved.Insight.AccountID != "acct_c" {
+		t.Fatalf("expected identity from account.json, got %+v", saved.Insight)
+	}
+	companionPath := m.companionSnapshotPath(ToolCodex, "work", codexAccountFile)
+	if raw, err := os.ReadFile(companionPath); err != nil || string(raw) != string(account) {
+		t.Fatalf("companion snapshot not saved: %q %v", raw, err)
+	}
+
+	items, err := m.List(nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(items) != 1 || items[0].AuthInsight.AccountEmail != "companion@example.com" {
+		t.Fatalf("expected list identity from companion, got %+v", items)
+	}
+
+	target := filepath.Join(t.TempDir(), "auth.json")
+	if _, err := m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: target}); err != nil {
+		t.Fatalf("Use: %v", err)
+	}
+	if raw, err := os.ReadFile(filepath.Join(filepath.Dir(target), codexAccountFile)); err != nil || string(raw) != string(account) {
+		t.Fatalf("companion not restored next to target: %q %v", raw, err)
+	}
+
+	// Re-saving from a directory without account.json drops the companion.
+	bare := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, bare, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if _, err := m.Save(ToolCodex, "work", bare); err != nil {
+		t.Fatalf("re-save: %v", err)
+	}
+	if _, err := os.Stat(companionPath); !os.IsNotExist(err) {
+		t.Fatalf("expected stale companion snapshot removed, got %v", err)
+	}
+
+	writeFile(t, filepath.Join(sourceDir, codexAccountFile), []byte(`not-json`))
+	if _, err := m.Save(ToolCodex, "broken", source); err == nil {
+		t.Fatalf("expected invalid companion to fail save")
+	}
+	writeFile(t, filepath.Join(sourceDir, codexAccountFile), account)
+	if _, err := m.Save(ToolCodex, "gone", source); err != nil {
+		t.Fatalf("save gone: %v", err)
+	}
+	if _, err := m.Delete(ToolCodex, "gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(m.companionSnapshotPath(ToolCodex, "gone", codexAccountFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected companion removed with the profile, got %v", err)
+	}
+}
+
+func TestManagerCompanionFilesDisabledByDefault(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	sourceDir := t.TempDir()
+	source := filepath.Join(sourceDir, "auth.json")
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "", "", ""))
+	writeFile(t, filepath.Join(sourceDir, codexAccountFile), []byte(`{"email":"companion@example.com"}`))
+
+	saved, err := m.Save(ToolCodex, "work", source)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if saved.Insight.AccountEmail != "" {
+		t.Fatalf("companion should be ignored without companion_files, got %+v", saved.Insight)
+	}
+	if _, err := os.Stat(m.companionSnapshotPath(ToolCodex, "work", codexAccountFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected no companion snapshot, got %v", err)
+	}
+}
+
+func TestManagerMoveCompanions(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"companion_files": true}`))
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	// A pi auth file saved under codex, with a codex account.json beside it.
+	sourceDir := t.TempDir()
+	source := filepath.Join(sourceDir, "auth.json")
+	writeFile(t, source, []byte(`{"anthropic":{"type":"oauth","access":"a","expires":9999999999999}}`))
+	writeFile(t, filepath.Join(sourceDir, codexAccountFile), []byte(`{"email":"companion@example.com"}`))
+	if _, err := m.Save(ToolCodex, "work", source); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	codexCompanion := m.companionSnapshotPath(ToolCodex, "work", codexAccountFile)
+	if _, err := os.Stat(codexCompanion); err != nil {
+		t.Fatalf("expected companion saved: %v", err)
+	}
+
+	if _, err := m.Move(ToolCodex, "work", ToolPi); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if companions := state.Entries[stateKey(ToolPi, "work")].Companions; len(companions) != 0 {
+		t.Fatalf("expected codex companions dropped on a move to pi, got %+v", companions)
+	}
+	if _, err := os.Stat(codexCompanion); !os.IsNotExist(err) {
+		t.Fatalf("expected dropped companion removed, err=%v", err)
+	}
+
+	// A destination tool that keeps the same companion gets it re-homed.
+	if _, err := m.Save(ToolCodex, "home", source); err != nil {
+		t.Fatalf("Save home: %v", err)
+	}
+	previous := companionFiles[ToolPi]
+	companionFiles[ToolPi] = []string{codexAccountFile}
+	defer func() { companionFiles[ToolPi] = previous }()
+	if _, err := m.Move(ToolCodex, "home", ToolPi); err != nil {
+		t.Fatalf("Move home: %v", err)
+	}
+	state, err = m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	piCompanion := m.companionSnapshotPath(ToolPi, "home", codexAccountFile)
+	if got := state.Entries[stateKey(ToolPi, "home")].Companions[codexAccountFile]; got != piCompanion {
+		t.Fatalf("expected companion re-homed to %s, got %q", piCompanion, got)
+	}
+	if raw, err := os.ReadFile(piCompanion); err != nil || string(raw) != `{"email":"companion@example.com"}` {
+		t.Fatalf("re-homed companion not written: %q %v", raw, err)
+	}
+	if _, err := os.Stat(m.companionSnapshotPath(ToolCodex, "home", codexAccountFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected old companion removed, err=%v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package ags
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// completionCommands are offered for the first word after ags.
+var completionCommands = []string{
+	"save", "use", "delete", "list", "active", "check", "restore-state", "gc", "doctor", "dedupe",
+	"alias", "note", "tag", "touch", "snapshot-path", "move", "providers", "find", "diff", "default",
+	"config", "cache", "batch", "inspect", "whoami", "history", "diag", "link", "export-env", "completion", "version", "help",
+}
+
+// completionToolCommands take a tool as their first argument.
+var completionToolCommands = []string{
+	"save", "use", "delete", "list", "active", "check", "dedupe", "note", "touch", "snapshot-path", "move",
+	"diff", "inspect", "whoami", "history", "diag", "link", "export-env",
+}
+
+// completionLabelCommands take a saved label after the tool; the scripts
+// complete it from ags list --plain.
+var completionLabelCommands = []string{
+	"save", "use", "delete", "note", "touch", "snapshot-path", "move", "diff", "inspect", "history", "export-env",
+}
+
+const bashCompletionTemplate = `# bash completion for ags
+_ags() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	case "$COMP_CWORD" in
+	1)
+		COMPREPLY=($(compgen -W "%[1]s" -- "$cur"))
+		;;
+	2)
+		case "${COMP_WORDS[1]}" in
+		%[2]s) COMPREPLY=($(compgen -W "codex pi" -- "$cur")) ;;
+		esac
+		;;
+	3)
+		case "${COMP_WORDS[1]}" in
+		%[3]s) COMPREPLY=($(compgen -W "$(ags list "${COMP_WORDS[2]}" --plain --no-headers 2>/dev/null | cut -f2)" -- "$cur")) ;;
+		esac
+		;;
+	esac
+}
+complete -F _ags ags
+`
+
+const zshCompletionTemplate = `#compdef ags
+
+_ags() {
+	local -a commands tools labels
+	commands=(%[1]s)
+	tools=(codex pi)
+	case $CURRENT in
+	2)
+		compadd -a commands
+		;;
+	3)
+		case ${words[2]} in
+		%[2]s) compadd -a tools ;;
+		esac
+		;;
+	4)
+		case ${words[2]} in
+		%[3]s)
+			labels=(${(f)"$(ags list ${words[3]} --plain --no-headers 2>/dev/null | cut -f2)"})
+			compadd -a labels
+			;;
+		esac
+		;;
+	esac
+}
+
+_ags "$@"
+`
+
+const fishCompletionTemplate = `# fish completion for ags
+function __ags_labels
+	set -l tokens (commandline -opc)
+	if test (count $tokens) -eq 3
+		ags list $tokens[3] --plain --no-headers 2>/dev/null | cut -f2
+	end
+end
+
+complete -c ags -f
+complete -c ags -n '__fish_use_subcommand' -a '%[1]s'
+complete -c ags -n 'test (count (commandline -opc)) -eq 2; and __fish_seen_subcommand_from %[2]s' -a 'codex pi'
+complete -c ags -n '__fish_seen_subcommand_from %[3]s' -a '(__ags_labels)'
+`
+
+// completionScript returns the completion script for shell.
+func completionScript(shell string) (string, error) {
+	commands := strings.Join(completionCommands, " ")
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(bashCompletionTemplate, commands, strings.Join(completionToolCommands, "|"), strings.Join(completionLabelCommands, "|")), nil
+	case "zsh":
+		return fmt.Sprintf(zshCompletionTemplate, commands, strings.Join(completionToolCommands, "|"), strings.Join(completionLabelCommands, "|")), nil
+	case "fish":
+		return fmt.Sprintf(fishCompletionTemplate, commands, strings.Join(completionToolCommands, " "), strings.Join(completionLabelCommands, " ")), nil
+	default:
+		return "", invalidInputf("unsupported shell %q. expected one of: bash, zsh, fish", shell)
+	}
+}
+
+// completionInstallPath is where completion --install writes the script
+// for shell: the per-user directory each shell searches by default, or for
+// zsh one that must be added to fpath.
+func completionInstallPath(shell string) (string, error) {
+	home, err := userHomeDir()
+	if err != nil {
+		return "", ioErrorf("resolving home directory: %w", err)
+	}
+	xdg := func(env string, fallback string) string {
+		if dir := strings.TrimSpace(os.Getenv(env)); dir != "" {
+			return dir
+		}
+		return filepath.Join(home, fallback)
+	}
+	switch shell {
+	case "bash":
+		return filepath.Join(xdg("XDG_DATA_HOME", ".local/share"), "bash-completion", "completions", "ags"), nil
+	case "zsh":
+		return filepath.Join(home, ".zsh", "completions", "_ags"), nil
+	case "fish":
+		return filepath.Join(xdg("XDG_CONFIG_HOME", ".config"), "fish", "completions", "ags.fish"), nil
+	default:
+		return "", invalidInputf("unsupported shell %q. expected one of: bash, zsh, fish", shell)
+	}
+}
+
+// installCompletion writes the completion script for shell to its install
+// path and returns that path. An existing file is only replaced with force.
+func installCompletion(shell string, force bool) (string, error) {
+	script, err := completionScript(shell)
+	if err != nil {
+		return "", err
+	}
+	path, err := completionInstallPath(shell)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil && !force {
+		return "", classify(ErrAlreadyExists, fmt.Errorf("%s already exists; pass --force to replace it", path))
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", ioErrorf("checking completion file: %w", err)
+	}
+	if err := atomicWriteFile(path, []byte(script), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// completionInstallHint tells the user what is left to do after install.
+// Only zsh needs a config change; the others pick the file up on their own.
+func completionInstallHint(shell string, path string) string {
+	if shell == "zsh" {
+		dir := filepath.Dir(path)
+		return fmt.Sprintf("Add %s to fpath before compinit in ~/.zshrc:\n  fpath=(%s $fpath)\n", dir, dir)
+	}
+	return "Start a new shell to use it.\n"
+}
@@ -0,0 +1,112 @@
+package ags
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell)
+		if err != nil {
+			t.Fatalf("completionScript(%s): %v", shell, err)
+		}
+		if strings.Contains(script, "%!") {
+			t.Fatalf("%s script has a formatting error:\n%s", shell, script)
+		}
+		for _, want := range []string{"restore-state", "export-env", "codex pi", "ags list"} {
+			if !strings.Contains(script, want) {
+				t.Fatalf("%s script missing %q:\n%s", shell, want, script)
+			}
+		}
+	}
+	if _, err := completionScript("tcsh"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected unsupported shell to be rejected, got %v", err)
+	}
+}
+
+func TestCompletionCommandsAreKnown(t *testing.T) {
+	for _, command := range completionCommands {
+		if command == "help" {
+			continue
+		}
+		if err := Run([]string{"help", command}, nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("completion offers %q, which has no help topic: %v", command, err)
+		}
+	}
+}
+
+func TestRunCompletionInstall(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg-config"))
+
+	var out bytes.Buffer
+	if err := Run([]string{"completion", "bash"}, nil, &out, &out); err != nil {
+		t.Fatalf("completion bash: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "# bash completion for ags\n") {
+		t.Fatalf("unexpected bash script: %q", out.String())
+	}
+
+	bashPath := filepath.Join(home, ".local", "share", "bash-completion", "completions", "ags")
+	out.Reset()
+	if err := Run([]string{"completion", "bash", "--install"}, nil, &out, &out); err != nil {
+		t.Fatalf("completion bash --install: %v", err)
+	}
+	if !strings.Contains(out.String(), "Installed bash completion to "+bashPath) {
+		t.Fatalf("unexpected install output: %q", out.String())
+	}
+	raw, err := os.ReadFile(bashPath)
+	if err != nil {
+		t.Fatalf("read installed script: %v", err)
+	}
+	if script, _ := completionScript("bash"); string(raw) != script {
+		t.Fatalf("installed script differs from printed one")
+	}
+
+	writeFile(t, bashPath, []byte("# mine\n"))
+	if err := Run([]string{"completion", "bash", "--install"}, nil, io.Discard, io.Discard); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected existing file to be kept, got %v", err)
+	}
+	if raw, _ := os.ReadFile(bashPath); string(raw) != "# mine\n" {
+		t.Fatalf("existing file was overwritten: %q", raw)
+	}
+	if err := Run([]string{"completion", "bash", "--install", "--force"}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("completion bash --install --force: %v", err)
+	}
+	if raw, _ := os.ReadFile(bashPath); string(raw) == "# mine\n" {
+		t.Fatal("--force did not replace the existing file")
+	}
+
+	out.Reset()
+	if err := Run([]string{"completion", "zsh", "--install"}, nil, &out, &out); err != nil {
+		t.Fatalf("completion zsh --install: %v", err)
+	}
+	if !strings.Contains(out.String(), "fpath=("+filepath.Join(home, ".zsh", "completions")+" $fpath)") {
+		t.Fatalf("expected fpath hint, got %q", out.String())
+	}
+	if err := Run([]string{"completion", "fish", "--install"}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("completion fish --install: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "xdg-config", "fish", "completions", "ags.fish")); err != nil {
+		t.Fatalf("expected fish script under XDG_CONFIG_HOME: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"completion"},
+		{"completion", "tcsh"},
+		{"completion", "bash", "--force"},
+		{"completion", "bash", "zsh"},
+	} {
+		if err := Run(args, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
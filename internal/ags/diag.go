@@ -0,0 +1,78 @@
+package ags
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// secretKeyMarkers flag a JSON key whose string value is treated as a
+// secret, matched case-insensitively as a substring.
+var secretKeyMarkers = []string{"token", "secret", "password", "key"}
+
+// RedactedSnapshot returns a saved snapshot decoded as JSON with every
+// secret string replaced by "sha256:<prefix>", so its layout can be shared
+// without the credentials in it.
+func (m *Manager) RedactedSnapshot(tool Tool, label string) (any, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := m.readSavedSnapshot(state, tool, label)
+	if err != nil {
+		return nil, err
+	}
+	var payload any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, invalidInputf("snapshot for %s label=%q is not valid JSON: %w", tool, label, err)
+	}
+	return redactAuthValue("", payload), nil
+}
+
+// redactAuthValue walks a decoded auth document. Strings under a secret
+// key, and strings that look like tokens wherever they appear, are replaced
+// by a hash prefix; everything else is kept.
+func redactAuthValue(key string, value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(typed))
+		for childKey, child := range typed {
+			out[childKey] = redactAuthValue(childKey, child)
+		}
+		return out
+	case []any:
+		out := make([]any, len(typed))
+		for i, child := range typed {
+			out[i] = redactAuthValue(key, child)
+		}
+		return out
+	case string:
+		if isSecretKey(key) || looksLikeToken(typed) {
+			return "sha256:" + shortHash(sha256Hex([]byte(typed)))
+		}
+		return typed
+	default:
+		return typed
+	}
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if containsString(piSecretFields, key) {
+		return true
+	}
+	for _, marker := range secretKeyMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeToken catches secrets under unexpected keys: JWTs and other
+// long opaque strings. Emails and anything with spaces are left alone.
+func looksLikeToken(value string) bool {
+	return len(value) >= 40 && !strings.ContainsAny(value, " @")
+}
@@ -0,0 +1,38 @@
+package ags
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRedactAuthValue(t *testing.T) {
+	var payload any
+	raw := `{
+		"OPENAI_API_KEY": "sk-short",
+		"tokens": {"access_token": "a.b.c", "account_id": "acct_1"},
+		"anthropic": {"type": "oauth", "access": "x", "refresh": "y", "expires": 1700000000000},
+		"note": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"email": "someone.with.a.very.long.address@example-company.com",
+		"scopes": ["openid", "profile"]
+	}`
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	got := redactAuthValue("", payload).(map[string]any)
+	hashed := func(value string) string {
+		return "sha256:" + shortHash(sha256Hex([]byte(value)))
+	}
+	want := map[string]any{
+		"OPENAI_API_KEY": hashed("sk-short"),
+		"tokens":         map[string]any{"access_token": hashed("a.b.c"), "account_id": "acct_1"},
+		"anthropic":      map[string]any{"type": "oauth", "access": hashed("x"), "refresh": hashed("y"), "expires": float64(1700000000000)},
+		"note":           hashed("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		"email":          "someone.with.a.very.long.address@example-company.com",
+		"scopes":         []any{"openid", "profile"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected redaction:\n got %#v\nwant %#v", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package ags
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// codexTokenFields are compared by value only; their contents never appear
+// in diff output.
+var codexTokenFields = []string{"access_token", "id_token", "refresh_token"}
+
+// piSecretFields are the per-provider fields treated as opaque tokens.
+var piSecretFields = []string{"access", "refresh"}
+
+// Diff compares two saved snapshots of the same tool. Token values are
+// compared but never reported.
+func (m *Manager) Diff(tool Tool, labelA string, labelB string) (*DiffResult, error) {
+	if err := m.validateToolAndLabel(tool, labelA); err != nil {
+		return nil, err
+	}
+	if err := m.validateLabel(labelB); err != nil {
+		return nil, err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	rawA, err := m.readSavedSnapshot(state, tool, labelA)
+	if err != nil {
+		return nil, err
+	}
+	rawB, err := m.readSavedSnapshot(state, tool, labelB)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloadA, payloadB map[string]any
+	if err := json.Unmarshal(rawA, &payloadA); err != nil {
+		return nil, invalidInputf("snapshot %s is not valid JSON: %w", labelA, err)
+	}
+	if err := json.Unmarshal(rawB, &payloadB); err != nil {
+		return nil, invalidInputf("snapshot %s is not valid JSON: %w", labelB, err)
+	}
+
+	result := &DiffResult{Tool: tool, LabelA: labelA, LabelB: labelB}
+	switch tool {
+	case ToolCodex:
+		insightA := m.inspect(tool, rawA)
+		insightB := m.inspect(tool, rawB)
+		m.hydrateIdentity(&insightA, state)
+		m.hydrateIdentity(&insightB, state)
+		result.Changes = diffCodex(payloadA, payloadB, insightA, insightB)
+	case ToolPi:
+		result.Changes = diffPi(payloadA, payloadB)
+	}
+	return result, nil
+}
+
+func (m *Manager) readSavedSnapshot(state State, tool Tool, label string) ([]byte, error) {
+	entry, ok := state.Entries[stateKey(tool, label)]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+	raw, err := m.readSnapshotFile(entry.SnapshotPath)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	return raw, nil
+}
+
+func diffCodex(payloadA, payloadB map[string]any, insightA, insightB AuthInsight) []DiffChange {
+	var changes []DiffChange
+	changes = appendValueChange(changes, "account_id", insightA.AccountID, insightB.AccountID)
+	changes = appendValueChange(changes, "account_email", insightA.AccountEmail, insightB.AccountEmail)
+	changes = appendValueChange(changes, "account_plan", insightA.AccountPlan, insightB.AccountPlan)
+	changes = appendValueChange(changes, "expires_at", insightA.ExpiresAt, insightB.ExpiresAt)
+	changes = appendValueChange(changes, "last_refresh", insightA.LastRefresh, insightB.LastRefresh)
+
+	tokensA, _, _ := findCodexTokens(payloadA)
+	tokensB, _, _ := findCodexTokens(payloadB)
+	for _, field := range codexTokenFields {
+		changes = appendSecretChange(changes, "tokens."+field, extractStringClaim(tokensA, field), extractStringClaim(tokensB, field))
+	}
+	return changes
+}
+
+func diffPi(payloadA, payloadB map[string]any) []DiffChange {
+	keys := make([]string, 0, len(payloadA)+len(payloadB))
+	for key := range payloadA {
+		keys = append(keys, key)
+	}
+	for key := range payloadB {
+		if _, ok := payloadA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []DiffChange
+	for _, key := range keys {
+		field := "providers." + key
+		entryA, inA := payloadA[key]
+		entryB, inB := payloadB[key]
+		switch {
+		case !inA:
+			changes = append(changes, DiffChange{Field: field, Kind: "added"})
+			continue
+		case !inB:
+			changes = append(changes, DiffChange{Field: field, Kind: "removed"})
+			continue
+		}
+
+		providerA, _ := entryA.(map[string]any)
+		providerB, _ := entryB.(map[string]any)
+		changes = appendValueChange(changes, field+".expires", piProviderExpiry(providerA), piProviderExpiry(providerB))
+		for _, secret := range piSecretFields {
+			changes = appendSecretChange(changes, field+"."+secret, extractStringClaim(providerA, secret), extractStringClaim(providerB, secret))
+		}
+	}
+	return changes
+}
+
+func piProviderExpiry(entry map[string]any) string {
+	expMillis, ok := numberToFloat(entry["expires"])
+	if !ok {
+		return ""
+	}
+	return time.UnixMilli(int64(expMillis)).UTC().Format(time.RFC3339)
+}
+
+func appendValueChange(changes []DiffChange, field string, from string, to string) []DiffChange {
+	if kind := diffKind(from, to); kind != "" {
+		changes = append(changes, DiffChange{Field: field, Kind: kind, From: from, To: to})
+	}
+	return changes
+}
+
+func appendSecretChange(changes []DiffChange, field string, from string, to string) []DiffChange {
+	if kind := diffKind(from, to); kind != "" {
+		changes = append(changes, DiffChange{Field: field, Kind: kind, Redacted: true})
+	}
+	return changes
+}
+
+func diffKind(from string, to string) string {
+	switch {
+	case from == to:
+		return ""
+	case from == "":
+		return "added"
+	case to == "":
+		return "removed"
+	default:
+		return "changed"
+	}
+}
@@ -0,0 +1,183 @@
+package ags
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func saveTestSnapshot(t *testing.T, m *Manager, tool Tool, label string, raw []byte) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), label+".json")
+	writeFile(t, src, raw)
+	if _, err := m.Save(tool, label, src); err != nil {
+		t.Fatalf("save %s %s: %v", tool, label, err)
+	}
+}
+
+func TestManagerDiffCodex(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	exp := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	saveTestSnapshot(t, m, ToolCodex, "work", makeCodexAuthJSONWithIdentity(t, exp, "acct_1", "a@company.com", "pro"))
+	saveTestSnapshot(t, m, ToolCodex, "clone", makeCodexAuthJSONWithIdentity(t, exp.Add(time.Hour), "acct_1", "b@company.com", ""))
+
+	result, err := m.Diff(ToolCodex, "work", "clone")
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	got := map[string]DiffChange{}
+	for _, change := range result.Changes {
+		got[change.Field] = change
+	}
+	if _, ok := got["account_id"]; ok {
+		t.Fatalf("expected unchanged account id to be omitted, got %+v", result.Changes)
+	}
+	if c := got["account_email"]; c.Kind != "changed" || c.From != "a@company.com" || c.To != "b@company.com" {
+		t.Fatalf("unexpected email change: %+v", c)
+	}
+	if c := got["account_plan"]; c.Kind != "removed" || c.From != "Pro" {
+		t.Fatalf("unexpected plan change: %+v", c)
+	}
+	if c := got["expires_at"]; c.Kind != "changed" {
+		t.Fatalf("expected expiry change, got %+v", c)
+	}
+	for _, field := range []string{"tokens.access_token", "tokens.id_token"} {
+		c := got[field]
+		if c.Kind != "changed" || !c.Redacted || c.From != "" || c.To != "" {
+			t.Fatalf("expected redacted token change for %s, got %+v", field, c)
+		}
+	}
+
+	same, err := m.Diff(ToolCodex, "work", "work")
+	if err != nil || len(same.Changes) != 0 {
+		t.Fatalf("expected no changes diffing a label with itself, got %+v err=%v", same, err)
+	}
+}
+
+func TestManagerDiffPi(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	saveTestSnapshot(t, m, ToolPi, "a", []byte(`{"anthropic":{"access":"tok-1","expires":1700000000000},"gone":{"access":"x"}}`))
+	saveTestSnapshot(t, m, ToolPi, "b", []byte(`{"anthropic":{"access":"tok-2","refresh":"r","expires":1800000000000},"new":{"access":"y"}}`))
+
+	result, err := m.Diff(ToolPi, "a", "b")
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	var lines []string
+	for _, c := range result.Changes {
+		lines = append(lines, c.Kind+" "+c.Field)
+		if c.Redacted && (c.From != "" || c.To != "") {
+			t.Fatalf("redacted change leaked values: %+v", c)
+		}
+	}
+	want := "changed providers.anthropic.expires,changed providers.anthropic.access,added providers.anthropic.refresh,removed providers.gone,added providers.new"
+	if strings.Join(lines, ",") != want {
+		t.Fatalf("unexpected pi diff:\n%s\nwant\n%s", strings.Join(lines, ","), want)
+	}
+}
+
+func TestManagerDiffErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	saveTestSnapshot(t, m, ToolCodex, "work", makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	if _, err := m.Diff(ToolCodex, "work", "missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := m.Diff(ToolCodex, "bad/label", "work"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid label, got %v", err)
+	}
+	if _, err := m.Diff(ToolCodex, "work", "bad/label"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid second label, got %v", err)
+	}
+	if _, err := m.Diff(Tool("bad"), "work", "work"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool, got %v", err)
+	}
+
+	writeFile(t, m.snapshotPath(ToolCodex, "work"), []byte("not json"))
+	if _, err := m.Diff(ToolCodex, "work", "work"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid snapshot JSON, got %v", err)
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	srcA := filepath.Join(root, "a.json")
+	srcB := filepath.Join(root, "b.json")
+	writeFile(t, srcA, []byte(`{"anthropic":{"access":"secret-one"}}`))
+	writeFile(t, srcB, []byte(`{"anthropic":{"access":"secret-two"},"openai-codex":{"access":"x"}}`))
+
+	var out strings.Builder
+	for _, args := range [][]string{
+		{"save", "pi", "a", "--source", srcA, "--root", root},
+		{"save", "pi", "b", "--source", srcB, "--root", root},
+	} {
+		if err := Run(args, nil, &out, &out); err != nil {
+			t.Fatalf("Run %v: %v", args, err)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"diff", "pi", "a", "b", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	want := "Diff pi a -> b\n  changed  providers.anthropic.access: token changed\n  added    providers.openai-codex\n"
+	if out.String() != want {
+		t.Fatalf("unexpected diff output:\n%q\nwant\n%q", out.String(), want)
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Fatalf("diff output leaked a token: %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"diff", "pi", "a", "a", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("diff same: %v", err)
+	}
+	if !strings.Contains(out.String(), "no differences") {
+		t.Fatalf("expected no differences, got %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"diff", "pi", "a", "--root", root},
+		{"diff", "bad", "a", "b", "--root", root},
+		{"diff", "pi", "a", "bad/label", "--root", root},
+		{"diff", "pi", "a", "b", "--nope"},
+	} {
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Run %v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestFormatDiffValues(t *testing.T) {
+	for _, tc := range []struct {
+		change DiffChange
+		want   string
+	}{
+		{DiffChange{Kind: "added", To: "x"}, ": x"},
+		{DiffChange{Kind: "removed", From: "x"}, ": x"},
+		{DiffChange{Kind: "changed", From: "x", To: "y"}, ": x -> y"},
+		{DiffChange{Kind: "changed", Redacted: true}, ": token changed"},
+		{DiffChange{Kind: "added", Redacted: true}, ""},
+		{DiffChange{Kind: "other"}, ""},
+	} {
+		if got := formatDiffValues(tc.change); got != tc.want {
+			t.Fatalf("formatDiffValues(%+v) = %q, want %q", tc.change, got, tc.want)
+		}
+	}
+}
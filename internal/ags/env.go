@@ -0,0 +1,114 @@
+package ags
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnvVar is one credential exported by ags export-env. ExpiresAt is RFC3339
+// when the token's expiry is known.
+type EnvVar struct {
+	Name      string
+	Value     string
+	ExpiresAt string
+}
+
+// ExportEnv reads a saved snapshot and returns the environment variables
+// that carry its credentials. The values are secrets.
+func (m *Manager) ExportEnv(tool Tool, label string) ([]EnvVar, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := m.readSavedSnapshot(state, tool, label)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, invalidInputf("snapshot is not valid JSON: %w", err)
+	}
+
+	var vars []EnvVar
+	switch tool {
+	case ToolCodex:
+		vars = codexEnvVars(payload)
+	case ToolPi:
+		vars = piEnvVars(payload)
+	}
+	if len(vars) == 0 {
+		return nil, notFoundf("no exportable tokens in %s label=%q", tool, label)
+	}
+	return vars, nil
+}
+
+func codexEnvVars(payload map[string]any) []EnvVar {
+	var vars []EnvVar
+	tokens, _, _ := findCodexTokens(payload)
+	if access := extractStringClaim(tokens, "access_token"); access != "" {
+		vars = append(vars, EnvVar{Name: "CODEX_ACCESS_TOKEN", Value: access, ExpiresAt: jwtExpiryString(access)})
+	}
+	if accountID := extractStringClaim(tokens, "account_id"); accountID != "" {
+		vars = append(vars, EnvVar{Name: "CODEX_ACCOUNT_ID", Value: accountID})
+	}
+	if apiKey := extractStringClaim(payload, "OPENAI_API_KEY"); apiKey != "" {
+		vars = append(vars, EnvVar{Name: "OPENAI_API_KEY", Value: apiKey})
+	}
+	return vars
+}
+
+// piEnvVars names variables after each provider key, e.g. anthropic becomes
+// ANTHROPIC_ACCESS_TOKEN and openai-codex becomes OPENAI_CODEX_ACCESS_TOKEN.
+func piEnvVars(payload map[string]any) []EnvVar {
+	keys := make([]string, 0, len(payload))
+	for key := range payload {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var vars []EnvVar
+	for _, key := range keys {
+		entry, ok := payload[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		prefix := envVarPrefix(key)
+		if access := extractStringClaim(entry, "access"); access != "" {
+			vars = append(vars, EnvVar{Name: prefix + "_ACCESS_TOKEN", Value: access, ExpiresAt: piProviderExpiry(entry)})
+		}
+		if apiKey := extractStringClaim(entry, "key"); apiKey != "" {
+			vars = append(vars, EnvVar{Name: prefix + "_API_KEY", Value: apiKey})
+		}
+	}
+	return vars
+}
+
+func envVarPrefix(providerKey string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(providerKey) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	prefix := b.String()
+	if prefix == "" || (prefix[0] >= '0' && prefix[0] <= '9') {
+		prefix = "PI_" + prefix
+	}
+	return prefix
+}
+
+func jwtExpiryString(token string) string {
+	expiry, ok := extractJWTExpiry(token)
+	if !ok {
+		return ""
+	}
+	return expiry.Format(time.RFC3339)
+}
@@ -0,0 +1,138 @@
+package ags
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerExportEnvCodex(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	exp := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	saveTestSnapshot(t, m, ToolCodex, "work", makeCodexAuthJSONWithIdentity(t, exp, "acct_1", "", ""))
+
+	vars, err := m.ExportEnv(ToolCodex, "work")
+	if err != nil {
+		t.Fatalf("ExportEnv: %v", err)
+	}
+	if len(vars) != 2 || vars[0].Name != "CODEX_ACCESS_TOKEN" || vars[1].Name != "CODEX_ACCOUNT_ID" {
+		t.Fatalf("unexpected vars: %+v", vars)
+	}
+	if vars[0].ExpiresAt != exp.Format(time.RFC3339) {
+		t.Fatalf("expected access token expiry %s, got %q", exp.Format(time.RFC3339), vars[0].ExpiresAt)
+	}
+	if vars[1].Value != "acct_1" || vars[1].ExpiresAt != "" {
+		t.Fatalf("unexpected account var: %+v", vars[1])
+	}
+
+	saveTestSnapshot(t, m, ToolCodex, "apikey", []byte(`{"OPENAI_API_KEY":"sk-test"}`))
+	vars, err = m.ExportEnv(ToolCodex, "apikey")
+	if err != nil || len(vars) != 1 || vars[0].Name != "OPENAI_API_KEY" {
+		t.Fatalf("expected OPENAI_API_KEY export, got %+v err=%v", vars, err)
+	}
+
+	saveTestSnapshot(t, m, ToolCodex, "empty", []byte(`{"tokens":{}}`))
+	if _, err := m.ExportEnv(ToolCodex, "empty"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found for snapshot without tokens, got %v", err)
+	}
+	if _, err := m.ExportEnv(ToolCodex, "missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found for missing label, got %v", err)
+	}
+	if _, err := m.ExportEnv(Tool("bad"), "work"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool, got %v", err)
+	}
+}
+
+func TestManagerExportEnvPi(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	saveTestSnapshot(t, m, ToolPi, "work", []byte(`{
+		"openai-codex":{"access":"codex-tok","expires":1800000000000},
+		"anthropic":{"type":"api_key","key":"anthro-key"},
+		"9lives":{"access":"x"},
+		"notes":"ignored"
+	}`))
+
+	vars, err := m.ExportEnv(ToolPi, "work")
+	if err != nil {
+		t.Fatalf("ExportEnv: %v", err)
+	}
+	var names []string
+	for _, v := range vars {
+		names = append(names, v.Name)
+	}
+	if got := strings.Join(names, ","); got != "PI_9LIVES_ACCESS_TOKEN,ANTHROPIC_API_KEY,OPENAI_CODEX_ACCESS_TOKEN" {
+		t.Fatalf("unexpected pi var names: %s", got)
+	}
+	if vars[2].ExpiresAt != time.UnixMilli(1800000000000).UTC().Format(time.RFC3339) {
+		t.Fatalf("unexpected pi expiry: %+v", vars[2])
+	}
+}
+
+func TestRunExportEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "pi.json")
+	writeFile(t, src, []byte(`{"anthropic":{"access":"it's-secret","expires":1800000000000}}`))
+
+	var out strings.Builder
+	if err := Run([]string{"save", "pi", "work", "--source", src, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	out.Reset()
+	if err := Run([]string{"export-env", "pi", "work", "--root", root}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "--reveal") {
+		t.Fatalf("expected --reveal requirement, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected nothing printed without --reveal, got %q", out.String())
+	}
+
+	if err := Run([]string{"export-env", "pi", "work", "--reveal", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("export-env: %v", err)
+	}
+	if !strings.Contains(out.String(), "# ANTHROPIC_ACCESS_TOKEN expires ") {
+		t.Fatalf("expected expiry comment, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), `export ANTHROPIC_ACCESS_TOKEN='it'\''s-secret'`+"\n") {
+		t.Fatalf("expected sh export line, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := Run([]string{"export-env", "pi", "work", "--reveal", "--format", "fish", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("export-env fish: %v", err)
+	}
+	if !strings.Contains(out.String(), `set -x ANTHROPIC_ACCESS_TOKEN 'it\'s-secret'`+"\n") {
+		t.Fatalf("expected fish line, got %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"export-env", "pi", "--reveal", "--root", root},
+		{"export-env", "pi", "work", "--reveal", "--format", "csh", "--root", root},
+		{"export-env", "bad", "work", "--reveal", "--root", root},
+		{"export-env", "pi", "bad/label", "--reveal", "--root", root},
+		{"export-env", "pi", "work", "--nope"},
+	} {
+		if err := Run(args, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Run %v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestShellAndFishQuote(t *testing.T) {
+	if got := shellQuote(`a'b\c`); got != `'a'\''b\c'` {
+		t.Fatalf("shellQuote = %s", got)
+	}
+	if got := fishQuote(`a'b\c`); got != `'a\'b\\c'` {
+		t.Fatalf("fishQuote = %s", got)
+	}
+}
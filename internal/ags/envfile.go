@@ -0,0 +1,124 @@
+package ags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// EnvFileConfig is one env_files entry in config.json: a file ags use
+// --env-passthrough renders from Template and writes to Path.
+type EnvFileConfig struct {
+	Path     string `json:"path"`
+	Template string `json:"template"`
+}
+
+// envFileSpec is a validated EnvFileConfig.
+type envFileSpec struct {
+	path     string
+	template *template.Template
+}
+
+// envFileData is what an env file template sees. Env holds the credential
+// variables ags export-env would print for the snapshot, so tokens are
+// reached as {{.Env.CODEX_ACCESS_TOKEN}}.
+type envFileData struct {
+	Tool    string
+	Label   string
+	Insight AuthInsight
+	Env     map[string]string
+}
+
+// envFileWrite is a rendered env file waiting to be written.
+type envFileWrite struct {
+	path string
+	raw  []byte
+}
+
+// parseEnvFiles validates env_files from config.json. Paths must be absolute
+// after ~ expansion; they are the only places env files are written.
+func parseEnvFiles(raw map[string][]EnvFileConfig) (map[Tool][]envFileSpec, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	specs := make(map[Tool][]envFileSpec, len(raw))
+	for name, files := range raw {
+		tool, ok := ParseTool(strings.ToLower(strings.TrimSpace(name)))
+		if !ok {
+			return nil, invalidInputf("config env_files: invalid tool %q. expected one of: codex, pi", name)
+		}
+		for i, file := range files {
+			path, err := expandPath(strings.TrimSpace(file.Path))
+			if err != nil {
+				return nil, invalidInputf("config env_files.%s[%d].path: %v", name, i, err)
+			}
+			if !filepath.IsAbs(path) {
+				return nil, invalidInputf("config env_files.%s[%d].path must be absolute, got %q", name, i, file.Path)
+			}
+			if strings.TrimSpace(file.Template) == "" {
+				return nil, invalidInputf("config env_files.%s[%d].template is empty", name, i)
+			}
+			tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(file.Template)
+			if err != nil {
+				return nil, invalidInputf("config env_files.%s[%d].template: %v", name, i, err)
+			}
+			specs[tool] = append(specs[tool], envFileSpec{path: filepath.Clean(path), template: tmpl})
+		}
+	}
+	return specs, nil
+}
+
+// renderEnvFiles renders tool's env files for the snapshot being applied.
+// Rendering happens before the switch so a template error aborts it.
+func (m *Manager) renderEnvFiles(tool Tool, label string, snapshotRaw []byte, insight AuthInsight) ([]envFileWrite, error) {
+	specs := m.envFiles[tool]
+	if len(specs) == 0 {
+		return nil, invalidInputf("no env_files configured for %s in config.json", tool)
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(snapshotRaw, &payload); err != nil {
+		return nil, invalidInputf("snapshot is not valid JSON: %w", err)
+	}
+	var vars []EnvVar
+	switch tool {
+	case ToolCodex:
+		vars = codexEnvVars(payload)
+	case ToolPi:
+		vars = piEnvVars(payload)
+	}
+	data := envFileData{Tool: tool.String(), Label: label, Insight: insight, Env: make(map[string]string, len(vars))}
+	for _, v := range vars {
+		data.Env[v.Name] = v.Value
+	}
+
+	writes := make([]envFileWrite, 0, len(specs))
+	for _, spec := range specs {
+		path, err := m.checkSymlink(spec.path, false)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := spec.template.Execute(&buf, data); err != nil {
+			return nil, invalidInputf("rendering env file %s: %v", spec.path, err)
+		}
+		writes = append(writes, envFileWrite{path: path, raw: buf.Bytes()})
+	}
+	return writes, nil
+}
+
+// writeEnvFiles writes rendered env files with owner-only permissions and
+// returns the paths written. It stops at the first failure.
+func (m *Manager) writeEnvFiles(writes []envFileWrite) ([]string, error) {
+	paths := make([]string, 0, len(writes))
+	for _, w := range writes {
+		if err := m.writeFile(w.path, w.raw, 0o600); err != nil {
+			return paths, fmt.Errorf("writing env file %s: %w", w.path, err)
+		}
+		paths = append(paths, w.path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,57 @@
+package ags
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel error classes. Errors returned by this package can be tested with
+// errors.Is against these to tell failure kinds apart without parsing text.
+var (
+	ErrProfileNotFound = errors.New("profile not found")
+	ErrInvalidInput    = errors.New("invalid input")
+	ErrIO              = errors.New("io error")
+	ErrAlreadyExists   = errors.New("already exists")
+
+	// Runtime auth file failures reported by Manager.RuntimeInsight.
+	ErrRuntimeMissing = errors.New("runtime auth file missing")
+	ErrRuntimeEmpty   = errors.New("runtime auth file empty")
+	ErrRuntimeInvalid = errors.New("runtime auth JSON invalid")
+)
+
+// classifiedError tags err with a sentinel class while keeping err's message.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.err, e.class}
+}
+
+func classify(class error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+func invalidInput(msg string) error {
+	return classify(ErrInvalidInput, errors.New(msg))
+}
+
+func invalidInputf(format string, args ...any) error {
+	return classify(ErrInvalidInput, fmt.Errorf(format, args...))
+}
+
+func notFoundf(format string, args ...any) error {
+	return classify(ErrProfileNotFound, fmt.Errorf(format, args...))
+}
+
+func ioErrorf(format string, args ...any) error {
+	return classify(ErrIO, fmt.Errorf(format, args...))
+}
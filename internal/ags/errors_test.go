@@ -0,0 +1,57 @@
+package ags
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifiedErrors(t *testing.T) {
+	if classify(ErrIO, nil) != nil {
+		t.Fatalf("expected nil passthrough")
+	}
+
+	base := errors.New("disk full")
+	err := classify(ErrIO, base)
+	if err.Error() != "disk full" {
+		t.Fatalf("expected message preserved, got %q", err.Error())
+	}
+	if !errors.Is(err, ErrIO) || !errors.Is(err, base) {
+		t.Fatalf("expected class and cause to match")
+	}
+	if errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("did not expect unrelated class to match")
+	}
+
+	wrapped := fmt.Errorf("writing snapshot: %w", ioErrorf("replacing file: %w", base))
+	if !errors.Is(wrapped, ErrIO) || !errors.Is(wrapped, base) {
+		t.Fatalf("expected class to survive wrapping")
+	}
+
+	if !errors.Is(invalidInput("x"), ErrInvalidInput) || !errors.Is(invalidInputf("%s", "x"), ErrInvalidInput) {
+		t.Fatalf("expected invalid input class")
+	}
+	if !errors.Is(notFoundf("no saved profile %q", "work"), ErrProfileNotFound) {
+		t.Fatalf("expected not found class")
+	}
+}
+
+func TestManagerErrorClasses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.Use(ToolCodex, "missing", ""); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected ErrProfileNotFound, got %v", err)
+	}
+	if _, err := m.Delete(ToolCodex, "missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected ErrProfileNotFound from delete, got %v", err)
+	}
+	if _, err := m.Save(Tool("bad"), "work", ""); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	if _, err := m.Save(ToolCodex, "work", "/does/not/exist.json"); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected ErrIO, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package ags
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultFetchTimeout bounds a save --from-url request when no global
+// --timeout is set.
+const defaultFetchTimeout = 10 * time.Second
+
+// maxFetchBytes caps a save --from-url response body. Auth files are a few
+// KB; anything near this is not one.
+var maxFetchBytes int64 = 1 << 20
+
+// isURLSource reports whether a recorded source path is a URL rather than a
+// file, so nothing is looked up next to it.
+func isURLSource(sourcePath string) bool {
+	return strings.Contains(sourcePath, "://")
+}
+
+// checkFetchHost refuses hosts other than localhost and loopback addresses
+// unless allowRemote is set. Names are not resolved, so a DNS name that
+// happens to point at 127.0.0.1 still needs --allow-remote.
+func checkFetchHost(u *url.URL, allowRemote bool) error {
+	if allowRemote {
+		return nil
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return invalidInputf("refusing to fetch auth from non-local host %q; pass --allow-remote to allow it", host)
+}
+
+// fetchSource GETs auth JSON from an http(s) URL or a unix socket. A
+// unix:///path/to.sock URL requests / on the socket, or the request path
+// given as ?path=/v1/auth. It returns the URL to record as the source,
+// with any password removed, and the body.
+func fetchSource(rawURL string, allowRemote bool, timeout time.Duration) (string, []byte, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", nil, invalidInputf("invalid --from-url: %v", err)
+	}
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+
+	client := &http.Client{Timeout: timeout}
+	target := u
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return "", nil, invalidInputf("invalid --from-url %q: missing host", rawURL)
+		}
+		if err := checkFetchHost(u, allowRemote); err != nil {
+			return "", nil, err
+		}
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return checkFetchHost(req.URL, allowRemote)
+		}
+	case "unix":
+		socket := u.Path
+		if socket == "" {
+			return "", nil, invalidInputf("invalid --from-url %q: expected unix:///path/to.sock", rawURL)
+		}
+		requestPath := u.Query().Get("path")
+		if requestPath == "" {
+			requestPath = "/"
+		}
+		if !strings.HasPrefix(requestPath, "/") {
+			return "", nil, invalidInputf("invalid --from-url %q: path must start with /", rawURL)
+		}
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return errors.New("redirects are not followed on a unix socket")
+		}
+		target = &url.URL{Scheme: "http", Host: "unix", Path: requestPath}
+	default:
+		return "", nil, invalidInputf("invalid --from-url %q: scheme must be http, https, or unix", rawURL)
+	}
+
+	resp, err := client.Get(target.String())
+	if err != nil {
+		var invalid *url.Error
+		if errors.As(err, &invalid) && errors.Is(invalid.Err, ErrInvalidInput) {
+			return "", nil, invalid.Err
+		}
+		return "", nil, ioErrorf("fetching %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", nil, ioErrorf("fetching %s: %s", u.Redacted(), resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes+1))
+	if err != nil {
+		return "", nil, ioErrorf("reading %s: %w", u.Redacted(), err)
+	}
+	if int64(len(raw)) > maxFetchBytes {
+		return "", nil, invalidInputf("response from %s is larger than %d bytes", u.Redacted(), maxFetchBytes)
+	}
+	return u.Redacted(), raw, nil
+}
@@ -0,0 +1,115 @@
+package ags
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSaveFromURL(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	auth := makeCodexAuthJSON(t, time.Now().Add(time.Hour))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.Write(auth)
+		case "/array":
+			w.Write([]byte(`[]`))
+		case "/elsewhere":
+			http.Redirect(w, r, "http://example.com/auth", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--from-url", server.URL + "/auth", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("save --from-url: %v", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(root, "snapshots", "codex", "work.json"))
+	if err != nil || !bytes.Equal(saved, auth) {
+		t.Fatalf("expected the fetched auth as the snapshot, got %q (%v)", saved, err)
+	}
+
+	err = Run([]string{"save", "codex", "gone", "--from-url", server.URL + "/missing", "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrIO) || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected an I/O error for a 404, got %v", err)
+	}
+	if err := Run([]string{"save", "codex", "array", "--from-url", server.URL + "/array", "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected a non-object body to be rejected, got %v", err)
+	}
+	err = Run([]string{"save", "codex", "far", "--from-url", "http://example.com/auth", "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "--allow-remote") {
+		t.Fatalf("expected a remote host to be refused, got %v", err)
+	}
+	err = Run([]string{"save", "codex", "hop", "--from-url", server.URL + "/elsewhere", "--root", root}, nil, io.Discard, io.Discard)
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "non-local host") {
+		t.Fatalf("expected a redirect to a remote host to be refused, got %v", err)
+	}
+
+	previous := maxFetchBytes
+	maxFetchBytes = 16
+	err = Run([]string{"save", "codex", "big", "--from-url", server.URL + "/auth", "--root", root}, nil, io.Discard, io.Discard)
+	maxFetchBytes = previous
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "larger than 16 bytes") {
+		t.Fatalf("expected the size cap to apply, got %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"save", "codex", "x", "--from-url", server.URL + "/auth", "--source", "-"},
+		{"save", "codex", "x", "--from-url", server.URL + "/auth", "--from-active"},
+		{"save", "codex", "x", "--allow-remote"},
+		{"save", "codex", "x", "--from-url", "ftp://127.0.0.1/auth"},
+	} {
+		if err := Run(append(args, "--root", root), nil, io.Discard, io.Discard); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%v: expected invalid input, got %v", args, err)
+		}
+	}
+}
+
+func TestRunSaveFromUnixSocket(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	dir, err := os.MkdirTemp("", "ags-sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "helper.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	pi := []byte(`{"anthropic":{"type":"oauth","access":"a","expires":4102444800000}}`)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pi" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(pi)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	if err := Run([]string{"save", "pi", "home", "--from-url", "unix://" + socket + "?path=/pi", "--root", root}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("save --from-url unix: %v", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(root, "snapshots", "pi", "home.json"))
+	if err != nil || !bytes.Equal(saved, pi) {
+		t.Fatalf("expected the socket's auth as the snapshot, got %q (%v)", saved, err)
+	}
+
+	if err := Run([]string{"save", "pi", "root", "--from-url", "unix://" + socket, "--root", root}, nil, io.Discard, io.Discard); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected the default / path to 404, got %v", err)
+	}
+}
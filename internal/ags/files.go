@@ -1,16 +1,26 @@
 package ags
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 type tempFile interface {
 	Write([]byte) (int, error)
 	Chmod(os.FileMode) error
+	Sync() error
 	Close() error
 	Name() string
 }
@@ -21,11 +31,38 @@ var (
 	createTemp  = func(dir string, pattern string) (tempFile, error) { return os.CreateTemp(dir, pattern) }
 	removePath  = os.Remove
 	renamePath  = os.Rename
+	syncDir     = syncDirectory
+	probeDir    = probeWritable
 )
 
+// parseFileMode parses an octal permission mode such as 0640 for a
+// credential file. The owner must keep read and write access, since the tool
+// rewrites the file when it refreshes tokens.
+func parseFileMode(value string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || parsed > 0o777 {
+		return 0, invalidInputf("mode must be an octal permission like 0600, got %q", value)
+	}
+	mode := os.FileMode(parsed)
+	if err := validateFileMode(mode); err != nil {
+		return 0, err
+	}
+	return mode, nil
+}
+
+func validateFileMode(mode os.FileMode) error {
+	if mode&^os.ModePerm != 0 {
+		return invalidInputf("mode %v is not a plain permission mode", mode)
+	}
+	if mode&0o600 != 0o600 {
+		return invalidInputf("mode %04o must keep owner read and write (0600)", mode)
+	}
+	return nil
+}
+
 func expandPath(path string) (string, error) {
 	if strings.TrimSpace(path) == "" {
-		return "", fmt.Errorf("path cannot be empty")
+		return "", invalidInput("path cannot be empty")
 	}
 
 	if strings.HasPrefix(path, "~") {
@@ -43,37 +80,224 @@ func expandPath(path string) (string, error) {
 	return path, nil
 }
 
+// checkSymlink refuses a path that is a symlink unless follow is set, in
+// which case it returns the file the link points at. Missing paths pass.
+func checkSymlink(path string, follow bool) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		}
+		return "", ioErrorf("inspecting %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	if !follow {
+		return "", invalidInputf("%s is a symlink; refusing to follow it without --follow-symlinks", path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", ioErrorf("resolving symlink %s: %w", path, err)
+	}
+	return resolved, nil
+}
+
+// gzipSuffix is appended to the snapshot file name of compressed snapshots.
+// Readers sniff the gzip magic bytes rather than trusting the name.
+const gzipSuffix = ".gz"
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readSnapshotFile reads a snapshot, decompressing it when it is gzipped.
+func (m *Manager) readSnapshotFile(path string) ([]byte, error) {
+	raw, err := m.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSnapshot(raw)
+}
+
+func decodeSnapshot(raw []byte) ([]byte, error) {
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		return raw, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing snapshot: %w", err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing snapshot: %w", err)
+	}
+	return out, nil
+}
+
+func gzipBytes(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// errIOTimeout marks an operation abandoned by runWithTimeout.
+var errIOTimeout = errors.New("timed out")
+
+// runWithTimeout runs fn and gives up after timeout, so a hung network mount
+// fails the command instead of blocking it. A blocked system call cannot be
+// interrupted, so the abandoned goroutine finishes (or not) on its own; a
+// timed-out write may still land later. A zero timeout calls fn directly.
+func runWithTimeout(timeout time.Duration, op string, fn func() error) error {
+	_, err := valueWithTimeout(timeout, op, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
+
+// valueWithTimeout is runWithTimeout for an operation with a result. The
+// result travels only over the goroutine's own channel, so an abandoned
+// goroutine never writes anything the caller reads.
+func valueWithTimeout[T any](timeout time.Duration, op string, fn func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return fn()
+	}
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		var zero T
+		return zero, ioErrorf("%s %w after %s; the filesystem may be unresponsive", op, errIOTimeout, timeout)
+	}
+}
+
 func atomicWriteFile(path string, raw []byte, mode os.FileMode) error {
 	dir := filepath.Dir(path)
 	if err := mkdirAll(dir, 0o700); err != nil {
-		return fmt.Errorf("creating parent directory: %w", err)
+		return ioErrorf("creating parent directory: %w", err)
 	}
 
 	tmp, err := createTemp(dir, ".ags-*")
 	if err != nil {
-		return fmt.Errorf("creating temp file: %w", err)
+		return ioErrorf("creating temp file: %w", err)
 	}
 	tmpName := tmp.Name()
 	defer removePath(tmpName)
 
 	if _, err := tmp.Write(raw); err != nil {
 		tmp.Close()
-		return fmt.Errorf("writing temp file: %w", err)
+		return ioErrorf("writing temp file: %w", err)
 	}
 	if err := tmp.Chmod(mode); err != nil {
 		tmp.Close()
-		return fmt.Errorf("setting file mode: %w", err)
+		return ioErrorf("setting file mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return ioErrorf("syncing temp file: %w", err)
 	}
 	if err := tmp.Close(); err != nil {
-		return fmt.Errorf("closing temp file: %w", err)
+		return ioErrorf("closing temp file: %w", err)
 	}
 
 	if err := renamePath(tmpName, path); err != nil {
-		return fmt.Errorf("replacing file atomically: %w", err)
+		return ioErrorf("replacing file atomically: %w", err)
+	}
+	// Without syncing the directory, the rename itself may not survive a crash.
+	if err := syncDir(dir); err != nil {
+		return ioErrorf("syncing parent directory: %w", err)
 	}
 	return nil
 }
 
+// probeWritable creates and removes a file in dir to check that it accepts
+// writes. A missing dir passes, since it is created on first write.
+func probeWritable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	f, err := os.CreateTemp(dir, ".ags-probe-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+func isReadOnlyErr(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// tempFilePattern matches files created by atomicWriteFile and
+// probeWritable; only these are ever removed by removeStaleTempFiles.
+const tempFilePattern = ".ags-*"
+
+// staleTempAge is how old a temp file must be before it is treated as left
+// behind by a crash rather than a write still in progress.
+const staleTempAge = 10 * time.Minute
+
+// removeStaleTempFiles deletes temp files older than staleTempAge from each
+// dir. It is best effort: unreadable dirs and failed removals are skipped.
+func removeStaleTempFiles(dirs []string, now time.Time) []string {
+	var removed []string
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, tempFilePattern))
+		if err != nil {
+			continue
+		}
+		for _, path := range matches {
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if now.Sub(info.ModTime()) < staleTempAge {
+				continue
+			}
+			if err := os.Remove(path); err == nil {
+				removed = append(removed, path)
+			}
+		}
+	}
+	return removed
+}
+
+func syncDirectory(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
 func validateJSONObject(raw []byte) error {
 	var payload any
 	if err := json.Unmarshal(raw, &payload); err != nil {
@@ -85,3 +309,68 @@ func validateJSONObject(raw []byte) error {
 	}
 	return nil
 }
+
+// validateStrictJSONObject is validateJSONObject plus the checks
+// json.Unmarshal skips for credential files: invalid UTF-8 (Unmarshal
+// silently substitutes U+FFFD), duplicate object keys (the last one silently
+// wins), and any content after the top-level object.
+func validateStrictJSONObject(raw []byte) error {
+	if err := validateJSONObject(raw); err != nil {
+		return err
+	}
+	if !utf8.Valid(raw) {
+		return fmt.Errorf("contains invalid UTF-8")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := checkStrictJSONValue(dec, ""); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected content after the top-level object")
+	}
+	return nil
+}
+
+// checkStrictJSONValue consumes one value from dec, rejecting duplicate keys
+// in any object. path names the value in errors.
+func checkStrictJSONValue(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	switch delim {
+	case '{':
+		seen := map[string]bool{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := keyTok.(string)
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			if seen[key] {
+				return fmt.Errorf("duplicate key %q", child)
+			}
+			seen[key] = true
+			if err := checkStrictJSONValue(dec, child); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for dec.More() {
+			if err := checkStrictJSONValue(dec, path+"[]"); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
@@ -2,15 +2,20 @@ package ags
 
 import (
 	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type fakeTempFile struct {
 	name     string
 	writeErr error
 	chmodErr error
+	syncErr  error
 	closeErr error
 }
 
@@ -25,6 +30,10 @@ func (f *fakeTempFile) Chmod(_ os.FileMode) error {
 	return f.chmodErr
 }
 
+func (f *fakeTempFile) Sync() error {
+	return f.syncErr
+}
+
 func (f *fakeTempFile) Close() error {
 	return f.closeErr
 }
@@ -39,12 +48,16 @@ func restoreFileSeams() func() {
 	oldCreateTemp := createTemp
 	oldRemovePath := removePath
 	oldRenamePath := renamePath
+	oldSyncDir := syncDir
+	oldProbeDir := probeDir
 	return func() {
 		userHomeDir = oldUserHomeDir
 		mkdirAll = oldMkdirAll
 		createTemp = oldCreateTemp
 		removePath = oldRemovePath
 		renamePath = oldRenamePath
+		syncDir = oldSyncDir
+		probeDir = oldProbeDir
 	}
 }
 
@@ -168,6 +181,29 @@ func TestAtomicWriteFileErrorBranches(t *testing.T) {
 		}
 	})
 
+	t.Run("sync error", func(t *testing.T) {
+		restore := restoreFileSeams()
+		defer restore()
+		createTemp = func(dir string, _ string) (tempFile, error) {
+			return &fakeTempFile{name: filepath.Join(dir, "tmp"), syncErr: errors.New("sync failed")}, nil
+		}
+		err := atomicWriteFile(filepath.Join(t.TempDir(), "x.json"), []byte("{}"), 0o600)
+		if err == nil || !strings.Contains(err.Error(), "syncing temp file") {
+			t.Fatalf("expected sync error, got %v", err)
+		}
+	})
+
+	t.Run("dir sync error", func(t *testing.T) {
+		restore := restoreFileSeams()
+		defer restore()
+		syncDir = func(string) error { return errors.New("dir sync failed") }
+		path := filepath.Join(t.TempDir(), "x.json")
+		err := atomicWriteFile(path, []byte("{}"), 0o600)
+		if err == nil || !strings.Contains(err.Error(), "syncing parent directory") {
+			t.Fatalf("expected dir sync error, got %v", err)
+		}
+	})
+
 	t.Run("close error", func(t *testing.T) {
 		restore := restoreFileSeams()
 		defer restore()
@@ -205,3 +241,189 @@ func TestValidateJSONObject(t *testing.T) {
 		t.Fatalf("expected top-level object error")
 	}
 }
+
+func TestValidateStrictJSONObject(t *testing.T) {
+	if err := validateStrictJSONObject([]byte("{\"a\":{\"b\":[1,{\"c\":2}]},\"d\":\"e\"}\n\n")); err != nil {
+		t.Fatalf("expected valid object: %v", err)
+	}
+
+	cases := map[string]string{
+		"trailing junk":       `{"a":1} garbage`,
+		"concatenated object": `{"a":1}{"a":2}`,
+		"duplicate key":       `{"tokens":{"access_token":"x","access_token":"y"}}`,
+		"invalid utf-8":       "{\"a\":\"\xff\"}",
+		"not an object":       `[1]`,
+	}
+	for name, raw := range cases {
+		if err := validateStrictJSONObject([]byte(raw)); err == nil {
+			t.Fatalf("%s: expected strict validation error", name)
+		}
+	}
+	if err := validateStrictJSONObject([]byte(`{"tokens":{"a":1,"a":2}}`)); err == nil || !strings.Contains(err.Error(), `"tokens.a"`) {
+		t.Fatalf("expected duplicate key path in error, got %v", err)
+	}
+	// The lenient check accepts what strict mode is for.
+	if err := validateJSONObject([]byte(`{"a":1,"a":2}`)); err != nil {
+		t.Fatalf("lenient validation should accept duplicate keys: %v", err)
+	}
+}
+
+func TestDecodeSnapshot(t *testing.T) {
+	plain := []byte(`{"a":1}`)
+	got, err := decodeSnapshot(plain)
+	if err != nil || string(got) != string(plain) {
+		t.Fatalf("plain snapshot should pass through, got %q err=%v", got, err)
+	}
+
+	compressed, err := gzipBytes(plain)
+	if err != nil {
+		t.Fatalf("gzipBytes: %v", err)
+	}
+	got, err = decodeSnapshot(compressed)
+	if err != nil || string(got) != string(plain) {
+		t.Fatalf("expected decompressed snapshot, got %q err=%v", got, err)
+	}
+
+	if _, err := decodeSnapshot(compressed[:len(compressed)-4]); err == nil {
+		t.Fatalf("expected error for truncated gzip data")
+	}
+
+	// Detection is by content, not by file name.
+	path := filepath.Join(t.TempDir(), "work.json")
+	writeFile(t, path, compressed)
+	got, err = (&Manager{}).readSnapshotFile(path)
+	if err != nil || string(got) != string(plain) {
+		t.Fatalf("readSnapshotFile: got %q err=%v", got, err)
+	}
+}
+
+func TestSyncDirectory(t *testing.T) {
+	if err := syncDirectory(t.TempDir()); err != nil {
+		t.Fatalf("syncDirectory: %v", err)
+	}
+	if err := syncDirectory(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("expected error for missing directory")
+	}
+}
+
+func TestCheckSymlink(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.json")
+	if got, err := checkSymlink(missing, false); err != nil || got != missing {
+		t.Fatalf("expected missing path to pass, got %q err=%v", got, err)
+	}
+
+	dangling := filepath.Join(dir, "dangling.json")
+	if err := os.Symlink(filepath.Join(dir, "nowhere.json"), dangling); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if _, err := checkSymlink(dangling, false); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected refusal, got %v", err)
+	}
+	if _, err := checkSymlink(dangling, true); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected resolve error for dangling link, got %v", err)
+	}
+}
+
+func TestProbeWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := probeWritable(dir); err != nil {
+		t.Fatalf("expected writable temp dir, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("expected probe file removed, found %v", entries)
+	}
+	if err := probeWritable(filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("expected missing dir to pass, got %v", err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("x"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := probeWritable(file); err != nil {
+		t.Fatalf("expected non-directory to pass, got %v", err)
+	}
+
+	if !isReadOnlyErr(&os.PathError{Op: "open", Path: dir, Err: syscall.EROFS}) {
+		t.Fatalf("expected EROFS to count as read-only")
+	}
+	if !isReadOnlyErr(fs.ErrPermission) {
+		t.Fatalf("expected permission error to count as read-only")
+	}
+	if isReadOnlyErr(errors.New("boom")) {
+		t.Fatalf("expected other errors not to count as read-only")
+	}
+}
+
+func TestRemoveStaleTempFilesSkipsNonRegular(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".ags-dir"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	removed := removeStaleTempFiles([]string{dir, filepath.Join(dir, "missing")}, time.Now().Add(time.Hour))
+	if len(removed) != 0 {
+		t.Fatalf("expected nothing removed, got %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".ags-dir")); err != nil {
+		t.Fatalf("expected directory kept: %v", err)
+	}
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	if err := runWithTimeout(0, "op", func() error { return os.ErrNotExist }); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected direct call without timeout, got %v", err)
+	}
+	if err := runWithTimeout(time.Second, "op", func() error { return nil }); err != nil {
+		t.Fatalf("expected fast op to succeed, got %v", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	err := runWithTimeout(10*time.Millisecond, "reading /mnt/nfs/state.json", func() error {
+		<-release
+		return nil
+	})
+	if !errors.Is(err, ErrIO) || !errors.Is(err, errIOTimeout) {
+		t.Fatalf("expected ErrIO timeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "reading /mnt/nfs/state.json timed out after 10ms") {
+		t.Fatalf("unexpected timeout message: %v", err)
+	}
+}
+
+func TestManagerIOTimeoutOnBlockedRead(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir(), WithIOTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	// Opening a FIFO for reading blocks until a writer appears, which stands
+	// in for a hung network mount.
+	fifo := filepath.Join(t.TempDir(), "auth.json")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("mkfifo unsupported: %v", err)
+	}
+	defer func() {
+		if w, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+			w.Close()
+		}
+	}()
+
+	_, err = m.Save(ToolCodex, "work", fifo)
+	if !errors.Is(err, ErrIO) || !errors.Is(err, errIOTimeout) {
+		t.Fatalf("expected timeout reading a blocked source, got %v", err)
+	}
+
+	// The runtime auth file use reads before replacing it is bounded too.
+	source := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	if _, err := m.Save(ToolCodex, "work", source); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	_, err = m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: fifo})
+	if !errors.Is(err, ErrIO) || !errors.Is(err, errIOTimeout) {
+		t.Fatalf("expected timeout reading a blocked runtime file, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package ags
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Hook names as they appear in config.json and in AGS_HOOK.
+const (
+	preUseHook  = "pre_use"
+	postUseHook = "post_use"
+)
+
+// useHookContext is what a use hook learns about the switch.
+type useHookContext struct {
+	Tool    Tool
+	Label   string
+	Target  string
+	Insight AuthInsight
+	Output  io.Writer
+}
+
+// runUseHook runs command with sh -c when it is non-empty. The hook inherits
+// the environment plus AGS_HOOK, AGS_TOOL, AGS_LABEL, AGS_TARGET,
+// AGS_ACCOUNT_ID, AGS_ACCOUNT_EMAIL, and AGS_ACCOUNT_PLAN; token values are
+// never passed. Its stdout and stderr go to ctx.Output.
+func runUseHook(name string, command string, ctx useHookContext) error {
+	if command == "" {
+		return nil
+	}
+	out := ctx.Output
+	if out == nil {
+		out = io.Discard
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"AGS_HOOK="+name,
+		"AGS_TOOL="+ctx.Tool.String(),
+		"AGS_LABEL="+ctx.Label,
+		"AGS_TARGET="+ctx.Target,
+		"AGS_ACCOUNT_ID="+ctx.Insight.AccountID,
+		"AGS_ACCOUNT_EMAIL="+ctx.Insight.AccountEmail,
+		"AGS_ACCOUNT_PLAN="+ctx.Insight.AccountPlan,
+	)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package ags
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeHookConfig(t *testing.T, root string, cfg Config) {
+	t.Helper()
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	writeFile(t, filepath.Join(root, "config.json"), raw)
+}
+
+func TestRunUseHooks(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_hook", "hook@example.com", "plus"))
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &bytes.Buffer{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	target := filepath.Join(t.TempDir(), "auth.json")
+	envLog := filepath.Join(t.TempDir(), "env.log")
+
+	writeHookConfig(t, root, Config{
+		PreUse:  `echo "pre $AGS_TOOL $AGS_LABEL $AGS_ACCOUNT_ID $AGS_ACCOUNT_EMAIL" >> ` + envLog,
+		PostUse: `echo "post $AGS_HOOK $AGS_TARGET" >> ` + envLog + `; echo relayed; exit 3`,
+	})
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	logged, err := os.ReadFile(envLog)
+	if err != nil {
+		t.Fatalf("read env log: %v", err)
+	}
+	want := "pre codex work acct_hook hook@example.com\npost post_use " + target + "\n"
+	if string(logged) != want {
+		t.Fatalf("unexpected hook env:\n%s\nwant:\n%s", logged, want)
+	}
+	if !strings.Contains(stderr.String(), "relayed\n") || !strings.Contains(stderr.String(), "Warning: post_use hook failed: exit status 3") {
+		t.Fatalf("expected relayed output and post_use warning, got %q", stderr.String())
+	}
+
+	if err := os.Remove(target); err != nil {
+		t.Fatalf("remove target: %v", err)
+	}
+	writeHookConfig(t, root, Config{PreUse: "echo nope >&2; exit 1"})
+	stderr.Reset()
+	err = Run([]string{"use", "codex", "work", "--target", target, "--root", root}, nil, &stdout, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "pre_use hook failed") || !strings.Contains(err.Error(), "was not activated") {
+		t.Fatalf("expected pre_use failure to abort, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("expected runtime file untouched after aborted switch, err=%v", err)
+	}
+	if !strings.Contains(stderr.String(), "nope") {
+		t.Fatalf("expected pre_use output relayed, got %q", stderr.String())
+	}
+}
@@ -9,12 +9,20 @@ import (
 	"time"
 )
 
+// defaultExpiringSoon is how close to expiry a token must be before it is
+// reported as expiring_soon when no other window is configured.
+const defaultExpiringSoon = 15 * time.Minute
+
 func inspectAuth(tool Tool, raw []byte) AuthInsight {
+	return inspectAuthWithin(tool, raw, defaultExpiringSoon)
+}
+
+func inspectAuthWithin(tool Tool, raw []byte, soon time.Duration) AuthInsight {
 	switch tool {
 	case ToolCodex:
-		return inspectCodex(raw)
+		return inspectCodex(raw, soon)
 	case ToolPi:
-		return inspectPi(raw)
+		return inspectPi(raw, soon)
 	default:
 		return AuthInsight{
 			Status:       "unknown",
@@ -23,7 +31,105 @@ func inspectAuth(tool Tool, raw []byte) AuthInsight {
 	}
 }
 
-func inspectCodex(raw []byte) AuthInsight {
+// runtimeAuthProblems checks a written runtime auth file the way the tool
+// will read it and describes anything it would likely reject. Nil means the
+// file looks usable.
+func runtimeAuthProblems(tool Tool, raw []byte) []string {
+	if err := validateJSONObject(raw); err != nil {
+		return []string{fmt.Sprintf("runtime file is not a JSON object: %v", err)}
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return []string{fmt.Sprintf("runtime file is not a JSON object: %v", err)}
+	}
+
+	var problems []string
+	switch tool {
+	case ToolCodex:
+		tokens, _, ok := findCodexTokens(payload)
+		if !ok {
+			if extractStringClaim(payload, "OPENAI_API_KEY") == "" {
+				problems = append(problems, "no access_token or OPENAI_API_KEY found")
+			}
+			break
+		}
+		if !inspectAccessToken(extractStringClaim(tokens, "access_token")).IsJWT {
+			problems = append(problems, "access_token is missing or not a parseable JWT")
+		}
+	case ToolPi:
+		if len(payload) == 0 {
+			problems = append(problems, "no providers in runtime file")
+		}
+		for _, key := range sortedKeys(payload) {
+			if _, ok := payload[key].(map[string]any); !ok {
+				problems = append(problems, fmt.Sprintf("provider %s is not a JSON object", key))
+			}
+		}
+	}
+	return problems
+}
+
+// piProviderFields are keys that mark a top-level object as a pi provider
+// credential entry.
+var piProviderFields = []string{"type", "access", "refresh", "expires", "key"}
+
+// detectToolFormat returns the one tool whose auth file shape raw matches.
+// It reports false when raw matches neither or both.
+func detectToolFormat(raw []byte) (Tool, bool) {
+	codex, pi := looksLikeToolFormat(ToolCodex, raw), looksLikeToolFormat(ToolPi, raw)
+	switch {
+	case codex && !pi:
+		return ToolCodex, true
+	case pi && !codex:
+		return ToolPi, true
+	}
+	return "", false
+}
+
+// looksLikeToolFormat reports whether raw is shaped like tool's auth file:
+// codex needs a recognized token shape or an API key, pi needs at least one
+// provider object.
+func looksLikeToolFormat(tool Tool, raw []byte) bool {
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return false
+	}
+	switch tool {
+	case ToolCodex:
+		if _, _, ok := findCodexTokens(payload); ok {
+			return true
+		}
+		return extractStringClaim(payload, "OPENAI_API_KEY") != ""
+	case ToolPi:
+		for _, value := range payload {
+			entry, ok := value.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, field := range piProviderFields {
+				if _, ok := entry[field]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// emptyAuthInsight describes a zero-byte auth file, which usually means the
+// tool crashed mid-write rather than wrote bad JSON.
+func emptyAuthInsight() AuthInsight {
+	return AuthInsight{
+		Status:       "unknown",
+		NeedsRefresh: "unknown",
+		Details:      []string{"file is empty (likely truncated by a failed write)"},
+	}
+}
+
+func inspectCodex(raw []byte, soon time.Duration) AuthInsight {
+	if len(raw) == 0 {
+		return emptyAuthInsight()
+	}
 	var payload map[string]any
 	if err := json.Unmarshal(raw, &payload); err != nil {
 		return AuthInsight{
@@ -43,11 +149,22 @@ func inspectCodex(raw []byte) AuthInsight {
 		insight.LastRefresh = lastRefreshRaw
 	}
 
-	tokens, ok := payload["tokens"].(map[string]any)
+	tokens, shape, ok := findCodexTokens(payload)
 	if !ok {
-		insight.Details = append(insight.Details, "tokens object missing")
+		if extractStringClaim(payload, "OPENAI_API_KEY") != "" {
+			insight.Details = append(insight.Details, "token shape: OPENAI_API_KEY (api key, no expiry)")
+			return insight
+		}
+		seen := "none"
+		if len(payload) > 0 {
+			seen = strings.Join(sortedKeys(payload), ", ")
+		}
+		insight.Details = append(insight.Details, "tokens object missing", "top-level keys: "+seen)
 		return insight
 	}
+	if shape != codexTokenShapes[0].name {
+		insight.Details = append(insight.Details, "token shape: "+shape)
+	}
 
 	insight.AccountID = extractStringClaim(tokens, "account_id")
 
@@ -66,6 +183,7 @@ func inspectCodex(raw []byte) AuthInsight {
 					insight.AccountID = jwtAccountID
 				}
 			}
+			applyTokenClaims(&insight, idInfo)
 		}
 	}
 
@@ -76,25 +194,90 @@ func inspectCodex(raw []byte) AuthInsight {
 	}
 
 	tokenInfo := inspectAccessToken(accessToken)
+	if tokenInfo.IsJWT {
+		applyTokenClaims(&insight, tokenInfo)
+	}
 	if !tokenInfo.HasExp {
 		insight.Details = append(insight.Details, "could not parse access_token exp")
 		return insight
 	}
 
 	insight.ExpiresAt = tokenInfo.ExpiresAt.Format(time.RFC3339)
-	status := classifyExpiry(tokenInfo.ExpiresAt)
+	status := classifyExpiry(tokenInfo.ExpiresAt, soon)
 	insight.Status = status
 	insight.NeedsRefresh = needsRefreshFromStatus(status)
 	return insight
 }
 
+// codexTokenShapes lists where known codex CLI versions keep the token
+// object, in the order inspectCodex tries them. The first entry is the
+// current layout and is not reported as a detail.
+var codexTokenShapes = []struct {
+	name string
+	find func(payload map[string]any) map[string]any
+}{
+	{"tokens", func(payload map[string]any) map[string]any {
+		tokens, _ := payload["tokens"].(map[string]any)
+		return tokens
+	}},
+	{"openai.tokens", func(payload map[string]any) map[string]any {
+		openai, _ := payload["openai"].(map[string]any)
+		tokens, _ := openai["tokens"].(map[string]any)
+		return tokens
+	}},
+	{"openai", func(payload map[string]any) map[string]any {
+		openai, _ := payload["openai"].(map[string]any)
+		if extractStringClaim(openai, "access_token") == "" {
+			return nil
+		}
+		return openai
+	}},
+	{"top-level", func(payload map[string]any) map[string]any {
+		if extractStringClaim(payload, "access_token") == "" {
+			return nil
+		}
+		return payload
+	}},
+}
+
+// findCodexTokens returns the token object of a codex auth payload and the
+// name of the shape it was found under.
+func findCodexTokens(payload map[string]any) (map[string]any, string, bool) {
+	for _, shape := range codexTokenShapes {
+		if tokens := shape.find(payload); tokens != nil {
+			return tokens, shape.name, true
+		}
+	}
+	return nil, "", false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyTokenClaims copies iss, sub, and aud onto insight. Later calls win,
+// so inspectCodex applies the id token first and the access token second.
+func applyTokenClaims(insight *AuthInsight, info accessTokenInsight) {
+	insight.Issuer = firstNonEmpty(info.Issuer, insight.Issuer)
+	insight.Subject = firstNonEmpty(info.Subject, insight.Subject)
+	insight.Audience = firstNonEmpty(info.Audience, insight.Audience)
+}
+
 type piIdentityCandidate struct {
 	AccountEmail string
 	AccountPlan  string
 	AccountID    string
 }
 
-func inspectPi(raw []byte) AuthInsight {
+func inspectPi(raw []byte, soon time.Duration) AuthInsight {
+	if len(raw) == 0 {
+		return emptyAuthInsight()
+	}
 	var payload map[string]any
 	if err := json.Unmarshal(raw, &payload); err != nil {
 		return AuthInsight{
@@ -144,7 +327,7 @@ func inspectPi(raw []byte) AuthInsight {
 		expiry := time.UnixMilli(int64(expMillis)).UTC()
 		statuses = append(statuses, providerStatus{
 			name:      displayPIProviderName(key, role),
-			status:    classifyExpiry(expiry),
+			status:    classifyExpiry(expiry, soon),
 			expiresAt: expiry,
 		})
 	}
@@ -160,19 +343,29 @@ func inspectPi(raw []byte) AuthInsight {
 	}
 
 	sort.Slice(statuses, func(i, j int) bool {
-		return statusRank(statuses[i].status) > statusRank(statuses[j].status)
+		if statusRank(statuses[i].status) != statusRank(statuses[j].status) {
+			return statusRank(statuses[i].status) > statusRank(statuses[j].status)
+		}
+		if !statuses[i].expiresAt.Equal(statuses[j].expiresAt) {
+			return statuses[i].expiresAt.Before(statuses[j].expiresAt)
+		}
+		return statuses[i].name < statuses[j].name
 	})
 	worst := statuses[0]
 
 	details := make([]string, 0, len(statuses))
+	providers := make([]ProviderInsight, 0, len(statuses))
 	for _, s := range statuses {
-		details = append(details, fmt.Sprintf("%s=%s (%s)", s.name, s.status, s.expiresAt.Format(time.RFC3339)))
+		expiresAt := s.expiresAt.Format(time.RFC3339)
+		details = append(details, fmt.Sprintf("%s=%s (%s)", s.name, s.status, expiresAt))
+		providers = append(providers, ProviderInsight{Name: s.name, Status: s.status, ExpiresAt: expiresAt})
 	}
 
 	insight.Status = worst.status
 	insight.ExpiresAt = worst.expiresAt.Format(time.RFC3339)
 	insight.NeedsRefresh = needsRefreshFromStatus(worst.status)
 	insight.Details = details
+	insight.Providers = providers
 	return insight
 }
 
@@ -488,16 +681,22 @@ func resolveCodexAccountIDFromJWT(info accessTokenInsight) string {
 
 func normalizePlan(plan string) string {
 	cleaned := strings.TrimSpace(strings.ToLower(plan))
+	cleaned = strings.NewReplacer("-", "_", " ", "_").Replace(cleaned)
+	cleaned = strings.TrimPrefix(strings.TrimPrefix(cleaned, "chatgpt"), "_")
 	switch cleaned {
-	case "plus", "chatgpt_plus":
+	case "plus":
 		return "Plus"
-	case "pro", "chatgpt_pro":
+	case "pro":
 		return "Pro"
-	case "team", "chatgpt_team":
+	case "team":
 		return "Team"
-	case "enterprise", "chatgpt_enterprise":
+	case "business":
+		return "Business"
+	case "enterprise", "ent":
 		return "Enterprise"
-	case "free", "chatgpt_free":
+	case "edu", "education":
+		return "Edu"
+	case "free":
 		return "Free"
 	default:
 		if cleaned == "" {
@@ -553,6 +752,40 @@ func extractAudienceClaim(claims map[string]any) string {
 	}
 }
 
+// describeSnapshotTokens returns a describeJWTToken line for every JWT in
+// a snapshot, in a stable order.
+func describeSnapshotTokens(tool Tool, raw []byte) []string {
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil
+	}
+
+	var out []string
+	describe := func(name string, token string) {
+		if info := inspectAccessToken(token); info.IsJWT {
+			out = append(out, describeJWTToken(name, info))
+		}
+	}
+	switch tool {
+	case ToolCodex:
+		tokens, _, _ := findCodexTokens(payload)
+		for _, field := range []string{"access_token", "id_token"} {
+			describe(field, extractStringClaim(tokens, field))
+		}
+	case ToolPi:
+		keys := make([]string, 0, len(payload))
+		for key := range payload {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry, _ := payload[key].(map[string]any)
+			describe(key+".access", extractStringClaim(entry, "access"))
+		}
+	}
+	return out
+}
+
 func describeJWTToken(tokenName string, info accessTokenInsight) string {
 	parts := []string{fmt.Sprintf("%s format=jwt", tokenName)}
 	if info.HeaderAlg != "" {
@@ -618,12 +851,12 @@ func numberToFloat(value any) (float64, bool) {
 	}
 }
 
-func classifyExpiry(expiry time.Time) string {
+func classifyExpiry(expiry time.Time, soon time.Duration) string {
 	d := time.Until(expiry)
 	if d <= 0 {
 		return "expired"
 	}
-	if d <= 15*time.Minute {
+	if d <= soon {
 		return "expiring_soon"
 	}
 	return "valid"
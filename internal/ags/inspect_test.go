@@ -3,6 +3,7 @@ package ags
 import (
 	"encoding/base64"
 	"encoding/json"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -43,19 +44,22 @@ func TestInspectAuthDispatch(t *testing.T) {
 }
 
 func TestInspectCodexBranches(t *testing.T) {
-	if got := inspectCodex([]byte("not-json")); len(got.Details) == 0 || got.Details[0] != "invalid JSON" {
+	if got := inspectCodex([]byte("not-json"), defaultExpiringSoon); len(got.Details) == 0 || got.Details[0] != "invalid JSON" {
 		t.Fatalf("invalid json branch not hit: %+v", got)
 	}
+	if got := inspectCodex(nil, defaultExpiringSoon); len(got.Details) == 0 || !strings.HasPrefix(got.Details[0], "file is empty") {
+		t.Fatalf("empty file branch not hit: %+v", got)
+	}
 
-	if got := inspectCodex([]byte(`{"x":1}`)); len(got.Details) == 0 || got.Details[0] != "tokens object missing" {
+	if got := inspectCodex([]byte(`{"x":1}`), defaultExpiringSoon); len(got.Details) == 0 || got.Details[0] != "tokens object missing" {
 		t.Fatalf("missing tokens branch not hit: %+v", got)
 	}
 
-	if got := inspectCodex([]byte(`{"tokens":{}}`)); len(got.Details) == 0 || got.Details[0] != "access_token missing" {
+	if got := inspectCodex([]byte(`{"tokens":{}}`), defaultExpiringSoon); len(got.Details) == 0 || got.Details[0] != "access_token missing" {
 		t.Fatalf("missing access token branch not hit: %+v", got)
 	}
 
-	got := inspectCodex([]byte(`{"tokens":{"access_token":"bad"}}`))
+	got := inspectCodex([]byte(`{"tokens":{"access_token":"bad"}}`), defaultExpiringSoon)
 	joined := strings.Join(got.Details, " ")
 	if !strings.Contains(joined, "could not parse access_token exp") {
 		t.Fatalf("bad token branch not hit: %+v", got)
@@ -63,7 +67,7 @@ func TestInspectCodexBranches(t *testing.T) {
 
 	future := time.Now().UTC().Add(1 * time.Hour).Unix()
 	validRaw := `{"last_refresh":"2026-01-01T00:00:00Z","tokens":{"access_token":"` + jwtWithExp(t, future) + `"}}`
-	got = inspectCodex([]byte(validRaw))
+	got = inspectCodex([]byte(validRaw), defaultExpiringSoon)
 	if got.Status != "valid" || got.NeedsRefresh != "no" || got.ExpiresAt == "" {
 		t.Fatalf("valid branch failed: %+v", got)
 	}
@@ -72,13 +76,13 @@ func TestInspectCodexBranches(t *testing.T) {
 	}
 
 	expSoon := time.Now().UTC().Add(5 * time.Minute).Unix()
-	got = inspectCodex([]byte(`{"tokens":{"access_token":"` + jwtWithExp(t, expSoon) + `"}}`))
+	got = inspectCodex([]byte(`{"tokens":{"access_token":"`+jwtWithExp(t, expSoon)+`"}}`), defaultExpiringSoon)
 	if got.Status != "expiring_soon" || got.NeedsRefresh != "yes" {
 		t.Fatalf("expiring soon branch failed: %+v", got)
 	}
 
 	expired := time.Now().UTC().Add(-1 * time.Minute).Unix()
-	got = inspectCodex([]byte(`{"tokens":{"access_token":"` + jwtWithExp(t, expired) + `"}}`))
+	got = inspectCodex([]byte(`{"tokens":{"access_token":"`+jwtWithExp(t, expired)+`"}}`), defaultExpiringSoon)
 	if got.Status != "expired" || got.NeedsRefresh != "yes" {
 		t.Fatalf("expired branch failed: %+v", got)
 	}
@@ -86,25 +90,28 @@ func TestInspectCodexBranches(t *testing.T) {
 	jwtNoExpHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
 	jwtNoExpClaims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"u1"}`))
 	jwtNoExp := jwtNoExpHeader + "." + jwtNoExpClaims + ".sig"
-	got = inspectCodex([]byte(`{"tokens":{"access_token":"` + jwtNoExp + `"}}`))
+	got = inspectCodex([]byte(`{"tokens":{"access_token":"`+jwtNoExp+`"}}`), defaultExpiringSoon)
 	if !strings.Contains(strings.Join(got.Details, " "), "could not parse access_token exp") {
 		t.Fatalf("expected jwt-without-exp parse failure detail branch, got %+v", got)
 	}
 }
 
 func TestInspectPiBranches(t *testing.T) {
-	if got := inspectPi([]byte("not-json")); len(got.Details) == 0 || got.Details[0] != "invalid JSON" {
+	if got := inspectPi([]byte("not-json"), defaultExpiringSoon); len(got.Details) == 0 || got.Details[0] != "invalid JSON" {
 		t.Fatalf("invalid json branch not hit: %+v", got)
 	}
+	if got := inspectPi(nil, defaultExpiringSoon); len(got.Details) == 0 || !strings.HasPrefix(got.Details[0], "file is empty") {
+		t.Fatalf("empty file branch not hit: %+v", got)
+	}
 
-	if got := inspectPi([]byte(`{"provider":{},"other":"x","badexp":{"expires":"x"}}`)); len(got.Details) == 0 || got.Details[0] != "no provider expires fields found" {
+	if got := inspectPi([]byte(`{"provider":{},"other":"x","badexp":{"expires":"x"}}`), defaultExpiringSoon); len(got.Details) == 0 || got.Details[0] != "no provider expires fields found" {
 		t.Fatalf("no expires branch not hit: %+v", got)
 	}
 
 	validMillis := time.Now().UTC().Add(2 * time.Hour).UnixMilli()
 	expiredMillis := time.Now().UTC().Add(-2 * time.Hour).UnixMilli()
 	raw := `{"provider_a":{"expires":` + strconv.FormatInt(validMillis, 10) + `},"provider_b":{"expires":` + strconv.FormatInt(expiredMillis, 10) + `}}`
-	got := inspectPi([]byte(raw))
+	got := inspectPi([]byte(raw), defaultExpiringSoon)
 	if got.Status != "expired" || got.NeedsRefresh != "yes" {
 		t.Fatalf("expected worst provider status to be expired: %+v", got)
 	}
@@ -115,6 +122,16 @@ func TestInspectPiBranches(t *testing.T) {
 	if !strings.Contains(joined, "provider_b=expired") || !strings.Contains(joined, "provider_a=valid") {
 		t.Fatalf("unexpected details: %+v", got.Details)
 	}
+	wantProviders := []ProviderInsight{
+		{Name: "provider_b", Status: "expired", ExpiresAt: time.UnixMilli(expiredMillis).UTC().Format(time.RFC3339)},
+		{Name: "provider_a", Status: "valid", ExpiresAt: time.UnixMilli(validMillis).UTC().Format(time.RFC3339)},
+	}
+	if !reflect.DeepEqual(got.Providers, wantProviders) {
+		t.Fatalf("expected worst-first providers %+v, got %+v", wantProviders, got.Providers)
+	}
+	if codex := inspectCodex(makeCodexAuthJSON(t, time.Now().Add(time.Hour)), defaultExpiringSoon); codex.Providers != nil {
+		t.Fatalf("expected no providers for codex, got %+v", codex.Providers)
+	}
 }
 
 func TestInspectPiTokenDetails(t *testing.T) {
@@ -130,7 +147,7 @@ func TestInspectPiTokenDetails(t *testing.T) {
 		"account_id": "acct_from_jwt",
 	})
 	raw := `{"openai-codex":{"access":"` + jwt + `","expires":` + strconv.FormatInt(expMillis, 10) + `,"accountId":"acct_from_entry"},"anthropic":{"access":"opaque-token","expires":` + strconv.FormatInt(expMillis, 10) + `}}`
-	got := inspectPi([]byte(raw))
+	got := inspectPi([]byte(raw), defaultExpiringSoon)
 	joined := strings.Join(got.Details, " ")
 	if !strings.Contains(joined, "codex=valid") {
 		t.Fatalf("expected codex status detail, got %+v", got.Details)
@@ -163,7 +180,7 @@ func TestInspectPiInfersCodexRoleFromTokenIssuer(t *testing.T) {
 	})
 
 	raw := `{"provider-x":{"access":"` + codexJWT + `","expires":` + strconv.FormatInt(expMillis, 10) + `},"provider-y":{"access":"` + otherJWT + `","expires":` + strconv.FormatInt(expMillis, 10) + `}}`
-	got := inspectPi([]byte(raw))
+	got := inspectPi([]byte(raw), defaultExpiringSoon)
 	joined := strings.Join(got.Details, " ")
 	if !strings.Contains(joined, "codex=valid") {
 		t.Fatalf("expected codex role from token issuer, got %+v", got.Details)
@@ -186,7 +203,7 @@ func TestInspectPiOtherProviderIdentityFallback(t *testing.T) {
 	})
 
 	raw := `{"provider-z":{"access":"` + otherJWT + `","expires":` + strconv.FormatInt(expMillis, 10) + `}}`
-	got := inspectPi([]byte(raw))
+	got := inspectPi([]byte(raw), defaultExpiringSoon)
 	if got.AccountEmail != "other.person@company.com" {
 		t.Fatalf("expected provider email from JWT claims, got %+v", got)
 	}
@@ -392,13 +409,13 @@ func TestNumberToFloatAndStatusHelpers(t *testing.T) {
 		t.Fatalf("expected default failure")
 	}
 
-	if classifyExpiry(time.Now().UTC().Add(-time.Second)) != "expired" {
+	if classifyExpiry(time.Now().UTC().Add(-time.Second), defaultExpiringSoon) != "expired" {
 		t.Fatalf("expected expired")
 	}
-	if classifyExpiry(time.Now().UTC().Add(5*time.Minute)) != "expiring_soon" {
+	if classifyExpiry(time.Now().UTC().Add(5*time.Minute), defaultExpiringSoon) != "expiring_soon" {
 		t.Fatalf("expected expiring_soon")
 	}
-	if classifyExpiry(time.Now().UTC().Add(2*time.Hour)) != "valid" {
+	if classifyExpiry(time.Now().UTC().Add(2*time.Hour), defaultExpiringSoon) != "valid" {
 		t.Fatalf("expected valid")
 	}
 
@@ -410,3 +427,183 @@ func TestNumberToFloatAndStatusHelpers(t *testing.T) {
 		t.Fatalf("unexpected statusRank mapping")
 	}
 }
+
+func TestClassifyExpiryCustomWindowBoundaries(t *testing.T) {
+	window := 2 * time.Hour
+	if got := classifyExpiry(time.Now().Add(window-time.Minute), window); got != "expiring_soon" {
+		t.Fatalf("expected expiring_soon just under window, got %q", got)
+	}
+	if got := classifyExpiry(time.Now().Add(window+time.Minute), window); got != "valid" {
+		t.Fatalf("expected valid just over window, got %q", got)
+	}
+	if got := classifyExpiry(time.Now().Add(time.Minute), 0); got != "valid" {
+		t.Fatalf("expected zero window to disable expiring_soon, got %q", got)
+	}
+
+	raw := []byte(`{"tokens":{"access_token":"` + jwtWithExp(t, time.Now().Add(time.Hour).Unix()) + `"}}`)
+	if got := inspectAuthWithin(ToolCodex, raw, window); got.Status != "expiring_soon" || got.NeedsRefresh != "yes" {
+		t.Fatalf("expected custom window to apply, got %+v", got)
+	}
+	if got := inspectAuth(ToolCodex, raw); got.Status != "valid" {
+		t.Fatalf("expected default window to keep status valid, got %+v", got)
+	}
+}
+
+func TestInspectCodexIssuerSubjectAudience(t *testing.T) {
+	exp := time.Now().Add(2 * time.Hour).Unix()
+	access := jwtWithClaims(t, map[string]any{"exp": exp, "iss": "https://auth.example.com", "aud": []any{"api-a", "api-b"}})
+	id := jwtWithClaims(t, map[string]any{"iss": "https://id.example.com", "sub": "user-123", "aud": "client-x"})
+	raw := []byte(`{"tokens":{"access_token":"` + access + `","id_token":"` + id + `"}}`)
+
+	insight := inspectCodex(raw, defaultExpiringSoon)
+	if insight.Issuer != "https://auth.example.com" {
+		t.Fatalf("expected access token issuer to win, got %q", insight.Issuer)
+	}
+	if insight.Subject != "user-123" {
+		t.Fatalf("expected subject from id token fallback, got %q", insight.Subject)
+	}
+	if insight.Audience != "api-a,api-b" {
+		t.Fatalf("expected access token audience, got %q", insight.Audience)
+	}
+
+	pi := inspectPi([]byte(`{"openai-codex":{"access":"`+access+`"}}`), defaultExpiringSoon)
+	if pi.Issuer != "" || pi.Subject != "" || pi.Audience != "" {
+		t.Fatalf("expected pi insight without token claims, got %+v", pi)
+	}
+}
+
+func TestNormalizePlanVariants(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":                    "",
+		"plus":                "Plus",
+		"chatgpt_pro":         "Pro",
+		"ChatGPT Team":        "Team",
+		"business":            "Business",
+		"chatgpt-business":    "Business",
+		"enterprise":          "Enterprise",
+		"chatgpt_enterprise":  "Enterprise",
+		"chatgptenterprise":   "Enterprise",
+		"ent":                 "Enterprise",
+		"edu":                 "Edu",
+		"chatgpt_edu":         "Edu",
+		"education":           "Edu",
+		"free":                "Free",
+		"chatgpt_custom_tier": "Custom_tier",
+	} {
+		if got := normalizePlan(raw); got != want {
+			t.Fatalf("normalizePlan(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestDescribeSnapshotTokens(t *testing.T) {
+	access := jwtWithClaims(t, map[string]any{"exp": 1, "iss": "iss-a"})
+	pi := []byte(`{"b":{"access":"` + access + `"},"a":{"access":"opaque"},"c":"x"}`)
+	got := describeSnapshotTokens(ToolPi, pi)
+	if len(got) != 1 || !strings.HasPrefix(got[0], "b.access format=jwt") || !strings.Contains(got[0], "iss=iss-a") {
+		t.Fatalf("unexpected pi token summaries: %v", got)
+	}
+	if strings.Contains(strings.Join(got, " "), access) {
+		t.Fatalf("token summary leaked the token")
+	}
+	if got := describeSnapshotTokens(ToolCodex, []byte("not json")); got != nil {
+		t.Fatalf("expected nil for invalid JSON, got %v", got)
+	}
+}
+
+func TestInspectCodexTokenShapes(t *testing.T) {
+	token := jwtWithExp(t, time.Now().UTC().Add(time.Hour).Unix())
+	for _, tc := range []struct {
+		name   string
+		raw    string
+		detail string
+	}{
+		{"tokens", `{"tokens":{"access_token":"` + token + `","account_id":"acct_1"}}`, ""},
+		{"openai.tokens", `{"openai":{"tokens":{"access_token":"` + token + `","account_id":"acct_1"}}}`, "token shape: openai.tokens"},
+		{"openai", `{"openai":{"access_token":"` + token + `","account_id":"acct_1"}}`, "token shape: openai"},
+		{"top-level", `{"access_token":"` + token + `","account_id":"acct_1"}`, "token shape: top-level"},
+	} {
+		got := inspectCodex([]byte(tc.raw), defaultExpiringSoon)
+		if got.Status != "valid" || got.AccountID != "acct_1" {
+			t.Fatalf("%s: expected valid insight with account id, got %+v", tc.name, got)
+		}
+		joined := strings.Join(got.Details, "\n")
+		if tc.detail == "" && strings.Contains(joined, "token shape") {
+			t.Fatalf("%s: expected no shape detail for the default layout, got %v", tc.name, got.Details)
+		}
+		if tc.detail != "" && !strings.Contains(joined, tc.detail) {
+			t.Fatalf("%s: expected detail %q, got %v", tc.name, tc.detail, got.Details)
+		}
+	}
+
+	got := inspectCodex([]byte(`{"OPENAI_API_KEY":"sk-test","tokens":null}`), defaultExpiringSoon)
+	if got.Status != "unknown" || len(got.Details) != 1 || !strings.Contains(got.Details[0], "OPENAI_API_KEY") {
+		t.Fatalf("expected api key shape detail, got %+v", got)
+	}
+
+	got = inspectCodex([]byte(`{"zeta":1,"alpha":{}}`), defaultExpiringSoon)
+	if len(got.Details) != 2 || got.Details[1] != "top-level keys: alpha, zeta" {
+		t.Fatalf("expected top-level keys listed, got %v", got.Details)
+	}
+	got = inspectCodex([]byte(`{}`), defaultExpiringSoon)
+	if len(got.Details) != 2 || got.Details[1] != "top-level keys: none" {
+		t.Fatalf("expected no keys listed, got %v", got.Details)
+	}
+
+	vars := codexEnvVars(map[string]any{"openai": map[string]any{"access_token": token}})
+	if len(vars) != 1 || vars[0].Name != "CODEX_ACCESS_TOKEN" {
+		t.Fatalf("expected export-env to read alternate shape, got %+v", vars)
+	}
+}
+
+func TestRuntimeAuthProblems(t *testing.T) {
+	valid := makeCodexAuthJSON(t, time.Now().Add(time.Hour))
+	cases := []struct {
+		name string
+		tool Tool
+		raw  string
+		want string
+	}{
+		{"codex valid", ToolCodex, string(valid), ""},
+		{"codex api key", ToolCodex, `{"OPENAI_API_KEY":"sk-test"}`, ""},
+		{"not json", ToolCodex, `nope`, "not a JSON object"},
+		{"array", ToolPi, `[1]`, "not a JSON object"},
+		{"codex no tokens", ToolCodex, `{"other":1}`, "no access_token or OPENAI_API_KEY"},
+		{"codex opaque token", ToolCodex, `{"tokens":{"access_token":"opaque"}}`, "not a parseable JWT"},
+		{"pi valid", ToolPi, `{"anthropic":{"type":"oauth","access":"a"}}`, ""},
+		{"pi empty", ToolPi, `{}`, "no providers"},
+		{"pi non-object provider", ToolPi, `{"anthropic":"x"}`, "provider anthropic is not a JSON object"},
+	}
+	for _, tc := range cases {
+		problems := runtimeAuthProblems(tc.tool, []byte(tc.raw))
+		if tc.want == "" {
+			if len(problems) != 0 {
+				t.Fatalf("%s: expected no problems, got %v", tc.name, problems)
+			}
+			continue
+		}
+		if len(problems) == 0 || !strings.Contains(strings.Join(problems, "; "), tc.want) {
+			t.Fatalf("%s: expected %q, got %v", tc.name, tc.want, problems)
+		}
+	}
+}
+
+func TestDetectToolFormat(t *testing.T) {
+	codex := makeCodexAuthJSON(t, time.Now().Add(time.Hour))
+	for name, tc := range map[string]struct {
+		raw  []byte
+		want Tool
+		ok   bool
+	}{
+		"codex tokens":  {codex, ToolCodex, true},
+		"codex api key": {[]byte(`{"OPENAI_API_KEY":"sk-test"}`), ToolCodex, true},
+		"pi providers":  {[]byte(`{"anthropic":{"type":"oauth","expires":1}}`), ToolPi, true},
+		"empty object":  {[]byte(`{}`), "", false},
+		"invalid":       {[]byte(`not json`), "", false},
+	} {
+		got, ok := detectToolFormat(tc.raw)
+		if got != tc.want || ok != tc.ok {
+			t.Fatalf("%s: detectToolFormat = %q, %v; want %q, %v", name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ags
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultLabelPattern is what a label must match when config.json has no
+// label_pattern. Tags and alias names always use it.
+const defaultLabelPattern = `[a-zA-Z0-9._-]+`
+
+var namePattern = regexp.MustCompile(`^` + defaultLabelPattern + `$`)
+
+// labelRule is the rule labels are checked against: the default, or a
+// config's label_pattern. The zero value is the default rule.
+type labelRule struct {
+	pattern *regexp.Regexp
+	// expr is the label_pattern as written, shown in error messages.
+	expr string
+}
+
+// unsafeLabelProbes are labels with a path separator, which would let a
+// snapshot or companion path leave its directory. A label_pattern matching
+// any of them is rejected. The . and .. names are left to labelRule.valid,
+// as every pattern that allows dots matches them.
+var unsafeLabelProbes = []string{"../a", "a/..", "a/b", "/", `a\b`, `\`, `..\a`}
+
+// valid reports whether label matches the rule. Separators and the . and ..
+// names are refused whatever the pattern says, since the probes cannot prove
+// a custom pattern safe.
+func (r labelRule) valid(label string) bool {
+	if strings.ContainsAny(label, `/\`) || label == "." || label == ".." {
+		return false
+	}
+	if r.pattern == nil {
+		return namePattern.MatchString(label)
+	}
+	return r.pattern.MatchString(label)
+}
+
+// String returns the pattern error messages ask a label to match.
+func (r labelRule) String() string {
+	if r.pattern == nil {
+		return defaultLabelPattern
+	}
+	return r.expr
+}
+
+// compileLabelPattern compiles a label_pattern value. It must match whole
+// labels, so it is anchored here, and must not admit any unsafe probe.
+func compileLabelPattern(expr string) (*regexp.Regexp, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, invalidInput("config label_pattern is empty")
+	}
+	re, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, invalidInputf("config label_pattern: %v", err)
+	}
+	for _, probe := range unsafeLabelProbes {
+		if re.MatchString(probe) {
+			return nil, invalidInputf("config label_pattern %q allows %q; labels must not contain path separators", expr, probe)
+		}
+	}
+	return re, nil
+}
+
+// parseLabelRule turns a config label_pattern into a labelRule; an unset
+// pattern is the default rule.
+func parseLabelRule(expr string) (labelRule, error) {
+	if strings.TrimSpace(expr) == "" {
+		return labelRule{}, nil
+	}
+	re, err := compileLabelPattern(expr)
+	if err != nil {
+		return labelRule{}, err
+	}
+	return labelRule{pattern: re, expr: strings.TrimSpace(expr)}, nil
+}
+
+// configuredLabelRule reads the label rule from root's config.json, for the
+// label checks Run makes before it opens a Manager, waiting at most timeout
+// when it is positive. A missing, unreadable, or unparsable config gives the
+// default; NewManager reports the latter two.
+func configuredLabelRule(root string, timeout time.Duration) (labelRule, error) {
+	dir, err := expandPath(root)
+	if err != nil {
+		return labelRule{}, nil
+	}
+	path := filepath.Join(dir, "config.json")
+	raw, err := valueWithTimeout(timeout, "reading "+path, func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
+	if err != nil {
+		return labelRule{}, nil
+	}
+	var cfg Config
+	if json.Unmarshal(raw, &cfg) != nil {
+		return labelRule{}, nil
+	}
+	rule, err := parseLabelRule(cfg.LabelPattern)
+	if err != nil {
+		return labelRule{}, fmt.Errorf("%w (in %s)", err, path)
+	}
+	return rule, nil
+}
@@ -1,16 +1,23 @@
 package ags
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -18,7 +25,41 @@ var (
 	unmarshalPIAuthJSON = json.Unmarshal
 )
 
-func NewManager(rootDir string) (*Manager, error) {
+// WithExpiringSoon overrides the window in which a token is reported as
+// expiring_soon instead of valid.
+func WithExpiringSoon(window time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.expiringSoon = window
+	}
+}
+
+// WithoutIdentityCache makes the manager report only the identity found in
+// each token: cached emails and plans are not filled in, and nothing new is
+// written to the cache.
+func WithoutIdentityCache() ManagerOption {
+	return func(m *Manager) {
+		m.noIdentityCache = true
+	}
+}
+
+// WithIOTimeout makes state reads, source lookups, and snapshot and state
+// writes fail with ErrIO instead of blocking longer than timeout, for data on
+// network filesystems that can hang. Zero disables the limit.
+func WithIOTimeout(timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.ioTimeout = timeout
+	}
+}
+
+// WithLogFile sets the operation log path, overriding log_file in
+// config.json.
+func WithLogFile(path string) ManagerOption {
+	return func(m *Manager) {
+		m.logFile = path
+	}
+}
+
+func NewManager(rootDir string, opts ...ManagerOption) (*Manager, error) {
 	rootExpanded, err := expandPath(rootDir)
 	if err != nil {
 		return nil, err
@@ -44,379 +85,840 @@ func NewManager(rootDir string) (*Manager, error) {
 		},
 	}
 
-	return &Manager{
-		rootDir: rootExpanded,
-		paths:   paths,
-	}, nil
+	m := &Manager{
+		rootDir:      rootExpanded,
+		paths:        paths,
+		expiringSoon: defaultExpiringSoon,
+	}
+	// Options are applied before the config is read, so its read honours
+	// WithIOTimeout, and again after, so they override config values.
+	for _, opt := range opts {
+		opt(m)
+	}
+	if err := m.applyConfig(); err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if err := probeDir(m.rootDir); err != nil && isReadOnlyErr(err) {
+		m.readOnly = true
+	}
+	if !m.readOnly {
+		removeStaleTempFiles(m.tempFileDirs(), nowUTC())
+	}
+	return m, nil
 }
 
-func (m *Manager) Save(tool Tool, label string, sourceOverride string) (*SaveResult, error) {
-	return m.save(tool, label, sourceOverride, "")
+// tempFileDirs lists every directory atomicWriteFile writes into under the
+// data root: the flat snapshot layout's snapshots/, each tool's snapshot and
+// backup directories, and each saved label's companions directory.
+func (m *Manager) tempFileDirs() []string {
+	dirs := []string{m.rootDir, filepath.Join(m.rootDir, "snapshots")}
+	for _, tool := range []Tool{ToolCodex, ToolPi} {
+		dirs = append(dirs,
+			filepath.Join(m.rootDir, "snapshots", tool.String()),
+			filepath.Join(m.rootDir, "backups", tool.String()),
+		)
+		companionDirs, _ := filepath.Glob(filepath.Join(m.rootDir, "snapshots", tool.String(), "companions", "*"))
+		dirs = append(dirs, companionDirs...)
+	}
+	return dirs
 }
 
-func (m *Manager) SaveWithPIProvider(tool Tool, label string, sourceOverride string, provider string) (*SaveResult, error) {
-	return m.save(tool, label, sourceOverride, provider)
+// ReadOnly reports whether the data root rejected a write probe. Read
+// commands and use still work; anything that records state fails.
+func (m *Manager) ReadOnly() bool {
+	return m.readOnly
 }
 
-func (m *Manager) save(tool Tool, label string, sourceOverride string, piProvider string) (*SaveResult, error) {
-	if err := validateManagerToolAndLabel(tool, label); err != nil {
-		return nil, err
-	}
+// LogFile returns the operation log path from config.json, or "" when
+// operation logging is not configured.
+func (m *Manager) LogFile() string {
+	return m.logFile
+}
+
+// Path override kinds for SetPathOverride and UnsetPathOverride.
+const (
+	PathKindRuntime = "runtime"
+	PathKindSource  = "source"
+)
 
-	sourcePath, err := m.resolveSourcePath(tool, sourceOverride)
+// SetPathOverride persists a runtime or source path for tool in config.json,
+// replacing the built-in default for later commands. It returns the
+// expanded path that was stored.
+func (m *Manager) SetPathOverride(tool Tool, kind string, p string) (string, error) {
+	if err := validateManagerTool(tool); err != nil {
+		return "", err
+	}
+	expanded, err := expandPath(p)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	err = m.updateConfigPaths(func(paths map[string]PathOverride) error {
+		override := paths[tool.String()]
+		switch kind {
+		case PathKindRuntime:
+			override.Runtime = expanded
+		case PathKindSource:
+			override.Source = expanded
+		default:
+			return invalidInputf("path kind must be %s or %s, got %q", PathKindRuntime, PathKindSource, kind)
+		}
+		paths[tool.String()] = override
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// UnsetPathOverride removes a persisted runtime or source path for tool so
+// the built-in default applies again.
+func (m *Manager) UnsetPathOverride(tool Tool, kind string) error {
+	if err := validateManagerTool(tool); err != nil {
+		return err
 	}
+	return m.updateConfigPaths(func(paths map[string]PathOverride) error {
+		override := paths[tool.String()]
+		switch kind {
+		case PathKindRuntime:
+			if override.Runtime == "" {
+				return notFoundf("no %s path override set for %s", kind, tool)
+			}
+			override.Runtime = ""
+		case PathKindSource:
+			if override.Source == "" {
+				return notFoundf("no %s path override set for %s", kind, tool)
+			}
+			override.Source = ""
+		default:
+			return invalidInputf("path kind must be %s or %s, got %q", PathKindRuntime, PathKindSource, kind)
+		}
+		if override == (PathOverride{}) {
+			delete(paths, tool.String())
+		} else {
+			paths[tool.String()] = override
+		}
+		return nil
+	})
+}
 
-	raw, err := os.ReadFile(sourcePath)
+// updateConfigPaths rewrites the "paths" key of config.json, keeping every
+// other setting as written.
+func (m *Manager) updateConfigPaths(update func(paths map[string]PathOverride) error) error {
+	if m.readOnly {
+		return ioErrorf("data root %s is read-only; config not saved", m.rootDir)
+	}
+	raw, ok, err := m.readOptionalFile(m.configPath())
 	if err != nil {
-		return nil, fmt.Errorf("reading source auth file: %w", err)
+		return ioErrorf("reading config: %w", err)
 	}
-	if err := validateJSONObject(raw); err != nil {
-		return nil, fmt.Errorf("source is not valid JSON object: %w", err)
+	cfg := map[string]json.RawMessage{}
+	if ok {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return invalidInputf("parsing config: %w", err)
+		}
 	}
-	if tool == ToolPi && strings.TrimSpace(piProvider) != "" {
-		raw, err = filterPIAuthProviders(raw, piProvider)
-		if err != nil {
-			return nil, err
+	paths := map[string]PathOverride{}
+	if existing, ok := cfg["paths"]; ok {
+		if err := json.Unmarshal(existing, &paths); err != nil {
+			return invalidInputf("parsing config paths: %w", err)
 		}
 	}
-
-	snapshotPath := m.snapshotPath(tool, label)
-	if err := atomicWriteFile(snapshotPath, raw, 0o600); err != nil {
-		return nil, fmt.Errorf("writing snapshot: %w", err)
+	if err := update(paths); err != nil {
+		return err
 	}
-
-	hash := sha256Hex(raw)
-	state, err := m.loadState()
-	if err != nil {
-		return nil, err
+	if len(paths) == 0 {
+		delete(cfg, "paths")
+	} else {
+		encoded, err := json.Marshal(paths)
+		if err != nil {
+			return fmt.Errorf("serializing config paths: %w", err)
+		}
+		cfg["paths"] = encoded
 	}
-	key := stateKey(tool, label)
-	prev, hadPrev := state.Entries[key]
-	changed := !hadPrev || prev.SHA256 != hash
-
-	insight := inspectAuth(tool, raw)
-	hydrateIdentityFromCache(&insight, state)
-	rememberIdentity(&state, insight)
-
-	state.Entries[key] = StateEntry{
-		Tool:         tool.String(),
-		Label:        label,
-		SourcePath:   sourcePath,
-		SnapshotPath: snapshotPath,
-		SHA256:       hash,
-		SavedAt:      nowISO(),
-		LastUsedAt:   prev.LastUsedAt,
-		LastUsedSHA:  prev.LastUsedSHA,
+	out, err := jsonMarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("serializing config: %w", err)
 	}
-
-	if err := m.saveState(state); err != nil {
-		return nil, err
+	out = append(out, '\n')
+	if err := m.writeFile(m.configPath(), out, 0o600); err != nil {
+		return ioErrorf("writing config: %w", err)
 	}
-
-	return &SaveResult{
-		Tool:                 tool,
-		Label:                label,
-		SourcePath:           sourcePath,
-		SnapshotPath:         snapshotPath,
-		ChangedSinceLastSave: changed,
-		Insight:              insight,
-	}, nil
-}
-
-func (m *Manager) Use(tool Tool, label string, targetOverride string) (*UseResult, error) {
-	return m.use(tool, label, targetOverride, "")
+	return nil
 }
 
-func (m *Manager) UseWithPIProvider(tool Tool, label string, targetOverride string, provider string) (*UseResult, error) {
-	return m.use(tool, label, targetOverride, provider)
+func (m *Manager) configPath() string {
+	return filepath.Join(m.rootDir, "config.json")
 }
 
-func (m *Manager) use(tool Tool, label string, targetOverride string, piProvider string) (*UseResult, error) {
-	if err := validateManagerToolAndLabel(tool, label); err != nil {
-		return nil, err
-	}
-
-	state, err := m.loadState()
+func (m *Manager) applyConfig() error {
+	raw, ok, err := m.readOptionalFile(m.configPath())
 	if err != nil {
-		return nil, err
+		return ioErrorf("reading config: %w", err)
 	}
-
-	key := stateKey(tool, label)
-	entry, ok := state.Entries[key]
 	if !ok {
-		return nil, fmt.Errorf("no saved profile for %s label=%q; run `ags save %s --label %s` first", tool, label, tool, label)
+		return nil
 	}
 
-	snapshotRaw, err := os.ReadFile(entry.SnapshotPath)
-	if err != nil {
-		return nil, fmt.Errorf("reading snapshot file: %w", err)
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return invalidInputf("parsing config: %w", err)
 	}
-	if err := validateJSONObject(snapshotRaw); err != nil {
-		return nil, fmt.Errorf("snapshot JSON invalid: %w", err)
+	m.compressSnapshots = cfg.CompressSnapshots
+	labels, err := parseLabelRule(cfg.LabelPattern)
+	if err != nil {
+		return err
 	}
-	snapshotToApply := snapshotRaw
-	if tool == ToolPi && strings.TrimSpace(piProvider) != "" {
-		snapshotToApply, err = filterPIAuthProviders(snapshotRaw, piProvider)
+	m.labels = labels
+	if strings.TrimSpace(cfg.RuntimeMode) != "" {
+		mode, err := parseFileMode(cfg.RuntimeMode)
 		if err != nil {
-			return nil, err
+			return invalidInputf("config runtime_mode: %v", err)
 		}
+		m.runtimeMode = mode
 	}
-
-	target := targetOverride
-	if strings.TrimSpace(target) == "" {
-		target = m.paths[tool].DefaultRuntime
-	}
-	target, err = expandPath(target)
+	aliases, err := parsePIProviderAliases(cfg.PIProviderAliases)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	previousTargetRaw, hadPreviousTarget, err := readOptionalFile(target)
+	m.piProviderAliases = aliases
+	envFiles, err := parseEnvFiles(cfg.EnvFiles)
 	if err != nil {
-		return nil, fmt.Errorf("reading existing target auth file: %w", err)
+		return err
 	}
-
-	rawToWrite := snapshotToApply
-	if tool == ToolPi {
-		rawToWrite, err = mergePIAuthWithTarget(snapshotToApply, target)
-		if err != nil {
-			return nil, fmt.Errorf("merging pi auth file: %w", err)
+	m.envFiles = envFiles
+	switch layout := strings.ToLower(strings.TrimSpace(cfg.SnapshotLayout)); layout {
+	case "", SnapshotLayoutNested:
+	case SnapshotLayoutFlat:
+		m.flatSnapshots = true
+	default:
+		return invalidInputf("config snapshot_layout must be %s or %s, got %q", SnapshotLayoutNested, SnapshotLayoutFlat, cfg.SnapshotLayout)
+	}
+	m.preUseHook = strings.TrimSpace(cfg.PreUse)
+	m.postUseHook = strings.TrimSpace(cfg.PostUse)
+	if cfg.CompanionFiles {
+		for tool, names := range companionFiles {
+			paths := m.paths[tool]
+			paths.Companions = names
+			m.paths[tool] = paths
 		}
 	}
-
-	if err := atomicWriteFile(target, rawToWrite, 0o600); err != nil {
-		return nil, fmt.Errorf("writing target auth file: %w", err)
+	for name, override := range cfg.Paths {
+		tool, ok := ParseTool(name)
+		if !ok {
+			return invalidInputf("config paths: unknown tool %q", name)
+		}
+		paths := m.paths[tool]
+		if override.Runtime != "" {
+			runtime, err := expandPath(override.Runtime)
+			if err != nil {
+				return invalidInputf("config paths.%s.runtime: %v", name, err)
+			}
+			paths.DefaultRuntime = runtime
+			paths.SaveCandidates = []string{runtime}
+		}
+		if override.Source != "" {
+			source, err := expandPath(override.Source)
+			if err != nil {
+				return invalidInputf("config paths.%s.source: %v", name, err)
+			}
+			paths.SaveCandidates = []string{source}
+		}
+		if override.RuntimeDir != "" {
+			dir, err := expandPath(override.RuntimeDir)
+			if err != nil {
+				return invalidInputf("config paths.%s.runtime_dir: %v", name, err)
+			}
+			paths.RuntimeDir = filepath.Clean(dir)
+		}
+		m.paths[tool] = paths
 	}
-
-	hash := sha256Hex(snapshotToApply)
-	changeSignal := "first use"
-	if entry.LastUsedSHA != "" {
-		if entry.LastUsedSHA == hash {
-			changeSignal = "unchanged since last use"
-		} else {
-			changeSignal = "changed since last use (likely refreshed)"
+	if strings.TrimSpace(cfg.LogFile) != "" {
+		logFile, err := expandPath(strings.TrimSpace(cfg.LogFile))
+		if err != nil {
+			return invalidInputf("config log_file: %v", err)
 		}
+		m.logFile = logFile
 	}
-
-	insight := inspectAuth(tool, snapshotToApply)
-	hydrateIdentityFromCache(&insight, state)
-	rememberIdentity(&state, insight)
-
-	entry.LastUsedAt = nowISO()
-	entry.LastUsedSHA = hash
-	state.Entries[key] = entry
-	if err := m.saveState(state); err != nil {
-		rollbackErr := rollbackUseTargetWrite(target, previousTargetRaw, hadPreviousTarget)
-		if rollbackErr != nil {
-			return nil, fmt.Errorf("saving state after writing target: %w (rollback failed: %v)", err, rollbackErr)
+	if strings.TrimSpace(cfg.ExpiringSoon) != "" {
+		window, err := time.ParseDuration(strings.TrimSpace(cfg.ExpiringSoon))
+		if err != nil || window < 0 {
+			return invalidInputf("config expiring_soon must be a non-negative duration, got %q", cfg.ExpiringSoon)
 		}
-		return nil, fmt.Errorf("saving state after writing target: %w (target rolled back)", err)
+		m.expiringSoon = window
 	}
-
-	return &UseResult{
-		Tool:               tool,
-		Label:              label,
-		TargetPath:         target,
-		ChangeSinceLastUse: changeSignal,
-		Insight:            insight,
-	}, nil
+	return nil
 }
 
-func filterPIAuthProviders(raw []byte, selector string) ([]byte, error) {
-	var payload map[string]any
-	if err := json.Unmarshal(raw, &payload); err != nil {
-		return nil, fmt.Errorf("pi auth JSON invalid: %w", err)
-	}
-	keys, err := resolvePIProviderKeys(payload, selector)
-	if err != nil {
-		return nil, err
-	}
-
-	filtered := make(map[string]any, len(keys))
-	for _, key := range keys {
-		filtered[key] = payload[key]
+// verifyExpiryFuture refuses an already expired source. A pi source is only
+// refused when every provider has expired; the expired ones of a partly
+// expired source are returned so the caller can warn.
+func (m *Manager) verifyExpiryFuture(tool Tool, raw []byte) ([]string, error) {
+	insight := m.inspect(tool, raw)
+	if tool == ToolPi && len(insight.Providers) > 0 {
+		expired := []string{}
+		for _, provider := range insight.Providers {
+			if provider.Status == "expired" {
+				expired = append(expired, provider.Name)
+			}
+		}
+		sort.Strings(expired)
+		if len(expired) == len(insight.Providers) {
+			return nil, invalidInputf("every provider in the source has expired (%s); refusing to save a dead credential", strings.Join(expired, ", "))
+		}
+		return expired, nil
 	}
-
-	out, err := jsonMarshalIndent(filtered, "", "  ")
-	if err != nil {
-		return nil, fmt.Errorf("serializing filtered pi auth: %w", err)
+	if insight.Status == "expired" {
+		return nil, invalidInputf("source token expired at %s; refusing to save a dead credential", insight.ExpiresAt)
 	}
-	out = append(out, '\n')
-	return out, nil
+	return nil, nil
 }
 
-func resolvePIProviderKeys(payload map[string]any, selector string) ([]string, error) {
-	selector = strings.TrimSpace(strings.ToLower(selector))
-	if selector == "" {
-		return nil, errors.New("pi provider selector is required")
-	}
-
-	matches := []string{}
-	switch selector {
-	case "codex":
-		for key := range payload {
-			lower := strings.ToLower(key)
-			if strings.Contains(lower, "codex") || strings.Contains(lower, "openai") {
-				matches = append(matches, key)
-			}
+// parsePIProviderAliases validates pi_provider_aliases and lowercases its
+// selectors so they compare like the --provider value.
+func parsePIProviderAliases(raw map[string][]string) (map[string][]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	aliases := make(map[string][]string, len(raw))
+	for name, keys := range raw {
+		selector := strings.ToLower(strings.TrimSpace(name))
+		if selector == "" || selector == "all" || strings.Contains(selector, ",") {
+			return nil, invalidInputf("config pi_provider_aliases: invalid selector %q", name)
 		}
-	case "anthropic":
-		for key := range payload {
-			lower := strings.ToLower(key)
-			if strings.Contains(lower, "anthropic") {
-				matches = append(matches, key)
+		cleaned := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if key = strings.TrimSpace(key); key != "" {
+				cleaned = append(cleaned, key)
 			}
 		}
-	default:
-		for key := range payload {
-			if key == selector || strings.EqualFold(key, selector) {
-				matches = append(matches, key)
-			}
+		if len(cleaned) == 0 {
+			return nil, invalidInputf("config pi_provider_aliases: %q lists no provider keys", name)
 		}
+		aliases[selector] = cleaned
 	}
-	sort.Strings(matches)
-	if len(matches) > 0 {
-		return matches, nil
-	}
+	return aliases, nil
+}
 
-	available := make([]string, 0, len(payload))
-	for key := range payload {
-		available = append(available, key)
-	}
-	sort.Strings(available)
-	return nil, fmt.Errorf("pi provider %q not found in source/snapshot. available providers: %s", selector, strings.Join(available, ", "))
+func (m *Manager) inspect(tool Tool, raw []byte) AuthInsight {
+	return inspectAuthWithin(tool, raw, m.expiringSoon)
 }
 
-func mergePIAuthWithTarget(snapshotRaw []byte, targetPath string) ([]byte, error) {
-	var snapshot map[string]any
-	if err := json.Unmarshal(snapshotRaw, &snapshot); err != nil {
-		return nil, fmt.Errorf("snapshot JSON invalid: %w", err)
-	}
+func (m *Manager) Save(tool Tool, label string, sourceOverride string) (*SaveResult, error) {
+	return m.save(tool, label, SaveOptions{SourceOverride: sourceOverride})
+}
+
+func (m *Manager) SaveWithPIProvider(tool Tool, label string, sourceOverride string, provider string) (*SaveResult, error) {
+	return m.save(tool, label, SaveOptions{SourceOverride: sourceOverride, PIProvider: provider})
+}
+
+func (m *Manager) SaveWithOptions(tool Tool, label string, opts SaveOptions) (*SaveResult, error) {
+	return m.save(tool, label, opts)
+}
 
-	targetRaw, err := os.ReadFile(targetPath)
+func (m *Manager) save(tool Tool, label string, opts SaveOptions) (*SaveResult, error) {
+	staged, err := m.stageSave(tool, label, opts)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return snapshotRaw, nil
-		}
-		return nil, fmt.Errorf("reading target auth file: %w", err)
+		return nil, err
 	}
-	if err := validateJSONObject(targetRaw); err != nil {
-		return nil, fmt.Errorf("target auth JSON invalid: %w", err)
+	if staged.result.Declined {
+		return staged.result, nil
 	}
-
-	var target map[string]any
-	if err := unmarshalPIAuthJSON(targetRaw, &target); err != nil {
-		return nil, fmt.Errorf("parsing target auth JSON: %w", err)
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	result := m.applyStagedSave(&state, staged)
+	if err := m.saveState(state); err != nil {
+		return nil, err
 	}
+	if err := staged.cleanup(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	for provider, auth := range snapshot {
-		target[provider] = auth
+// stagedSave is a save whose snapshot and companion files are written but
+// whose state entry is not recorded yet, so a caller can commit it with its
+// own state change or roll the files back.
+type stagedSave struct {
+	result         *SaveResult
+	raw            []byte
+	companions     map[string][]byte
+	companionPaths map[string]string
+	note           *string
+	tags           []string
+	// writes are the files written, with what they held before.
+	writes []companionTarget
+	// prev is the entry the save replaces, set by applyStagedSave.
+	prev    StateEntry
+	hadPrev bool
+}
+
+// rollback restores the files the stage overwrote and removes the ones it
+// created.
+func (s *stagedSave) rollback() error {
+	var firstErr error
+	for i := len(s.writes) - 1; i >= 0; i-- {
+		w := s.writes[i]
+		if err := rollbackUseTargetWrite(w.Path, w.Previous, w.HadPrevious); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	merged, err := jsonMarshalIndent(target, "", "  ")
-	if err != nil {
-		return nil, fmt.Errorf("serializing merged pi auth: %w", err)
+// cleanup removes what the replaced entry left behind once the new one is
+// saved: a snapshot under a different name (plain vs compressed) and
+// companions the new entry no longer has.
+func (s *stagedSave) cleanup() error {
+	if s.hadPrev && !s.prev.Linked && s.prev.SnapshotPath != s.result.SnapshotPath {
+		if err := os.Remove(s.prev.SnapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return ioErrorf("removing previous snapshot file: %w", err)
+		}
 	}
-	merged = append(merged, '\n')
-	return merged, nil
+	return removeCompanions(s.prev.Companions, s.companionPaths)
 }
 
-func (m *Manager) Delete(tool Tool, label string) (*DeleteResult, error) {
-	if err := validateManagerToolAndLabel(tool, label); err != nil {
+// stageSave reads and checks the source and writes the snapshot and its
+// companions, leaving state alone.
+func (m *Manager) stageSave(tool Tool, label string, opts SaveOptions) (*stagedSave, error) {
+	piProvider := opts.PIProvider
+	if opts.LabelFromAccount {
+		if err := validateManagerTool(tool); err != nil {
+			return nil, err
+		}
+	} else if err := m.validateToolAndLabel(tool, label); err != nil {
 		return nil, err
 	}
 
-	state, err := m.loadState()
-	if err != nil {
+	if opts.Note != nil {
+		if err := validateNote(*opts.Note); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTags(opts.Tags); err != nil {
 		return nil, err
 	}
 
-	key := stateKey(tool, label)
-	entry, ok := state.Entries[key]
-	if !ok {
-		return nil, fmt.Errorf("no saved snapshot for %s label=%q", tool, label)
+	sourcePath, raw, err := m.readSource(tool, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateJSONObject(raw); err != nil {
+		return nil, invalidInputf("source is not valid JSON object: %w", err)
+	}
+	if opts.StrictJSON {
+		if err := validateStrictJSONObject(raw); err != nil {
+			return nil, invalidInputf("source failed strict JSON validation: %w", err)
+		}
+	}
+	var detected Tool
+	switch opts.ExpectTool {
+	case "", ExpectToolWarn, ExpectToolStrict:
+		if sniffed, ok := detectToolFormat(raw); ok && sniffed != tool {
+			if opts.ExpectTool == ExpectToolStrict {
+				return nil, invalidInputf("source looks like a %s auth file, not %s; save it under %s or pass --expect-tool warn", sniffed, tool, sniffed)
+			}
+			detected = sniffed
+		}
+	case ExpectToolOff:
+	default:
+		return nil, invalidInputf("expect-tool must be %s, %s, or %s", ExpectToolWarn, ExpectToolStrict, ExpectToolOff)
+	}
+	companions, err := m.readCompanions(tool, sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if tool == ToolPi && strings.TrimSpace(piProvider) != "" {
+		raw, err = filterPIAuthProviders(raw, piProvider, m.piProviderAliases)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var expiredProviders []string
+	if opts.VerifyExpiryFuture {
+		expiredProviders, err = m.verifyExpiryFuture(tool, raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.LabelFromAccount {
+		label, err = m.labelFromAccount(tool, raw, companions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.ConfirmOverwrite != nil {
+		confirmed, err := m.confirmOverwrite(tool, label, raw, companions, opts.ConfirmOverwrite)
+		if err != nil {
+			return nil, err
+		}
+		if !confirmed {
+			return &stagedSave{result: &SaveResult{Tool: tool, Label: label, SourcePath: sourcePath, Declined: true}}, nil
+		}
 	}
 
-	snapshotDeleted := false
-	if err := os.Remove(entry.SnapshotPath); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("deleting snapshot file: %w", err)
+	snapshotPath := m.snapshotPath(tool, label)
+	fileRaw := raw
+	compress := m.compressSnapshots
+	if opts.Compress != nil {
+		compress = *opts.Compress
+	}
+	if compress {
+		snapshotPath += gzipSuffix
+		fileRaw, err = gzipBytes(raw)
+		if err != nil {
+			return nil, fmt.Errorf("compressing snapshot: %w", err)
 		}
-	} else {
-		snapshotDeleted = true
 	}
 
-	delete(state.Entries, key)
-	if err := m.saveState(state); err != nil {
-		return nil, err
+	staged := &stagedSave{
+		result: &SaveResult{
+			Tool:             tool,
+			Label:            label,
+			SourcePath:       sourcePath,
+			SnapshotPath:     snapshotPath,
+			DetectedTool:     detected,
+			ExpiredProviders: expiredProviders,
+		},
+		raw:        raw,
+		companions: companions,
+		note:       opts.Note,
+		tags:       opts.Tags,
+	}
+	if err := m.stageWrite(staged, snapshotPath, fileRaw); err != nil {
+		staged.rollback()
+		return nil, ioErrorf("writing snapshot: %w", err)
+	}
+	if len(companions) > 0 {
+		staged.companionPaths = make(map[string]string, len(companions))
+		for name, companionRaw := range companions {
+			path := m.companionSnapshotPath(tool, label, name)
+			if err := m.stageWrite(staged, path, companionRaw); err != nil {
+				staged.rollback()
+				return nil, ioErrorf("writing companion snapshot: %w", err)
+			}
+			staged.companionPaths[name] = path
+		}
 	}
+	return staged, nil
+}
 
-	return &DeleteResult{
-		Tool:            tool,
-		Label:           label,
-		SnapshotPath:    entry.SnapshotPath,
-		SnapshotDeleted: snapshotDeleted,
-	}, nil
+// stageWrite writes one file of a staged save, remembering what it held.
+func (m *Manager) stageWrite(staged *stagedSave, path string, raw []byte) error {
+	previous, hadPrevious, err := m.readOptionalFile(path)
+	if err != nil {
+		return err
+	}
+	if err := m.writeFile(path, raw, 0o600); err != nil {
+		return err
+	}
+	staged.writes = append(staged.writes, companionTarget{Path: path, Raw: raw, Previous: previous, HadPrevious: hadPrevious})
+	return nil
 }
 
-func (m *Manager) List(toolFilter *Tool) ([]ListItem, error) {
-	if toolFilter != nil {
-		if err := validateManagerTool(*toolFilter); err != nil {
-			return nil, err
-		}
+// applyStagedSave records a staged save in state and returns its result.
+// The caller saves state and then calls the stage's cleanup.
+func (m *Manager) applyStagedSave(state *State, staged *stagedSave) *SaveResult {
+	result := staged.result
+	tool, label := result.Tool, result.Label
+	hash := sha256Hex(staged.raw)
+	key := stateKey(tool, label)
+	prev, hadPrev := state.Entries[key]
+	staged.prev, staged.hadPrev = prev, hadPrev
+	result.ChangedSinceLastSave = !hadPrev || prev.SHA256 != hash
+	result.DuplicateLabels = duplicateLabels(*state, tool, label, hash)
+
+	insight := m.inspect(tool, staged.raw)
+	applyCompanionIdentity(tool, &insight, staged.companions)
+	m.hydrateIdentity(&insight, *state)
+	m.rememberIdentity(state, insight)
+	result.Insight = insight
+	result.AccountConflicts = m.labelAccountConflicts(*state, tool, label, insight)
+
+	note := prev.Note
+	if staged.note != nil {
+		note = strings.TrimSpace(*staged.note)
+	}
+	action := "resaved"
+	switch {
+	case !hadPrev:
+		action = "created"
+	case result.ChangedSinceLastSave:
+		action = "changed"
+	}
+	savedAt := nowISO()
+	state.Entries[key] = StateEntry{
+		Tool:         tool.String(),
+		Label:        label,
+		SourcePath:   result.SourcePath,
+		SnapshotPath: result.SnapshotPath,
+		SHA256:       hash,
+		SavedAt:      savedAt,
+		LastUsedAt:   prev.LastUsedAt,
+		LastUsedSHA:  prev.LastUsedSHA,
+		Note:         note,
+		Tags:         mergeTags(prev.Tags, staged.tags...),
+		Companions:   staged.companionPaths,
+		History:      appendHistory(prev.History, action, savedAt, hash),
 	}
+	return result
+}
 
+// labelFromAccount derives a label from the local part of the account
+// email in raw. When that label is already saved for a different account, a
+// numeric suffix is appended until one is free or belongs to this account.
+func (m *Manager) labelFromAccount(tool Tool, raw []byte, companions map[string][]byte) (string, error) {
 	state, err := m.loadState()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	insight := m.inspect(tool, raw)
+	applyCompanionIdentity(tool, &insight, companions)
+	m.hydrateIdentity(&insight, state)
+	email := strings.TrimSpace(insight.AccountEmail)
+	base := accountLabelSlug(email)
+	if base == "" {
+		return "", invalidInputf("cannot derive a label: no account email found in the %s source", tool)
 	}
 
-	items := make([]ListItem, 0, len(state.Entries))
-	for _, entry := range state.Entries {
-		tool, ok := ParseTool(entry.Tool)
+	for n := 1; ; n++ {
+		label := base
+		if n > 1 {
+			label = fmt.Sprintf("%s-%d", base, n)
+		}
+		if !m.labels.valid(label) {
+			return "", invalidInputf("label %q derived from %s must match %s; pass a label instead", label, email, m.labels)
+		}
+		entry, ok := state.Entries[stateKey(tool, label)]
 		if !ok {
+			return label, nil
+		}
+		existingRaw, err := m.readSnapshotFile(entry.SnapshotPath)
+		if err != nil {
 			continue
 		}
-		if toolFilter != nil && *toolFilter != tool {
+		existing := m.inspect(tool, existingRaw)
+		applyCompanionIdentity(tool, &existing, m.readEntryCompanions(entry))
+		m.hydrateIdentity(&existing, state)
+		if strings.EqualFold(strings.TrimSpace(existing.AccountEmail), email) {
+			return label, nil
+		}
+	}
+}
+
+// confirmOverwrite asks confirm before raw replaces a saved snapshot with
+// different content. A new label or an identical snapshot needs no answer.
+func (m *Manager) confirmOverwrite(tool Tool, label string, raw []byte, companions map[string][]byte, confirm func(string, AuthInsight, AuthInsight) bool) (bool, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return false, err
+	}
+	prev, ok := state.Entries[stateKey(tool, label)]
+	if !ok || prev.SHA256 == sha256Hex(raw) {
+		return true, nil
+	}
+	var previous AuthInsight
+	if prevRaw, err := m.readSnapshotFile(prev.SnapshotPath); err == nil {
+		previous = m.inspect(tool, prevRaw)
+		applyCompanionIdentity(tool, &previous, m.readEntryCompanions(prev))
+		m.hydrateIdentity(&previous, state)
+	}
+	next := m.inspect(tool, raw)
+	applyCompanionIdentity(tool, &next, companions)
+	m.hydrateIdentity(&next, state)
+	return confirm(label, previous, next), nil
+}
+
+// accountLabelSlug turns the local part of email into a label: lowercased,
+// with each run of non-alphanumerics replaced by a single "-".
+func accountLabelSlug(email string) string {
+	local, _, _ := strings.Cut(email, "@")
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(local) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
 			continue
 		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
 
-		raw, err := os.ReadFile(entry.SnapshotPath)
-		insight := AuthInsight{
-			Status:       "unknown",
-			NeedsRefresh: "unknown",
-			Details:      []string{"snapshot missing or unreadable"},
+// duplicateLabels returns the other labels of tool whose recorded snapshot
+// hash equals hash, sorted.
+func duplicateLabels(state State, tool Tool, label string, hash string) []string {
+	var labels []string
+	for _, entry := range state.Entries {
+		if entry.Tool == tool.String() && entry.Label != label && entry.SHA256 == hash {
+			labels = append(labels, entry.Label)
 		}
-		if err == nil {
-			insight = inspectAuth(tool, raw)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// labelAccountConflicts returns the profiles of other tools saved under the
+// same label whose account differs from insight. Profiles whose account is
+// unknown on either side are skipped.
+func (m *Manager) labelAccountConflicts(state State, tool Tool, label string, insight AuthInsight) []AccountConflict {
+	var conflicts []AccountConflict
+	for _, other := range []Tool{ToolCodex, ToolPi} {
+		if other == tool {
+			continue
 		}
+		entry, ok := state.Entries[stateKey(other, label)]
+		if !ok {
+			continue
+		}
+		raw, err := m.readSnapshotFile(entry.SnapshotPath)
+		if err != nil {
+			continue
+		}
+		otherInsight := m.inspect(other, raw)
+		m.hydrateIdentity(&otherInsight, state)
+		if sameAccount(insight, otherInsight) {
+			continue
+		}
+		conflicts = append(conflicts, AccountConflict{Tool: other, Account: accountGroupKey(otherInsight)})
+	}
+	return conflicts
+}
 
-		items = append(items, ListItem{
-			Tool:        tool,
-			Label:       entry.Label,
-			SavedAt:     entry.SavedAt,
-			LastUsedAt:  entry.LastUsedAt,
-			Snapshot:    entry.SnapshotPath,
-			AuthInsight: insight,
-		})
+// sameAccount compares emails when both sides have one, then account ids.
+// It reports true when there is nothing to compare.
+func sameAccount(a, b AuthInsight) bool {
+	emailA, emailB := strings.TrimSpace(a.AccountEmail), strings.TrimSpace(b.AccountEmail)
+	if emailA != "" && emailB != "" {
+		return strings.EqualFold(emailA, emailB)
 	}
+	idA, idB := strings.TrimSpace(a.AccountID), strings.TrimSpace(b.AccountID)
+	if idA != "" && idB != "" {
+		return idA == idB
+	}
+	return true
+}
 
-	sort.Slice(items, func(i, j int) bool {
-		if items[i].Tool == items[j].Tool {
-			return items[i].Label < items[j].Label
+// Link registers an existing JSON file as the snapshot for tool and label
+// without copying it. The file must already be a JSON object.
+func (m *Manager) Link(tool Tool, label string, snapshotPath string) (*SaveResult, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+	path, err := expandPath(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, ioErrorf("resolving snapshot path: %w", err)
+	}
+	info, err := m.stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, notFoundf("snapshot file does not exist: %s", path)
 		}
-		return items[i].Tool < items[j].Tool
-	})
+		return nil, ioErrorf("inspecting snapshot file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, invalidInputf("snapshot path is not a regular file: %s", path)
+	}
+	raw, err := m.readSnapshotFile(path)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	if err := validateJSONObject(raw); err != nil {
+		return nil, invalidInputf("snapshot is not valid JSON object: %w", err)
+	}
 
-	return items, nil
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	key := stateKey(tool, label)
+	if _, exists := state.Entries[key]; exists {
+		return nil, classify(ErrAlreadyExists, fmt.Errorf("%s label=%q already exists; delete it before linking", tool, label))
+	}
+
+	hash := sha256Hex(raw)
+	insight := m.inspect(tool, raw)
+	m.hydrateIdentity(&insight, state)
+	m.rememberIdentity(&state, insight)
+
+	savedAt := nowISO()
+	state.Entries[key] = StateEntry{
+		Tool:         tool.String(),
+		Label:        label,
+		SourcePath:   path,
+		SnapshotPath: path,
+		SHA256:       hash,
+		SavedAt:      savedAt,
+		Linked:       true,
+		History:      appendHistory(nil, "linked", savedAt, hash),
+	}
+	if err := m.saveState(state); err != nil {
+		return nil, err
+	}
+
+	return &SaveResult{
+		Tool:                 tool,
+		Label:                label,
+		SourcePath:           path,
+		SnapshotPath:         path,
+		ChangedSinceLastSave: true,
+		Insight:              insight,
+		DuplicateLabels:      duplicateLabels(state, tool, label, hash),
+	}, nil
 }
 
-func (m *Manager) Active(toolFilter *Tool) ([]ActiveItem, error) {
-	if toolFilter != nil {
-		if err := validateManagerTool(*toolFilter); err != nil {
+func (m *Manager) Use(tool Tool, label string, targetOverride string) (*UseResult, error) {
+	return m.use(tool, label, UseOptions{TargetOverride: targetOverride})
+}
+
+func (m *Manager) UseWithPIProvider(tool Tool, label string, targetOverride string, provider string) (*UseResult, error) {
+	return m.use(tool, label, UseOptions{TargetOverride: targetOverride, PIProvider: provider})
+}
+
+func (m *Manager) UseWithOptions(tool Tool, label string, opts UseOptions) (*UseResult, error) {
+	return m.use(tool, label, opts)
+}
+
+func (m *Manager) use(tool Tool, label string, opts UseOptions) (*UseResult, error) {
+	piProvider := opts.PIProvider
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+	switch opts.MergeStrategy {
+	case "", PIMergeReplace, PIMergeDeep:
+	default:
+		return nil, invalidInputf("merge strategy must be %s or %s", PIMergeReplace, PIMergeDeep)
+	}
+	mode := opts.Mode
+	if mode != 0 {
+		if err := validateFileMode(mode); err != nil {
+			return nil, err
+		}
+	} else if m.runtimeMode != 0 {
+		mode = m.runtimeMode
+	} else {
+		mode = defaultRuntimeMode
+	}
+	if opts.BackupRuntimeTo != "" {
+		if err := m.validateToolAndLabel(tool, opts.BackupRuntimeTo); err != nil {
 			return nil, err
 		}
+		if opts.BackupRuntimeTo == label {
+			return nil, invalidInputf("cannot back up the runtime auth file to %s/%s, the label being activated", tool, label)
+		}
 	}
 
 	state, err := m.loadState()
@@ -424,111 +926,1710 @@ func (m *Manager) Active(toolFilter *Tool) ([]ActiveItem, error) {
 		return nil, err
 	}
 
-	tools := []Tool{ToolCodex, ToolPi}
-	if toolFilter != nil {
-		tools = []Tool{*toolFilter}
+	key := stateKey(tool, label)
+	entry, ok := state.Entries[key]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q; run `ags save %s --label %s` first", tool, label, tool, label)
 	}
 
-	items := make([]ActiveItem, 0, len(tools))
-	for _, tool := range tools {
-		runtimePath := m.paths[tool].DefaultRuntime
-		toolEntries := make([]StateEntry, 0)
-		for _, entry := range state.Entries {
-			parsedTool, ok := ParseTool(entry.Tool)
-			if ok && parsedTool == tool {
-				toolEntries = append(toolEntries, entry)
-			}
+	snapshotRaw, err := m.readSnapshotFile(entry.SnapshotPath)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	if opts.StrictJSON {
+		if err := validateStrictJSONObject(snapshotRaw); err != nil {
+			return nil, invalidInputf("snapshot %s failed strict JSON validation: %w", entry.SnapshotPath, err)
+		}
+	}
+	modified, err := checkSnapshotHash(entry, snapshotRaw, opts.Strict)
+	if err != nil {
+		return nil, err
+	}
+	snapshotToApply, err := prepareSnapshotToApply(tool, snapshotRaw, piProvider, m.piProviderAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	target := opts.TargetOverride
+	if strings.TrimSpace(target) == "" {
+		target = m.paths[tool].DefaultRuntime
+	}
+	target, err = expandPath(target)
+	if err != nil {
+		return nil, err
+	}
+	target, err = m.checkSymlink(target, opts.FollowSymlinks)
+	if err != nil {
+		return nil, err
+	}
+
+	var companions map[string][]byte
+	if len(m.paths[tool].Companions) > 0 {
+		companions = m.readEntryCompanions(entry)
+	}
+	insight := m.inspect(tool, snapshotToApply)
+	applyCompanionIdentity(tool, &insight, companions)
+	m.hydrateIdentity(&insight, state)
+	if expected := strings.TrimSpace(opts.ExpectEmail); expected != "" && !strings.EqualFold(insight.AccountEmail, expected) {
+		actual := insight.AccountEmail
+		if actual == "" {
+			actual = "an unknown account"
+		}
+		return nil, invalidInputf("%s/%s belongs to %s, not %s; refusing to switch", tool, label, actual, expected)
+	}
+	if opts.IfChanged {
+		applied, err := m.runtimeAlreadyApplied(tool, snapshotToApply, target, opts.NoMerge, opts.MergeStrategy)
+		if err != nil {
+			return nil, err
 		}
+		if applied {
+			return &UseResult{
+				Tool:             tool,
+				Label:            label,
+				TargetPath:       target,
+				Insight:          insight,
+				SnapshotModified: modified,
+				AlreadyActive:    true,
+			}, nil
+		}
+	}
+	var envWrites []envFileWrite
+	if opts.EnvFiles {
+		envWrites, err = m.renderEnvFiles(tool, label, snapshotToApply, insight)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		if len(toolEntries) == 0 {
-			items = append(items, ActiveItem{
-				Tool:        tool,
-				Status:      "no saved profiles",
-				RuntimePath: runtimePath,
-			})
-			continue
+	if opts.BackupRuntimeTo != "" && m.readOnly {
+		return nil, ioErrorf("data root %s is read-only; --backup-runtime-to cannot save there", m.rootDir)
+	}
+
+	hook := useHookContext{Tool: tool, Label: label, Target: target, Insight: insight, Output: opts.HookOutput}
+	if err := runUseHook(preUseHook, m.preUseHook, hook); err != nil {
+		return nil, fmt.Errorf("%w; %s/%s was not activated", err, tool, label)
+	}
+
+	previousTargetRaw, hadPreviousTarget, err := m.readOptionalFile(target)
+	if err != nil {
+		return nil, ioErrorf("reading existing target auth file: %w", err)
+	}
+	if opts.StrictJSON && tool == ToolPi && !opts.NoMerge && hadPreviousTarget {
+		if err := validateStrictJSONObject(previousTargetRaw); err != nil {
+			return nil, invalidInputf("runtime file %s failed strict JSON validation: %w", target, err)
+		}
+	}
+	if opts.Backup && m.readOnly {
+		return nil, ioErrorf("data root %s is read-only; --backup cannot write there", m.rootDir)
+	}
+	backupPath := ""
+	if opts.Backup && hadPreviousTarget {
+		backupPath, err = m.writeRuntimeBackup(tool, label, previousTargetRaw)
+		if err != nil {
+			return nil, ioErrorf("writing runtime backup: %w", err)
+		}
+	}
+
+	rawToWrite := snapshotToApply
+	if tool == ToolPi && !opts.NoMerge {
+		rawToWrite, err = m.mergePIAuthWithStrategy(snapshotToApply, target, opts.MergeStrategy)
+		if err != nil {
+			return nil, fmt.Errorf("merging pi auth file: %w", err)
+		}
+	}
+
+	companionWrites, err := m.companionTargets(companions, target)
+	if err != nil {
+		return nil, err
+	}
+
+	// The runtime backup is staged last, just before the runtime write, and
+	// committed with this use's state change; every failure from here on
+	// rolls its files back so the backup label only exists after a switch.
+	var runtimeBackup *SaveResult
+	var backupStage *stagedSave
+	if opts.BackupRuntimeTo != "" {
+		backupStage, err = m.stageSave(tool, opts.BackupRuntimeTo, SaveOptions{SourceOverride: target, FollowSymlinks: opts.FollowSymlinks})
+		if err != nil {
+			return nil, fmt.Errorf("%w; %s/%s was not activated", err, tool, label)
 		}
+		runtimeBackup = m.applyStagedSave(&state, backupStage)
+	}
+	abortBackup := func(err error) error {
+		if backupStage == nil {
+			return err
+		}
+		if rollbackErr := backupStage.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (removing runtime backup %s failed: %v)", err, opts.BackupRuntimeTo, rollbackErr)
+		}
+		return err
+	}
+
+	if err := m.writeFile(target, rawToWrite, mode); err != nil {
+		return nil, abortBackup(ioErrorf("writing target auth file: %w", err))
+	}
+	for _, companion := range companionWrites {
+		if err := m.writeFile(companion.Path, companion.Raw, 0o600); err != nil {
+			return nil, abortBackup(ioErrorf("writing companion file: %w", err))
+		}
+	}
+
+	hash := sha256Hex(snapshotToApply)
+	changeSignal := "first use"
+	if entry.LastUsedSHA != "" {
+		if entry.LastUsedSHA == hash {
+			changeSignal = changeSignalUnchanged
+		} else {
+			changeSignal = "changed since last use (likely refreshed)"
+		}
+	}
+
+	m.rememberIdentity(&state, insight)
+
+	result := &UseResult{
+		Tool:               tool,
+		Label:              label,
+		TargetPath:         target,
+		BackupPath:         backupPath,
+		ChangeSinceLastUse: changeSignal,
+		Insight:            insight,
+		SnapshotModified:   modified,
+		TargetMode:         mode,
+		RuntimeBackup:      runtimeBackup,
+	}
+	if opts.RuntimeCheck {
+		result.RuntimeProblems = m.checkRuntimeFile(tool, target)
+	}
+	writeEnvFiles := func() {
+		paths, err := m.writeEnvFiles(envWrites)
+		result.EnvFiles = paths
+		if err != nil {
+			result.EnvFileError = err.Error()
+		}
+	}
+	if m.readOnly {
+		writeEnvFiles()
+		result.Warning = fmt.Sprintf("data root %s is read-only; last-used time was not recorded", m.rootDir)
+		if err := runUseHook(postUseHook, m.postUseHook, hook); err != nil {
+			result.PostUseHookError = err.Error()
+		}
+		return result, nil
+	}
+
+	entry.LastUsedAt = nowISO()
+	entry.LastUsedSHA = hash
+	entry.History = appendHistory(entry.History, "used", entry.LastUsedAt, hash)
+	state.Entries[key] = entry
+	if last := state.LastActivatedLabel[tool.String()]; last != "" && last != label {
+		state.PreviousActivatedLabel[tool.String()] = last
+	}
+	state.LastActivatedLabel[tool.String()] = label
+	if err := m.saveState(state); err != nil {
+		rollbackErr := rollbackUseTargetWrite(target, previousTargetRaw, hadPreviousTarget)
+		for _, companion := range companionWrites {
+			if companionErr := rollbackUseTargetWrite(companion.Path, companion.Previous, companion.HadPrevious); companionErr != nil && rollbackErr == nil {
+				rollbackErr = companionErr
+			}
+		}
+		if rollbackErr != nil {
+			return nil, abortBackup(fmt.Errorf("saving state after writing target: %w (rollback failed: %v)", err, rollbackErr))
+		}
+		return nil, abortBackup(fmt.Errorf("saving state after writing target: %w (target rolled back)", err))
+	}
+	if backupStage != nil {
+		if err := backupStage.cleanup(); err != nil {
+			result.Warning = err.Error()
+		}
+	}
+	writeEnvFiles()
+	if err := runUseHook(postUseHook, m.postUseHook, hook); err != nil {
+		result.PostUseHookError = err.Error()
+	}
+	return result, nil
+}
+
+// Touch marks a saved profile as used now without reading the snapshot or
+// writing the runtime file, for when the profile is known to be active
+// already. It returns the recorded LastUsedAt.
+func (m *Manager) Touch(tool Tool, label string) (string, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return "", err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return "", err
+	}
+	key := stateKey(tool, label)
+	entry, ok := state.Entries[key]
+	if !ok {
+		return "", notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+
+	entry.LastUsedAt = nowISO()
+	entry.LastUsedSHA = entry.SHA256
+	entry.History = appendHistory(entry.History, "touched", entry.LastUsedAt, entry.SHA256)
+	state.Entries[key] = entry
+	if err := m.saveState(state); err != nil {
+		return "", err
+	}
+	return entry.LastUsedAt, nil
+}
+
+// SnapshotPath locates the snapshot of tool/label without reading it. An
+// unsaved label is reported as not found unless evenIfMissing is set, in
+// which case only LayoutPath is filled in.
+func (m *Manager) SnapshotPath(tool Tool, label string, evenIfMissing bool) (*SnapshotPathResult, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	result := &SnapshotPathResult{Tool: tool, Label: label, LayoutPath: m.snapshotPath(tool, label)}
+	entry, ok := state.Entries[stateKey(tool, label)]
+	if !ok {
+		if !evenIfMissing {
+			return nil, notFoundf("no saved profile for %s label=%q", tool, label)
+		}
+		return result, nil
+	}
+	result.RecordedPath = entry.SnapshotPath
+	result.Linked = entry.Linked
+	return result, nil
+}
+
+// History returns the recorded events of one profile, newest first. State
+// written before history was recorded yields events rebuilt from SavedAt and
+// LastUsedAt instead.
+func (m *Manager) History(tool Tool, label string) ([]HistoryEvent, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := state.Entries[stateKey(tool, label)]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+
+	events := append([]HistoryEvent(nil), entry.History...)
+	if len(events) == 0 {
+		events = append(events, HistoryEvent{Action: "saved", At: entry.SavedAt, SHA256: entry.SHA256})
+		if entry.LastUsedAt != "" {
+			events = append(events, HistoryEvent{Action: "used", At: entry.LastUsedAt, SHA256: entry.LastUsedSHA})
+		}
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// appendHistory adds an event, dropping the oldest beyond maxHistoryEvents.
+func appendHistory(history []HistoryEvent, action string, at string, hash string) []HistoryEvent {
+	history = append(history, HistoryEvent{Action: action, At: at, SHA256: hash})
+	if len(history) > maxHistoryEvents {
+		history = append([]HistoryEvent(nil), history[len(history)-maxHistoryEvents:]...)
+	}
+	return history
+}
+
+// PreviousLabel returns the label that was active before the current one for
+// tool, as recorded by use.
+func (m *Manager) PreviousLabel(tool Tool) (string, error) {
+	if err := validateManagerTool(tool); err != nil {
+		return "", err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return "", err
+	}
+	label := state.PreviousActivatedLabel[tool.String()]
+	if label == "" {
+		return "", notFoundf("no previously active %s profile recorded; --revert needs an earlier ags use of a different label", tool)
+	}
+	return label, nil
+}
+
+// checkRuntimeFile re-reads a just-written runtime file and returns the
+// problems runtimeAuthProblems finds, plus any that keep the owner from
+// reading it.
+func (m *Manager) checkRuntimeFile(tool Tool, target string) []string {
+	info, err := m.stat(target)
+	if err != nil {
+		return []string{fmt.Sprintf("could not stat runtime file: %v", err)}
+	}
+	var problems []string
+	if info.Mode().Perm()&0o400 == 0 {
+		problems = append(problems, fmt.Sprintf("runtime file is not readable by its owner (mode %s)", info.Mode().Perm()))
+	}
+	raw, err := m.readFile(target)
+	if err != nil {
+		return append(problems, fmt.Sprintf("could not re-read runtime file: %v", err))
+	}
+	return append(problems, runtimeAuthProblems(tool, raw)...)
+}
+
+// ReadSnapshot returns the snapshot content that `use` would apply, with pi
+// provider filtering, without touching the runtime file or state.
+func (m *Manager) ReadSnapshot(tool Tool, label string, piProvider string) ([]byte, error) {
+	result, err := m.ReadSnapshotWithOptions(tool, label, UseOptions{PIProvider: piProvider})
+	if err != nil {
+		return nil, err
+	}
+	return result.Raw, nil
+}
+
+// ReadSnapshotWithOptions returns what use would write for tool/label
+// without writing it, as for ags use --print. Only the options that check
+// the snapshot itself apply: PIProvider, Strict, and StrictJSON.
+func (m *Manager) ReadSnapshotWithOptions(tool Tool, label string, opts UseOptions) (*ReadSnapshotResult, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := state.Entries[stateKey(tool, label)]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q; run `ags save %s --label %s` first", tool, label, tool, label)
+	}
+	snapshotRaw, err := m.readSnapshotFile(entry.SnapshotPath)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	if opts.StrictJSON {
+		if err := validateStrictJSONObject(snapshotRaw); err != nil {
+			return nil, invalidInputf("snapshot %s failed strict JSON validation: %w", entry.SnapshotPath, err)
+		}
+	}
+	modified, err := checkSnapshotHash(entry, snapshotRaw, opts.Strict)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := prepareSnapshotToApply(tool, snapshotRaw, opts.PIProvider, m.piProviderAliases)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadSnapshotResult{Tool: tool, Label: label, Raw: raw, SnapshotModified: modified}, nil
+}
+
+func prepareSnapshotToApply(tool Tool, snapshotRaw []byte, piProvider string, aliases map[string][]string) ([]byte, error) {
+	if err := validateJSONObject(snapshotRaw); err != nil {
+		return nil, fmt.Errorf("snapshot JSON invalid: %w", err)
+	}
+	if tool == ToolPi && strings.TrimSpace(piProvider) != "" {
+		return filterPIAuthProviders(snapshotRaw, piProvider, aliases)
+	}
+	return snapshotRaw, nil
+}
+
+// snapshotModified reports whether raw no longer hashes to the SHA-256
+// recorded at save time. Linked snapshots are expected to change in place
+// and entries without a recorded hash cannot be checked.
+func snapshotModified(entry StateEntry, raw []byte) bool {
+	if entry.Linked || entry.SHA256 == "" {
+		return false
+	}
+	return sha256Hex(raw) != entry.SHA256
+}
+
+// checkSnapshotHash reports whether raw was modified since entry was saved,
+// failing instead when strict is set.
+func checkSnapshotHash(entry StateEntry, raw []byte, strict bool) (bool, error) {
+	modified := snapshotModified(entry, raw)
+	if modified && strict {
+		return false, ioErrorf("snapshot %s was modified outside ags (sha256 differs from save); re-save it or drop --strict", entry.SnapshotPath)
+	}
+	return modified, nil
+}
+
+// piSelectorAliases are the --provider selectors that match by name rather
+// than by exact provider key.
+var piSelectorAliases = []string{"codex", "anthropic"}
+
+// PIProviders lists the provider keys of a saved pi snapshot, sorted, each
+// inspected on its own so its status is not masked by the others.
+func (m *Manager) PIProviders(label string) ([]PIProviderItem, error) {
+	if err := m.validateLabel(label); err != nil {
+		return nil, err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := state.Entries[stateKey(ToolPi, label)]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q", ToolPi, label)
+	}
+	raw, err := m.readSnapshotFile(entry.SnapshotPath)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, invalidInputf("pi auth JSON invalid: %v", err)
+	}
+
+	selectors := map[string][]string{}
+	aliases := append([]string{}, piSelectorAliases...)
+	for alias := range m.piProviderAliases {
+		if !containsString(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		for _, key := range matchPIProviderSelector(payload, alias, m.piProviderAliases) {
+			selectors[key] = append(selectors[key], alias)
+		}
+	}
+
+	items := make([]PIProviderItem, 0, len(payload))
+	for _, key := range sortedKeys(payload) {
+		single, err := json.Marshal(map[string]any{key: payload[key]})
+		if err != nil {
+			return nil, fmt.Errorf("serializing pi provider %s: %w", key, err)
+		}
+		insight := m.inspect(ToolPi, single)
+		items = append(items, PIProviderItem{
+			Key:       key,
+			Status:    insight.Status,
+			ExpiresAt: insight.ExpiresAt,
+			Selectors: selectors[key],
+		})
+	}
+	return items, nil
+}
+
+func filterPIAuthProviders(raw []byte, selector string, aliases map[string][]string) ([]byte, error) {
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("pi auth JSON invalid: %w", err)
+	}
+	keys, err := resolvePIProviderKeys(payload, selector, aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(map[string]any, len(keys))
+	for _, key := range keys {
+		filtered[key] = payload[key]
+	}
+
+	out, err := jsonMarshalIndent(filtered, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("serializing filtered pi auth: %w", err)
+	}
+	out = append(out, '\n')
+	return out, nil
+}
+
+// resolvePIProviderKeys resolves a selector such as "codex", "codex,anthropic",
+// or "all" into the sorted set of matching provider keys in payload. aliases
+// holds the pi_provider_aliases from config; see matchPIProviderSelector.
+func resolvePIProviderKeys(payload map[string]any, selector string, aliases map[string][]string) ([]string, error) {
+	selector = strings.TrimSpace(strings.ToLower(selector))
+	if selector == "" {
+		return nil, invalidInput("pi provider selector is required")
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		for _, key := range matchPIProviderSelector(payload, part, aliases) {
+			seen[key] = true
+		}
+	}
+
+	matches := make([]string, 0, len(seen))
+	for key := range seen {
+		matches = append(matches, key)
+	}
+	sort.Strings(matches)
+	if len(matches) > 0 {
+		return matches, nil
+	}
+
+	available := make([]string, 0, len(payload))
+	for key := range payload {
+		available = append(available, key)
+	}
+	sort.Strings(available)
+	return nil, notFoundf("pi provider %q not found in source/snapshot. available providers: %s", selector, strings.Join(available, ", "))
+}
+
+// matchPIProviderSelector returns the payload keys selector picks. A selector
+// configured in aliases matches exactly its listed keys (ignoring case);
+// otherwise the built-in codex and anthropic selectors match by substring and
+// anything else must equal a key.
+func matchPIProviderSelector(payload map[string]any, selector string, aliases map[string][]string) []string {
+	matches := []string{}
+	if keys, ok := aliases[selector]; ok {
+		for key := range payload {
+			for _, want := range keys {
+				if strings.EqualFold(key, want) {
+					matches = append(matches, key)
+					break
+				}
+			}
+		}
+		return matches
+	}
+	switch selector {
+	case "all":
+		for key := range payload {
+			matches = append(matches, key)
+		}
+	case "codex":
+		for key := range payload {
+			lower := strings.ToLower(key)
+			if strings.Contains(lower, "codex") || strings.Contains(lower, "openai") {
+				matches = append(matches, key)
+			}
+		}
+	case "anthropic":
+		for key := range payload {
+			lower := strings.ToLower(key)
+			if strings.Contains(lower, "anthropic") {
+				matches = append(matches, key)
+			}
+		}
+	default:
+		for key := range payload {
+			if key == selector || strings.EqualFold(key, selector) {
+				matches = append(matches, key)
+			}
+		}
+	}
+	return matches
+}
+
+func (m *Manager) mergePIAuthWithTarget(snapshotRaw []byte, targetPath string) ([]byte, error) {
+	return m.mergePIAuthWithStrategy(snapshotRaw, targetPath, PIMergeReplace)
+}
+
+// mergePIAuthWithStrategy merges snapshot providers into the target file.
+// PIMergeReplace swaps each provider object wholesale; PIMergeDeep merges it
+// recursively with deepMergeJSON.
+func (m *Manager) mergePIAuthWithStrategy(snapshotRaw []byte, targetPath string, strategy string) ([]byte, error) {
+	var snapshot map[string]any
+	if err := json.Unmarshal(snapshotRaw, &snapshot); err != nil {
+		return nil, fmt.Errorf("snapshot JSON invalid: %w", err)
+	}
+
+	targetRaw, ok, err := m.readOptionalFile(targetPath)
+	if err != nil {
+		return nil, ioErrorf("reading target auth file: %w", err)
+	}
+	if !ok {
+		return snapshotRaw, nil
+	}
+	if err := validateJSONObject(targetRaw); err != nil {
+		return nil, fmt.Errorf("target auth JSON invalid: %w", err)
+	}
+
+	var target map[string]any
+	if err := unmarshalPIAuthJSON(targetRaw, &target); err != nil {
+		return nil, fmt.Errorf("parsing target auth JSON: %w", err)
+	}
+
+	for provider, auth := range snapshot {
+		if strategy == PIMergeDeep {
+			target[provider] = deepMergeJSON(target[provider], auth)
+			continue
+		}
+		target[provider] = auth
+	}
+
+	merged, err := jsonMarshalIndent(target, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("serializing merged pi auth: %w", err)
+	}
+	merged = append(merged, '\n')
+	return merged, nil
+}
+
+// deepMergeJSON merges src into dst. Objects present in both are merged key
+// by key; at any other conflict, including arrays, src wins.
+func deepMergeJSON(dst any, src any) any {
+	dstObj, dstOK := dst.(map[string]any)
+	srcObj, srcOK := src.(map[string]any)
+	if !dstOK || !srcOK {
+		return src
+	}
+	for key, value := range srcObj {
+		dstObj[key] = deepMergeJSON(dstObj[key], value)
+	}
+	return dstObj
+}
+
+// runtimeAlreadyApplied reports whether writing snapshotRaw to target, merged
+// into it for pi unless noMerge, would leave the runtime auth unchanged. Pi
+// content is compared as JSON because the merge re-indents the file.
+func (m *Manager) runtimeAlreadyApplied(tool Tool, snapshotRaw []byte, target string, noMerge bool, strategy string) (bool, error) {
+	current, ok, err := m.readOptionalFile(target)
+	if err != nil {
+		return false, ioErrorf("reading existing target auth file: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	if tool != ToolPi {
+		return bytes.Equal(snapshotRaw, current), nil
+	}
+
+	want := snapshotRaw
+	if !noMerge {
+		if validateJSONObject(current) != nil {
+			return false, nil
+		}
+		want, err = m.mergePIAuthWithStrategy(snapshotRaw, target, strategy)
+		if err != nil {
+			return false, fmt.Errorf("merging pi auth file: %w", err)
+		}
+	}
+	var wantObj, currentObj map[string]any
+	if unmarshalPIAuthJSON(want, &wantObj) != nil || unmarshalPIAuthJSON(current, &currentObj) != nil {
+		return false, nil
+	}
+	return reflect.DeepEqual(wantObj, currentObj), nil
+}
+
+func (m *Manager) Delete(tool Tool, label string) (*DeleteResult, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	key := stateKey(tool, label)
+	entry, ok := state.Entries[key]
+	if !ok {
+		return nil, notFoundf("no saved snapshot for %s label=%q", tool, label)
+	}
+
+	// Read the account before the snapshot goes away so callers can log it.
+	var accountID string
+	if raw, err := m.readSnapshotFile(entry.SnapshotPath); err == nil {
+		insight := m.inspect(tool, raw)
+		m.hydrateIdentity(&insight, state)
+		accountID = insight.AccountID
+	}
+
+	snapshotDeleted := false
+	if !entry.Linked {
+		if err := os.Remove(entry.SnapshotPath); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, ioErrorf("deleting snapshot file: %w", err)
+			}
+		} else {
+			snapshotDeleted = true
+		}
+	}
+
+	if err := removeCompanions(entry.Companions, nil); err != nil {
+		return nil, err
+	}
+
+	delete(state.Entries, key)
+	if state.LastActivatedLabel[tool.String()] == label {
+		delete(state.LastActivatedLabel, tool.String())
+	}
+	if state.PreviousActivatedLabel[tool.String()] == label {
+		delete(state.PreviousActivatedLabel, tool.String())
+	}
+	if err := m.saveState(state); err != nil {
+		return nil, err
+	}
+
+	return &DeleteResult{
+		Tool:            tool,
+		Label:           label,
+		SnapshotPath:    entry.SnapshotPath,
+		SnapshotDeleted: snapshotDeleted,
+		SnapshotLinked:  entry.Linked,
+		AccountID:       accountID,
+	}, nil
+}
+
+// Move reclassifies a saved profile from one tool to another. The snapshot
+// must parse as the new tool's auth format; it is re-homed under
+// snapshots/<to>/ (linked snapshots stay where they are) and re-inspected.
+func (m *Manager) Move(from Tool, label string, to Tool) (*MoveResult, error) {
+	if err := m.validateToolAndLabel(from, label); err != nil {
+		return nil, err
+	}
+	if err := validateManagerTool(to); err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, invalidInputf("%s label=%q is already saved under %s", from, label, to)
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	oldKey := stateKey(from, label)
+	entry, ok := state.Entries[oldKey]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q", from, label)
+	}
+	newKey := stateKey(to, label)
+	if _, exists := state.Entries[newKey]; exists {
+		return nil, classify(ErrAlreadyExists, fmt.Errorf("%s label=%q already exists; delete it before moving", to, label))
+	}
+
+	fileRaw, err := m.readFile(entry.SnapshotPath)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	raw, err := decodeSnapshot(fileRaw)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+	if !looksLikeToolFormat(to, raw) {
+		return nil, invalidInputf("snapshot for %s label=%q does not look like a %s auth file", from, label, to)
+	}
+
+	oldPath := entry.SnapshotPath
+	newPath := oldPath
+	if !entry.Linked {
+		newPath = m.snapshotPath(to, label)
+		if bytes.HasPrefix(fileRaw, gzipMagic) {
+			newPath += gzipSuffix
+		}
+		if err := m.writeFile(newPath, fileRaw, 0o600); err != nil {
+			return nil, ioErrorf("writing snapshot: %w", err)
+		}
+	}
+
+	oldCompanions := entry.Companions
+	newCompanions, err := m.moveCompanions(oldCompanions, to, label)
+	if err != nil {
+		if newPath != oldPath {
+			_ = os.Remove(newPath)
+		}
+		return nil, err
+	}
+
+	insight := m.inspect(to, raw)
+	m.hydrateIdentity(&insight, state)
+	m.rememberIdentity(&state, insight)
+
+	entry.Tool = to.String()
+	entry.SnapshotPath = newPath
+	entry.Companions = newCompanions
+	delete(state.Entries, oldKey)
+	state.Entries[newKey] = entry
+	if state.LastActivatedLabel[from.String()] == label {
+		delete(state.LastActivatedLabel, from.String())
+	}
+	if state.PreviousActivatedLabel[from.String()] == label {
+		delete(state.PreviousActivatedLabel, from.String())
+	}
+	if state.Defaults[from.String()] == label {
+		delete(state.Defaults, from.String())
+	}
+	for name, target := range state.Aliases {
+		if target.Tool == from.String() && target.Label == label {
+			state.Aliases[name] = AliasTarget{Tool: to.String(), Label: label}
+		}
+	}
+	if err := m.saveState(state); err != nil {
+		if newPath != oldPath {
+			_ = os.Remove(newPath)
+		}
+		_ = removeCompanions(newCompanions, oldCompanions)
+		return nil, err
+	}
+	if newPath != oldPath {
+		if err := os.Remove(oldPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, ioErrorf("removing old snapshot file: %w", err)
+		}
+	}
+	if err := removeCompanions(oldCompanions, newCompanions); err != nil {
+		return nil, err
+	}
+
+	return &MoveResult{
+		From:         from,
+		To:           to,
+		Label:        label,
+		SnapshotPath: newPath,
+		Insight:      insight,
+	}, nil
+}
+
+// MatchLabels returns the saved labels of tool that match a path.Match
+// glob, sorted. Patterns may not contain path separators, so a match never
+// leaves the tool's own labels.
+func (m *Manager) MatchLabels(tool Tool, pattern string) ([]string, error) {
+	if err := validateManagerTool(tool); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(pattern, `/\`) {
+		return nil, invalidInputf("label pattern %q must not contain path separators", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, invalidInputf("invalid label pattern %q: %v", pattern, err)
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	var labels []string
+	for _, entry := range state.Entries {
+		if entry.Tool != tool.String() || !m.labels.valid(entry.Label) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, entry.Label); ok {
+			labels = append(labels, entry.Label)
+		}
+	}
+	if len(labels) == 0 {
+		return nil, notFoundf("no saved %s labels match %q", tool, pattern)
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+func (m *Manager) List(toolFilter *Tool) ([]ListItem, error) {
+	if toolFilter == nil {
+		return m.ListTools(nil)
+	}
+	return m.ListTools([]Tool{*toolFilter})
+}
+
+// ListTools lists saved profiles for the given tools, or for every tool
+// when tools is empty.
+func (m *Manager) ListTools(tools []Tool) ([]ListItem, error) {
+	entries, state, err := m.listEntries(tools)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading and decoding snapshots dominates on slow disks, so it runs on
+	// a bounded pool; each worker fills only its own slots of items.
+	items := make([]ListItem, len(entries))
+	workers := min(runtime.GOMAXPROCS(0), len(entries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				items[i] = m.listItem(entries[i], state)
+			}
+		}()
+	}
+	for i := range entries {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Tool == items[j].Tool {
+			return items[i].Label < items[j].Label
+		}
+		return items[i].Tool < items[j].Tool
+	})
+
+	return items, nil
+}
+
+// EachListItem calls fn with each profile ListTools would return, in the
+// same order, as soon as its snapshot has been inspected, so callers can
+// stream output instead of waiting for every snapshot. It stops at the
+// first error fn returns.
+func (m *Manager) EachListItem(tools []Tool, fn func(ListItem) error) error {
+	entries, state, err := m.listEntries(tools)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Tool == entries[j].Tool {
+			return entries[i].Label < entries[j].Label
+		}
+		return entries[i].Tool < entries[j].Tool
+	})
+	for _, entry := range entries {
+		if err := fn(m.listItem(entry, state)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listEntries loads state and returns the entries of the given tools, or of
+// every known tool when tools is empty, in no particular order.
+func (m *Manager) listEntries(tools []Tool) ([]StateEntry, State, error) {
+	for _, tool := range tools {
+		if err := validateManagerTool(tool); err != nil {
+			return nil, State{}, err
+		}
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, State{}, err
+	}
+
+	entries := make([]StateEntry, 0, len(state.Entries))
+	for _, entry := range state.Entries {
+		tool, ok := ParseTool(entry.Tool)
+		if !ok {
+			continue
+		}
+		if len(tools) > 0 && !containsTool(tools, tool) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, state, nil
+}
+
+// listItem reads and inspects one entry's snapshot. A snapshot that cannot
+// be read still yields an item, with an unknown status.
+func (m *Manager) listItem(entry StateEntry, state State) ListItem {
+	tool, _ := ParseTool(entry.Tool)
+	raw, err := m.readSnapshotFile(entry.SnapshotPath)
+	insight := AuthInsight{
+		Status:       "unknown",
+		NeedsRefresh: "unknown",
+		Details:      []string{"snapshot missing or unreadable"},
+	}
+	if err == nil {
+		insight = m.inspect(tool, raw)
+		applyCompanionIdentity(tool, &insight, m.readEntryCompanions(entry))
+		m.hydrateIdentity(&insight, state)
+	}
+	return newListItem(tool, entry, insight)
+}
+
+// Inspect returns the decoded insight for one saved profile, including a
+// summary of each token's claims. Unlike List it reads only that snapshot.
+func (m *Manager) Inspect(tool Tool, label string) (*ListItem, error) {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := state.Entries[stateKey(tool, label)]
+	if !ok {
+		return nil, notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+	raw, err := m.readSnapshotFile(entry.SnapshotPath)
+	if err != nil {
+		return nil, ioErrorf("reading snapshot file: %w", err)
+	}
+
+	insight := m.inspect(tool, raw)
+	applyCompanionIdentity(tool, &insight, m.readEntryCompanions(entry))
+	m.hydrateIdentity(&insight, state)
+	item := newListItem(tool, entry, insight)
+	item.Tokens = describeSnapshotTokens(tool, raw)
+	return &item, nil
+}
+
+func newListItem(tool Tool, entry StateEntry, insight AuthInsight) ListItem {
+	return ListItem{
+		Tool:        tool,
+		Label:       entry.Label,
+		SavedAt:     entry.SavedAt,
+		LastUsedAt:  entry.LastUsedAt,
+		Snapshot:    entry.SnapshotPath,
+		Linked:      entry.Linked,
+		Note:        entry.Note,
+		Tags:        entry.Tags,
+		AuthInsight: insight,
+	}
+}
+
+// Find returns saved profiles across all tools whose email or account id
+// contains query, case-insensitively.
+func (m *Manager) Find(query string) ([]ListItem, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, invalidInput("find query is required")
+	}
+
+	items, err := m.List(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]ListItem, 0, len(items))
+	for _, item := range items {
+		email := strings.ToLower(strings.TrimSpace(item.AuthInsight.AccountEmail))
+		accountID := strings.ToLower(strings.TrimSpace(item.AuthInsight.AccountID))
+		if (email != "" && strings.Contains(email, query)) || (accountID != "" && strings.Contains(accountID, query)) {
+			matches = append(matches, item)
+		}
+	}
+	return matches, nil
+}
+
+// RuntimeInsight inspects tool's live runtime auth file. A missing, empty or
+// non-object file is reported as ErrRuntimeMissing, ErrRuntimeEmpty or
+// ErrRuntimeInvalid so callers can tell those apart from read failures.
+func (m *Manager) RuntimeInsight(tool Tool) (AuthInsight, error) {
+	if err := validateManagerTool(tool); err != nil {
+		return AuthInsight{}, err
+	}
+	raw, err := m.readRuntime(tool)
+	if err != nil {
+		return AuthInsight{}, err
+	}
+	return m.inspect(tool, raw), nil
+}
+
+// readRuntime reads tool's runtime auth file and checks it holds a JSON
+// object.
+func (m *Manager) readRuntime(tool Tool) ([]byte, error) {
+	return m.readRuntimeFile(tool, m.paths[tool].DefaultRuntime)
+}
+
+// readRuntimeFile reads one runtime auth file of tool and checks it holds a
+// JSON object.
+func (m *Manager) readRuntimeFile(tool Tool, runtimePath string) ([]byte, error) {
+	raw, ok, err := m.readOptionalFile(runtimePath)
+	if err != nil {
+		return nil, ioErrorf("reading runtime auth file for %s: %w", tool, err)
+	}
+	if !ok {
+		return nil, classify(ErrRuntimeMissing, fmt.Errorf("runtime auth file for %s not found: %s", tool, runtimePath))
+	}
+	if len(raw) == 0 {
+		return nil, classify(ErrRuntimeEmpty, fmt.Errorf("runtime auth file for %s is empty: %s", tool, runtimePath))
+	}
+	if err := validateJSONObject(raw); err != nil {
+		return nil, classify(ErrRuntimeInvalid, fmt.Errorf("runtime auth file for %s: %w", tool, err))
+	}
+	return raw, nil
+}
+
+// runtimeDirFiles returns, sorted, the *.json files in tool's runtime
+// directory. ok is false when the directory does not exist.
+func (m *Manager) runtimeDirFiles(tool Tool) (files []string, ok bool, err error) {
+	dir := m.paths[tool].RuntimeDir
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, ioErrorf("reading runtime auth directory for %s: %w", tool, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, true, nil
+}
+
+func (m *Manager) Active(toolFilter *Tool) ([]ActiveItem, error) {
+	if toolFilter != nil {
+		if err := validateManagerTool(*toolFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	tools := []Tool{ToolCodex, ToolPi}
+	if toolFilter != nil {
+		tools = []Tool{*toolFilter}
+	}
+
+	items := make([]ActiveItem, 0, len(tools))
+	for _, tool := range tools {
+		runtimeDir := m.paths[tool].RuntimeDir
+		toolEntries := make([]StateEntry, 0)
+		for _, entry := range state.Entries {
+			parsedTool, ok := ParseTool(entry.Tool)
+			if ok && parsedTool == tool {
+				toolEntries = append(toolEntries, entry)
+			}
+		}
+
+		if runtimeDir == "" {
+			if len(toolEntries) == 0 {
+				items = append(items, ActiveItem{
+					Tool:        tool,
+					Status:      "no saved profiles",
+					RuntimePath: m.paths[tool].DefaultRuntime,
+				})
+				continue
+			}
+			item, err := m.activeItem(tool, m.paths[tool].DefaultRuntime, toolEntries, state)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		if len(toolEntries) == 0 {
+			items = append(items, ActiveItem{
+				Tool:        tool,
+				Status:      "no saved profiles",
+				RuntimePath: runtimeDir,
+			})
+			continue
+		}
+		files, ok, err := m.runtimeDirFiles(tool)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			items = append(items, ActiveItem{
+				Tool:        tool,
+				Status:      "runtime auth directory missing",
+				RuntimePath: runtimeDir,
+			})
+			continue
+		}
+		if len(files) == 0 {
+			items = append(items, ActiveItem{
+				Tool:        tool,
+				Status:      "no runtime auth files",
+				RuntimePath: runtimeDir,
+			})
+			continue
+		}
+		for _, file := range files {
+			item, err := m.activeItem(tool, file, toolEntries, state)
+			if err != nil {
+				return nil, err
+			}
+			item.RuntimeFile = filepath.Base(file)
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// activeItem matches one runtime auth file of tool against toolEntries, the
+// tool's saved profiles.
+func (m *Manager) activeItem(tool Tool, runtimePath string, toolEntries []StateEntry, state State) (ActiveItem, error) {
+	runtimeRaw, err := m.readRuntimeFile(tool, runtimePath)
+	switch {
+	case errors.Is(err, ErrRuntimeMissing):
+		return ActiveItem{
+			Tool:        tool,
+			Status:      "runtime auth file missing",
+			RuntimePath: runtimePath,
+		}, nil
+	case errors.Is(err, ErrRuntimeEmpty):
+		return ActiveItem{
+			Tool:        tool,
+			Status:      "runtime auth file empty",
+			RuntimePath: runtimePath,
+			Details:     []string{"the tool probably failed while writing it; log in again or run ags use"},
+		}, nil
+	case errors.Is(err, ErrRuntimeInvalid):
+		return ActiveItem{
+			Tool:        tool,
+			Status:      "runtime auth JSON invalid",
+			RuntimePath: runtimePath,
+		}, nil
+	case err != nil:
+		return ActiveItem{}, err
+	}
+
+	insight := m.inspect(tool, runtimeRaw)
+	item := ActiveItem{
+		Tool:        tool,
+		RuntimePath: runtimePath,
+		TokenExpiry: insight.ExpiresAt,
+		Expired:     insight.Status == "expired",
+	}
+
+	matchedLabels := make([]string, 0)
+	switch tool {
+	case ToolPi:
+		var runtimeObj map[string]any
+		if err := unmarshalPIAuthJSON(runtimeRaw, &runtimeObj); err != nil {
+			return ActiveItem{}, fmt.Errorf("parsing runtime pi auth JSON: %w", err)
+		}
+		for _, entry := range toolEntries {
+			snapshotRaw, err := m.readSnapshotFile(entry.SnapshotPath)
+			if err != nil {
+				continue
+			}
+			if err := validateJSONObject(snapshotRaw); err != nil {
+				continue
+			}
+			var snapshotObj map[string]any
+			if err := unmarshalPIAuthJSON(snapshotRaw, &snapshotObj); err != nil {
+				continue
+			}
+			if piProviderSubsetMatch(snapshotObj, runtimeObj) {
+				matchedLabels = append(matchedLabels, entry.Label)
+			}
+		}
+	default:
+		runtimeHash := sha256Hex(runtimeRaw)
+		for _, entry := range toolEntries {
+			if entry.SHA256 == runtimeHash {
+				matchedLabels = append(matchedLabels, entry.Label)
+			}
+		}
+	}
+
+	sort.Strings(matchedLabels)
+	if len(matchedLabels) == 0 && tool == ToolCodex {
+		byAccount := m.codexLabelsByAccount(runtimeRaw, toolEntries)
+		if len(byAccount) == 1 {
+			item.ActiveLabel = byAccount[0]
+			item.Status = "match (by account)"
+			item.Details = []string{"runtime auth differs from the snapshot but has the same account id; the token was probably refreshed"}
+			return item, nil
+		}
+		if len(byAccount) > 1 {
+			item.Status = "no matching saved profile"
+			item.Details = []string{"several saved labels share the runtime account id: " + strings.Join(byAccount, ",")}
+			return item, nil
+		}
+	}
+	switch len(matchedLabels) {
+	case 0:
+		item.Status = "no matching saved profile"
+	case 1:
+		item.ActiveLabel = matchedLabels[0]
+		item.Status = "match"
+	default:
+		lastActivated := state.LastActivatedLabel[tool.String()]
+		if containsString(matchedLabels, lastActivated) {
+			item.ActiveLabel = lastActivated
+			item.Status = "match (by last-activated)"
+			item.Details = []string{"multiple saved labels match current runtime auth: " + strings.Join(matchedLabels, ",")}
+			return item, nil
+		}
+		item.ActiveLabel = strings.Join(matchedLabels, ",")
+		item.Status = "ambiguous"
+		item.Details = []string{"multiple saved labels match current runtime auth"}
+	}
+	return item, nil
+}
+
+// codexLabelsByAccount returns, sorted, the labels whose snapshot has the
+// same account id and issuer as the runtime auth. It is the fallback for
+// Active once a token refresh has changed the runtime file's hash.
+func (m *Manager) codexLabelsByAccount(runtimeRaw []byte, entries []StateEntry) []string {
+	runtime := inspectCodex(runtimeRaw, 0)
+	if runtime.AccountID == "" {
+		return nil
+	}
+	labels := []string{}
+	for _, entry := range entries {
+		snapshotRaw, err := m.readSnapshotFile(entry.SnapshotPath)
+		if err != nil {
+			continue
+		}
+		snapshot := inspectCodex(snapshotRaw, 0)
+		if snapshot.AccountID == runtime.AccountID && snapshot.Issuer == runtime.Issuer {
+			labels = append(labels, entry.Label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Check reports how close each saved profile (or, with activeOnly, each
+// tool's runtime auth file) is to expiry relative to warnBefore.
+func (m *Manager) Check(toolFilter *Tool, warnBefore time.Duration, activeOnly bool) ([]CheckItem, error) {
+	if toolFilter != nil {
+		if err := validateManagerTool(*toolFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	items := []CheckItem{}
+	if activeOnly {
+		tools := []Tool{ToolCodex, ToolPi}
+		if toolFilter != nil {
+			tools = []Tool{*toolFilter}
+		}
+		for _, tool := range tools {
+			insight, err := m.RuntimeInsight(tool)
+			switch {
+			case errors.Is(err, ErrRuntimeMissing):
+				continue
+			case errors.Is(err, ErrRuntimeEmpty), errors.Is(err, ErrRuntimeInvalid):
+				insight = AuthInsight{}
+			case err != nil:
+				return nil, err
+			}
+			items = append(items, checkItemFromInsight(tool, "runtime", m.paths[tool].DefaultRuntime, insight, warnBefore))
+		}
+		return items, nil
+	}
+
+	listed, err := m.List(toolFilter)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range listed {
+		items = append(items, checkItemFromInsight(item.Tool, item.Label, item.Snapshot, item.AuthInsight, warnBefore))
+	}
+	return items, nil
+}
+
+func checkItemFromInsight(tool Tool, label string, path string, insight AuthInsight, warnBefore time.Duration) CheckItem {
+	item := CheckItem{
+		Tool:      tool,
+		Label:     label,
+		Path:      path,
+		Status:    "unknown",
+		ExpiresAt: insight.ExpiresAt,
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(insight.ExpiresAt))
+	if err != nil {
+		return item
+	}
+
+	item.Remaining = time.Until(expiresAt)
+	switch {
+	case item.Remaining <= 0:
+		item.Status = "expired"
+	case item.Remaining <= warnBefore:
+		item.Status = "expiring"
+	default:
+		item.Status = "ok"
+	}
+	return item
+}
+
+// SetNote replaces the note on a saved profile. An empty note clears it.
+func (m *Manager) SetNote(tool Tool, label string, note string) error {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return err
+	}
+	if err := validateNote(note); err != nil {
+		return err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	key := stateKey(tool, label)
+	entry, ok := state.Entries[key]
+	if !ok {
+		return notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+
+	entry.Note = strings.TrimSpace(note)
+	state.Entries[key] = entry
+	return m.saveState(state)
+}
+
+func validateNote(note string) error {
+	if n := utf8.RuneCountInString(strings.TrimSpace(note)); n > maxNoteLength {
+		return invalidInputf("note is %d characters; the limit is %d", n, maxNoteLength)
+	}
+	return nil
+}
+
+// AddTag adds tag to a saved profile. Adding a tag it already has is a no-op.
+func (m *Manager) AddTag(tool Tool, label string, tag string) error {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return err
+	}
+	if err := validateTags([]string{tag}); err != nil {
+		return err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	key := stateKey(tool, label)
+	entry, ok := state.Entries[key]
+	if !ok {
+		return notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+
+	entry.Tags = mergeTags(entry.Tags, tag)
+	state.Entries[key] = entry
+	return m.saveState(state)
+}
+
+// RemoveTag removes tag from a saved profile.
+func (m *Manager) RemoveTag(tool Tool, label string, tag string) error {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	key := stateKey(tool, label)
+	entry, ok := state.Entries[key]
+	if !ok {
+		return notFoundf("no saved profile for %s label=%q", tool, label)
+	}
+
+	tags := make([]string, 0, len(entry.Tags))
+	for _, existing := range entry.Tags {
+		if existing != tag {
+			tags = append(tags, existing)
+		}
+	}
+	if len(tags) == len(entry.Tags) {
+		return notFoundf("%s %s has no tag %q", tool, label, tag)
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	entry.Tags = tags
+	state.Entries[key] = entry
+	return m.saveState(state)
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !namePattern.MatchString(tag) {
+			return invalidInputf("tag %q must match [a-zA-Z0-9._-]+", tag)
+		}
+	}
+	return nil
+}
+
+// mergeTags returns the sorted union of existing and added.
+func mergeTags(existing []string, added ...string) []string {
+	if len(existing)+len(added) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(existing)+len(added))
+	tags := make([]string, 0, len(existing)+len(added))
+	for _, tag := range append(append([]string{}, existing...), added...) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// SetDefault records label as the default for tool. The label does not
+// have to be saved yet, so a default can name a profile before its first save.
+func (m *Manager) SetDefault(tool Tool, label string) error {
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	state.Defaults[tool.String()] = label
+	return m.saveState(state)
+}
+
+// ClearDefault removes the default label for tool.
+func (m *Manager) ClearDefault(tool Tool) error {
+	if err := validateManagerTool(tool); err != nil {
+		return err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	if _, ok := state.Defaults[tool.String()]; !ok {
+		return notFoundf("no default label set for %s", tool)
+	}
+	delete(state.Defaults, tool.String())
+	return m.saveState(state)
+}
+
+// Defaults returns the default label for each tool that has one.
+func (m *Manager) Defaults() (map[Tool]string, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	defaults := make(map[Tool]string, len(state.Defaults))
+	for tool, label := range state.Defaults {
+		defaults[Tool(tool)] = label
+	}
+	return defaults, nil
+}
+
+func (m *Manager) AddAlias(name string, tool Tool, label string) (*AliasItem, error) {
+	if err := validateAliasName(name); err != nil {
+		return nil, err
+	}
+	if err := m.validateToolAndLabel(tool, label); err != nil {
+		return nil, err
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := state.Aliases[name]; ok {
+		return nil, classify(ErrAlreadyExists, fmt.Errorf("alias %q already points at %s %s; remove it first", name, existing.Tool, existing.Label))
+	}
+
+	state.Aliases[name] = AliasTarget{Tool: tool.String(), Label: label}
+	if err := m.saveState(state); err != nil {
+		return nil, err
+	}
+	return &AliasItem{Name: name, Tool: tool, Label: label}, nil
+}
+
+func (m *Manager) RemoveAlias(name string) (*AliasItem, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	target, ok := state.Aliases[name]
+	if !ok {
+		return nil, notFoundf("no alias named %q", name)
+	}
+
+	delete(state.Aliases, name)
+	if err := m.saveState(state); err != nil {
+		return nil, err
+	}
+	return &AliasItem{Name: name, Tool: Tool(target.Tool), Label: target.Label}, nil
+}
+
+// IdentityCache returns the cached account identities, sorted by account id.
+func (m *Manager) IdentityCache() ([]IdentityCacheEntry, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]IdentityCacheEntry, 0, len(state.IdentityCache))
+	for accountID, item := range state.IdentityCache {
+		entries = append(entries, IdentityCacheEntry{
+			AccountID: accountID,
+			Email:     item.Email,
+			Plan:      item.Plan,
+			UpdatedAt: item.UpdatedAt,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AccountID < entries[j].AccountID
+	})
+	return entries, nil
+}
+
+// ClearIdentityCache removes the cached identity of accountID, or every
+// cached identity when accountID is empty, and returns how many it removed.
+func (m *Manager) ClearIdentityCache(accountID string) (int, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return 0, err
+	}
+	accountID = strings.TrimSpace(accountID)
+	removed := len(state.IdentityCache)
+	if accountID == "" {
+		state.IdentityCache = map[string]IdentityCacheItem{}
+	} else {
+		if _, ok := state.IdentityCache[accountID]; !ok {
+			return 0, notFoundf("no cached identity for account id %q", accountID)
+		}
+		delete(state.IdentityCache, accountID)
+		removed = 1
+	}
+	if err := m.saveState(state); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+func (m *Manager) Aliases() ([]AliasItem, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]AliasItem, 0, len(state.Aliases))
+	for name, target := range state.Aliases {
+		items = append(items, AliasItem{Name: name, Tool: Tool(target.Tool), Label: target.Label})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	return items, nil
+}
 
-		runtimeRaw, err := os.ReadFile(runtimePath)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				items = append(items, ActiveItem{
-					Tool:        tool,
-					Status:      "runtime auth file missing",
-					RuntimePath: runtimePath,
-				})
-				continue
-			}
-			return nil, fmt.Errorf("reading runtime auth file for %s: %w", tool, err)
-		}
-		if err := validateJSONObject(runtimeRaw); err != nil {
-			items = append(items, ActiveItem{
-				Tool:        tool,
-				Status:      "runtime auth JSON invalid",
-				RuntimePath: runtimePath,
-			})
-			continue
-		}
+// ResolveAlias returns the tool and label an alias points at. ok is false
+// when no alias with that name exists.
+func (m *Manager) ResolveAlias(name string) (Tool, string, bool, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return "", "", false, err
+	}
+	target, ok := state.Aliases[name]
+	if !ok {
+		return "", "", false, nil
+	}
+	return Tool(target.Tool), target.Label, true, nil
+}
 
-		matchedLabels := make([]string, 0)
-		switch tool {
-		case ToolPi:
-			var runtimeObj map[string]any
-			if err := unmarshalPIAuthJSON(runtimeRaw, &runtimeObj); err != nil {
-				return nil, fmt.Errorf("parsing runtime pi auth JSON: %w", err)
-			}
-			for _, entry := range toolEntries {
-				snapshotRaw, err := os.ReadFile(entry.SnapshotPath)
-				if err != nil {
-					continue
-				}
-				if err := validateJSONObject(snapshotRaw); err != nil {
-					continue
-				}
-				var snapshotObj map[string]any
-				if err := unmarshalPIAuthJSON(snapshotRaw, &snapshotObj); err != nil {
-					continue
-				}
-				if piProviderSubsetMatch(snapshotObj, runtimeObj) {
-					matchedLabels = append(matchedLabels, entry.Label)
-				}
-			}
-		default:
-			runtimeHash := sha256Hex(runtimeRaw)
-			for _, entry := range toolEntries {
-				if entry.SHA256 == runtimeHash {
-					matchedLabels = append(matchedLabels, entry.Label)
-				}
-			}
-		}
+func validateAliasName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return invalidInput("alias name is required")
+	}
+	if !namePattern.MatchString(name) {
+		return invalidInput("alias name must match [a-zA-Z0-9._-]+")
+	}
+	if _, isTool := ParseTool(strings.ToLower(name)); isTool {
+		return invalidInputf("alias name %q collides with a tool name", name)
+	}
+	return nil
+}
 
-		sort.Strings(matchedLabels)
-		switch len(matchedLabels) {
-		case 0:
-			items = append(items, ActiveItem{
-				Tool:        tool,
-				Status:      "no matching saved profile",
-				RuntimePath: runtimePath,
-			})
-		case 1:
-			items = append(items, ActiveItem{
-				Tool:        tool,
-				ActiveLabel: matchedLabels[0],
-				Status:      "match",
-				RuntimePath: runtimePath,
-			})
-		default:
-			items = append(items, ActiveItem{
-				Tool:        tool,
-				ActiveLabel: strings.Join(matchedLabels, ","),
-				Status:      "ambiguous",
-				RuntimePath: runtimePath,
-				Details:     []string{"multiple saved labels match current runtime auth"},
-			})
+func containsTool(tools []Tool, tool Tool) bool {
+	for _, candidate := range tools {
+		if candidate == tool {
+			return true
 		}
 	}
+	return false
+}
 
-	return items, nil
+func containsString(values []string, target string) bool {
+	if target == "" {
+		return false
+	}
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
 }
 
 func piProviderSubsetMatch(snapshotObj map[string]any, runtimeObj map[string]any) bool {
@@ -547,6 +2648,24 @@ func piProviderSubsetMatch(snapshotObj map[string]any, runtimeObj map[string]any
 	return true
 }
 
+// hydrateIdentity fills a missing email and plan from the identity cache
+// unless the cache is disabled.
+func (m *Manager) hydrateIdentity(insight *AuthInsight, state State) {
+	if m.noIdentityCache {
+		state = State{}
+	}
+	hydrateIdentityFromCache(insight, state)
+}
+
+// rememberIdentity records insight's identity in the cache unless the cache
+// is disabled.
+func (m *Manager) rememberIdentity(state *State, insight AuthInsight) {
+	if m.noIdentityCache {
+		return
+	}
+	rememberIdentity(state, insight)
+}
+
 func hydrateIdentityFromCache(insight *AuthInsight, state State) {
 	if insight == nil {
 		return
@@ -569,9 +2688,11 @@ func hydrateIdentityFromCache(insight *AuthInsight, state State) {
 	}
 	if strings.TrimSpace(cacheItem.Email) != "" {
 		insight.AccountEmail = strings.TrimSpace(cacheItem.Email)
+		insight.IdentityCachedAt = cacheItem.UpdatedAt
 	}
 	if insight.AccountPlan == "" && strings.TrimSpace(cacheItem.Plan) != "" {
 		insight.AccountPlan = strings.TrimSpace(cacheItem.Plan)
+		insight.IdentityCachedAt = cacheItem.UpdatedAt
 	}
 }
 
@@ -598,31 +2719,43 @@ func rememberIdentity(state *State, insight AuthInsight) {
 	}
 }
 
-func validateManagerToolAndLabel(tool Tool, label string) error {
+func (m *Manager) validateToolAndLabel(tool Tool, label string) error {
 	if err := validateManagerTool(tool); err != nil {
 		return err
 	}
-	return validateManagerLabel(label)
+	return m.validateLabel(label)
 }
 
 func validateManagerTool(tool Tool) error {
 	if _, ok := ParseTool(tool.String()); !ok {
-		return fmt.Errorf("invalid tool %q. expected one of: codex, pi", tool)
+		return invalidInputf("invalid tool %q. expected one of: codex, pi", tool)
 	}
 	return nil
 }
 
-func validateManagerLabel(label string) error {
+func (m *Manager) validateLabel(label string) error {
 	label = strings.TrimSpace(label)
 	if label == "" {
-		return errors.New("label is required")
+		return invalidInput("label is required")
 	}
-	if !labelPattern.MatchString(label) {
-		return errors.New("label must match [a-zA-Z0-9._-]+")
+	if !m.labels.valid(label) {
+		return invalidInputf("label must match %s", m.labels)
 	}
 	return nil
 }
 
+// readOptionalFile is readOptionalFile under the manager's I/O timeout.
+func (m *Manager) readOptionalFile(path string) ([]byte, bool, error) {
+	raw, err := m.readFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return raw, true, nil
+}
+
 func readOptionalFile(path string) ([]byte, bool, error) {
 	raw, err := os.ReadFile(path)
 	if err != nil {
@@ -647,68 +2780,530 @@ func rollbackUseTargetWrite(target string, previousRaw []byte, hadPrevious bool)
 	return nil
 }
 
+func (m *Manager) readSource(tool Tool, opts SaveOptions) (string, []byte, error) {
+	if strings.TrimSpace(opts.FromURL) != "" {
+		if strings.TrimSpace(opts.SourceOverride) != "" || opts.FromActive {
+			return "", nil, invalidInput("from-url cannot be combined with a source override or from-active")
+		}
+		return fetchSource(opts.FromURL, opts.AllowRemote, m.ioTimeout)
+	}
+	if opts.AllowRemote {
+		return "", nil, invalidInput("allow-remote only applies to from-url")
+	}
+	if strings.TrimSpace(opts.SourceOverride) == "-" {
+		if opts.Stdin == nil {
+			return "", nil, invalidInput("source - requires stdin input")
+		}
+		raw, err := io.ReadAll(opts.Stdin)
+		if err != nil {
+			return "", nil, ioErrorf("reading source auth from stdin: %w", err)
+		}
+		return stdinSourcePath, raw, nil
+	}
+
+	var sourcePath string
+	var err error
+	if opts.FromActive {
+		if strings.TrimSpace(opts.SourceOverride) != "" {
+			return "", nil, invalidInput("from-active cannot be combined with a source override")
+		}
+		sourcePath = m.paths[tool].DefaultRuntime
+		if _, err := m.stat(sourcePath); err != nil {
+			return "", nil, ioErrorf("no active %s runtime auth file at %s; log in with %s first", tool, sourcePath, tool)
+		}
+	} else {
+		sourcePath, err = m.resolveSourcePath(tool, opts.SourceOverride)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	sourcePath, err = m.checkSymlink(sourcePath, opts.FollowSymlinks)
+	if err != nil {
+		return "", nil, err
+	}
+	raw, err := m.readFile(sourcePath)
+	if err != nil {
+		return "", nil, ioErrorf("reading source auth file: %w", err)
+	}
+	return sourcePath, raw, nil
+}
+
 func (m *Manager) resolveSourcePath(tool Tool, sourceOverride string) (string, error) {
 	if strings.TrimSpace(sourceOverride) != "" {
 		p, err := expandPath(sourceOverride)
 		if err != nil {
 			return "", err
 		}
-		if _, err := os.Stat(p); err != nil {
-			return "", fmt.Errorf("source path does not exist: %s", p)
+		if _, err := m.stat(p); err != nil {
+			if errors.Is(err, errIOTimeout) {
+				return "", err
+			}
+			return "", ioErrorf("source path does not exist: %s", p)
 		}
 		return p, nil
 	}
 
 	candidates := m.paths[tool].SaveCandidates
 	for _, candidate := range candidates {
-		if _, err := os.Stat(candidate); err == nil {
+		_, err := m.stat(candidate)
+		if err == nil {
 			return candidate, nil
 		}
+		if errors.Is(err, errIOTimeout) {
+			return "", err
+		}
 	}
-	return "", fmt.Errorf("could not find %s auth file. tried: %s. pass --source <path>", tool, strings.Join(candidates, ", "))
+	return "", ioErrorf("could not find %s auth file. tried: %s. pass --source <path>", tool, strings.Join(candidates, ", "))
+}
+
+// readFile, stat, and writeFile run file operations under the manager's
+// I/O timeout.
+func (m *Manager) readFile(path string) ([]byte, error) {
+	return valueWithTimeout(m.ioTimeout, "reading "+path, func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+func (m *Manager) stat(path string) (os.FileInfo, error) {
+	return valueWithTimeout(m.ioTimeout, "checking "+path, func() (os.FileInfo, error) {
+		return os.Stat(path)
+	})
+}
+
+// checkSymlink is checkSymlink under the manager's I/O timeout.
+func (m *Manager) checkSymlink(path string, follow bool) (string, error) {
+	return valueWithTimeout(m.ioTimeout, "checking "+path, func() (string, error) {
+		return checkSymlink(path, follow)
+	})
+}
+
+func (m *Manager) writeFile(path string, raw []byte, mode os.FileMode) error {
+	return runWithTimeout(m.ioTimeout, "writing "+path, func() error {
+		return atomicWriteFile(path, raw, mode)
+	})
 }
 
 func (m *Manager) snapshotPath(tool Tool, label string) string {
+	if m.flatSnapshots {
+		return filepath.Join(m.rootDir, "snapshots", tool.String()+"-"+label+".json")
+	}
 	return filepath.Join(m.rootDir, "snapshots", tool.String(), label+".json")
 }
 
+// writeRuntimeBackup writes raw to a new backups/<tool>/before-<label>-<time>.json
+// and returns its path. The name is claimed with an exclusive create, and a
+// -2, -3, ... suffix is added when a backup from the same second exists, so
+// one backup never replaces another.
+func (m *Manager) writeRuntimeBackup(tool Tool, label string, raw []byte) (string, error) {
+	dir := filepath.Join(m.rootDir, "backups", tool.String())
+	base := "before-" + label + "-" + nowUTC().Format("20060102T150405Z")
+	path, err := valueWithTimeout(m.ioTimeout, "creating backup in "+dir, func() (string, error) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+		for n := 1; ; n++ {
+			name := base
+			if n > 1 {
+				name = fmt.Sprintf("%s-%d", base, n)
+			}
+			path := filepath.Join(dir, name+".json")
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			return path, f.Close()
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := m.writeFile(path, raw, 0o600); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
 func (m *Manager) statePath() string {
 	return filepath.Join(m.rootDir, "state.json")
 }
 
 func (m *Manager) loadState() (State, error) {
 	path := m.statePath()
-	raw, err := os.ReadFile(path)
+	raw, err := m.readFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return defaultState(), nil
 		}
-		return State{}, fmt.Errorf("reading state: %w", err)
+		return State{}, ioErrorf("reading state: %w", err)
 	}
 
 	var state State
 	if err := json.Unmarshal(raw, &state); err != nil {
 		return State{}, fmt.Errorf("parsing state: %w", err)
 	}
+	if state.Version > currentStateVersion {
+		return State{}, fmt.Errorf("state.json is version %d but this ags only understands up to %d; upgrade ags", state.Version, currentStateVersion)
+	}
+	normalizeState(&state)
+	if state.Version < currentStateVersion {
+		migrateState(&state)
+		if !m.readOnly {
+			if err := m.saveState(state); err != nil {
+				return State{}, err
+			}
+		}
+	}
+	return state, nil
+}
+
+// stateMigrations[n] upgrades a state from version n to n+1. Append a
+// function and bump currentStateVersion together.
+var stateMigrations = []func(*State){
+	// 0 -> 1: files written before versioning. normalizeState already fills
+	// the maps, so there is nothing else to change.
+	func(*State) {},
+}
+
+// migrateState runs every migration from state.Version up to
+// currentStateVersion in order.
+func migrateState(state *State) {
+	for state.Version < currentStateVersion {
+		stateMigrations[state.Version](state)
+		state.Version++
+	}
+}
+
+// normalizeState fills the maps that older or hand-edited state files omit.
+func normalizeState(state *State) {
 	if state.Entries == nil {
 		state.Entries = map[string]StateEntry{}
 	}
 	if state.IdentityCache == nil {
 		state.IdentityCache = map[string]IdentityCacheItem{}
 	}
-	if state.Version == 0 {
-		state.Version = 1
+	if state.LastActivatedLabel == nil {
+		state.LastActivatedLabel = map[string]string{}
+	}
+	if state.PreviousActivatedLabel == nil {
+		state.PreviousActivatedLabel = map[string]string{}
+	}
+	if state.Aliases == nil {
+		state.Aliases = map[string]AliasTarget{}
+	}
+	if state.Defaults == nil {
+		state.Defaults = map[string]string{}
 	}
-	return state, nil
 }
 
 func (m *Manager) saveState(state State) error {
+	if m.readOnly {
+		return ioErrorf("data root %s is read-only; state not saved", m.rootDir)
+	}
 	raw, err := jsonMarshalIndent(state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("serializing state: %w", err)
 	}
 	raw = append(raw, '\n')
-	return atomicWriteFile(m.statePath(), raw, 0o600)
+	if err := m.rotateStateBackups(); err != nil {
+		return err
+	}
+	return m.writeFile(m.statePath(), raw, 0o600)
+}
+
+func (m *Manager) stateBackupPath(n int) string {
+	return fmt.Sprintf("%s.%d", m.statePath(), n)
+}
+
+// rotateStateBackups shifts state.json.1..N-1 up by one slot and copies the
+// current state.json into slot 1. It is a no-op when no state exists yet.
+func (m *Manager) rotateStateBackups() error {
+	current, ok, err := m.readOptionalFile(m.statePath())
+	if err != nil {
+		return ioErrorf("reading state for backup: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	for n := stateBackupCount - 1; n >= 1; n-- {
+		from, to := m.stateBackupPath(n), m.stateBackupPath(n+1)
+		err := runWithTimeout(m.ioTimeout, "renaming "+from, func() error { return renamePath(from, to) })
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return ioErrorf("rotating state backup %d: %w", n, err)
+		}
+	}
+	if err := m.writeFile(m.stateBackupPath(1), current, 0o600); err != nil {
+		return ioErrorf("writing state backup: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) RestoreState(n int) (*RestoreStateResult, error) {
+	if n < 1 || n > stateBackupCount {
+		return nil, invalidInputf("state backup must be between 1 and %d", stateBackupCount)
+	}
+
+	if m.readOnly {
+		return nil, ioErrorf("data root %s is read-only; state not restored", m.rootDir)
+	}
+
+	backupPath := m.stateBackupPath(n)
+	raw, err := m.readFile(backupPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, notFoundf("no state backup found at %s", backupPath)
+		}
+		return nil, ioErrorf("reading state backup: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("state backup %s is not valid: %w", backupPath, err)
+	}
+
+	// The state being replaced goes into the ring like any other save, so a
+	// restore can itself be undone.
+	if err := m.rotateStateBackups(); err != nil {
+		return nil, err
+	}
+	if err := m.writeFile(m.statePath(), raw, 0o600); err != nil {
+		return nil, ioErrorf("restoring state: %w", err)
+	}
+
+	return &RestoreStateResult{
+		BackupPath: backupPath,
+		StatePath:  m.statePath(),
+		Entries:    len(state.Entries),
+	}, nil
+}
+
+// GC reconciles snapshots/<tool>/*.json with state.json. Snapshot files no
+// state entry points at are removed (or only listed when dryRun is set), and
+// entries whose snapshot file is gone are reported.
+func (m *Manager) GC(dryRun bool) (*GCResult, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun && m.readOnly {
+		return nil, ioErrorf("data root %s is read-only; nothing removed", m.rootDir)
+	}
+
+	referenced := make(map[string]bool, len(state.Entries))
+	keys := make([]string, 0, len(state.Entries))
+	for key, entry := range state.Entries {
+		referenced[filepath.Clean(entry.SnapshotPath)] = true
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &GCResult{DryRun: dryRun}
+	for _, tool := range []Tool{ToolCodex, ToolPi} {
+		snapshotsDir := filepath.Join(m.rootDir, "snapshots")
+		matches := []string{}
+		for _, pattern := range []string{
+			filepath.Join(snapshotsDir, tool.String(), "*.json"),
+			filepath.Join(snapshotsDir, tool.String(), "*.json"+gzipSuffix),
+			filepath.Join(snapshotsDir, tool.String()+"-*.json"),
+			filepath.Join(snapshotsDir, tool.String()+"-*.json"+gzipSuffix),
+		} {
+			found, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, ioErrorf("listing %s snapshots: %w", tool, err)
+			}
+			matches = append(matches, found...)
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			if referenced[filepath.Clean(path)] {
+				continue
+			}
+			if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if !dryRun {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return nil, ioErrorf("removing orphaned snapshot: %w", err)
+				}
+			}
+			result.OrphanedSnapshots = append(result.OrphanedSnapshots, path)
+		}
+	}
+
+	for _, key := range keys {
+		entry := state.Entries[key]
+		if _, err := m.stat(entry.SnapshotPath); err != nil && errors.Is(err, os.ErrNotExist) {
+			result.MissingSnapshots = append(result.MissingSnapshots, MissingSnapshot{
+				Tool:         Tool(entry.Tool),
+				Label:        entry.Label,
+				SnapshotPath: entry.SnapshotPath,
+			})
+		}
+	}
+	return result, nil
+}
+
+// Doctor checks state.json against the snapshot files: recorded SHA-256
+// values that no longer match, orphaned snapshot files, and entries whose
+// snapshot is gone. The last two come from GC. Each repair in opts runs
+// only for its own kind of problem; the result lists every problem found,
+// fixed or not.
+func (m *Manager) Doctor(opts DoctorOptions) (*DoctorResult, error) {
+	if (opts.FixSHA || opts.FixOrphans || opts.FixMissing) && m.readOnly {
+		return nil, ioErrorf("data root %s is read-only; nothing repaired", m.rootDir)
+	}
+	gc, err := m.GC(!opts.FixOrphans)
+	if err != nil {
+		return nil, err
+	}
+	result := &DoctorResult{
+		Options:           opts,
+		OrphanedSnapshots: gc.OrphanedSnapshots,
+		MissingSnapshots:  gc.MissingSnapshots,
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(state.Entries))
+	for key := range state.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := state.Entries[key]
+		// Missing and unreadable snapshots are reported elsewhere.
+		raw, err := m.readSnapshotFile(entry.SnapshotPath)
+		if err != nil || !snapshotModified(entry, raw) {
+			continue
+		}
+		actual := sha256Hex(raw)
+		result.SHAMismatches = append(result.SHAMismatches, SHAMismatch{
+			Tool:         Tool(entry.Tool),
+			Label:        entry.Label,
+			SnapshotPath: entry.SnapshotPath,
+			Recorded:     entry.SHA256,
+			Actual:       actual,
+		})
+		entry.SHA256 = actual
+		state.Entries[key] = entry
+	}
+	if opts.FixSHA && len(result.SHAMismatches) > 0 {
+		if err := m.saveState(state); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.FixMissing {
+		for _, missing := range result.MissingSnapshots {
+			if _, err := m.Delete(missing.Tool, missing.Label); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// Dedupe collapses labels of tool whose snapshots share a SHA-256. In each
+// group the oldest (or, with keep=DedupeKeepNewest, most recently saved)
+// label is kept; the others are removed, and aliases, defaults, and active
+// labels pointing at them are moved to the kept label. With dryRun nothing
+// changes.
+func (m *Manager) Dedupe(tool Tool, keep string, dryRun bool) (*DedupeResult, error) {
+	if err := validateManagerTool(tool); err != nil {
+		return nil, err
+	}
+	if keep != DedupeKeepOldest && keep != DedupeKeepNewest {
+		return nil, invalidInputf("keep must be %s or %s", DedupeKeepOldest, DedupeKeepNewest)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun && m.readOnly {
+		return nil, ioErrorf("data root %s is read-only; nothing removed", m.rootDir)
+	}
+
+	byHash := map[string][]StateEntry{}
+	for _, entry := range state.Entries {
+		if entry.Tool == tool.String() && entry.SHA256 != "" {
+			byHash[entry.SHA256] = append(byHash[entry.SHA256], entry)
+		}
+	}
+	hashes := make([]string, 0, len(byHash))
+	for hash, entries := range byHash {
+		if len(entries) > 1 {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	result := &DedupeResult{Tool: tool, DryRun: dryRun}
+	var removed []StateEntry
+	for _, hash := range hashes {
+		entries := byHash[hash]
+		sort.Slice(entries, func(i, j int) bool {
+			left, _ := parseISO(entries[i].SavedAt)
+			right, _ := parseISO(entries[j].SavedAt)
+			if !left.Equal(right) {
+				return left.Before(right) == (keep == DedupeKeepOldest)
+			}
+			return entries[i].Label < entries[j].Label
+		})
+		group := DedupeGroup{SHA256: hash, Kept: entries[0].Label}
+		for _, entry := range entries[1:] {
+			group.Removed = append(group.Removed, entry.Label)
+			removed = append(removed, entry)
+		}
+		sort.Strings(group.Removed)
+		result.Groups = append(result.Groups, group)
+	}
+	sort.Slice(result.Groups, func(i, j int) bool {
+		return result.Groups[i].Kept < result.Groups[j].Kept
+	})
+	if dryRun || len(removed) == 0 {
+		return result, nil
+	}
+
+	for _, group := range result.Groups {
+		for _, label := range group.Removed {
+			delete(state.Entries, stateKey(tool, label))
+			for _, labels := range []map[string]string{state.LastActivatedLabel, state.PreviousActivatedLabel, state.Defaults} {
+				if labels[tool.String()] == label {
+					labels[tool.String()] = group.Kept
+				}
+			}
+			for name, target := range state.Aliases {
+				if target.Tool == tool.String() && target.Label == label {
+					state.Aliases[name] = AliasTarget{Tool: tool.String(), Label: group.Kept}
+				}
+			}
+		}
+	}
+	if state.PreviousActivatedLabel[tool.String()] == state.LastActivatedLabel[tool.String()] {
+		delete(state.PreviousActivatedLabel, tool.String())
+	}
+	if err := m.saveState(state); err != nil {
+		return nil, err
+	}
+	// State no longer points at these files, so a failed removal only leaves
+	// an orphan for ags gc.
+	for _, entry := range removed {
+		if err := removeCompanions(entry.Companions, nil); err != nil {
+			return nil, err
+		}
+		if entry.Linked {
+			continue
+		}
+		if err := os.Remove(entry.SnapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, ioErrorf("removing duplicate snapshot: %w", err)
+		}
+	}
+	return result, nil
 }
 
 func stateKey(tool Tool, label string) string {
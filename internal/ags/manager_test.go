@@ -1,16 +1,22 @@
 package ags
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
-func makeJWT(t *testing.T, claims map[string]any) string {
+func makeJWT(t testing.TB, claims map[string]any) string {
 	t.Helper()
 	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
 	claimsBytes, err := json.Marshal(claims)
@@ -21,13 +27,13 @@ func makeJWT(t *testing.T, claims map[string]any) string {
 	return header + "." + claimsPart + ".sig"
 }
 
-func makeCodexAuthJSON(t *testing.T, exp time.Time) []byte {
+func makeCodexAuthJSON(t testing.TB, exp time.Time) []byte {
 	t.Helper()
 	token := makeJWT(t, map[string]any{"exp": exp.Unix()})
 	return []byte(`{"tokens":{"access_token":"` + token + `"}}`)
 }
 
-func makeCodexAuthJSONWithIdentity(t *testing.T, exp time.Time, accountID string, email string, plan string) []byte {
+func makeCodexAuthJSONWithIdentity(t testing.TB, exp time.Time, accountID string, email string, plan string) []byte {
 	t.Helper()
 	accessToken := makeJWT(t, map[string]any{"exp": exp.Unix()})
 	idClaims := map[string]any{}
@@ -367,6 +373,71 @@ func TestManagerStateHelpersAndPersistence(t *testing.T) {
 	}
 }
 
+func TestManagerLoadStateMigrations(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	current := `{
+  "version": 1,
+  "entries": {
+    "codex:work": {
+      "tool": "codex",
+      "label": "work",
+      "source_path": "/tmp/auth.json",
+      "snapshot_path": "/tmp/work.json",
+      "sha256": "abc",
+      "saved_at": "2026-01-02T03:04:05Z",
+      "note": "keep me",
+      "tags": ["prod"]
+    }
+  },
+  "identity_cache": {"acct_1": {"email": "a@example.com", "updated_at": "2026-01-02T03:04:05Z"}},
+  "aliases": {"w": {"tool": "codex", "label": "work"}}
+}
+`
+	writeFile(t, m.statePath(), []byte(current))
+	st, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState v1: %v", err)
+	}
+	entry := st.Entries["codex:work"]
+	if st.Version != 1 || entry.Note != "keep me" || len(entry.Tags) != 1 || st.IdentityCache["acct_1"].Email != "a@example.com" || st.Aliases["w"].Label != "work" {
+		t.Fatalf("v1 state lost data: %+v", st)
+	}
+	if raw, _ := os.ReadFile(m.statePath()); string(raw) != current {
+		t.Fatalf("a current-version state should not be rewritten on load")
+	}
+
+	writeFile(t, m.statePath(), []byte(`{"entries":{"codex:work":{"tool":"codex","label":"work","snapshot_path":"/tmp/work.json","sha256":"abc","saved_at":"2026-01-02T03:04:05Z"}}}`))
+	st, err = m.loadState()
+	if err != nil {
+		t.Fatalf("loadState v0: %v", err)
+	}
+	if st.Version != currentStateVersion || st.Entries["codex:work"].SHA256 != "abc" {
+		t.Fatalf("unexpected migrated state: %+v", st)
+	}
+	raw, err := os.ReadFile(m.statePath())
+	if err != nil {
+		t.Fatalf("read migrated state: %v", err)
+	}
+	var persisted State
+	if err := json.Unmarshal(raw, &persisted); err != nil {
+		t.Fatalf("decode migrated state: %v", err)
+	}
+	if persisted.Version != currentStateVersion || persisted.Entries["codex:work"].SHA256 != "abc" {
+		t.Fatalf("migrated state was not persisted: %s", raw)
+	}
+
+	writeFile(t, m.statePath(), []byte(`{"version":99}`))
+	if _, err := m.loadState(); err == nil || !strings.Contains(err.Error(), "upgrade ags") {
+		t.Fatalf("expected newer-version error, got %v", err)
+	}
+}
+
 func TestManagerLoadStateReadErrorAndSaveStateWriteError(t *testing.T) {
 	home := t.TempDir()
 	t.Setenv("HOME", home)
@@ -718,14 +789,14 @@ func TestResolveSourcePathExpandErrorAndSaveStateSerializeError(t *testing.T) {
 
 func TestMergePIAuthWithTarget(t *testing.T) {
 	t.Run("invalid snapshot", func(t *testing.T) {
-		if _, err := mergePIAuthWithTarget([]byte("not-json"), filepath.Join(t.TempDir(), "target.json")); err == nil {
+		if _, err := (&Manager{}).mergePIAuthWithTarget([]byte("not-json"), filepath.Join(t.TempDir(), "target.json")); err == nil {
 			t.Fatalf("expected snapshot parse error")
 		}
 	})
 
 	t.Run("target missing", func(t *testing.T) {
 		snapshot := []byte(`{"openai-codex":{"access":"new"}}`)
-		merged, err := mergePIAuthWithTarget(snapshot, filepath.Join(t.TempDir(), "missing.json"))
+		merged, err := (&Manager{}).mergePIAuthWithTarget(snapshot, filepath.Join(t.TempDir(), "missing.json"))
 		if err != nil {
 			t.Fatalf("target missing merge should succeed: %v", err)
 		}
@@ -739,7 +810,7 @@ func TestMergePIAuthWithTarget(t *testing.T) {
 		if err := os.MkdirAll(targetDir, 0o700); err != nil {
 			t.Fatalf("mkdir target dir: %v", err)
 		}
-		if _, err := mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"new"}}`), targetDir); err == nil {
+		if _, err := (&Manager{}).mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"new"}}`), targetDir); err == nil {
 			t.Fatalf("expected target read error")
 		}
 	})
@@ -747,7 +818,7 @@ func TestMergePIAuthWithTarget(t *testing.T) {
 	t.Run("target invalid json", func(t *testing.T) {
 		target := filepath.Join(t.TempDir(), "target.json")
 		writeFile(t, target, []byte("not-json"))
-		if _, err := mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"new"}}`), target); err == nil {
+		if _, err := (&Manager{}).mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"new"}}`), target); err == nil {
 			t.Fatalf("expected target invalid json error")
 		}
 	})
@@ -757,7 +828,7 @@ func TestMergePIAuthWithTarget(t *testing.T) {
 		writeFile(t, target, []byte(`{"anthropic":{"access":"anthro-old"},"openai-codex":{"access":"codex-old"}}`))
 		snapshot := []byte(`{"openai-codex":{"access":"codex-new"}}`)
 
-		mergedRaw, err := mergePIAuthWithTarget(snapshot, target)
+		mergedRaw, err := (&Manager{}).mergePIAuthWithTarget(snapshot, target)
 		if err != nil {
 			t.Fatalf("mergePIAuthWithTarget: %v", err)
 		}
@@ -777,13 +848,40 @@ func TestMergePIAuthWithTarget(t *testing.T) {
 		}
 	})
 
+	t.Run("deep merge keeps runtime-only nested fields", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "target.json")
+		writeFile(t, target, []byte(`{"openai-codex":{"access":"codex-old","device":{"id":"dev-1","name":"laptop"},"scopes":["a","b"]},"anthropic":{"access":"anthro"}}`))
+		snapshot := []byte(`{"openai-codex":{"access":"codex-new","device":{"name":"desktop"},"scopes":["c"]}}`)
+
+		for strategy, wantDevice := range map[string]map[string]any{
+			PIMergeDeep:    {"id": "dev-1", "name": "desktop"},
+			PIMergeReplace: {"name": "desktop"},
+		} {
+			mergedRaw, err := (&Manager{}).mergePIAuthWithStrategy(snapshot, target, strategy)
+			if err != nil {
+				t.Fatalf("%s merge: %v", strategy, err)
+			}
+			var merged map[string]any
+			if err := json.Unmarshal(mergedRaw, &merged); err != nil {
+				t.Fatalf("unmarshal merged json: %v", err)
+			}
+			openai := merged["openai-codex"].(map[string]any)
+			if openai["access"] != "codex-new" || !reflect.DeepEqual(openai["device"], wantDevice) || !reflect.DeepEqual(openai["scopes"], []any{"c"}) {
+				t.Fatalf("%s merge: unexpected provider %+v", strategy, openai)
+			}
+			if merged["anthropic"].(map[string]any)["access"] != "anthro" {
+				t.Fatalf("%s merge: expected other providers preserved, got %+v", strategy, merged)
+			}
+		}
+	})
+
 	t.Run("merge serialize error", func(t *testing.T) {
 		restore := restoreManagerSeams()
 		defer restore()
 		jsonMarshalIndent = func(any, string, string) ([]byte, error) { return nil, os.ErrInvalid }
 		target := filepath.Join(t.TempDir(), "target.json")
 		writeFile(t, target, []byte(`{"anthropic":{"access":"anthro-old"}}`))
-		if _, err := mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"codex-new"}}`), target); err == nil {
+		if _, err := (&Manager{}).mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"codex-new"}}`), target); err == nil {
 			t.Fatalf("expected merge serialization error")
 		}
 	})
@@ -791,21 +889,21 @@ func TestMergePIAuthWithTarget(t *testing.T) {
 
 func TestFilterPIAuthProviders(t *testing.T) {
 	t.Run("invalid json", func(t *testing.T) {
-		if _, err := filterPIAuthProviders([]byte("not-json"), "codex"); err == nil {
+		if _, err := filterPIAuthProviders([]byte("not-json"), "codex", nil); err == nil {
 			t.Fatalf("expected invalid JSON error")
 		}
 	})
 
 	t.Run("missing provider", func(t *testing.T) {
 		raw := []byte(`{"openai-codex":{"access":"c1"},"anthropic":{"access":"a1"}}`)
-		if _, err := filterPIAuthProviders(raw, "missing"); err == nil {
+		if _, err := filterPIAuthProviders(raw, "missing", nil); err == nil {
 			t.Fatalf("expected provider missing error")
 		}
 	})
 
 	t.Run("codex alias", func(t *testing.T) {
 		raw := []byte(`{"openai-codex":{"access":"c1"},"anthropic":{"access":"a1"}}`)
-		filtered, err := filterPIAuthProviders(raw, "codex")
+		filtered, err := filterPIAuthProviders(raw, "codex", nil)
 		if err != nil {
 			t.Fatalf("filter codex: %v", err)
 		}
@@ -823,7 +921,7 @@ func TestFilterPIAuthProviders(t *testing.T) {
 
 	t.Run("exact provider case-insensitive", func(t *testing.T) {
 		raw := []byte(`{"openai-codex":{"access":"c1"},"anthropic":{"access":"a1"}}`)
-		filtered, err := filterPIAuthProviders(raw, "ANTHROPIC")
+		filtered, err := filterPIAuthProviders(raw, "ANTHROPIC", nil)
 		if err != nil {
 			t.Fatalf("filter anthropic exact: %v", err)
 		}
@@ -954,7 +1052,7 @@ func TestMergePIAuthWithTargetTargetParseErrorViaSeam(t *testing.T) {
 
 	target := filepath.Join(t.TempDir(), "target.json")
 	writeFile(t, target, []byte(`{"anthropic":{"access":"anthro-old"}}`))
-	if _, err := mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"codex-new"}}`), target); err == nil {
+	if _, err := (&Manager{}).mergePIAuthWithTarget([]byte(`{"openai-codex":{"access":"codex-new"}}`), target); err == nil {
 		t.Fatalf("expected target parse error from seam")
 	}
 }
@@ -1049,6 +1147,13 @@ func TestManagerActive(t *testing.T) {
 	if len(items) != 1 || items[0].Status != "match" || items[0].ActiveLabel != "work" {
 		t.Fatalf("unexpected active match result: %+v", items)
 	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState after use: %v", err)
+	}
+	if state.LastActivatedLabel[ToolCodex.String()] != "work" {
+		t.Fatalf("expected last-activated label recorded, got %+v", state.LastActivatedLabel)
+	}
 
 	if _, err := m.Save(ToolCodex, "work-clone", codexSrc); err != nil {
 		t.Fatalf("save codex clone: %v", err)
@@ -1057,6 +1162,27 @@ func TestManagerActive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Active codex ambiguous: %v", err)
 	}
+	if len(items) != 1 || items[0].Status != "match (by last-activated)" || items[0].ActiveLabel != "work" {
+		t.Fatalf("expected last-activated tiebreak, got %+v", items)
+	}
+
+	if _, err := m.Delete(ToolCodex, "work"); err != nil {
+		t.Fatalf("delete codex work: %v", err)
+	}
+	state, err = m.loadState()
+	if err != nil {
+		t.Fatalf("loadState after delete: %v", err)
+	}
+	if _, ok := state.LastActivatedLabel[ToolCodex.String()]; ok {
+		t.Fatalf("expected last-activated label cleared on delete, got %+v", state.LastActivatedLabel)
+	}
+	if _, err := m.Save(ToolCodex, "work-clone-2", codexSrc); err != nil {
+		t.Fatalf("save codex clone 2: %v", err)
+	}
+	items, err = m.Active(&filtered)
+	if err != nil {
+		t.Fatalf("Active codex ambiguous: %v", err)
+	}
 	if len(items) != 1 || items[0].Status != "ambiguous" {
 		t.Fatalf("unexpected ambiguous result: %+v", items)
 	}
@@ -1089,6 +1215,77 @@ func TestManagerActive(t *testing.T) {
 	}
 }
 
+func TestManagerActiveByAccount(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	exp := time.Now().Add(time.Hour)
+	src := filepath.Join(t.TempDir(), "codex.json")
+	writeFile(t, src, makeCodexAuthJSONWithIdentity(t, exp, "acct-work", "work@example.com", "plus"))
+	if _, err := m.Save(ToolCodex, "work", src); err != nil {
+		t.Fatalf("save work: %v", err)
+	}
+	writeFile(t, src, makeCodexAuthJSONWithIdentity(t, exp, "acct-home", "home@example.com", "plus"))
+	if _, err := m.Save(ToolCodex, "home", src); err != nil {
+		t.Fatalf("save home: %v", err)
+	}
+
+	// A refreshed token changes the runtime bytes but keeps the account.
+	runtimePath := filepath.Join(home, ".codex", "auth.json")
+	writeFile(t, runtimePath, makeCodexAuthJSONWithIdentity(t, exp.Add(time.Hour), "acct-work", "work@example.com", "plus"))
+	codex := ToolCodex
+	items, err := m.Active(&codex)
+	if err != nil {
+		t.Fatalf("Active refreshed: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != "match (by account)" || items[0].ActiveLabel != "work" {
+		t.Fatalf("expected match by account, got %+v", items)
+	}
+
+	// An exact hash match still wins.
+	if _, err := m.Use(ToolCodex, "home", ""); err != nil {
+		t.Fatalf("use home: %v", err)
+	}
+	items, err = m.Active(&codex)
+	if err != nil {
+		t.Fatalf("Active exact: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != "match" || items[0].ActiveLabel != "home" {
+		t.Fatalf("expected exact match, got %+v", items)
+	}
+
+	// Two labels for the same account are not guessed between.
+	writeFile(t, src, makeCodexAuthJSONWithIdentity(t, exp.Add(2*time.Hour), "acct-work", "work@example.com", "plus"))
+	if _, err := m.Save(ToolCodex, "work-2", src); err != nil {
+		t.Fatalf("save work-2: %v", err)
+	}
+	writeFile(t, runtimePath, makeCodexAuthJSONWithIdentity(t, exp.Add(3*time.Hour), "acct-work", "work@example.com", "plus"))
+	items, err = m.Active(&codex)
+	if err != nil {
+		t.Fatalf("Active shared account: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != "no matching saved profile" || items[0].ActiveLabel != "" {
+		t.Fatalf("expected no match for shared account, got %+v", items)
+	}
+	if len(items[0].Details) != 1 || !strings.Contains(items[0].Details[0], "work,work-2") {
+		t.Fatalf("expected candidate labels in details, got %+v", items[0].Details)
+	}
+
+	// Without an account id there is nothing to fall back on.
+	writeFile(t, runtimePath, makeCodexAuthJSON(t, exp))
+	items, err = m.Active(&codex)
+	if err != nil {
+		t.Fatalf("Active no account: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != "no matching saved profile" || len(items[0].Details) != 0 {
+		t.Fatalf("expected plain no match, got %+v", items)
+	}
+}
+
 func TestManagerActiveErrors(t *testing.T) {
 	home := t.TempDir()
 	t.Setenv("HOME", home)
@@ -1126,15 +1323,32 @@ func TestManagerActiveErrors(t *testing.T) {
 		t.Fatalf("unexpected active invalid runtime result: %+v", items)
 	}
 
+	writeFile(t, piTarget, nil)
+	items, err = m2.Active(&piTool)
+	if err != nil {
+		t.Fatalf("Active with empty runtime file: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != "runtime auth file empty" || len(items[0].Details) != 1 {
+		t.Fatalf("unexpected active empty runtime result: %+v", items)
+	}
+
 	codexSrc := filepath.Join(t.TempDir(), "codex.json")
 	writeFile(t, codexSrc, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
 	if _, err := m2.Save(ToolCodex, "work", codexSrc); err != nil {
 		t.Fatalf("save codex work: %v", err)
 	}
+	writeFile(t, filepath.Join(home, ".codex", "auth.json"), nil)
+	codexTool := ToolCodex
+	items, err = m2.Active(&codexTool)
+	if err != nil || len(items) != 1 || items[0].Status != "runtime auth file empty" {
+		t.Fatalf("unexpected active empty codex runtime result: %+v (%v)", items, err)
+	}
+	if err := os.Remove(filepath.Join(home, ".codex", "auth.json")); err != nil {
+		t.Fatalf("remove empty codex runtime: %v", err)
+	}
 	if err := os.MkdirAll(filepath.Join(home, ".codex", "auth.json"), 0o700); err != nil {
 		t.Fatalf("mkdir codex runtime dir: %v", err)
 	}
-	codexTool := ToolCodex
 	if _, err := m2.Active(&codexTool); err == nil {
 		t.Fatalf("expected active runtime read error for codex")
 	}
@@ -1332,3 +1546,1594 @@ func TestManagerUseRollsBackTargetWhenStateSaveFails(t *testing.T) {
 		}
 	})
 }
+
+func TestManagerUseWithBackup(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	source := filepath.Join(t.TempDir(), "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	if _, err := m.Save(ToolCodex, "work", source); err != nil {
+		t.Fatalf("save setup: %v", err)
+	}
+
+	missingTarget := filepath.Join(t.TempDir(), "missing.json")
+	res, err := m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: missingTarget, Backup: true})
+	if err != nil {
+		t.Fatalf("use with backup and no existing target: %v", err)
+	}
+	if res.BackupPath != "" {
+		t.Fatalf("expected no backup when target is missing, got %q", res.BackupPath)
+	}
+
+	target := filepath.Join(t.TempDir(), "target.json")
+	originalRaw := []byte(`{"tokens":{"access_token":"old"}}`)
+	writeFile(t, target, originalRaw)
+	res, err = m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: target, Backup: true})
+	if err != nil {
+		t.Fatalf("use with backup: %v", err)
+	}
+	if !strings.HasPrefix(res.BackupPath, filepath.Join(root, "backups", "codex", "before-work-")) {
+		t.Fatalf("unexpected backup path %q", res.BackupPath)
+	}
+	backupRaw, err := os.ReadFile(res.BackupPath)
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if string(backupRaw) != string(originalRaw) {
+		t.Fatalf("expected backup to hold previous runtime content, got %q", string(backupRaw))
+	}
+	info, err := os.Stat(res.BackupPath)
+	if err != nil {
+		t.Fatalf("stat backup: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected backup mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// Two backups in the same second get distinct files.
+	frozen := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	previousNow := nowUTC
+	nowUTC = func() time.Time { return frozen }
+	writeFile(t, target, originalRaw)
+	first, err := m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: target, Backup: true})
+	if err != nil {
+		nowUTC = previousNow
+		t.Fatalf("first frozen backup: %v", err)
+	}
+	second, err := m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: target, Backup: true})
+	nowUTC = previousNow
+	if err != nil {
+		t.Fatalf("second frozen backup: %v", err)
+	}
+	if first.BackupPath == second.BackupPath || !strings.HasSuffix(second.BackupPath, "before-work-20260102T030405Z-2.json") {
+		t.Fatalf("expected a suffixed second backup, got %q and %q", first.BackupPath, second.BackupPath)
+	}
+	if raw, err := os.ReadFile(first.BackupPath); err != nil || string(raw) != string(originalRaw) {
+		t.Fatalf("expected the first backup to keep the original runtime file, got %q (%v)", raw, err)
+	}
+
+	blockedRoot := t.TempDir()
+	blocked, err := NewManager(blockedRoot)
+	if err != nil {
+		t.Fatalf("NewManager blocked: %v", err)
+	}
+	if _, err := blocked.Save(ToolCodex, "work", source); err != nil {
+		t.Fatalf("save blocked setup: %v", err)
+	}
+	writeFile(t, filepath.Join(blockedRoot, "backups"), []byte("not a dir"))
+	if _, err := blocked.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: target, Backup: true}); err == nil || !strings.Contains(err.Error(), "writing runtime backup") {
+		t.Fatalf("expected backup write error, got %v", err)
+	}
+}
+
+func TestManagerStateBackupRotationAndRestore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	if err := m.saveState(defaultState()); err != nil {
+		t.Fatalf("first saveState: %v", err)
+	}
+	if _, err := os.Stat(m.stateBackupPath(1)); !os.IsNotExist(err) {
+		t.Fatalf("expected no backup before any prior state, got err=%v", err)
+	}
+
+	for i := 0; i < stateBackupCount+1; i++ {
+		st := defaultState()
+		for j := 0; j <= i; j++ {
+			label := "l" + strconv.Itoa(j)
+			st.Entries[stateKey(ToolCodex, label)] = StateEntry{Tool: ToolCodex.String(), Label: label}
+		}
+		if err := m.saveState(st); err != nil {
+			t.Fatalf("saveState %d: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(m.stateBackupPath(stateBackupCount + 1)); !os.IsNotExist(err) {
+		t.Fatalf("expected ring capped at %d backups, got err=%v", stateBackupCount, err)
+	}
+
+	res, err := m.RestoreState(1)
+	if err != nil {
+		t.Fatalf("RestoreState(1): %v", err)
+	}
+	if res.Entries != stateBackupCount || res.BackupPath != m.stateBackupPath(1) {
+		t.Fatalf("unexpected restore result: %+v", res)
+	}
+	st, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState after restore: %v", err)
+	}
+	if len(st.Entries) != stateBackupCount {
+		t.Fatalf("expected restored state with %d entries, got %d", stateBackupCount, len(st.Entries))
+	}
+	replaced, err := os.ReadFile(m.stateBackupPath(1))
+	if err != nil {
+		t.Fatalf("read backup 1 after restore: %v", err)
+	}
+	var undo State
+	if err := json.Unmarshal(replaced, &undo); err != nil || len(undo.Entries) != stateBackupCount+1 {
+		t.Fatalf("expected the replaced state rotated into backup 1, got %d entries (%v)", len(undo.Entries), err)
+	}
+
+	if _, err := m.RestoreState(0); err == nil {
+		t.Fatalf("expected out-of-range slot error")
+	}
+	writeFile(t, m.stateBackupPath(2), []byte(`{broken`))
+	if _, err := m.RestoreState(2); err == nil || !strings.Contains(err.Error(), "is not valid") {
+		t.Fatalf("expected invalid backup error, got %v", err)
+	}
+	if err := os.Remove(m.stateBackupPath(3)); err != nil {
+		t.Fatalf("remove backup 3: %v", err)
+	}
+	if _, err := m.RestoreState(3); err == nil || !strings.Contains(err.Error(), "no state backup found") {
+		t.Fatalf("expected missing backup error, got %v", err)
+	}
+}
+
+func TestManagerRotateStateBackupsErrors(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	writeFile(t, m.statePath(), []byte(`{}`))
+
+	restore := restoreFileSeams()
+	renamePath = func(string, string) error { return os.ErrPermission }
+	if err := m.rotateStateBackups(); err == nil || !strings.Contains(err.Error(), "rotating state backup") {
+		t.Fatalf("expected rotate error, got %v", err)
+	}
+	restore()
+
+	restore = restoreFileSeams()
+	defer restore()
+	createTemp = func(string, string) (tempFile, error) { return nil, os.ErrPermission }
+	if err := m.rotateStateBackups(); err == nil || !strings.Contains(err.Error(), "writing state backup") {
+		t.Fatalf("expected backup write error, got %v", err)
+	}
+}
+
+func TestManagerSnapshotLayout(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	source := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	nestedRoot := t.TempDir()
+	nested, err := NewManager(nestedRoot)
+	if err != nil {
+		t.Fatalf("NewManager nested: %v", err)
+	}
+	result, err := nested.Save(ToolCodex, "work", source)
+	if err != nil {
+		t.Fatalf("save nested: %v", err)
+	}
+	if want := filepath.Join(nestedRoot, "snapshots", "codex", "work.json"); result.SnapshotPath != want {
+		t.Fatalf("expected nested snapshot %s, got %s", want, result.SnapshotPath)
+	}
+
+	// Switching an existing root to flat keeps old entries where they are.
+	writeFile(t, filepath.Join(nestedRoot, "config.json"), []byte(`{"snapshot_layout": "flat"}`))
+	flat, err := NewManager(nestedRoot)
+	if err != nil {
+		t.Fatalf("NewManager flat: %v", err)
+	}
+	result, err = flat.Save(ToolCodex, "home", source)
+	if err != nil {
+		t.Fatalf("save flat: %v", err)
+	}
+	if want := filepath.Join(nestedRoot, "snapshots", "codex-home.json"); result.SnapshotPath != want {
+		t.Fatalf("expected flat snapshot %s, got %s", want, result.SnapshotPath)
+	}
+	items, err := flat.List(nil)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected both layouts listed, got %+v err=%v", items, err)
+	}
+	for _, label := range []string{"work", "home"} {
+		target := filepath.Join(t.TempDir(), "auth.json")
+		if _, err := flat.UseWithOptions(ToolCodex, label, UseOptions{TargetOverride: target}); err != nil {
+			t.Fatalf("use %s: %v", label, err)
+		}
+	}
+
+	orphan := filepath.Join(nestedRoot, "snapshots", "codex-stray.json")
+	writeFile(t, orphan, []byte(`{}`))
+	gc, err := flat.GC(true)
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if len(gc.OrphanedSnapshots) != 1 || gc.OrphanedSnapshots[0] != orphan {
+		t.Fatalf("expected only the flat orphan, got %+v", gc.OrphanedSnapshots)
+	}
+
+	writeFile(t, filepath.Join(nestedRoot, "config.json"), []byte(`{"snapshot_layout": "tree"}`))
+	if _, err := NewManager(nestedRoot); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid snapshot_layout to be rejected, got %v", err)
+	}
+}
+
+func TestManagerRuntimeInsight(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	runtimePath := filepath.Join(home, ".codex", "auth.json")
+
+	if _, err := m.RuntimeInsight(ToolCodex); !errors.Is(err, ErrRuntimeMissing) {
+		t.Fatalf("expected ErrRuntimeMissing, got %v", err)
+	}
+	writeFile(t, runtimePath, nil)
+	if _, err := m.RuntimeInsight(ToolCodex); !errors.Is(err, ErrRuntimeEmpty) {
+		t.Fatalf("expected ErrRuntimeEmpty, got %v", err)
+	}
+	writeFile(t, runtimePath, []byte(`[1]`))
+	if _, err := m.RuntimeInsight(ToolCodex); !errors.Is(err, ErrRuntimeInvalid) {
+		t.Fatalf("expected ErrRuntimeInvalid, got %v", err)
+	}
+
+	writeFile(t, runtimePath, makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-1", "one@example.com", "plus"))
+	insight, err := m.RuntimeInsight(ToolCodex)
+	if err != nil {
+		t.Fatalf("RuntimeInsight: %v", err)
+	}
+	if insight.AccountID != "acct-1" || insight.AccountEmail != "one@example.com" {
+		t.Fatalf("unexpected runtime insight: %+v", insight)
+	}
+
+	if _, err := m.RuntimeInsight(Tool("bad")); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool error, got %v", err)
+	}
+}
+
+func TestManagerCheck(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	sources := map[string]time.Time{
+		"fresh":    time.Now().Add(5 * time.Hour),
+		"soon":     time.Now().Add(time.Hour),
+		"gone":     time.Now().Add(-time.Hour),
+		"unknown1": {},
+	}
+	for label, exp := range sources {
+		source := filepath.Join(t.TempDir(), label+".json")
+		if exp.IsZero() {
+			writeFile(t, source, []byte(`{"tokens":{}}`))
+		} else {
+			writeFile(t, source, makeCodexAuthJSON(t, exp))
+		}
+		if _, err := m.Save(ToolCodex, label, source); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	items, err := m.Check(nil, 2*time.Hour, false)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	got := map[string]string{}
+	for _, item := range items {
+		got[item.Label] = item.Status
+	}
+	want := map[string]string{"fresh": "ok", "soon": "expiring", "gone": "expired", "unknown1": "unknown"}
+	for label, status := range want {
+		if got[label] != status {
+			t.Fatalf("expected %s=%s, got %+v", label, status, got)
+		}
+	}
+
+	filter := ToolPi
+	items, err = m.Check(&filter, time.Hour, true)
+	if err != nil {
+		t.Fatalf("Check active-only missing runtime: %v", err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("expected no runtime items when runtime is missing, got %+v", items)
+	}
+
+	writeFile(t, filepath.Join(home, ".codex", "auth.json"), makeCodexAuthJSON(t, time.Now().Add(30*time.Minute)))
+	items, err = m.Check(nil, time.Hour, true)
+	if err != nil {
+		t.Fatalf("Check active-only: %v", err)
+	}
+	if len(items) != 1 || items[0].Label != "runtime" || items[0].Status != "expiring" {
+		t.Fatalf("unexpected runtime check items: %+v", items)
+	}
+
+	bad := Tool("bad")
+	if _, err := m.Check(&bad, time.Hour, false); err == nil {
+		t.Fatalf("expected invalid tool error")
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".pi", "agent", "auth.json"), 0o700); err != nil {
+		t.Fatalf("mkdir pi runtime dir: %v", err)
+	}
+	if _, err := m.Check(nil, time.Hour, true); err == nil {
+		t.Fatalf("expected runtime read error")
+	}
+}
+
+func TestManagerExpiringSoonConfigAndOption(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	source := filepath.Join(t.TempDir(), "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if m.expiringSoon != defaultExpiringSoon {
+		t.Fatalf("expected default window, got %v", m.expiringSoon)
+	}
+	saved, err := m.Save(ToolCodex, "work", source)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if saved.Insight.Status != "valid" {
+		t.Fatalf("expected valid with default window, got %q", saved.Insight.Status)
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"expiring_soon":"2h"}`))
+	m, err = NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager with config: %v", err)
+	}
+	items, err := m.List(nil)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if items[0].AuthInsight.Status != "expiring_soon" {
+		t.Fatalf("expected config window to apply, got %q", items[0].AuthInsight.Status)
+	}
+
+	m, err = NewManager(root, WithExpiringSoon(30*time.Minute))
+	if err != nil {
+		t.Fatalf("NewManager with option: %v", err)
+	}
+	if m.expiringSoon != 30*time.Minute {
+		t.Fatalf("expected option to override config, got %v", m.expiringSoon)
+	}
+
+	for _, raw := range []string{`{broken`, `{"expiring_soon":"soon"}`, `{"expiring_soon":"-1h"}`} {
+		writeFile(t, filepath.Join(root, "config.json"), []byte(raw))
+		if _, err := NewManager(root); err == nil {
+			t.Fatalf("expected config error for %s", raw)
+		}
+	}
+	if err := os.Remove(filepath.Join(root, "config.json")); err != nil {
+		t.Fatalf("remove config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "config.json"), 0o700); err != nil {
+		t.Fatalf("mkdir config dir: %v", err)
+	}
+	if _, err := NewManager(root); err == nil || !strings.Contains(err.Error(), "reading config") {
+		t.Fatalf("expected config read error, got %v", err)
+	}
+}
+
+func TestResolvePIProviderKeysMultiSelector(t *testing.T) {
+	payload := map[string]any{
+		"openai-codex": map[string]any{"access": "c1"},
+		"anthropic":    map[string]any{"access": "a1"},
+		"google":       map[string]any{"access": "g1"},
+	}
+
+	keys, err := resolvePIProviderKeys(payload, "codex, anthropic,codex", nil)
+	if err != nil {
+		t.Fatalf("resolve multi: %v", err)
+	}
+	if strings.Join(keys, ",") != "anthropic,openai-codex" {
+		t.Fatalf("expected deduped sorted union, got %v", keys)
+	}
+
+	keys, err = resolvePIProviderKeys(payload, "ALL", nil)
+	if err != nil {
+		t.Fatalf("resolve all: %v", err)
+	}
+	if strings.Join(keys, ",") != "anthropic,google,openai-codex" {
+		t.Fatalf("expected every provider for all, got %v", keys)
+	}
+
+	keys, err = resolvePIProviderKeys(payload, "missing,google", nil)
+	if err != nil {
+		t.Fatalf("resolve partial match: %v", err)
+	}
+	if strings.Join(keys, ",") != "google" {
+		t.Fatalf("expected partial selector list to keep matches, got %v", keys)
+	}
+
+	if _, err := resolvePIProviderKeys(payload, "missing,other", nil); err == nil || !strings.Contains(err.Error(), "available providers") {
+		t.Fatalf("expected error when no selector matches, got %v", err)
+	}
+	if _, err := resolvePIProviderKeys(payload, " , ", nil); err == nil {
+		t.Fatalf("expected error for selector list with no entries")
+	}
+	if _, err := resolvePIProviderKeys(map[string]any{}, "all", nil); err == nil {
+		t.Fatalf("expected error for all on empty payload")
+	}
+}
+
+func TestResolvePIProviderKeysConfiguredAliases(t *testing.T) {
+	payload := map[string]any{
+		"openai-codex":  map[string]any{"access": "c1"},
+		"openai-images": map[string]any{"access": "i1"},
+		"anthropic":     map[string]any{"access": "a1"},
+	}
+
+	keys, err := resolvePIProviderKeys(payload, "codex", nil)
+	if err != nil {
+		t.Fatalf("resolve fuzzy: %v", err)
+	}
+	if strings.Join(keys, ",") != "openai-codex,openai-images" {
+		t.Fatalf("expected the fuzzy match to over-match, got %v", keys)
+	}
+
+	aliases, err := parsePIProviderAliases(map[string][]string{"Codex": {"OpenAI-Codex"}, "work": {"openai-codex", " anthropic "}})
+	if err != nil {
+		t.Fatalf("parsePIProviderAliases: %v", err)
+	}
+	keys, err = resolvePIProviderKeys(payload, "codex", aliases)
+	if err != nil {
+		t.Fatalf("resolve configured: %v", err)
+	}
+	if strings.Join(keys, ",") != "openai-codex" {
+		t.Fatalf("expected configured alias to pin exact keys, got %v", keys)
+	}
+	keys, err = resolvePIProviderKeys(payload, "work,anthropic", aliases)
+	if err != nil {
+		t.Fatalf("resolve configured list: %v", err)
+	}
+	if strings.Join(keys, ",") != "anthropic,openai-codex" {
+		t.Fatalf("expected configured and built-in selectors to combine, got %v", keys)
+	}
+	if _, err := resolvePIProviderKeys(map[string]any{"openai-images": map[string]any{}}, "codex", aliases); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected configured alias with no listed key present to match nothing, got %v", err)
+	}
+
+	for _, bad := range []map[string][]string{
+		{"all": {"x"}},
+		{"a,b": {"x"}},
+		{" ": {"x"}},
+		{"codex": {" "}},
+	} {
+		if _, err := parsePIProviderAliases(bad); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %v to be rejected, got %v", bad, err)
+		}
+	}
+}
+
+func TestManagerPIProviderAliasesFromConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"pi_provider_aliases": {"codex": ["openai-codex"]}}`))
+	source := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, []byte(`{"openai-codex":{"access":"c1"},"openai-images":{"access":"i1"}}`))
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.SaveWithPIProvider(ToolPi, "work", source, "codex"); err != nil {
+		t.Fatalf("save --provider codex: %v", err)
+	}
+	raw, err := m.ReadSnapshot(ToolPi, "work", "")
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if strings.Contains(string(raw), "openai-images") || !strings.Contains(string(raw), "openai-codex") {
+		t.Fatalf("expected only openai-codex saved, got %s", raw)
+	}
+
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"pi_provider_aliases": {"all": ["x"]}}`))
+	if _, err := NewManager(root); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid alias config to be rejected, got %v", err)
+	}
+}
+
+func TestManagerSaveFromStdin(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	raw := makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_stdin", "stdin@company.com", "pro")
+	res, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: "-", Stdin: bytes.NewReader(raw)})
+	if err != nil {
+		t.Fatalf("save from stdin: %v", err)
+	}
+	if res.SourcePath != stdinSourcePath || !res.ChangedSinceLastSave {
+		t.Fatalf("unexpected stdin save result: %+v", res)
+	}
+	if res.Insight.AccountEmail != "stdin@company.com" || res.Insight.AccountPlan != "Pro" {
+		t.Fatalf("expected identity from stdin payload, got %+v", res.Insight)
+	}
+
+	res, err = m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: "-", Stdin: bytes.NewReader(raw)})
+	if err != nil {
+		t.Fatalf("second save from stdin: %v", err)
+	}
+	if res.ChangedSinceLastSave {
+		t.Fatalf("expected unchanged on identical stdin input")
+	}
+
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if state.Entries[stateKey(ToolCodex, "work")].SourcePath != stdinSourcePath {
+		t.Fatalf("expected %q recorded as source path, got %+v", stdinSourcePath, state.Entries)
+	}
+
+	if _, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: "-"}); err == nil || !strings.Contains(err.Error(), "requires stdin") {
+		t.Fatalf("expected missing stdin error, got %v", err)
+	}
+	if _, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: "-", Stdin: strings.NewReader(`[1]`)}); err == nil || !strings.Contains(err.Error(), "not valid JSON object") {
+		t.Fatalf("expected JSON object validation error, got %v", err)
+	}
+	if _, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: "-", Stdin: iotest.ErrReader(os.ErrClosed)}); err == nil || !strings.Contains(err.Error(), "reading source auth from stdin") {
+		t.Fatalf("expected stdin read error, got %v", err)
+	}
+}
+
+func TestManagerReadSnapshotErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.ReadSnapshot(Tool("bad"), "work", ""); err == nil {
+		t.Fatalf("expected invalid tool error")
+	}
+	if err := os.MkdirAll(m.statePath(), 0o700); err != nil {
+		t.Fatalf("mkdir state dir: %v", err)
+	}
+	if _, err := m.ReadSnapshot(ToolCodex, "work", ""); err == nil {
+		t.Fatalf("expected state read error")
+	}
+}
+
+func TestManagerNotes(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	note := "  client X sandbox account "
+	if _, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: src, Note: &note}); err != nil {
+		t.Fatalf("save with note: %v", err)
+	}
+	if _, err := m.Save(ToolCodex, "work", src); err != nil {
+		t.Fatalf("re-save: %v", err)
+	}
+	items, err := m.List(nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(items) != 1 || items[0].Note != "client X sandbox account" {
+		t.Fatalf("expected note preserved across re-save, got %+v", items)
+	}
+
+	if err := m.SetNote(ToolCodex, "work", ""); err != nil {
+		t.Fatalf("SetNote clear: %v", err)
+	}
+	items, _ = m.List(nil)
+	if items[0].Note != "" {
+		t.Fatalf("expected note cleared, got %q", items[0].Note)
+	}
+
+	tooLong := strings.Repeat("é", maxNoteLength+1)
+	if err := m.SetNote(ToolCodex, "work", tooLong); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for long note, got %v", err)
+	}
+	if _, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: src, Note: &tooLong}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for long save note, got %v", err)
+	}
+	if err := m.SetNote(ToolCodex, "work", strings.Repeat("é", maxNoteLength)); err != nil {
+		t.Fatalf("expected note at limit to be accepted: %v", err)
+	}
+	if err := m.SetNote(ToolCodex, "missing", "x"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := m.SetNote(Tool("bad"), "work", "x"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool, got %v", err)
+	}
+}
+
+func TestManagerSaveReportsDuplicateLabels(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	writeFile(t, src, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	piSrc := filepath.Join(root, "pi.json")
+	writeFile(t, piSrc, []byte(`{"anthropic":{"access":"a"}}`))
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	result, err := m.Save(ToolCodex, "personal", src)
+	if err != nil {
+		t.Fatalf("save personal: %v", err)
+	}
+	if len(result.DuplicateLabels) != 0 {
+		t.Fatalf("expected no duplicates on first save, got %v", result.DuplicateLabels)
+	}
+	if _, err := m.Save(ToolPi, "work", piSrc); err != nil {
+		t.Fatalf("save pi: %v", err)
+	}
+	if _, err := m.Save(ToolCodex, "alt", src); err != nil {
+		t.Fatalf("save alt: %v", err)
+	}
+	result, err = m.Save(ToolCodex, "work", src)
+	if err != nil {
+		t.Fatalf("save work: %v", err)
+	}
+	if strings.Join(result.DuplicateLabels, ",") != "alt,personal" {
+		t.Fatalf("expected duplicates alt,personal, got %v", result.DuplicateLabels)
+	}
+
+	// Re-saving the same label is not a duplicate of itself.
+	result, err = m.Save(ToolCodex, "work", src)
+	if err != nil {
+		t.Fatalf("re-save work: %v", err)
+	}
+	if strings.Join(result.DuplicateLabels, ",") != "alt,personal" {
+		t.Fatalf("expected self excluded, got %v", result.DuplicateLabels)
+	}
+}
+
+func TestManagerFind(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	exp := time.Now().Add(2 * time.Hour)
+	for _, tc := range []struct {
+		label, accountID, email string
+	}{
+		{"work", "acct_work", "Person@Company.com"},
+		{"personal", "acct_home", "person@home.example"},
+		{"noemail", "acct_company_ci", ""},
+	} {
+		src := filepath.Join(root, tc.label+".json")
+		writeFile(t, src, makeCodexAuthJSONWithIdentity(t, exp, tc.accountID, tc.email, ""))
+		if _, err := m.Save(ToolCodex, tc.label, src); err != nil {
+			t.Fatalf("save %s: %v", tc.label, err)
+		}
+	}
+
+	labels := func(items []ListItem) string {
+		out := make([]string, 0, len(items))
+		for _, item := range items {
+			out = append(out, item.Label)
+		}
+		return strings.Join(out, ",")
+	}
+
+	items, err := m.Find("person@company")
+	if err != nil || labels(items) != "work" {
+		t.Fatalf("find by email: items=%v err=%v", labels(items), err)
+	}
+	items, err = m.Find("COMPANY")
+	if err != nil || labels(items) != "noemail,work" {
+		t.Fatalf("find by email or id substring: items=%v err=%v", labels(items), err)
+	}
+	items, err = m.Find("acct_home")
+	if err != nil || labels(items) != "personal" {
+		t.Fatalf("find by account id: items=%v err=%v", labels(items), err)
+	}
+	items, err = m.Find("nobody")
+	if err != nil || len(items) != 0 {
+		t.Fatalf("expected no matches, got %v err=%v", labels(items), err)
+	}
+	if _, err := m.Find("  "); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for empty query, got %v", err)
+	}
+}
+
+func TestManagerUseNoMergeReplacesPiRuntime(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(root, "pi.json")
+	writeFile(t, src, []byte(`{"openai-codex":{"access":"codex-work"},"anthropic":{"access":"anthro-work"}}`))
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.Save(ToolPi, "work", src); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	target := filepath.Join(home, ".pi", "agent", "auth.json")
+	writeFile(t, target, []byte(`{"runtime-only":{"access":"keep-me"},"anthropic":{"access":"old"}}`))
+	if _, err := m.UseWithOptions(ToolPi, "work", UseOptions{}); err != nil {
+		t.Fatalf("use merge: %v", err)
+	}
+	merged, _ := os.ReadFile(target)
+	if !strings.Contains(string(merged), "runtime-only") {
+		t.Fatalf("expected default use to keep runtime-only provider, got %s", merged)
+	}
+
+	if _, err := m.UseWithOptions(ToolPi, "work", UseOptions{NoMerge: true, PIProvider: "anthropic"}); err != nil {
+		t.Fatalf("use no-merge: %v", err)
+	}
+	var payload map[string]any
+	raw, _ := os.ReadFile(target)
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		t.Fatalf("decode runtime: %v", err)
+	}
+	if len(payload) != 1 || payload["anthropic"] == nil {
+		t.Fatalf("expected runtime to hold only the filtered snapshot, got %s", raw)
+	}
+}
+
+func TestManagerUseRefusesSymlinkedTarget(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(root, "codex.json")
+	raw := makeCodexAuthJSON(t, time.Now().Add(2*time.Hour))
+	writeFile(t, src, raw)
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.Save(ToolCodex, "work", src); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	victim := filepath.Join(t.TempDir(), "elsewhere.json")
+	writeFile(t, victim, []byte(`{"untouched":true}`))
+	link := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.Symlink(victim, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	_, err = m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: link})
+	if !errors.Is(err, ErrInvalidInput) || !strings.Contains(err.Error(), "symlink") {
+		t.Fatalf("expected symlink refusal, got %v", err)
+	}
+	if got, _ := os.ReadFile(victim); string(got) != `{"untouched":true}` {
+		t.Fatalf("expected symlink target untouched, got %s", got)
+	}
+
+	result, err := m.UseWithOptions(ToolCodex, "work", UseOptions{TargetOverride: link, FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("use with FollowSymlinks: %v", err)
+	}
+	resolvedVictim, _ := filepath.EvalSymlinks(victim)
+	if result.TargetPath != resolvedVictim {
+		t.Fatalf("expected write through to %s, got %s", resolvedVictim, result.TargetPath)
+	}
+	if got, _ := os.ReadFile(victim); string(got) != string(raw) {
+		t.Fatalf("expected snapshot written through symlink, got %s", got)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected symlink preserved, info=%v err=%v", info, err)
+	}
+}
+
+func TestManagerSaveRefusesSymlinkedSource(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	realPath := filepath.Join(root, "real.json")
+	writeFile(t, realPath, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	link := filepath.Join(root, "link.json")
+	if err := os.Symlink(realPath, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: link}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected symlinked source refused, got %v", err)
+	}
+	result, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: link, FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("save with FollowSymlinks: %v", err)
+	}
+	resolvedReal, _ := filepath.EvalSymlinks(realPath)
+	if result.SourcePath != resolvedReal {
+		t.Fatalf("expected resolved source path %s, got %s", resolvedReal, result.SourcePath)
+	}
+}
+
+func TestManagerReadOnlyRoot(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	src := filepath.Join(t.TempDir(), "codex.json")
+	raw := makeCodexAuthJSON(t, time.Now().Add(2*time.Hour))
+	writeFile(t, src, raw)
+
+	writable, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if writable.ReadOnly() {
+		t.Fatalf("expected temp root to be writable")
+	}
+	if _, err := writable.Save(ToolCodex, "work", src); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	stateBefore, err := os.ReadFile(filepath.Join(root, "state.json"))
+	if err != nil {
+		t.Fatalf("read state: %v", err)
+	}
+
+	restore := restoreFileSeams()
+	defer restore()
+	probeDir = func(dir string) error {
+		return &os.PathError{Op: "open", Path: dir, Err: os.ErrPermission}
+	}
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager read-only: %v", err)
+	}
+	if !m.ReadOnly() {
+		t.Fatalf("expected read-only manager")
+	}
+
+	if items, err := m.List(nil); err != nil || len(items) != 1 {
+		t.Fatalf("expected list to work, items=%v err=%v", items, err)
+	}
+	result, err := m.Use(ToolCodex, "work", "")
+	if err != nil {
+		t.Fatalf("use on read-only root: %v", err)
+	}
+	if !strings.Contains(result.Warning, "read-only") {
+		t.Fatalf("expected read-only warning, got %q", result.Warning)
+	}
+	if got, _ := os.ReadFile(filepath.Join(home, ".codex", "auth.json")); string(got) != string(raw) {
+		t.Fatalf("expected runtime target written, got %s", got)
+	}
+	if stateAfter, _ := os.ReadFile(filepath.Join(root, "state.json")); string(stateAfter) != string(stateBefore) {
+		t.Fatalf("expected state.json untouched")
+	}
+	if items, err := m.Active(nil); err != nil || len(items) == 0 {
+		t.Fatalf("expected active to work, items=%v err=%v", items, err)
+	}
+
+	if _, err := m.UseWithOptions(ToolCodex, "work", UseOptions{Backup: true}); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected --backup refused on read-only root, got %v", err)
+	}
+	if err := m.SetNote(ToolCodex, "work", "x"); !errors.Is(err, ErrIO) || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("expected state write refused, got %v", err)
+	}
+
+	probeDir = func(string) error { return errors.New("unexpected") }
+	m, err = NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if m.ReadOnly() {
+		t.Fatalf("expected non-permission probe errors to leave root writable")
+	}
+}
+
+func TestManagerLink(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	external := filepath.Join(t.TempDir(), "legacy.json")
+	raw := makeCodexAuthJSON(t, time.Now().Add(2*time.Hour))
+	writeFile(t, external, raw)
+
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	result, err := m.Link(ToolCodex, "legacy", external)
+	if err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+	if result.SnapshotPath != external {
+		t.Fatalf("expected snapshot path %s, got %s", external, result.SnapshotPath)
+	}
+	if _, err := os.Stat(m.snapshotPath(ToolCodex, "legacy")); !os.IsNotExist(err) {
+		t.Fatalf("expected no copy under the data root, err=%v", err)
+	}
+
+	items, err := m.List(nil)
+	if err != nil || len(items) != 1 || !items[0].Linked || items[0].AuthInsight.Status != "valid" {
+		t.Fatalf("expected linked profile in list, items=%+v err=%v", items, err)
+	}
+	if _, err := m.Use(ToolCodex, "legacy", ""); err != nil {
+		t.Fatalf("use linked profile: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(home, ".codex", "auth.json")); string(got) != string(raw) {
+		t.Fatalf("expected linked snapshot applied, got %s", got)
+	}
+
+	if _, err := m.Link(ToolCodex, "legacy", external); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected already exists, got %v", err)
+	}
+
+	deleted, err := m.Delete(ToolCodex, "legacy")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if !deleted.SnapshotLinked || deleted.SnapshotDeleted {
+		t.Fatalf("expected linked snapshot kept, got %+v", deleted)
+	}
+	if _, err := os.Stat(external); err != nil {
+		t.Fatalf("expected external file kept: %v", err)
+	}
+}
+
+func TestManagerLinkValidation(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	notObject := filepath.Join(dir, "array.json")
+	writeFile(t, notObject, []byte(`[1,2]`))
+
+	for _, tc := range []struct {
+		name string
+		path string
+		want error
+	}{
+		{"missing", filepath.Join(dir, "missing.json"), ErrProfileNotFound},
+		{"directory", dir, ErrInvalidInput},
+		{"not object", notObject, ErrInvalidInput},
+		{"empty", " ", ErrInvalidInput},
+	} {
+		if _, err := m.Link(ToolCodex, "x", tc.path); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+	if _, err := m.Link(ToolCodex, "bad/label", notObject); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid label, got %v", err)
+	}
+}
+
+func TestManagerInspect(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	saveTestSnapshot(t, m, ToolCodex, "work", makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_1", "a@example.com", "pro"))
+
+	item, err := m.Inspect(ToolCodex, "work")
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if item.Label != "work" || item.AuthInsight.AccountEmail != "a@example.com" || item.AuthInsight.Status != "valid" {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	if len(item.Tokens) != 2 || !strings.HasPrefix(item.Tokens[0], "access_token format=jwt") || !strings.HasPrefix(item.Tokens[1], "id_token format=jwt") {
+		t.Fatalf("unexpected token summaries: %v", item.Tokens)
+	}
+
+	if _, err := m.Inspect(ToolCodex, "missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := m.Inspect(Tool("bad"), "work"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool, got %v", err)
+	}
+	if err := os.Remove(m.snapshotPath(ToolCodex, "work")); err != nil {
+		t.Fatalf("remove snapshot: %v", err)
+	}
+	if _, err := m.Inspect(ToolCodex, "work"); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected IO error for missing snapshot file, got %v", err)
+	}
+}
+
+func TestManagerListToolsValidates(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if _, err := m.ListTools([]Tool{ToolCodex, Tool("bad")}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool, got %v", err)
+	}
+}
+
+func TestManagerEachListItemStreams(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	for _, label := range []string{"b", "a"} {
+		if _, err := m.Save(ToolCodex, label, source); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+
+	// Removing b's snapshot while handling a shows b is read only after a
+	// has been handed over.
+	var seen []string
+	err = m.EachListItem(nil, func(item ListItem) error {
+		seen = append(seen, item.Label+"="+item.AuthInsight.Status)
+		if item.Label == "a" {
+			return os.Remove(filepath.Join(root, "snapshots", "codex", "b.json"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachListItem: %v", err)
+	}
+	if strings.Join(seen, ",") != "a=valid,b=unknown" {
+		t.Fatalf("expected items in order, read one at a time, got %v", seen)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	if err := m.EachListItem(nil, func(ListItem) error { calls++; return stop }); !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("expected EachListItem to stop at the first error, got %v after %d call(s)", err, calls)
+	}
+	if err := m.EachListItem([]Tool{Tool("bad")}, func(ListItem) error { return nil }); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool, got %v", err)
+	}
+}
+
+func TestManagerStagedSaveRollback(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	first := filepath.Join(root, "first.json")
+	writeFile(t, first, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	if _, err := m.Save(ToolCodex, "work", first); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	snapshot := filepath.Join(root, "snapshots", "codex", "work.json")
+	before, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := filepath.Join(root, "second.json")
+	writeFile(t, second, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	for _, label := range []string{"work", "fresh"} {
+		staged, err := m.stageSave(ToolCodex, label, SaveOptions{SourceOverride: second})
+		if err != nil {
+			t.Fatalf("stage %s: %v", label, err)
+		}
+		if err := staged.rollback(); err != nil {
+			t.Fatalf("rollback %s: %v", label, err)
+		}
+	}
+	if after, err := os.ReadFile(snapshot); err != nil || !bytes.Equal(after, before) {
+		t.Fatalf("expected rollback to restore the overwritten snapshot, err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshots", "codex", "fresh.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected rollback to remove a new snapshot, got %v", err)
+	}
+}
+
+func TestNewManagerRemovesStaleTempFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	snapshotDir := filepath.Join(root, "snapshots", "codex")
+	old := time.Now().Add(-time.Hour)
+
+	plant := func(path string, mtime time.Time) {
+		t.Helper()
+		writeFile(t, path, []byte("{}"))
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	staleRoot := filepath.Join(root, ".ags-123")
+	staleSnapshot := filepath.Join(snapshotDir, ".ags-456")
+	fresh := filepath.Join(snapshotDir, ".ags-789")
+	realSnapshot := filepath.Join(snapshotDir, "work.json")
+	hidden := filepath.Join(snapshotDir, ".agsnot-temp")
+	staleFlat := filepath.Join(root, "snapshots", ".ags-321")
+	staleCompanion := filepath.Join(snapshotDir, "companions", "work", ".ags-654")
+	plant(staleRoot, old)
+	plant(staleSnapshot, old)
+	plant(staleFlat, old)
+	plant(staleCompanion, old)
+	plant(fresh, time.Now())
+	plant(realSnapshot, old)
+	plant(hidden, old)
+
+	if _, err := NewManager(root); err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	for _, path := range []string{staleRoot, staleSnapshot, staleFlat, staleCompanion} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected stale temp %s removed, err=%v", path, err)
+		}
+	}
+	for _, path := range []string{fresh, realSnapshot, hidden} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s kept: %v", path, err)
+		}
+	}
+}
+
+func TestManagerGC(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	source := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	for _, label := range []string{"work", "gone"} {
+		if _, err := m.Save(ToolCodex, label, source); err != nil {
+			t.Fatalf("save %s: %v", label, err)
+		}
+	}
+	orphan := filepath.Join(root, "snapshots", "pi", "stray.json")
+	writeFile(t, orphan, []byte(`{}`))
+	notJSON := filepath.Join(root, "snapshots", "codex", "notes.txt")
+	writeFile(t, notJSON, []byte("keep"))
+	if err := os.Remove(m.snapshotPath(ToolCodex, "gone")); err != nil {
+		t.Fatalf("remove snapshot: %v", err)
+	}
+
+	result, err := m.GC(true)
+	if err != nil {
+		t.Fatalf("GC dry run: %v", err)
+	}
+	if !result.DryRun || len(result.OrphanedSnapshots) != 1 || result.OrphanedSnapshots[0] != orphan {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if len(result.MissingSnapshots) != 1 || result.MissingSnapshots[0].Label != "gone" || result.MissingSnapshots[0].Tool != ToolCodex {
+		t.Fatalf("expected missing snapshot reported, got %+v", result.MissingSnapshots)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("dry run removed orphan: %v", err)
+	}
+
+	if _, err := m.GC(false); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if _, err := os.Stat(orphan); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected orphan removed, got %v", err)
+	}
+	for _, keep := range []string{m.snapshotPath(ToolCodex, "work"), notJSON} {
+		if _, err := os.Stat(keep); err != nil {
+			t.Fatalf("expected %s kept: %v", keep, err)
+		}
+	}
+
+	m.readOnly = true
+	if _, err := m.GC(false); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected read-only gc to fail with ErrIO, got %v", err)
+	}
+	if _, err := m.GC(true); err != nil {
+		t.Fatalf("expected read-only dry run to succeed, got %v", err)
+	}
+}
+
+func TestManagerSaveReportsCrossToolAccountConflicts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	exp := time.Now().Add(time.Hour)
+	piAuth := func(email string) []byte {
+		access := makeJWT(t, map[string]any{"exp": exp.Unix(), "iss": "https://auth.openai.com", "email": email})
+		return []byte(`{"openai-codex":{"access":"` + access + `","expires":` + strconv.FormatInt(exp.UnixMilli(), 10) + `}}`)
+	}
+
+	piSource := filepath.Join(t.TempDir(), "pi.json")
+	writeFile(t, piSource, piAuth("person@b.com"))
+	if _, err := m.Save(ToolPi, "work", piSource); err != nil {
+		t.Fatalf("save pi: %v", err)
+	}
+
+	codexSource := filepath.Join(t.TempDir(), "codex.json")
+	writeFile(t, codexSource, makeCodexAuthJSONWithIdentity(t, exp, "acct_a", "person@a.com", ""))
+	result, err := m.Save(ToolCodex, "work", codexSource)
+	if err != nil {
+		t.Fatalf("save codex: %v", err)
+	}
+	if len(result.AccountConflicts) != 1 || result.AccountConflicts[0] != (AccountConflict{Tool: ToolPi, Account: "person@b.com"}) {
+		t.Fatalf("expected pi conflict, got %+v", result.AccountConflicts)
+	}
+
+	writeFile(t, piSource, piAuth("Person@A.com"))
+	if _, err := m.Save(ToolPi, "work", piSource); err != nil {
+		t.Fatalf("re-save pi: %v", err)
+	}
+	result, err = m.Save(ToolCodex, "work", codexSource)
+	if err != nil {
+		t.Fatalf("re-save codex: %v", err)
+	}
+	if len(result.AccountConflicts) != 0 {
+		t.Fatalf("expected no conflict for matching email, got %+v", result.AccountConflicts)
+	}
+
+	if !sameAccount(AuthInsight{AccountID: "a"}, AuthInsight{AccountEmail: "x@y.z"}) {
+		t.Fatalf("expected unknown comparisons to count as the same account")
+	}
+	if sameAccount(AuthInsight{AccountID: "a"}, AuthInsight{AccountID: "b"}) {
+		t.Fatalf("expected different account ids to differ")
+	}
+}
+
+func TestManagerUseDetectsModifiedSnapshot(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	source := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+	if _, err := m.Save(ToolCodex, "work", source); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	result, err := m.Use(ToolCodex, "work", "")
+	if err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	if result.SnapshotModified {
+		t.Fatalf("expected untouched snapshot to verify")
+	}
+
+	edited := makeCodexAuthJSON(t, time.Now().Add(2*time.Hour))
+	writeFile(t, m.snapshotPath(ToolCodex, "work"), edited)
+	runtime := filepath.Join(home, ".codex", "auth.json")
+	before, err := os.ReadFile(runtime)
+	if err != nil {
+		t.Fatalf("read runtime: %v", err)
+	}
+	if _, err := m.UseWithOptions(ToolCodex, "work", UseOptions{Strict: true}); !errors.Is(err, ErrIO) {
+		t.Fatalf("expected --strict to refuse modified snapshot, got %v", err)
+	}
+	after, err := os.ReadFile(runtime)
+	if err != nil {
+		t.Fatalf("read runtime: %v", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatalf("expected runtime untouched after strict refusal")
+	}
+
+	result, err = m.UseWithOptions(ToolCodex, "work", UseOptions{})
+	if err != nil {
+		t.Fatalf("use modified: %v", err)
+	}
+	if !result.SnapshotModified {
+		t.Fatalf("expected modified snapshot to be reported")
+	}
+
+	if snapshotModified(StateEntry{Linked: true, SHA256: "x"}, edited) || snapshotModified(StateEntry{}, edited) {
+		t.Fatalf("expected linked and hash-less entries to be skipped")
+	}
+}
+
+func TestManagerCompressedSnapshots(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"compress_snapshots": true}`))
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	source := filepath.Join(t.TempDir(), "auth.json")
+	authRaw := makeCodexAuthJSON(t, time.Now().Add(time.Hour))
+	writeFile(t, source, authRaw)
+
+	zipped, err := m.Save(ToolCodex, "zipped", source)
+	if err != nil {
+		t.Fatalf("save zipped: %v", err)
+	}
+	if !strings.HasSuffix(zipped.SnapshotPath, ".json.gz") {
+		t.Fatalf("expected compressed snapshot path, got %s", zipped.SnapshotPath)
+	}
+	onDisk, err := os.ReadFile(zipped.SnapshotPath)
+	if err != nil || !bytes.HasPrefix(onDisk, gzipMagic) {
+		t.Fatalf("expected gzip data on disk, err=%v", err)
+	}
+	if zipped.Insight.Status != "valid" {
+		t.Fatalf("expected insight from decompressed JSON, got %+v", zipped.Insight)
+	}
+
+	plainOpt := false
+	plain, err := m.SaveWithOptions(ToolCodex, "plain", SaveOptions{SourceOverride: source, Compress: &plainOpt})
+	if err != nil {
+		t.Fatalf("save plain: %v", err)
+	}
+	if plain.SnapshotPath != m.snapshotPath(ToolCodex, "plain") {
+		t.Fatalf("expected plain snapshot path, got %s", plain.SnapshotPath)
+	}
+
+	items, err := m.List(nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, item := range items {
+		if item.AuthInsight.Status != "valid" {
+			t.Fatalf("expected both snapshots readable, got %+v", item)
+		}
+	}
+	if _, err := m.Inspect(ToolCodex, "zipped"); err != nil {
+		t.Fatalf("Inspect zipped: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "runtime.json")
+	res, err := m.UseWithOptions(ToolCodex, "zipped", UseOptions{TargetOverride: target, Strict: true})
+	if err != nil {
+		t.Fatalf("use zipped: %v", err)
+	}
+	if res.SnapshotModified {
+		t.Fatalf("hash should cover the uncompressed snapshot")
+	}
+	written, err := os.ReadFile(target)
+	if err != nil || !bytes.Equal(written, authRaw) {
+		t.Fatalf("expected decompressed auth written to target, err=%v", err)
+	}
+
+	// Re-saving uncompressed replaces the .gz file rather than orphaning it.
+	if _, err := m.SaveWithOptions(ToolCodex, "zipped", SaveOptions{SourceOverride: source, Compress: &plainOpt}); err != nil {
+		t.Fatalf("re-save plain: %v", err)
+	}
+	if _, err := os.Stat(zipped.SnapshotPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected old compressed snapshot removed, err=%v", err)
+	}
+	gc, err := m.GC(true)
+	if err != nil || len(gc.OrphanedSnapshots) != 0 {
+		t.Fatalf("expected no orphans, got %+v err=%v", gc, err)
+	}
+}
+
+func TestManagerHashIgnoresCompression(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	source := filepath.Join(t.TempDir(), "auth.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	plainOpt, gzipOpt := false, true
+	plain, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: source, Compress: &plainOpt})
+	if err != nil {
+		t.Fatalf("save plain: %v", err)
+	}
+	zipped, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: source, Compress: &gzipOpt})
+	if err != nil {
+		t.Fatalf("re-save compressed: %v", err)
+	}
+	if zipped.ChangedSinceLastSave {
+		t.Fatalf("compressing an unchanged snapshot should not count as a change")
+	}
+	if zipped.SnapshotPath == plain.SnapshotPath {
+		t.Fatalf("expected a .gz snapshot path, got %s", zipped.SnapshotPath)
+	}
+
+	// The runtime file is plaintext; active must still match the .gz snapshot.
+	if _, err := m.Use(ToolCodex, "work", ""); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	codex := ToolCodex
+	items, err := m.Active(&codex)
+	if err != nil {
+		t.Fatalf("Active: %v", err)
+	}
+	if len(items) != 1 || items[0].Status != "match" || items[0].ActiveLabel != "work" {
+		t.Fatalf("expected exact match against compressed snapshot, got %+v", items)
+	}
+
+	back, err := m.SaveWithOptions(ToolCodex, "work", SaveOptions{SourceOverride: source, Compress: &plainOpt})
+	if err != nil {
+		t.Fatalf("re-save plain: %v", err)
+	}
+	if back.ChangedSinceLastSave {
+		t.Fatalf("decompressing an unchanged snapshot should not count as a change")
+	}
+}
+
+func TestManagerMove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	piSource := filepath.Join(t.TempDir(), "pi.json")
+	writeFile(t, piSource, []byte(`{"anthropic":{"type":"oauth","access":"a","expires":9999999999999}}`))
+	codexSource := filepath.Join(t.TempDir(), "codex.json")
+	writeFile(t, codexSource, makeCodexAuthJSON(t, time.Now().Add(time.Hour)))
+
+	// A pi auth file saved under codex by mistake.
+	if _, err := m.Save(ToolCodex, "work", piSource); err != nil {
+		t.Fatalf("save mistagged: %v", err)
+	}
+	if _, err := m.AddAlias("w", ToolCodex, "work"); err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	if err := m.SetDefault(ToolCodex, "work"); err != nil {
+		t.Fatalf("SetDefault: %v", err)
+	}
+	if _, err := m.Save(ToolCodex, "real", codexSource); err != nil {
+		t.Fatalf("save codex: %v", err)
+	}
+
+	if _, err := m.Move(ToolCodex, "real", ToolPi); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected format mismatch error, got %v", err)
+	}
+	if _, err := m.Move(ToolCodex, "work", ToolCodex); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected same-tool error, got %v", err)
+	}
+	if _, err := m.Move(ToolCodex, "missing", ToolPi); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if _, err := m.Move(ToolCodex, "work", Tool("bogus")); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid tool error, got %v", err)
+	}
+
+	res, err := m.Move(ToolCodex, "work", ToolPi)
+	if err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if res.SnapshotPath != m.snapshotPath(ToolPi, "work") || res.Insight.Status != "valid" {
+		t.Fatalf("unexpected move result: %+v", res)
+	}
+	if _, err := os.Stat(m.snapshotPath(ToolCodex, "work")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected old snapshot removed, err=%v", err)
+	}
+	state, err := m.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	entry, ok := state.Entries[stateKey(ToolPi, "work")]
+	if !ok || entry.Tool != "pi" {
+		t.Fatalf("expected pi entry, got %+v", state.Entries)
+	}
+	if _, ok := state.Entries[stateKey(ToolCodex, "work")]; ok {
+		t.Fatalf("expected codex entry removed")
+	}
+	if state.Aliases["w"].Tool != "pi" {
+		t.Fatalf("expected alias to follow the profile, got %+v", state.Aliases["w"])
+	}
+	if _, ok := state.Defaults["codex"]; ok {
+		t.Fatalf("expected codex default cleared")
+	}
+
+	if _, err := m.Save(ToolCodex, "work", piSource); err != nil {
+		t.Fatalf("re-save: %v", err)
+	}
+	if _, err := m.Move(ToolCodex, "work", ToolPi); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("expected already exists, got %v", err)
+	}
+}
+
+func TestManagerHistoryCapAndLegacyEntries(t *testing.T) {
+	var history []HistoryEvent
+	for i := 0; i < maxHistoryEvents+5; i++ {
+		history = appendHistory(history, "used", fmt.Sprintf("2026-01-01T00:00:%02dZ", i%60), "h")
+	}
+	if len(history) != maxHistoryEvents || history[0].At != "2026-01-01T00:00:05Z" {
+		t.Fatalf("expected oldest events dropped, got %d starting %s", len(history), history[0].At)
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "state.json"), []byte(`{"version":1,"entries":{"codex:old":{"tool":"codex","label":"old","snapshot_path":"/x","sha256":"aa","saved_at":"2026-01-01T00:00:00Z","last_used_at":"2026-01-02T00:00:00Z","last_used_sha256":"bb"}}}`))
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	events, err := m.History(ToolCodex, "old")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != "used" || events[0].SHA256 != "bb" || events[1].Action != "saved" {
+		t.Fatalf("unexpected legacy history: %+v", events)
+	}
+}
+
+func TestAccountLabelSlug(t *testing.T) {
+	for email, want := range map[string]string{
+		"jane.doe@example.com":    "jane-doe",
+		"Jane_Doe+CI@example.com": "jane-doe-ci",
+		"--x..y--@example.com":    "x-y",
+		"bob":                     "bob",
+		"@example.com":            "",
+		"":                        "",
+	} {
+		if got := accountLabelSlug(email); got != want {
+			t.Fatalf("accountLabelSlug(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
+
+func BenchmarkManagerList(b *testing.B) {
+	b.Setenv("HOME", b.TempDir())
+	m, err := NewManager(b.TempDir())
+	if err != nil {
+		b.Fatalf("NewManager: %v", err)
+	}
+	src := filepath.Join(b.TempDir(), "codex.json")
+	for i := 0; i < 50; i++ {
+		raw := makeCodexAuthJSONWithIdentity(b, time.Now().Add(time.Duration(i)*time.Minute), fmt.Sprintf("acct-%d", i), fmt.Sprintf("user%d@example.com", i), "plus")
+		if err := os.WriteFile(src, raw, 0o600); err != nil {
+			b.Fatalf("write source: %v", err)
+		}
+		if _, err := m.Save(ToolCodex, fmt.Sprintf("profile-%02d", i), src); err != nil {
+			b.Fatalf("save %d: %v", i, err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		items, err := m.List(nil)
+		if err != nil {
+			b.Fatalf("List: %v", err)
+		}
+		if len(items) != 50 {
+			b.Fatalf("expected 50 items, got %d", len(items))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ags
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// operationLogEntry is one line of the --log-file audit log. It carries
+// identifiers and outcomes only, never token values.
+type operationLogEntry struct {
+	Time      string `json:"time"`
+	Op        string `json:"op"`
+	Tool      string `json:"tool"`
+	Label     string `json:"label"`
+	AccountID string `json:"account_id,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+}
+
+// logOperation appends the outcome of a mutating command to the operation
+// log of manager, from --log-file or log_file in config.json. Logging is best
+// effort: a failed write is reported on stderr and never fails the command.
+func logOperation(stderr io.Writer, manager *Manager, op string, tool Tool, label string, accountID string, opErr error) {
+	if manager == nil || manager.LogFile() == "" {
+		return
+	}
+	path := manager.LogFile()
+
+	entry := operationLogEntry{
+		Time:      nowISO(),
+		Op:        op,
+		Tool:      tool.String(),
+		Label:     label,
+		AccountID: accountID,
+		Outcome:   "ok",
+	}
+	if opErr != nil {
+		entry.Outcome = "error"
+		entry.Error = opErr.Error()
+	}
+	if err := appendOperationLog(path, entry); err != nil {
+		fmt.Fprintf(stderr, "Warning: could not write operation log %s: %v\n", path, err)
+	}
+}
+
+func appendOperationLog(path string, entry operationLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
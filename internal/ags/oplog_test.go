@@ -0,0 +1,115 @@
+package ags
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readOperationLog(t *testing.T, path string) []operationLogEntry {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	var entries []operationLogEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		var entry operationLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRunLogFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	authRaw := makeCodexAuthJSONWithIdentity(t, time.Now().Add(2*time.Hour), "acct_log", "log@example.com", "plus")
+	writeFile(t, source, authRaw)
+	logPath := filepath.Join(t.TempDir(), "ops.log")
+	target := filepath.Join(t.TempDir(), "auth.json")
+
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{"--log-file", logPath, "save", "codex", "work", "--source", source},
+		{"use", "codex", "work", "--target", target, "--log-file=" + logPath},
+		{"delete", "codex", "work", "--yes", "--log-file", logPath},
+	} {
+		if err := Run(append(args, "--root", root), nil, &out, &out); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	if err := Run([]string{"use", "codex", "work", "--target", target, "--root", root, "--log-file", logPath}, nil, &out, &out); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	// --log-file applies to its own Run only.
+	if err := Run([]string{"save", "codex", "other", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save without --log-file: %v", err)
+	}
+
+	entries := readOperationLog(t, logPath)
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 log lines, got %+v", entries)
+	}
+	for i, op := range []string{"save", "use", "delete"} {
+		got := entries[i]
+		if got.Op != op || got.Tool != "codex" || got.Label != "work" || got.AccountID != "acct_log" || got.Outcome != "ok" || got.Time == "" {
+			t.Fatalf("unexpected %s entry: %+v", op, got)
+		}
+	}
+	if entries[3].Outcome != "error" || entries[3].Error == "" || entries[3].AccountID != "" {
+		t.Fatalf("unexpected failure entry: %+v", entries[3])
+	}
+
+	raw, _ := os.ReadFile(logPath)
+	var auth struct {
+		Tokens map[string]string `json:"tokens"`
+	}
+	if err := json.Unmarshal(authRaw, &auth); err != nil {
+		t.Fatalf("parse auth: %v", err)
+	}
+	for _, token := range auth.Tokens {
+		if len(token) > 8 && strings.Contains(string(raw), token) {
+			t.Fatalf("operation log leaked a token value")
+		}
+	}
+}
+
+func TestRunLogFileFromConfigAndWriteFailure(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	source := filepath.Join(root, "source.json")
+	writeFile(t, source, makeCodexAuthJSON(t, time.Now().Add(2*time.Hour)))
+	logPath := filepath.Join(t.TempDir(), "config-ops.log")
+	writeFile(t, filepath.Join(root, "config.json"), []byte(`{"log_file": "`+logPath+`"}`))
+
+	var out bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if entries := readOperationLog(t, logPath); len(entries) != 1 || entries[0].Op != "save" {
+		t.Fatalf("expected config log_file used, got %+v", entries)
+	}
+
+	// An unwritable log warns but leaves the command successful.
+	badLog := filepath.Join(t.TempDir(), "missing-dir", "ops.log")
+	var stdout, stderr bytes.Buffer
+	if err := Run([]string{"save", "codex", "work", "--source", source, "--root", root, "--log-file", badLog}, nil, &stdout, &stderr); err != nil {
+		t.Fatalf("save with bad log: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: could not write operation log") {
+		t.Fatalf("expected log warning, got %q", stderr.String())
+	}
+
+	if err := Run([]string{"list", "--log-file"}, nil, &out, &out); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for missing --log-file value, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package ags
+
+// listItemJSONSchema is the JSON Schema of one ags list --jsonl line, the
+// shape of listItemJSON. It is printed by ags list --schema; a test checks
+// real output against it, so change both together.
+const listItemJSONSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "ags list --jsonl item",
+  "description": "One saved profile, as printed on each line of ags list --jsonl.",
+  "type": "object",
+  "required": ["tool", "label", "status", "needs_refresh", "saved_at", "snapshot"],
+  "additionalProperties": false,
+  "properties": {
+    "tool": {"type": "string", "enum": ["codex", "pi"]},
+    "label": {"type": "string", "description": "Matches [a-zA-Z0-9._-]+, or the label_pattern set in config.json"},
+    "status": {"type": "string", "enum": ["valid", "expiring_soon", "expired", "unknown"]},
+    "needs_refresh": {"type": "string", "enum": ["yes", "no", "unknown"]},
+    "expires_at": {"type": "string", "description": "RFC 3339 token expiry"},
+    "last_refresh": {"type": "string", "description": "RFC 3339 time of the last token refresh"},
+    "saved_at": {"type": "string", "description": "RFC 3339 time the snapshot was saved"},
+    "last_used_at": {"type": "string", "description": "RFC 3339 time of the last ags use or ags touch"},
+    "account_email": {"type": "string"},
+    "account_plan": {"type": "string"},
+    "account_id": {"type": "string"},
+    "issuer": {"type": "string"},
+    "subject": {"type": "string"},
+    "audience": {"type": "string"},
+    "note": {"type": "string"},
+    "tags": {"type": "array", "items": {"type": "string"}},
+    "snapshot": {"type": "string", "description": "Path of the snapshot file"},
+    "linked": {"type": "boolean"},
+    "details": {"type": "array", "items": {"type": "string"}},
+    "tokens": {"type": "array", "items": {"type": "string"}},
+    "providers": {
+      "type": "array",
+      "description": "pi only: per-provider expiry, worst status first",
+      "items": {
+        "type": "object",
+        "required": ["name", "status", "expires_at"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string"},
+          "status": {"type": "string", "enum": ["valid", "expiring_soon", "expired", "unknown"]},
+          "expires_at": {"type": "string"}
+        }
+      }
+    },
+    "identity_cached_at": {"type": "string", "description": "Set when the email or plan came from the identity cache"},
+    "stale_identity": {"type": "boolean"}
+  }
+}
+`
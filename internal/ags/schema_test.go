@@ -0,0 +1,180 @@
+package ags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validateAgainstSchema checks value against the subset of JSON Schema that
+// listItemJSONSchema uses: type, enum, pattern, required, properties,
+// additionalProperties false, and items.
+func validateAgainstSchema(schema map[string]any, value any, path string) error {
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, value)
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required %q", path, name)
+			}
+		}
+		for key, field := range obj {
+			propSchema, ok := properties[key].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateAgainstSchema(propSchema, field, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, value)
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			if err := validateAgainstSchema(itemSchema, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, value)
+		}
+		if enum, ok := schema["enum"].([]any); ok {
+			found := false
+			for _, candidate := range enum {
+				found = found || candidate == s
+			}
+			if !found {
+				return fmt.Errorf("%s: %q is not one of %v", path, s, enum)
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			return fmt.Errorf("%s: %q does not match %s", path, s, pattern)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", path, value)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported schema type %v", path, schema["type"])
+	}
+	return nil
+}
+
+func loadListItemSchema(t *testing.T) map[string]any {
+	t.Helper()
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(listItemJSONSchema), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	return schema
+}
+
+// TestListItemJSONSchemaMatchesStruct pins the schema to listItemJSON: every
+// field has a property, and exactly the fields without omitempty are
+// required.
+func TestListItemJSONSchemaMatchesStruct(t *testing.T) {
+	schema := loadListItemSchema(t)
+	properties := schema["properties"].(map[string]any)
+
+	var fields, required []string
+	typ := reflect.TypeOf(listItemJSON{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, opts, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+		if opts != "omitempty" {
+			required = append(required, name)
+		}
+	}
+
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(fields)
+	sort.Strings(names)
+	if !reflect.DeepEqual(fields, names) {
+		t.Fatalf("schema properties %v do not match listItemJSON fields %v", names, fields)
+	}
+
+	var schemaRequired []string
+	for _, name := range schema["required"].([]any) {
+		schemaRequired = append(schemaRequired, name.(string))
+	}
+	sort.Strings(required)
+	sort.Strings(schemaRequired)
+	if !reflect.DeepEqual(required, schemaRequired) {
+		t.Fatalf("schema required %v does not match non-omitempty fields %v", schemaRequired, required)
+	}
+}
+
+func TestRunListJSONLMatchesSchema(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	codex := filepath.Join(root, "codex.json")
+	writeFile(t, codex, makeCodexAuthJSONWithIdentity(t, time.Now().Add(time.Hour), "acct-1", "one@example.com", "plus"))
+	pi := filepath.Join(root, "pi.json")
+	writeFile(t, pi, []byte(fmt.Sprintf(`{"anthropic":{"type":"oauth","access":"a","expires":%d},"openai-codex":{"type":"oauth","access":"c"}}`, time.Now().Add(-time.Hour).UnixMilli())))
+	unknown := filepath.Join(root, "unknown.json")
+	writeFile(t, unknown, []byte(`{"tokens":{}}`))
+	for _, args := range [][]string{
+		{"save", "codex", "work", "--source", codex, "--tag", "prod", "--note", "main account"},
+		{"save", "codex", "blank", "--source", unknown},
+		{"save", "pi", "home", "--source", pi},
+		{"link", "codex", "shared", "--snapshot", codex},
+	} {
+		if err := Run(append(args, "--root", root), nil, io.Discard, io.Discard); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Run([]string{"list", "--jsonl", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("list --jsonl: %v", err)
+	}
+	schema := loadListItemSchema(t)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %q", out.String())
+	}
+	for _, line := range lines {
+		var value any
+		if err := json.Unmarshal([]byte(line), &value); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		if err := validateAgainstSchema(schema, value, "item"); err != nil {
+			t.Fatalf("%v\nline: %s", err, line)
+		}
+	}
+
+	out.Reset()
+	if err := Run([]string{"list", "--schema", "--root", root}, nil, &out, io.Discard); err != nil {
+		t.Fatalf("list --schema: %v", err)
+	}
+	if out.String() != listItemJSONSchema {
+		t.Fatalf("expected the schema, got %q", out.String())
+	}
+	if err := Run([]string{"list", "--schema", "--plain", "--root", root}, nil, io.Discard, io.Discard); err == nil {
+		t.Fatalf("expected --schema with --plain to be rejected")
+	}
+}
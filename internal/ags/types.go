@@ -1,6 +1,10 @@
 package ags
 
-import "time"
+import (
+	"io"
+	"os"
+	"time"
+)
 
 type Tool string
 
@@ -30,9 +34,88 @@ type AuthInsight struct {
 	AccountEmail string
 	AccountPlan  string
 	AccountID    string
-	Details      []string
+	// Issuer, Subject, and Audience come from the codex access token, falling
+	// back to the id token. They stay empty for pi.
+	Issuer   string
+	Subject  string
+	Audience string
+	Details  []string
+	// Providers holds per-provider expiry for pi snapshots, worst status
+	// first. It is nil for codex.
+	Providers []ProviderInsight
+	// IdentityCachedAt is the UpdatedAt of the identity cache entry that
+	// supplied AccountEmail/AccountPlan, or empty when the token itself did.
+	IdentityCachedAt string
+}
+
+// ProviderInsight is the expiry state of one provider in a pi auth file.
+type ProviderInsight struct {
+	Name      string
+	Status    string
+	ExpiresAt string
+}
+
+// PIProviderItem is one provider key in a saved pi snapshot, with the
+// selector aliases that would pick it for --provider.
+type PIProviderItem struct {
+	Key       string
+	Status    string
+	ExpiresAt string
+	Selectors []string
 }
 
+// stdinSourcePath is the SourcePath recorded for snapshots read from stdin.
+const stdinSourcePath = "<stdin>"
+
+type SaveOptions struct {
+	SourceOverride string
+	PIProvider     string
+	// Stdin is read when SourceOverride is "-".
+	Stdin io.Reader
+	// Note replaces the profile note when non-nil; nil keeps the existing one.
+	Note *string
+	// FollowSymlinks allows the source path to be a symlink.
+	FollowSymlinks bool
+	// FromActive reads only the tool's runtime auth file, failing when it is
+	// missing instead of trying other candidates.
+	FromActive bool
+	// Compress gzips the snapshot when non-nil and true; nil follows the
+	// compress_snapshots config setting.
+	Compress *bool
+	// Tags are added to the profile's existing tags.
+	Tags []string
+	// StrictJSON rejects a source with invalid UTF-8, duplicate keys, or
+	// trailing content; see validateStrictJSONObject.
+	StrictJSON bool
+	// LabelFromAccount ignores the label argument and derives one from the
+	// source's account email; see Manager.labelFromAccount.
+	LabelFromAccount bool
+	// ConfirmOverwrite, when set, is asked before an existing snapshot is
+	// replaced with different content. Returning false leaves everything as
+	// it was and sets SaveResult.Declined.
+	ConfirmOverwrite func(label string, previous AuthInsight, next AuthInsight) bool
+	// ExpectTool controls the check that the source is shaped like the
+	// tool's auth file: ExpectToolWarn (the default when empty) reports a
+	// mismatch in SaveResult.DetectedTool, ExpectToolStrict refuses the save,
+	// and ExpectToolOff skips the check.
+	ExpectTool string
+	// VerifyExpiryFuture refuses a source whose token has already expired;
+	// for pi, one whose providers have all expired.
+	VerifyExpiryFuture bool
+	// FromURL fetches the source from an http(s) or unix socket URL instead
+	// of a file; see fetchSource. Only loopback hosts are allowed unless
+	// AllowRemote is set.
+	FromURL     string
+	AllowRemote bool
+}
+
+// Values for SaveOptions.ExpectTool.
+const (
+	ExpectToolWarn   = "warn"
+	ExpectToolStrict = "strict"
+	ExpectToolOff    = "off"
+)
+
 type SaveResult struct {
 	Tool                 Tool
 	Label                string
@@ -40,14 +123,141 @@ type SaveResult struct {
 	SnapshotPath         string
 	ChangedSinceLastSave bool
 	Insight              AuthInsight
+	// DuplicateLabels lists other labels of the same tool whose snapshot is
+	// byte-identical to this one.
+	DuplicateLabels []string
+	// AccountConflicts lists other tools' profiles with the same label that
+	// belong to a different account.
+	AccountConflicts []AccountConflict
+	// Declined is set when ConfirmOverwrite refused the save; nothing was
+	// written.
+	Declined bool
+	// DetectedTool is set when the source looks like another tool's auth
+	// file; see SaveOptions.ExpectTool.
+	DetectedTool Tool
+	// ExpiredProviders lists the pi providers that had already expired when
+	// SaveOptions.VerifyExpiryFuture let a partly expired source through.
+	ExpiredProviders []string
+}
+
+// AccountConflict is a same-label profile of another tool and the account
+// (email, else account id) it belongs to.
+type AccountConflict struct {
+	Tool    Tool
+	Account string
+}
+
+type UseOptions struct {
+	TargetOverride string
+	PIProvider     string
+	Backup         bool
+	// NoMerge writes the pi snapshot verbatim instead of merging it into the
+	// existing runtime file. Codex always overwrites, so it has no effect there.
+	NoMerge bool
+	// MergeStrategy is how pi provider objects are merged into the runtime
+	// file: PIMergeReplace (the default when empty) or PIMergeDeep.
+	MergeStrategy string
+	// FollowSymlinks allows the runtime target to be a symlink; the write
+	// goes to the file it points at.
+	FollowSymlinks bool
+	// Strict refuses to apply a snapshot whose bytes no longer match the
+	// hash recorded when it was saved.
+	Strict bool
+	// RuntimeCheck re-reads the runtime file after writing it and reports
+	// problems the tool would likely reject in UseResult.RuntimeProblems.
+	RuntimeCheck bool
+	// ExpectEmail, when set, refuses the switch unless the snapshot's account
+	// email (including one from the identity cache) equals it, ignoring case.
+	ExpectEmail string
+	// StrictJSON applies validateStrictJSONObject to the snapshot and, when
+	// merging pi, to the existing runtime file.
+	StrictJSON bool
+	// IfChanged skips the write, hooks, and state update when the runtime
+	// file already holds what use would write (the merged result for pi).
+	IfChanged bool
+	// Mode is the permission mode of the written runtime auth file. Zero uses
+	// runtime_mode from config.json, else defaultRuntimeMode.
+	Mode os.FileMode
+	// BackupRuntimeTo, when set, saves the current runtime auth file under
+	// this label before switching. A failed save aborts the switch.
+	BackupRuntimeTo string
+	// EnvFiles renders and writes the tool's env_files from config.json
+	// after the switch. Template errors abort before anything is written.
+	EnvFiles bool
+	// HookOutput receives the stdout and stderr of pre_use/post_use hooks.
+	// Nil discards it.
+	HookOutput io.Writer
 }
 
+// Pi merge strategies for UseOptions.MergeStrategy.
+const (
+	PIMergeReplace = "replace"
+	PIMergeDeep    = "deep"
+)
+
+// changeSignalUnchanged is UseResult.ChangeSinceLastUse when the applied
+// snapshot is the one applied last time.
+const changeSignalUnchanged = "unchanged since last use"
+
 type UseResult struct {
 	Tool               Tool
 	Label              string
 	TargetPath         string
+	BackupPath         string
 	ChangeSinceLastUse string
 	Insight            AuthInsight
+	// Warning is set when use succeeded but could not record state.
+	Warning string
+	// SnapshotModified is set when the snapshot no longer matched its
+	// recorded hash and was applied anyway.
+	SnapshotModified bool
+	// RuntimeProblems lists reasons the written runtime file may be rejected
+	// by the tool. Only set with UseOptions.RuntimeCheck.
+	RuntimeProblems []string
+	// PostUseHookError is set when the post_use hook failed after the switch.
+	PostUseHookError string
+	// AlreadyActive is set when UseOptions.IfChanged found nothing to write.
+	AlreadyActive bool
+	// TargetMode is the permission mode the runtime auth file was written with.
+	TargetMode os.FileMode
+	// RuntimeBackup is the save made for UseOptions.BackupRuntimeTo.
+	RuntimeBackup *SaveResult
+	// EnvFiles lists the env files written for UseOptions.EnvFiles.
+	EnvFiles []string
+	// EnvFileError is set when writing an env file failed after the switch.
+	EnvFileError string
+}
+
+// ReadSnapshotResult is the snapshot use would write, as read by
+// Manager.ReadSnapshotWithOptions.
+type ReadSnapshotResult struct {
+	Tool  Tool
+	Label string
+	Raw   []byte
+	// SnapshotModified is set when the snapshot no longer matched its
+	// recorded hash and was returned anyway.
+	SnapshotModified bool
+}
+
+// SnapshotPathResult locates the snapshot of one profile.
+type SnapshotPathResult struct {
+	Tool  Tool
+	Label string
+	// RecordedPath is the snapshot path stored in state, empty when the
+	// profile is not saved.
+	RecordedPath string
+	// LayoutPath is where ags save would write the snapshot under the
+	// current snapshot layout.
+	LayoutPath string
+	Linked     bool
+}
+
+type MoveResult struct {
+	From         Tool
+	To           Tool
+	Label        string
+	SnapshotPath string
+	Insight      AuthInsight
 }
 
 type DeleteResult struct {
@@ -55,6 +265,99 @@ type DeleteResult struct {
 	Label           string
 	SnapshotPath    string
 	SnapshotDeleted bool
+	// SnapshotLinked is set when the snapshot was an external linked file,
+	// which is left in place.
+	SnapshotLinked bool
+	// AccountID is the account the deleted snapshot belonged to, if known.
+	AccountID string
+}
+
+type RestoreStateResult struct {
+	BackupPath string
+	StatePath  string
+	Entries    int
+}
+
+// GCResult reports how snapshot files and state entries disagree.
+// OrphanedSnapshots are removed unless DryRun is set; MissingSnapshots are
+// only reported.
+type GCResult struct {
+	DryRun            bool
+	OrphanedSnapshots []string
+	MissingSnapshots  []MissingSnapshot
+}
+
+// MissingSnapshot is a state entry whose snapshot file no longer exists.
+type MissingSnapshot struct {
+	Tool         Tool
+	Label        string
+	SnapshotPath string
+}
+
+// DoctorOptions selects the repairs Manager.Doctor applies; with none set
+// it only reports.
+type DoctorOptions struct {
+	// FixSHA records the on-disk SHA-256 for entries whose snapshot no
+	// longer matches it.
+	FixSHA bool
+	// FixOrphans removes snapshot files no state entry points at.
+	FixOrphans bool
+	// FixMissing removes state entries whose snapshot file is gone.
+	FixMissing bool
+}
+
+type DoctorResult struct {
+	Options           DoctorOptions
+	SHAMismatches     []SHAMismatch
+	OrphanedSnapshots []string
+	MissingSnapshots  []MissingSnapshot
+}
+
+// SHAMismatch is a state entry whose snapshot no longer hashes to the
+// recorded SHA-256.
+type SHAMismatch struct {
+	Tool         Tool
+	Label        string
+	SnapshotPath string
+	Recorded     string
+	Actual       string
+}
+
+// Keep policies for Manager.Dedupe.
+const (
+	DedupeKeepOldest = "oldest"
+	DedupeKeepNewest = "newest"
+)
+
+// DedupeGroup is one set of byte-identical snapshots: Kept survives and
+// Removed are deleted.
+type DedupeGroup struct {
+	SHA256  string
+	Kept    string
+	Removed []string
+}
+
+type DedupeResult struct {
+	Tool   Tool
+	DryRun bool
+	Groups []DedupeGroup
+}
+
+// DiffChange is one difference between two snapshots. Kind is "added",
+// "removed", or "changed". Redacted changes carry no From/To values.
+type DiffChange struct {
+	Field    string
+	Kind     string
+	From     string
+	To       string
+	Redacted bool
+}
+
+type DiffResult struct {
+	Tool    Tool
+	LabelA  string
+	LabelB  string
+	Changes []DiffChange
 }
 
 type ListItem struct {
@@ -63,7 +366,42 @@ type ListItem struct {
 	SavedAt     string
 	LastUsedAt  string
 	Snapshot    string
+	Linked      bool
+	Note        string
+	Tags        []string
 	AuthInsight AuthInsight
+	// Tokens summarizes each JWT in the snapshot (claim keys, iss/sub/aud,
+	// never token bytes). Only Manager.Inspect fills it.
+	Tokens []string
+	// StaleIdentity is set by list --stale when IdentityCachedAt is older
+	// than the requested window.
+	StaleIdentity bool
+}
+
+// ExitCodeError carries a specific process exit code for the CLI wrapper.
+type ExitCodeError struct {
+	Code int
+	Err  error
+	// Silent tells the wrapper not to print Err; the exit code is the whole
+	// answer.
+	Silent bool
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+	return e.Err
+}
+
+type CheckItem struct {
+	Tool      Tool
+	Label     string
+	Path      string
+	Status    string
+	ExpiresAt string
+	Remaining time.Duration
 }
 
 type ActiveItem struct {
@@ -71,13 +409,47 @@ type ActiveItem struct {
 	ActiveLabel string
 	Status      string
 	RuntimePath string
+	// RuntimeFile is the runtime file's name when the tool has a runtime
+	// directory, so rows of one tool can be told apart.
+	RuntimeFile string
 	Details     []string
+	// TokenExpiry and Expired describe the runtime auth file's token; both
+	// stay zero when the runtime file is missing or unreadable.
+	TokenExpiry string
+	Expired     bool
 }
 
 type State struct {
 	Version       int                          `json:"version"`
 	Entries       map[string]StateEntry        `json:"entries"`
 	IdentityCache map[string]IdentityCacheItem `json:"identity_cache,omitempty"`
+	// LastActivatedLabel maps a tool name to the label most recently applied by use.
+	LastActivatedLabel map[string]string `json:"last_activated_label,omitempty"`
+	// PreviousActivatedLabel maps a tool name to the label that was last
+	// activated before the current one; use --revert switches back to it.
+	PreviousActivatedLabel map[string]string      `json:"previous_activated_label,omitempty"`
+	Aliases                map[string]AliasTarget `json:"aliases,omitempty"`
+	// Defaults maps a tool name to the label used when save or use get none.
+	Defaults map[string]string `json:"defaults,omitempty"`
+}
+
+type AliasTarget struct {
+	Tool  string `json:"tool"`
+	Label string `json:"label"`
+}
+
+// IdentityCacheEntry is one identity cache item with its account id.
+type IdentityCacheEntry struct {
+	AccountID string
+	Email     string
+	Plan      string
+	UpdatedAt string
+}
+
+type AliasItem struct {
+	Name  string
+	Tool  Tool
+	Label string
 }
 
 type StateEntry struct {
@@ -85,10 +457,33 @@ type StateEntry struct {
 	Label        string `json:"label"`
 	SourcePath   string `json:"source_path"`
 	SnapshotPath string `json:"snapshot_path"`
-	SHA256       string `json:"sha256"`
-	SavedAt      string `json:"saved_at"`
-	LastUsedAt   string `json:"last_used_at,omitempty"`
-	LastUsedSHA  string `json:"last_used_sha256,omitempty"`
+	// SHA256 is the hash of the plaintext snapshot JSON, never of the
+	// gzip-compressed file, so it stays comparable with runtime auth files
+	// and across compression changes.
+	SHA256      string `json:"sha256"`
+	SavedAt     string `json:"saved_at"`
+	LastUsedAt  string `json:"last_used_at,omitempty"`
+	LastUsedSHA string `json:"last_used_sha256,omitempty"`
+	Note        string `json:"note,omitempty"`
+	// Tags are sorted, unique, and match the default label pattern.
+	Tags []string `json:"tags,omitempty"`
+	// Companions maps a companion file name (e.g. account.json) to its saved
+	// copy.
+	Companions map[string]string `json:"companions,omitempty"`
+	// Linked marks a snapshot registered in place by ags link. ags never
+	// deletes or rewrites a linked file.
+	Linked bool `json:"linked,omitempty"`
+	// History holds the most recent save/use events, oldest first, capped at
+	// maxHistoryEvents.
+	History []HistoryEvent `json:"history,omitempty"`
+}
+
+// HistoryEvent is one save or use of a profile. Action is one of created,
+// changed, resaved, linked, used, or touched.
+type HistoryEvent struct {
+	Action string `json:"action"`
+	At     string `json:"at"`
+	SHA256 string `json:"sha256"`
 }
 
 type IdentityCacheItem struct {
@@ -97,25 +492,139 @@ type IdentityCacheItem struct {
 	UpdatedAt string `json:"updated_at"`
 }
 
+// currentStateVersion is the State.Version this code writes. Older files are
+// upgraded by migrateState when loaded.
+const currentStateVersion = 1
+
+// defaultRuntimeMode is the permission mode of runtime auth files written by
+// use. Snapshots, backups, and state are always 0600.
+const defaultRuntimeMode os.FileMode = 0o600
+
+// maxNoteLength bounds the freeform note stored on a profile, in characters.
+const maxNoteLength = 500
+
+// stateBackupCount is how many rolling copies of state.json are kept as
+// state.json.1 (newest) through state.json.N (oldest).
+const stateBackupCount = 3
+
+// maxHistoryEvents caps StateEntry.History so state.json stays small.
+const maxHistoryEvents = 50
+
 type Manager struct {
-	rootDir string
-	paths   map[Tool]ToolPaths
+	rootDir      string
+	paths        map[Tool]ToolPaths
+	expiringSoon time.Duration
+	// readOnly is set when the data root exists but rejects writes; state is
+	// then never written.
+	readOnly bool
+	// ioTimeout bounds state reads, source lookups, and atomic writes. Zero
+	// waits forever.
+	ioTimeout time.Duration
+	// compressSnapshots is the config default for SaveOptions.Compress.
+	compressSnapshots bool
+	// runtimeMode is the config default for UseOptions.Mode; zero means
+	// defaultRuntimeMode.
+	runtimeMode os.FileMode
+	// flatSnapshots stores new snapshots as snapshots/<tool>-<label>.json
+	// instead of snapshots/<tool>/<label>.json.
+	flatSnapshots bool
+	// piProviderAliases is pi_provider_aliases from config.json, keyed by
+	// lowercase selector.
+	piProviderAliases map[string][]string
+	// envFiles is env_files from config.json.
+	envFiles map[Tool][]envFileSpec
+	// logFile is the operation log path: --log-file, else config.json.
+	logFile string
+	// labels is the label rule from config.json's label_pattern.
+	labels labelRule
+	// noIdentityCache disables identity cache reads and writes.
+	noIdentityCache bool
+	// preUseHook and postUseHook are the pre_use/post_use commands from
+	// config.json.
+	preUseHook  string
+	postUseHook string
+}
+
+// ManagerOption customizes a Manager created by NewManager.
+type ManagerOption func(*Manager)
+
+// Snapshot layouts for Config.SnapshotLayout.
+const (
+	SnapshotLayoutNested = "nested"
+	SnapshotLayoutFlat   = "flat"
+)
+
+// Config is the optional user configuration read from <root>/config.json.
+type Config struct {
+	ExpiringSoon string `json:"expiring_soon,omitempty"`
+	// CompressSnapshots gzips new snapshots as <label>.json.gz.
+	CompressSnapshots bool `json:"compress_snapshots,omitempty"`
+	// LogFile is the default for the global --log-file flag.
+	LogFile string `json:"log_file,omitempty"`
+	// PreUse and PostUse are shell commands ags use runs before writing the
+	// runtime file and after the switch; see runUseHook.
+	PreUse  string `json:"pre_use,omitempty"`
+	PostUse string `json:"post_use,omitempty"`
+	// CompanionFiles saves and restores companion files such as codex's
+	// account.json along with the auth file.
+	CompanionFiles bool `json:"companion_files,omitempty"`
+	// RuntimeMode is the octal permission mode (e.g. "0640") ags use writes
+	// runtime auth files with; the default is 0600.
+	RuntimeMode string `json:"runtime_mode,omitempty"`
+	// SnapshotLayout is SnapshotLayoutNested (the default) or
+	// SnapshotLayoutFlat. It only decides where new snapshots are written;
+	// existing entries keep the path stored in state.
+	SnapshotLayout string `json:"snapshot_layout,omitempty"`
+	// PIProviderAliases maps a --provider selector to the exact pi provider
+	// keys it stands for, e.g. {"codex": ["openai-codex"]}, replacing the
+	// built-in substring match for that selector.
+	PIProviderAliases map[string][]string `json:"pi_provider_aliases,omitempty"`
+	// EnvFiles maps a tool name to the files ags use --env-passthrough
+	// renders from the activated snapshot; see parseEnvFiles.
+	EnvFiles map[string][]EnvFileConfig `json:"env_files,omitempty"`
+	// LabelPattern replaces the [a-zA-Z0-9._-]+ rule for labels; see
+	// parseLabelRule.
+	LabelPattern string `json:"label_pattern,omitempty"`
+	// Paths maps a tool name to persistent runtime/source path overrides,
+	// managed by ags config set-path.
+	Paths map[string]PathOverride `json:"paths,omitempty"`
+}
+
+// PathOverride replaces a tool's built-in runtime auth path (where use
+// writes and active reads) and save source path. RuntimeDir makes active
+// scan every *.json file in a directory instead, for tools that keep
+// several runtime profiles side by side.
+type PathOverride struct {
+	Runtime    string `json:"runtime,omitempty"`
+	Source     string `json:"source,omitempty"`
+	RuntimeDir string `json:"runtime_dir,omitempty"`
 }
 
 type ToolPaths struct {
 	DefaultRuntime string
+	// RuntimeDir, when set, is a directory of runtime auth files that
+	// active checks one by one instead of DefaultRuntime.
+	RuntimeDir     string
 	SaveCandidates []string
+	// Companions are file names saved and restored alongside the auth file,
+	// from the same directory. Set only when companion_files is enabled.
+	Companions []string
 }
 
 func defaultState() State {
 	return State{
-		Version:       1,
-		Entries:       map[string]StateEntry{},
-		IdentityCache: map[string]IdentityCacheItem{},
+		Version:                currentStateVersion,
+		Entries:                map[string]StateEntry{},
+		IdentityCache:          map[string]IdentityCacheItem{},
+		LastActivatedLabel:     map[string]string{},
+		PreviousActivatedLabel: map[string]string{},
+		Aliases:                map[string]AliasTarget{},
+		Defaults:               map[string]string{},
 	}
 }
 
-func nowUTC() time.Time {
+// nowUTC is the clock; tests replace it to freeze time.
+var nowUTC = func() time.Time {
 	return time.Now().UTC()
 }
 
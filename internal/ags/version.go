@@ -1,3 +1,8 @@
 package ags
 
-var Version = "dev"
+// Version, Commit, and BuildDate are set at build time with -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
@@ -0,0 +1,82 @@
+package ags
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// WatchActive calls onChange with the current Active result, then again
+// each time a watched runtime auth file changes. Files are polled every
+// interval; a change is reported once the file has been stable for one
+// further interval, so a burst of writes yields one call. Polling stats the
+// path rather than an open handle, so atomic replacement is picked up.
+// WatchActive returns nil when ctx is done.
+func (m *Manager) WatchActive(ctx context.Context, toolFilter *Tool, interval time.Duration, onChange func([]ActiveItem) error) error {
+	if interval <= 0 {
+		return invalidInput("watch interval must be positive")
+	}
+	emit := func() error {
+		items, err := m.Active(toolFilter)
+		if err != nil {
+			return err
+		}
+		return onChange(items)
+	}
+
+	last := m.runtimeSignature(toolFilter)
+	if err := emit(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			sig := m.runtimeSignature(toolFilter)
+			if sig != last {
+				last = sig
+				pending = true
+				continue
+			}
+			if pending {
+				pending = false
+				if err := emit(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (m *Manager) runtimeSignature(toolFilter *Tool) string {
+	tools := []Tool{ToolCodex, ToolPi}
+	if toolFilter != nil {
+		tools = []Tool{*toolFilter}
+	}
+	parts := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		paths := []string{m.paths[tool].DefaultRuntime}
+		if m.paths[tool].RuntimeDir != "" {
+			// A listing error reads as an empty directory; the next Active
+			// call reports it.
+			files, _, _ := m.runtimeDirFiles(tool)
+			paths = append([]string{m.paths[tool].RuntimeDir}, files...)
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				parts = append(parts, path+":missing")
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s:%d:%d", path, info.ModTime().UnixNano(), info.Size()))
+		}
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,95 @@
+package ags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerWatchActiveReportsChanges(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := t.TempDir()
+	m, err := NewManager(root)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	raw := makeCodexAuthJSON(t, time.Now().Add(2*time.Hour))
+	saveTestSnapshot(t, m, ToolCodex, "work", raw)
+
+	runtime := filepath.Join(home, ".codex", "auth.json")
+	tool := ToolCodex
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var statuses []string
+	err = m.WatchActive(ctx, &tool, 5*time.Millisecond, func(items []ActiveItem) error {
+		statuses = append(statuses, items[0].Status+"/"+items[0].ActiveLabel)
+		switch len(statuses) {
+		case 1:
+			// Replace the runtime file atomically, as ags use does.
+			if err := atomicWriteFile(runtime, raw, 0o600); err != nil {
+				t.Fatalf("write runtime: %v", err)
+			}
+		case 2:
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchActive: %v", err)
+	}
+	if len(statuses) != 2 || statuses[1] != "match/work" || statuses[0] == statuses[1] {
+		t.Fatalf("unexpected watch emissions: %v", statuses)
+	}
+}
+
+func TestManagerWatchActiveErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	if err := m.WatchActive(context.Background(), nil, 0, nil); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid interval error, got %v", err)
+	}
+	boom := errors.New("boom")
+	if err := m.WatchActive(context.Background(), nil, time.Millisecond, func([]ActiveItem) error { return boom }); !errors.Is(err, boom) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+}
+
+func TestRunActiveJSONAndWatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+
+	var out strings.Builder
+	if err := Run([]string{"active", "codex", "--json", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --json: %v", err)
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &rows); err != nil {
+		t.Fatalf("decode active json %q: %v", out.String(), err)
+	}
+	if len(rows) != 1 || rows[0]["tool"] != "codex" {
+		t.Fatalf("unexpected active json rows: %v", rows)
+	}
+
+	oldContext, oldInterval := watchContext, watchInterval
+	defer func() { watchContext, watchInterval = oldContext, oldInterval }()
+	watchInterval = time.Millisecond
+	watchContext = func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 20*time.Millisecond)
+	}
+	out.Reset()
+	if err := Run([]string{"active", "--watch", "--root", root}, nil, &out, &out); err != nil {
+		t.Fatalf("active --watch: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "tool\tactive label\tstatus\truntime\n") {
+		t.Fatalf("expected initial table from watch, got %q", out.String())
+	}
+}